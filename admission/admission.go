@@ -0,0 +1,328 @@
+// Package admission decides whether a new connection is admitted before the channel created.
+//
+// A Controller checks the remote ip of connection by the CIDR deny and allow lists, the maximum
+// number of concurrent channels per ip, and the temporary bans of the ips which caused repeated
+// protocol errors, such as the undecodable packets. The config of Controller could be updated at
+// runtime, it takes effect on the following connections.
+package admission
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/emove/less/internal/timewheel"
+	"github.com/emove/less/log"
+)
+
+var (
+	// ErrDenied is returned when the remote ip matches the deny list
+	ErrDenied = errors.New("remote ip denied")
+	// ErrNotAllowed is returned when the allow list is not empty and the remote ip doesn't match it
+	ErrNotAllowed = errors.New("remote ip not allowed")
+	// ErrTooManyChannels is returned when the remote ip reaches the maximum number of channels
+	ErrTooManyChannels = errors.New("too many channels from remote ip")
+	// ErrBanned is returned when the remote ip is banned temporarily
+	ErrBanned = errors.New("remote ip banned")
+)
+
+// Config is the admission policies
+type Config struct {
+	// Allow is the CIDRs or ips admitted, empty means all admitted
+	Allow []string
+	// Deny is the CIDRs or ips refused, it takes precedence over Allow
+	Deny []string
+	// MaxChannelsPerIP is the maximum number of concurrent channels of each remote ip, zero means no limit
+	MaxChannelsPerIP uint32
+	// BanThreshold is the number of protocol errors in BanWindow which bans the remote ip, zero disables banning
+	BanThreshold uint32
+	// BanWindow is the duration counting the protocol errors, 1 minute by default
+	BanWindow time.Duration
+	// BanDuration is the duration of ban, 10 minutes by default
+	BanDuration time.Duration
+}
+
+// compiled is the parsed Config
+type compiled struct {
+	Config
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+func compile(config Config) (*compiled, error) {
+	c := &compiled{Config: config}
+	if c.BanWindow <= 0 {
+		c.BanWindow = time.Minute
+	}
+	if c.BanDuration <= 0 {
+		c.BanDuration = 10 * time.Minute
+	}
+	var err error
+	if c.allow, err = parseCIDRs(config.Allow); err != nil {
+		return nil, err
+	}
+	if c.deny, err = parseCIDRs(config.Deny); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// parseCIDRs parses the CIDRs, the single ip is parsed as a CIDR of itself
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if ip := net.ParseIP(cidr); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("admission: invalid CIDR %q: %w", cidr, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func contains(nets []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// RejectHook is invoked when a connection is refused
+type RejectHook func(remote net.Addr, err error)
+
+// Option sets Controller options
+type Option func(c *Controller)
+
+// WithRejectHook adds a hook invoked when a connection is refused
+func WithRejectHook(hook RejectHook) Option {
+	return func(c *Controller) {
+		if hook != nil {
+			c.hooks = append(c.hooks, hook)
+		}
+	}
+}
+
+// Controller admits the connections by Config
+type Controller struct {
+	config atomic.Value // *compiled
+	hooks  []RejectHook
+
+	mu  sync.Mutex // guard the following
+	ips map[string]*ipState
+}
+
+// ipState is the state of a remote ip
+type ipState struct {
+	channels int
+	errors   uint32
+	window   timewheel.TimeNoder // resets errors
+	banned   timewheel.TimeNoder // lifts the ban
+}
+
+func (s *ipState) idle() bool {
+	return s.channels == 0 && s.errors == 0 && s.window == nil && s.banned == nil
+}
+
+// NewController returns a Controller, it returns an error if the config is invalid
+func NewController(config Config, op ...Option) (*Controller, error) {
+	c := &Controller{ips: make(map[string]*ipState)}
+	for _, o := range op {
+		o(c)
+	}
+	if err := c.Update(config); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Update replaces the config, the current config is kept if the new one is invalid.
+// The admitted channels are not affected.
+func (c *Controller) Update(config Config) error {
+	cc, err := compile(config)
+	if err != nil {
+		return err
+	}
+	c.config.Store(cc)
+	return nil
+}
+
+// Config returns the current config
+func (c *Controller) Config() Config {
+	return c.config.Load().(*compiled).Config
+}
+
+// Admit checks the new connection from remote, the release should be called after the admitted
+// channel closed. The reject hooks are invoked if refused.
+func (c *Controller) Admit(remote net.Addr) (release func(), err error) {
+	config := c.config.Load().(*compiled)
+	key, ip := host(remote)
+
+	defer func() {
+		if err != nil {
+			for _, hook := range c.hooks {
+				hook(remote, err)
+			}
+		}
+	}()
+
+	if contains(config.deny, ip) {
+		return nil, ErrDenied
+	}
+	if len(config.allow) > 0 && !contains(config.allow, ip) {
+		return nil, ErrNotAllowed
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.ips[key]
+	if s != nil && s.banned != nil {
+		return nil, ErrBanned
+	}
+	if max := int(config.MaxChannelsPerIP); max > 0 && s != nil && s.channels >= max {
+		return nil, ErrTooManyChannels
+	}
+	if s == nil {
+		s = &ipState{}
+		c.ips[key] = s
+	}
+	s.channels++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			s.channels--
+			c.cleanup(key, s)
+		})
+	}, nil
+}
+
+// ReportError records a protocol error caused by remote, the remote ip is banned if the errors
+// reach the BanThreshold in BanWindow
+func (c *Controller) ReportError(remote net.Addr) {
+	config := c.config.Load().(*compiled)
+	if config.BanThreshold == 0 {
+		return
+	}
+	key, _ := host(remote)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.ips[key]
+	if s == nil {
+		s = &ipState{}
+		c.ips[key] = s
+	}
+	if s.banned != nil {
+		return
+	}
+
+	s.errors++
+	if s.window == nil {
+		s.window = timewheel.Timer.AfterFunc(config.BanWindow, func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			s.errors = 0
+			s.window = nil
+			c.cleanup(key, s)
+		})
+	}
+	if s.errors >= config.BanThreshold {
+		log.Warnf("remote ip %s banned for %v after %d protocol errors", key, config.BanDuration, s.errors)
+		c.ban(key, s, config.BanDuration)
+	}
+}
+
+// Ban bans the ip for the duration
+func (c *Controller) Ban(ip string, duration time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.ips[ip]
+	if s == nil {
+		s = &ipState{}
+		c.ips[ip] = s
+	}
+	c.ban(ip, s, duration)
+}
+
+// Unban lifts the ban of ip
+func (c *Controller) Unban(ip string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s := c.ips[ip]; s != nil && s.banned != nil {
+		s.banned.Stop()
+		s.banned = nil
+		c.cleanup(ip, s)
+	}
+}
+
+// Banned returns whether the ip is banned
+func (c *Controller) Banned(ip string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.ips[ip]
+	return s != nil && s.banned != nil
+}
+
+// ban bans the ip, the errors are reset. c.mu should be held.
+func (c *Controller) ban(ip string, s *ipState, duration time.Duration) {
+	if s.window != nil {
+		s.window.Stop()
+		s.window = nil
+	}
+	s.errors = 0
+	if s.banned != nil {
+		s.banned.Stop()
+	}
+
+	var banned timewheel.TimeNoder
+	banned = timewheel.Timer.AfterFunc(duration, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		// may be replaced by a later ban
+		if s.banned == banned {
+			s.banned = nil
+			c.cleanup(ip, s)
+		}
+	})
+	s.banned = banned
+}
+
+// cleanup removes the idle state of ip. c.mu should be held.
+func (c *Controller) cleanup(ip string, s *ipState) {
+	if s.idle() && c.ips[ip] == s {
+		delete(c.ips, ip)
+	}
+}
+
+// host returns the key and ip of address, the ip is nil if the address has no ip
+func host(addr net.Addr) (string, net.IP) {
+	if addr == nil {
+		return "", nil
+	}
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP.String(), tcpAddr.IP
+	}
+	h, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		h = addr.String()
+	}
+	return h, net.ParseIP(h)
+}
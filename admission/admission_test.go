@@ -0,0 +1,114 @@
+package admission
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func addr(ip string) net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP(ip), Port: 1024}
+}
+
+func TestController_Lists(t *testing.T) {
+	var rejected []error
+	c, err := NewController(Config{
+		Allow: []string{"10.0.0.0/8", "192.168.1.1"},
+		Deny:  []string{"10.0.1.0/24"},
+	}, WithRejectHook(func(remote net.Addr, err error) {
+		rejected = append(rejected, err)
+	}))
+	if err != nil {
+		t.Fatalf("NewController() error = %v", err)
+	}
+
+	tests := []struct {
+		ip   string
+		want error
+	}{
+		{ip: "10.0.0.1"},
+		{ip: "192.168.1.1"},
+		{ip: "10.0.1.1", want: ErrDenied},
+		{ip: "192.168.1.2", want: ErrNotAllowed},
+	}
+	for _, tt := range tests {
+		if _, err := c.Admit(addr(tt.ip)); err != tt.want {
+			t.Fatalf("ip: %s, want: %v, but: %v", tt.ip, tt.want, err)
+		}
+	}
+	if len(rejected) != 2 {
+		t.Fatalf("want 2 rejections reported, but: %v", rejected)
+	}
+
+	// hot reload
+	if err = c.Update(Config{Deny: []string{"10.0.0.1"}}); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if _, err = c.Admit(addr("192.168.1.2")); err != nil {
+		t.Fatalf("want admitted after update, but: %v", err)
+	}
+	if _, err = c.Admit(addr("10.0.0.1")); err != ErrDenied {
+		t.Fatalf("want: %v, but: %v", ErrDenied, err)
+	}
+	if err = c.Update(Config{Allow: []string{"invalid"}}); err == nil {
+		t.Fatalf("the invalid CIDR should be rejected")
+	}
+	if len(c.Config().Deny) != 1 {
+		t.Fatalf("the config should be kept")
+	}
+}
+
+func TestController_MaxChannelsPerIP(t *testing.T) {
+	c, _ := NewController(Config{MaxChannelsPerIP: 2})
+	r1, _ := c.Admit(addr("10.0.0.1"))
+	r2, _ := c.Admit(addr("10.0.0.1"))
+	if _, err := c.Admit(addr("10.0.0.1")); err != ErrTooManyChannels {
+		t.Fatalf("want: %v, but: %v", ErrTooManyChannels, err)
+	}
+	if _, err := c.Admit(addr("10.0.0.2")); err != nil {
+		t.Fatalf("the other ip should be admitted, but: %v", err)
+	}
+
+	r1()
+	r1() // released once
+	if _, err := c.Admit(addr("10.0.0.1")); err != nil {
+		t.Fatalf("want admitted after released, but: %v", err)
+	}
+	if _, err := c.Admit(addr("10.0.0.1")); err != ErrTooManyChannels {
+		t.Fatalf("want: %v, but: %v", ErrTooManyChannels, err)
+	}
+	r2()
+}
+
+func TestController_Ban(t *testing.T) {
+	c, _ := NewController(Config{BanThreshold: 2, BanDuration: 30 * time.Millisecond})
+	remote := addr("10.0.0.1")
+
+	c.ReportError(remote)
+	if _, err := c.Admit(remote); err != nil {
+		t.Fatalf("want admitted before threshold, but: %v", err)
+	}
+	c.ReportError(remote)
+	if _, err := c.Admit(remote); !errors.Is(err, ErrBanned) {
+		t.Fatalf("want: %v, but: %v", ErrBanned, err)
+	}
+
+	// the ban is lifted
+	deadline := time.Now().Add(time.Second)
+	for c.Banned("10.0.0.1") {
+		if time.Now().After(deadline) {
+			t.Fatalf("the ban should be lifted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	c.Ban("10.0.0.2", time.Hour)
+	if _, err := c.Admit(addr("10.0.0.2")); err != ErrBanned {
+		t.Fatalf("want: %v, but: %v", ErrBanned, err)
+	}
+	c.Unban("10.0.0.2")
+	if _, err := c.Admit(addr("10.0.0.2")); err != nil {
+		t.Fatalf("want admitted after unbanned, but: %v", err)
+	}
+}
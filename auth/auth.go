@@ -0,0 +1,311 @@
+// Package auth authenticates channels before their messages reach the router.
+//
+// The first messages of a channel are handled by the Session created by an Authenticator, until
+// the Session returns a Principal. The channel is closed if it's not authenticated in time, or
+// more messages than allowed are required. The Principal is attached to the channel after
+// authenticated, and could be got by FromContext or FromChannel in the downstream middlewares
+// and handlers.
+//
+// Both Auth.OnChannel and Auth.Middleware should be installed, the middleware should be installed
+// before the middlewares depending on the Principal:
+//
+//	a := auth.New(auth.Token(verify))
+//	srv := server.NewServer(addr,
+//		server.WithOnChannel(a.OnChannel),
+//		server.WithInboundMiddleware(a.Middleware(), authz.Middleware(authorizer)),
+//	)
+//
+// The messages of a channel are handled concurrently, so the peer should wait for the reply of
+// authentication before sending the other messages.
+package auth
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/emove/less"
+	"github.com/emove/less/internal/timewheel"
+	"github.com/emove/less/log"
+)
+
+var (
+	// ErrUnauthenticated is returned when the message is received by a channel which has not been
+	// prepared by Auth.OnChannel
+	ErrUnauthenticated = errors.New("channel unauthenticated")
+	// ErrTimeout is the reason of closing the channel which is not authenticated in time
+	ErrTimeout = errors.New("authentication timeout")
+	// ErrTooManyMessages is the reason of closing the channel which is not authenticated after
+	// the maximum number of messages
+	ErrTooManyMessages = errors.New("too many authentication messages")
+	// ErrInvalidCredentials is returned by the builtin authenticators when the credentials are wrong
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Principal is the identity of an authenticated channel
+type Principal struct {
+	// Name is the unique name of identity, such as the user name or the common name of certificate
+	Name string
+	// Roles are the roles granted to the identity
+	Roles []string
+	// Attributes are the extra attributes of identity
+	Attributes map[string]string
+}
+
+// HasRole returns whether the role is granted
+func (p *Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator creates the authentication Session of channels
+type Authenticator interface {
+	// NewSession is invoked when the channel established. It returns the principal if the channel has
+	// been authenticated by the connection, such as the verified client certificate, otherwise
+	// the session authenticating the following messages.
+	NewSession(ctx context.Context, ch less.Channel) (Session, *Principal, error)
+}
+
+// Session authenticates a channel by the messages received before the channel authenticated
+type Session interface {
+	// Authenticate returns the principal if the channel authenticated, otherwise the reply which
+	// will be sent to the peer, such as a challenge, nil means do not reply.
+	Authenticate(ctx context.Context, message interface{}) (principal *Principal, reply interface{}, err error)
+}
+
+// SessionFunc is an adapter to allow the use of ordinary functions as Session
+type SessionFunc func(ctx context.Context, message interface{}) (*Principal, interface{}, error)
+
+// Authenticate calls f(ctx, message)
+func (f SessionFunc) Authenticate(ctx context.Context, message interface{}) (*Principal, interface{}, error) {
+	return f(ctx, message)
+}
+
+type options struct {
+	timeout      time.Duration
+	maxMessages  int
+	successReply func(principal *Principal) interface{}
+	failureReply func(err error) interface{}
+}
+
+var defaultOptions = options{
+	timeout:     10 * time.Second,
+	maxMessages: 3,
+}
+
+// Option sets Auth options
+type Option func(ops *options)
+
+// WithTimeout sets the maximum duration from the channel established to authenticated, 10s by default
+func WithTimeout(timeout time.Duration) Option {
+	return func(ops *options) {
+		if timeout > 0 {
+			ops.timeout = timeout
+		}
+	}
+}
+
+// WithMaxMessages sets the maximum number of messages handled by the Session, 3 by default
+func WithMaxMessages(n int) Option {
+	return func(ops *options) {
+		if n > 0 {
+			ops.maxMessages = n
+		}
+	}
+}
+
+// WithSuccessReply sets the function returns the reply message which will be sent to the peer after
+// authenticated, nil means do not reply
+func WithSuccessReply(reply func(principal *Principal) interface{}) Option {
+	return func(ops *options) {
+		ops.successReply = reply
+	}
+}
+
+// WithFailureReply sets the function returns the reply message which will be sent to the peer before
+// the channel closed by authentication failure, nil means do not reply
+func WithFailureReply(reply func(err error) interface{}) Option {
+	return func(ops *options) {
+		ops.failureReply = reply
+	}
+}
+
+// Auth authenticates channels by an Authenticator
+type Auth struct {
+	authenticator Authenticator
+	ops           options
+}
+
+// New returns an Auth
+func New(authenticator Authenticator, op ...Option) *Auth {
+	ops := defaultOptions
+	for _, o := range op {
+		o(&ops)
+	}
+	return &Auth{authenticator: authenticator, ops: ops}
+}
+
+type stateKey struct{}
+
+// state is the authentication state of a channel
+type state struct {
+	principal atomic.Value // *Principal
+
+	mu       sync.Mutex // guard the following
+	session  Session
+	messages int
+	timer    timewheel.TimeNoder
+}
+
+func (s *state) load() *Principal {
+	p, _ := s.principal.Load().(*Principal)
+	return p
+}
+
+// authenticated attaches the principal and stops the deadline
+func (s *state) authenticated(p *Principal) {
+	s.principal.Store(p)
+	s.session = nil
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+}
+
+// OnChannel is an OnChannel hook which creates the Session of channel and starts the deadline
+func (a *Auth) OnChannel(ctx context.Context, ch less.Channel) (context.Context, error) {
+	session, principal, err := a.authenticator.NewSession(ctx, ch)
+	if err != nil {
+		return ctx, err
+	}
+
+	s := &state{session: session}
+	if principal != nil {
+		s.principal.Store(principal)
+		return context.WithValue(ctx, stateKey{}, s), nil
+	}
+	if session == nil {
+		return ctx, ErrUnauthenticated
+	}
+
+	s.timer = timewheel.Timer.AfterFunc(a.ops.timeout, func() {
+		s.mu.Lock()
+		expired := s.load() == nil
+		s.mu.Unlock()
+		if expired {
+			a.fail(ctx, ch, ErrTimeout)
+		}
+	})
+	ch.AddOnChannelClosed(func(ctx context.Context, ch less.Channel, err error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.timer != nil {
+			s.timer.Stop()
+			s.timer = nil
+		}
+	})
+	return context.WithValue(ctx, stateKey{}, s), nil
+}
+
+// Middleware returns an inbound middleware which routes the messages to the Session until the
+// channel authenticated. The channel which is not prepared by OnChannel is closed.
+func (a *Auth) Middleware() less.Middleware {
+	return func(next less.Handler) less.Handler {
+		return func(ctx context.Context, ch less.Channel, message interface{}) error {
+			s, _ := ctx.Value(stateKey{}).(*state)
+			if s == nil {
+				a.fail(ctx, ch, ErrUnauthenticated)
+				return nil
+			}
+			if s.load() != nil {
+				return next(ctx, ch, message)
+			}
+
+			done, err := a.authenticate(ctx, ch, s, message)
+			if err != nil {
+				a.fail(ctx, ch, err)
+				return nil
+			}
+			if done {
+				// authenticated by another message
+				return next(ctx, ch, message)
+			}
+			return nil
+		}
+	}
+}
+
+// authenticate passes message to the Session, returns true if the channel has been authenticated
+// before the message
+func (a *Auth) authenticate(ctx context.Context, ch less.Channel, s *state, message interface{}) (done bool, err error) {
+	s.mu.Lock()
+	defer func() {
+		if err != nil {
+			// the following messages are dropped until the channel closed
+			s.session = nil
+		}
+		s.mu.Unlock()
+	}()
+	if s.load() != nil {
+		return true, nil
+	}
+	if s.session == nil {
+		// failed
+		return false, nil
+	}
+
+	s.messages++
+	principal, reply, err := s.session.Authenticate(ctx, message)
+	if err != nil {
+		return false, err
+	}
+	if principal != nil {
+		s.authenticated(principal)
+		log.Debugw("remote", ch.RemoteAddr().String(), log.DefaultMsgKey, "channel authenticated", "principal", principal.Name)
+		if a.ops.successReply != nil {
+			reply = a.ops.successReply(principal)
+		}
+	} else if s.messages == a.ops.maxMessages {
+		return false, ErrTooManyMessages
+	}
+
+	if reply != nil {
+		if err = ch.Write(reply); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// fail replies the failure and closes the channel
+func (a *Auth) fail(ctx context.Context, ch less.Channel, err error) {
+	log.Warnw("remote", ch.RemoteAddr().String(), log.DefaultMsgKey, "authentication failed", "err", err)
+	if a.ops.failureReply != nil {
+		if reply := a.ops.failureReply(err); reply != nil {
+			_ = ch.Write(reply)
+		}
+	}
+	_ = ch.Close(ctx, err)
+}
+
+// FromContext returns the Principal of the channel which the ctx belongs to, false if the
+// channel has not been authenticated
+func FromContext(ctx context.Context) (*Principal, bool) {
+	s, _ := ctx.Value(stateKey{}).(*state)
+	if s == nil {
+		return nil, false
+	}
+	p := s.load()
+	return p, p != nil
+}
+
+// FromChannel returns the Principal of channel, false if the channel has not been authenticated
+func FromChannel(ch less.Channel) (*Principal, bool) {
+	return FromContext(ch.Context())
+}
@@ -0,0 +1,210 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emove/less"
+	"github.com/emove/less/transport"
+)
+
+type fakeChannel struct {
+	less.Channel
+	mu      sync.Mutex
+	ctx     context.Context
+	written []interface{}
+	closed  chan error
+}
+
+func newFakeChannel() *fakeChannel {
+	return &fakeChannel{ctx: context.Background(), closed: make(chan error, 1)}
+}
+
+func (ch *fakeChannel) Context() context.Context {
+	return ch.ctx
+}
+
+func (ch *fakeChannel) RemoteAddr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+func (ch *fakeChannel) Write(message interface{}) error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.written = append(ch.written, message)
+	return nil
+}
+
+func (ch *fakeChannel) Close(_ context.Context, err error) error {
+	select {
+	case ch.closed <- err:
+	default:
+	}
+	return nil
+}
+
+func (ch *fakeChannel) AddOnChannelClosed(...less.OnChannelClosed) {}
+
+// serve prepares ch by OnChannel and returns the handler which records the handled messages
+func serve(t *testing.T, a *Auth, ch *fakeChannel, handled *[]interface{}) less.Handler {
+	ctx, err := a.OnChannel(ch.ctx, ch)
+	if err != nil {
+		t.Fatalf("OnChannel() error = %v", err)
+	}
+	ch.ctx = ctx
+	return a.Middleware()(func(ctx context.Context, ch less.Channel, message interface{}) error {
+		p, ok := FromContext(ctx)
+		if !ok {
+			t.Errorf("the principal should be attached")
+		} else {
+			*handled = append(*handled, p.Name+":"+message.(string))
+		}
+		return nil
+	})
+}
+
+func closedWith(t *testing.T, ch *fakeChannel, want error) {
+	select {
+	case err := <-ch.closed:
+		if !errors.Is(err, want) {
+			t.Fatalf("want closed by: %v, but: %v", want, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("the channel should be closed by: %v", want)
+	}
+}
+
+func TestToken(t *testing.T) {
+	a := New(Token(func(ctx context.Context, token string) (*Principal, error) {
+		if token != "secret" {
+			return nil, ErrInvalidCredentials
+		}
+		return &Principal{Name: "alice"}, nil
+	}), WithSuccessReply(func(p *Principal) interface{} { return "welcome " + p.Name }),
+		WithFailureReply(func(err error) interface{} { return err.Error() }))
+
+	var handled []interface{}
+	ch := newFakeChannel()
+	h := serve(t, a, ch, &handled)
+	for _, msg := range []string{"secret", "hello"} {
+		if err := h(ch.ctx, ch, msg); err != nil {
+			t.Fatalf("handler error = %v", err)
+		}
+	}
+	if len(handled) != 1 || handled[0] != "alice:hello" {
+		t.Fatalf("want: [alice:hello], but: %v", handled)
+	}
+	if len(ch.written) != 1 || ch.written[0] != "welcome alice" {
+		t.Fatalf("want success reply, but: %v", ch.written)
+	}
+	if p, ok := FromChannel(ch); !ok || p.Name != "alice" {
+		t.Fatalf("want principal alice, but: %v", p)
+	}
+
+	ch = newFakeChannel()
+	h = serve(t, a, ch, &handled)
+	_ = h(ch.ctx, ch, "wrong")
+	closedWith(t, ch, ErrInvalidCredentials)
+	if len(ch.written) != 1 || ch.written[0] != ErrInvalidCredentials.Error() {
+		t.Fatalf("want failure reply, but: %v", ch.written)
+	}
+}
+
+func TestChallenge(t *testing.T) {
+	secret := []byte("alice's secret")
+	a := New(Challenge(func(ctx context.Context, identity string) (*Principal, []byte, error) {
+		if identity != "alice" {
+			return nil, nil, ErrInvalidCredentials
+		}
+		return &Principal{Name: identity, Roles: []string{"admin"}}, secret, nil
+	}))
+
+	for _, tt := range []struct {
+		name     string
+		identity string
+		secret   []byte
+		want     error
+	}{
+		{name: "ok", identity: "alice", secret: secret},
+		{name: "wrong secret", identity: "alice", secret: []byte("guess"), want: ErrInvalidCredentials},
+		{name: "unknown identity", identity: "bob", secret: secret, want: ErrInvalidCredentials},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var handled []interface{}
+			ch := newFakeChannel()
+			h := serve(t, a, ch, &handled)
+			_ = h(ch.ctx, ch, tt.identity)
+			if len(ch.written) != 1 {
+				t.Fatalf("want a challenge, but: %v", ch.written)
+			}
+			_ = h(ch.ctx, ch, ChallengeResponse(tt.secret, ch.written[0].(string)))
+
+			if tt.want != nil {
+				closedWith(t, ch, tt.want)
+				return
+			}
+			_ = h(ch.ctx, ch, "hello")
+			if len(handled) != 1 || handled[0] != "alice:hello" {
+				t.Fatalf("want: [alice:hello], but: %v", handled)
+			}
+			if p, _ := FromChannel(ch); !p.HasRole("admin") {
+				t.Fatalf("the roles should be attached")
+			}
+		})
+	}
+}
+
+func TestAuth_Limits(t *testing.T) {
+	pending := AuthenticatorFunc(func(ctx context.Context, ch less.Channel) (Session, *Principal, error) {
+		return SessionFunc(func(ctx context.Context, message interface{}) (*Principal, interface{}, error) {
+			return nil, nil, nil
+		}), nil, nil
+	})
+
+	var handled []interface{}
+	ch := newFakeChannel()
+	h := serve(t, New(pending, WithMaxMessages(2)), ch, &handled)
+	_ = h(ch.ctx, ch, "1")
+	_ = h(ch.ctx, ch, "2")
+	closedWith(t, ch, ErrTooManyMessages)
+
+	ch = newFakeChannel()
+	serve(t, New(pending, WithTimeout(30*time.Millisecond)), ch, &handled)
+	closedWith(t, ch, ErrTimeout)
+
+	// not prepared by OnChannel
+	ch = newFakeChannel()
+	_ = New(pending).Middleware()(nil)(ch.ctx, ch, "hello")
+	closedWith(t, ch, ErrUnauthenticated)
+
+	if len(handled) != 0 {
+		t.Fatalf("no message should be handled, but: %v", handled)
+	}
+}
+
+func TestMTLS(t *testing.T) {
+	a := New(MTLS(nil))
+	ch := newFakeChannel()
+	if _, err := a.OnChannel(ch.ctx, ch); err != ErrNoCertificate {
+		t.Fatalf("want: %v, but: %v", ErrNoCertificate, err)
+	}
+
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "alice"}}
+	ctx := transport.ContextWithTLS(context.Background(), &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{cert}},
+	})
+	ctx, err := a.OnChannel(ctx, ch)
+	if err != nil {
+		t.Fatalf("OnChannel() error = %v", err)
+	}
+	if p, ok := FromContext(ctx); !ok || p.Name != "alice" {
+		t.Fatalf("want principal alice, but: %v", p)
+	}
+}
@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+
+	"github.com/emove/less"
+	"github.com/emove/less/transport"
+)
+
+// ErrNoCertificate is returned by the MTLS authenticator when the connection has no verified client certificate
+var ErrNoCertificate = errors.New("no verified client certificate")
+
+// AuthenticatorFunc is an adapter to allow the use of ordinary functions as Authenticator
+type AuthenticatorFunc func(ctx context.Context, ch less.Channel) (Session, *Principal, error)
+
+// NewSession calls f(ctx, ch)
+func (f AuthenticatorFunc) NewSession(ctx context.Context, ch less.Channel) (Session, *Principal, error) {
+	return f(ctx, ch)
+}
+
+// text returns the text of string or []byte message
+func text(message interface{}) (string, bool) {
+	switch m := message.(type) {
+	case string:
+		return m, true
+	case []byte:
+		return string(m), true
+	default:
+		return "", false
+	}
+}
+
+// Token returns an Authenticator verifying the token carried by the first message, which is
+// a string or []byte
+func Token(verify func(ctx context.Context, token string) (*Principal, error)) Authenticator {
+	return AuthenticatorFunc(func(ctx context.Context, ch less.Channel) (Session, *Principal, error) {
+		return SessionFunc(func(ctx context.Context, message interface{}) (*Principal, interface{}, error) {
+			token, ok := text(message)
+			if !ok {
+				return nil, nil, ErrInvalidCredentials
+			}
+			principal, err := verify(ctx, token)
+			if err == nil && principal == nil {
+				err = ErrInvalidCredentials
+			}
+			return principal, nil, err
+		}), nil, nil
+	})
+}
+
+// Challenge returns an Authenticator of HMAC-SHA256 challenge-response. The first message carries the
+// identity of peer, which is replied with a random challenge in hex. The second message carries the
+// response computed by ChallengeResponse with the secret of identity.
+//
+// The lookup returns the principal and secret of identity. The failure of lookup is reported after
+// the response received, so that the peer could not probe the identities.
+func Challenge(lookup func(ctx context.Context, identity string) (*Principal, []byte, error)) Authenticator {
+	return AuthenticatorFunc(func(ctx context.Context, ch less.Channel) (Session, *Principal, error) {
+		return &challenge{lookup: lookup}, nil, nil
+	})
+}
+
+// ChallengeResponse returns the response of challenge computed with secret
+func ChallengeResponse(secret []byte, challenge string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(challenge))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+type challenge struct {
+	lookup    func(ctx context.Context, identity string) (*Principal, []byte, error)
+	challenge string
+	principal *Principal
+	secret    []byte
+	err       error
+}
+
+func (c *challenge) Authenticate(ctx context.Context, message interface{}) (*Principal, interface{}, error) {
+	msg, ok := text(message)
+	if !ok {
+		return nil, nil, ErrInvalidCredentials
+	}
+
+	if c.challenge == "" {
+		var nonce [32]byte
+		if _, err := rand.Read(nonce[:]); err != nil {
+			return nil, nil, err
+		}
+		c.challenge = hex.EncodeToString(nonce[:])
+		c.principal, c.secret, c.err = c.lookup(ctx, msg)
+		return nil, c.challenge, nil
+	}
+
+	if c.err != nil {
+		return nil, nil, c.err
+	}
+	if c.principal == nil || !hmac.Equal([]byte(msg), []byte(ChallengeResponse(c.secret, c.challenge))) {
+		return nil, nil, ErrInvalidCredentials
+	}
+	return c.principal, nil, nil
+}
+
+// MTLS returns an Authenticator authenticating channels by the verified client certificate of TLS
+// connection, which is passed by the transport through context, see transport.TLSFromContext.
+// The principal is mapped from the leaf certificate, the principal named by the common name is
+// used if mapper is nil.
+func MTLS(mapper func(cert *x509.Certificate) (*Principal, error)) Authenticator {
+	return AuthenticatorFunc(func(ctx context.Context, ch less.Channel) (Session, *Principal, error) {
+		state, ok := transport.TLSFromContext(ctx)
+		if !ok || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+			return nil, nil, ErrNoCertificate
+		}
+
+		cert := state.VerifiedChains[0][0]
+		if mapper == nil {
+			return nil, &Principal{Name: cert.Subject.CommonName}, nil
+		}
+		principal, err := mapper(cert)
+		if err == nil && principal == nil {
+			err = ErrInvalidCredentials
+		}
+		return nil, principal, err
+	})
+}
@@ -0,0 +1,166 @@
+// Package authz restricts the messages which each principal may send.
+//
+// The Middleware resolves the route of message by a KeyFunc, such as the type name of message
+// or the key of command router, and asks an Authorizer whether the principal attached by the
+// auth package is permitted to send it. The denied message is not handled, and a
+// *PermissionDenied is replied to the peer.
+//
+// The PolicyAuthorizer authorizes by role based rules, which could be reloaded at runtime
+// from a JSON policy file:
+//
+//	{
+//		"default": "deny",
+//		"rules": [
+//			{"route": "admin.*", "roles": ["admin"]},
+//			{"route": "*", "roles": ["*"]}
+//		]
+//	}
+//
+// The Middleware should be installed after auth.Auth.Middleware.
+package authz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/emove/less"
+	"github.com/emove/less/auth"
+	"github.com/emove/less/log"
+	"github.com/emove/less/router"
+)
+
+// ErrPermissionDenied is matched by *PermissionDenied with errors.Is
+var ErrPermissionDenied = errors.New("permission denied")
+
+// PermissionDenied is returned when the principal is not permitted to send the message,
+// and is replied to the peer by default
+type PermissionDenied struct {
+	// Route is the route of denied message
+	Route string `json:"route"`
+	// Principal is the name of principal, empty if the channel is not authenticated
+	Principal string `json:"principal,omitempty"`
+	// Reason is readable description
+	Reason string `json:"reason"`
+}
+
+func (e *PermissionDenied) Error() string {
+	if e.Principal == "" {
+		return fmt.Sprintf("permission denied, route: %s, reason: %s", e.Route, e.Reason)
+	}
+	return fmt.Sprintf("permission denied, route: %s, principal: %s, reason: %s", e.Route, e.Principal, e.Reason)
+}
+
+// Is reports whether target is ErrPermissionDenied
+func (e *PermissionDenied) Is(target error) bool {
+	return target == ErrPermissionDenied
+}
+
+// Authorizer decides whether the principal is permitted to send the message of route
+type Authorizer interface {
+	// Authorize returns nil if permitted, principal is nil if the channel is not authenticated.
+	Authorize(ctx context.Context, principal *auth.Principal, route string, message interface{}) error
+}
+
+// AuthorizerFunc is an adapter to allow the use of ordinary functions as Authorizer
+type AuthorizerFunc func(ctx context.Context, principal *auth.Principal, route string, message interface{}) error
+
+// Authorize calls f(ctx, principal, route, message)
+func (f AuthorizerFunc) Authorize(ctx context.Context, principal *auth.Principal, route string, message interface{}) error {
+	return f(ctx, principal, route, message)
+}
+
+// KeyFunc extracts the route of message
+type KeyFunc func(message interface{}) (string, error)
+
+// TypeKey is the KeyFunc of type router, the route is the type name of message without pointer,
+// such as "main.Login"
+func TypeKey(message interface{}) (string, error) {
+	if message == nil {
+		return "", errors.New("nil message")
+	}
+	typ := reflect.TypeOf(message)
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return typ.String(), nil
+}
+
+// CommandKey returns the KeyFunc of command router, the route is the formatted route key
+func CommandKey[K comparable](key router.KeyFunc[K]) KeyFunc {
+	return func(message interface{}) (string, error) {
+		k, err := key(message)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprint(k), nil
+	}
+}
+
+type options struct {
+	key   KeyFunc
+	reply func(err *PermissionDenied) interface{}
+}
+
+// Option sets the authorization middleware options
+type Option func(ops *options)
+
+// WithKey sets the KeyFunc extracting the route of message, TypeKey by default
+func WithKey(key KeyFunc) Option {
+	return func(ops *options) {
+		if key != nil {
+			ops.key = key
+		}
+	}
+}
+
+// WithReply sets the function returns the reply message which will be sent to the peer when
+// a message denied, the *PermissionDenied is replied by default, nil means do not reply
+func WithReply(reply func(err *PermissionDenied) interface{}) Option {
+	return func(ops *options) {
+		ops.reply = reply
+	}
+}
+
+// Middleware returns an inbound middleware which drops the messages denied by authorizer
+func Middleware(authorizer Authorizer, op ...Option) less.Middleware {
+	ops := &options{
+		key:   TypeKey,
+		reply: func(err *PermissionDenied) interface{} { return err },
+	}
+	for _, o := range op {
+		o(ops)
+	}
+
+	return func(next less.Handler) less.Handler {
+		return func(ctx context.Context, ch less.Channel, message interface{}) error {
+			route, err := ops.key(message)
+			if err != nil {
+				return err
+			}
+			principal, _ := auth.FromContext(ctx)
+			err = authorizer.Authorize(ctx, principal, route, message)
+			if err == nil {
+				return next(ctx, ch, message)
+			}
+
+			var denied *PermissionDenied
+			if !errors.As(err, &denied) {
+				denied = &PermissionDenied{Route: route, Reason: err.Error()}
+				if principal != nil {
+					denied.Principal = principal.Name
+				}
+			}
+			log.Debugw("remote", ch.RemoteAddr().String(), log.DefaultMsgKey, "message denied", "err", denied)
+			if ops.reply != nil {
+				if reply := ops.reply(denied); reply != nil {
+					if err = ch.Write(reply); err != nil {
+						log.Warnw("remote", ch.RemoteAddr().String(), log.DefaultMsgKey, "reply permission denied failed", "err", err)
+					}
+				}
+			}
+			return nil
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package authz
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/emove/less"
+	"github.com/emove/less/auth"
+)
+
+type fakeChannel struct {
+	less.Channel
+	written []interface{}
+}
+
+func (ch *fakeChannel) RemoteAddr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+func (ch *fakeChannel) Write(message interface{}) error {
+	ch.written = append(ch.written, message)
+	return nil
+}
+
+// authenticated returns the context of channel authenticated as principal
+func authenticated(t *testing.T, principal *auth.Principal) context.Context {
+	a := auth.New(auth.AuthenticatorFunc(func(ctx context.Context, ch less.Channel) (auth.Session, *auth.Principal, error) {
+		return nil, principal, nil
+	}))
+	ctx, err := a.OnChannel(context.Background(), &fakeChannel{})
+	if err != nil {
+		t.Fatalf("OnChannel() error = %v", err)
+	}
+	return ctx
+}
+
+type kick struct{}
+
+type chat struct{}
+
+func TestPolicyAuthorizer(t *testing.T) {
+	pa, err := NewPolicyAuthorizer(Policy{Rules: []Rule{
+		{Route: "admin.*", Roles: []string{"admin"}},
+		{Route: "chat", Roles: []string{AnyRole}},
+		{Route: "banned", Roles: nil},
+	}})
+	if err != nil {
+		t.Fatalf("NewPolicyAuthorizer() error = %v", err)
+	}
+
+	admin := &auth.Principal{Name: "alice", Roles: []string{"admin"}}
+	user := &auth.Principal{Name: "bob"}
+	tests := []struct {
+		principal *auth.Principal
+		route     string
+		allowed   bool
+	}{
+		{principal: admin, route: "admin.kick", allowed: true},
+		{principal: user, route: "admin.kick"},
+		{principal: nil, route: "admin.kick"},
+		{principal: user, route: "chat", allowed: true},
+		{principal: nil, route: "chat"},
+		{principal: admin, route: "banned"},
+		{principal: admin, route: "unknown"},
+	}
+	for _, tt := range tests {
+		err := pa.Authorize(context.Background(), tt.principal, tt.route, nil)
+		if (err == nil) != tt.allowed || (err != nil && !errors.Is(err, ErrPermissionDenied)) {
+			t.Fatalf("route: %s, principal: %v, want allowed: %v, but: %v", tt.route, tt.principal, tt.allowed, err)
+		}
+	}
+
+	if err = pa.Update(Policy{Default: Allow}); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if err = pa.Authorize(context.Background(), nil, "unknown", nil); err != nil {
+		t.Fatalf("the unmatched route should be allowed, but: %v", err)
+	}
+	if err = pa.Update(Policy{Rules: []Rule{{Route: "["}}}); err == nil {
+		t.Fatalf("the invalid pattern should be rejected")
+	}
+	if pa.Policy().Default != Allow {
+		t.Fatalf("the policy should be kept")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	pa, _ := NewPolicyAuthorizer(Policy{Rules: []Rule{
+		{Route: "authz.kick", Roles: []string{"admin"}},
+		{Route: "authz.*", Roles: []string{AnyRole}},
+	}})
+
+	var handled []interface{}
+	handler := func(ctx context.Context, ch less.Channel, message interface{}) error {
+		handled = append(handled, message)
+		return nil
+	}
+	ch := &fakeChannel{}
+	h := Middleware(pa)(handler)
+	ctx := authenticated(t, &auth.Principal{Name: "bob"})
+	for _, msg := range []interface{}{&chat{}, &kick{}} {
+		if err := h(ctx, ch, msg); err != nil {
+			t.Fatalf("handler error = %v", err)
+		}
+	}
+	if len(handled) != 1 {
+		t.Fatalf("want 1 handled message, but: %v", handled)
+	}
+	denied, ok := ch.written[0].(*PermissionDenied)
+	if !ok || denied.Route != "authz.kick" || denied.Principal != "bob" {
+		t.Fatalf("want *PermissionDenied replied, but: %v", ch.written)
+	}
+
+	// command router
+	ch = &fakeChannel{}
+	h = Middleware(AuthorizerFunc(func(ctx context.Context, principal *auth.Principal, route string, message interface{}) error {
+		if route != "1" {
+			return errors.New("unknown command")
+		}
+		return nil
+	}), WithKey(CommandKey(func(msg interface{}) (uint16, error) {
+		return msg.(uint16), nil
+	})), WithReply(func(err *PermissionDenied) interface{} { return err.Error() }))(handler)
+	_ = h(ctx, ch, uint16(1))
+	_ = h(ctx, ch, uint16(2))
+	if len(handled) != 2 || len(ch.written) != 1 ||
+		ch.written[0] != "permission denied, route: 2, principal: bob, reason: unknown command" {
+		t.Fatalf("unexpected result, handled: %v, replied: %v", handled, ch.written)
+	}
+}
+
+func TestPolicyAuthorizer_Watch(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "policy.json")
+	write := func(content string, modTime time.Time) {
+		if err := os.WriteFile(name, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		_ = os.Chtimes(name, modTime, modTime)
+	}
+	write(`{"rules": [{"route": "*", "roles": ["admin"]}]}`, time.Now().Add(-time.Hour))
+
+	pa, _ := NewPolicyAuthorizer(Policy{})
+	stop, err := pa.Watch(name, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	defer stop()
+
+	user := &auth.Principal{Name: "bob", Roles: []string{"user"}}
+	if err = pa.Authorize(context.Background(), user, "chat", nil); err == nil {
+		t.Fatalf("should be denied by the loaded policy")
+	}
+
+	write(`{"default": "allow"}`, time.Now())
+	deadline := time.Now().Add(time.Second)
+	for pa.Authorize(context.Background(), user, "chat", nil) != nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("the policy file should be reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
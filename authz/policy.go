@@ -0,0 +1,170 @@
+package authz
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/emove/less/auth"
+	"github.com/emove/less/internal/timewheel"
+	"github.com/emove/less/log"
+)
+
+// Effect is the decision of policy when no rule matches the route
+type Effect string
+
+const (
+	// Deny denies the unmatched routes, it's the default effect
+	Deny Effect = "deny"
+	// Allow permits the unmatched routes, even if the channel is not authenticated
+	Allow Effect = "allow"
+)
+
+// AnyRole matches any authenticated principal
+const AnyRole = "*"
+
+// Rule grants the roles to send the messages whose route matches the pattern
+type Rule struct {
+	// Route is the pattern of route, with the syntax of path.Match, such as "admin.*"
+	Route string `json:"route"`
+	// Roles are the roles permitted, AnyRole permits any authenticated principal, empty denies all
+	Roles []string `json:"roles"`
+}
+
+// Policy is a set of role based rules, the first rule matching the route decides
+type Policy struct {
+	// Default is the effect when no rule matches, Deny if empty
+	Default Effect `json:"default,omitempty"`
+	Rules   []Rule `json:"rules"`
+}
+
+// validate checks the patterns and effect of policy
+func (p Policy) validate() error {
+	switch p.Default {
+	case "", Deny, Allow:
+	default:
+		return fmt.Errorf("authz: unknown default effect %q", p.Default)
+	}
+	for _, r := range p.Rules {
+		if _, err := path.Match(r.Route, ""); err != nil {
+			return fmt.Errorf("authz: invalid route pattern %q: %w", r.Route, err)
+		}
+	}
+	return nil
+}
+
+// LoadPolicyFile reads the policy from a JSON file
+func LoadPolicyFile(name string) (Policy, error) {
+	var p Policy
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return p, err
+	}
+	if err = json.Unmarshal(data, &p); err != nil {
+		return p, fmt.Errorf("authz: parse policy file %s: %w", name, err)
+	}
+	return p, p.validate()
+}
+
+// PolicyAuthorizer is an Authorizer by Policy, the policy could be replaced at runtime
+type PolicyAuthorizer struct {
+	policy atomic.Value // Policy
+}
+
+var _ Authorizer = (*PolicyAuthorizer)(nil)
+
+// NewPolicyAuthorizer returns a PolicyAuthorizer, it returns an error if the policy is invalid
+func NewPolicyAuthorizer(policy Policy) (*PolicyAuthorizer, error) {
+	pa := &PolicyAuthorizer{}
+	if err := pa.Update(policy); err != nil {
+		return nil, err
+	}
+	return pa, nil
+}
+
+// Update replaces the policy, the current policy is kept if the new one is invalid
+func (pa *PolicyAuthorizer) Update(policy Policy) error {
+	if err := policy.validate(); err != nil {
+		return err
+	}
+	pa.policy.Store(policy)
+	return nil
+}
+
+// Policy returns the current policy
+func (pa *PolicyAuthorizer) Policy() Policy {
+	p, _ := pa.policy.Load().(Policy)
+	return p
+}
+
+// Authorize implements Authorizer
+func (pa *PolicyAuthorizer) Authorize(_ context.Context, principal *auth.Principal, route string, _ interface{}) error {
+	policy := pa.Policy()
+	denied := func(reason string) error {
+		e := &PermissionDenied{Route: route, Reason: reason}
+		if principal != nil {
+			e.Principal = principal.Name
+		}
+		return e
+	}
+
+	for _, r := range policy.Rules {
+		if ok, _ := path.Match(r.Route, route); !ok {
+			continue
+		}
+		if principal == nil {
+			return denied("unauthenticated")
+		}
+		for _, role := range r.Roles {
+			if role == AnyRole || principal.HasRole(role) {
+				return nil
+			}
+		}
+		return denied("role required")
+	}
+
+	if policy.Default == Allow {
+		return nil
+	}
+	return denied("no rule matches")
+}
+
+// Watch loads the policy file, and reloads it every interval if modified. The current policy
+// is kept if the modified file is invalid. It returns a function to stop watching.
+func (pa *PolicyAuthorizer) Watch(name string, interval time.Duration) (stop func(), err error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := LoadPolicyFile(name)
+	if err != nil {
+		return nil, err
+	}
+	pa.policy.Store(policy)
+
+	var mu sync.Mutex
+	modTime := info.ModTime()
+	node := timewheel.Timer.ScheduleFunc(interval, func() {
+		mu.Lock()
+		defer mu.Unlock()
+
+		info, err := os.Stat(name)
+		if err != nil || info.ModTime().Equal(modTime) {
+			return
+		}
+		modTime = info.ModTime()
+		policy, err := LoadPolicyFile(name)
+		if err != nil {
+			log.Warnf("reload policy file %s failed, err: %v", name, err)
+			return
+		}
+		pa.policy.Store(policy)
+		log.Infof("policy file %s reloaded", name)
+	})
+	return node.Stop, nil
+}
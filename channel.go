@@ -3,6 +3,9 @@ package less
 import (
 	"context"
 	"net"
+	"time"
+
+	"github.com/emove/less/codec"
 )
 
 type (
@@ -44,6 +47,26 @@ type Channel interface {
 	// Writeable returns the channel writeable or not
 	Writeable() bool
 
+	// PauseRead stops reading from the peer until ResumeRead called, the paused
+	// channel will not be regarded as an idle or dead channel by keepalive.
+	PauseRead()
+
+	// ResumeRead resumes reading from the peer.
+	ResumeRead()
+
+	// ReadPaused returns the channel reading paused or not
+	ReadPaused() bool
+
+	// SetReadDeadline sets the deadline for reading from the peer, the channel will be
+	// closed with a transport.TimeoutError when exceeded. A zero value for t means
+	// reading will not time out.
+	SetReadDeadline(t time.Time) error
+
+	// SetWriteDeadline sets the deadline for writing to the peer, the channel will be
+	// closed with a transport.TimeoutError when exceeded. A zero value for t means
+	// writing will not time out.
+	SetWriteDeadline(t time.Time) error
+
 	// AddOnChannelClosed adds OnChannelClosed hooks for this channel.
 	AddOnChannelClosed(onChannelClosed ...OnChannelClosed)
 
@@ -52,4 +75,17 @@ type Channel interface {
 
 	// AddOutboundMiddleware adds outbound Middleware for this channel.
 	AddOutboundMiddleware(mw ...Middleware)
+
+	// SetCodec replaces the codecs of this channel, a nil codec keeps the current one. It's used
+	// in OnChannel hooks to choose the codecs, the new codecs encode the messages written after,
+	// and decode the packets whose first byte is received after.
+	SetCodec(packetCodec codec.PacketCodec, payloadCodec codec.PayloadCodec)
+
+	// UpgradeCodec replaces the codecs of this channel mid-stream, a nil codec keeps the current one.
+	// The reply is written with the current codecs, and the packets received after the reply written
+	// are decoded with the new codecs, so the peer can switch codecs as soon as the reply received.
+	UpgradeCodec(reply interface{}, packetCodec codec.PacketCodec, payloadCodec codec.PayloadCodec) error
+
+	// Codec returns the packet codec and payload codec of this channel.
+	Codec() (codec.PacketCodec, codec.PayloadCodec)
 }
@@ -0,0 +1,563 @@
+// Package aead encrypts payloads end to end with AES-GCM or ChaCha20-Poly1305, so that the
+// messages stay confidential when crossing relays which terminate TLS.
+//
+// The keys are established per channel by an X25519 key exchange. Handshake returns an OnChannel
+// hook which replaces the payload codec of channel with an encrypting decorator of it:
+//
+//	srv := server.NewServer(addr, server.WithOnChannel(aead.Handshake(aead.WithPSK(psk))))
+//
+// Each side sends a Hello carrying its ephemeral public key before its first message, or in
+// reply to the Hello of peer, and the messages are held until the Hello of peer received.
+// Without a pre-shared key the exchange is not authenticated, an active man in the middle is
+// able to decrypt the traffic, so setting the same PSK on both sides is recommended.
+//
+// Every payload carries the key epoch and a sequence number which is the nonce of AEAD, the
+// payloads with reused sequence numbers are rejected as replays. The keys are rotated after a
+// number of messages, both sides derive the next key from the current one.
+package aead
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	stdio "io"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+
+	"github.com/emove/less/codec"
+	"github.com/emove/less/pkg/io"
+	"github.com/emove/less/pkg/io/reader"
+	"github.com/emove/less/pkg/io/writer"
+)
+
+var (
+	ErrUnencrypted        = errors.New("aead: unencrypted payload")
+	ErrHandshakeTimeout   = errors.New("aead: handshake timeout")
+	ErrUnexpectedHello    = errors.New("aead: unexpected hello")
+	ErrSuiteMismatch      = errors.New("aead: cipher suite mismatch")
+	ErrReplay             = errors.New("aead: replayed payload")
+	ErrEpoch              = errors.New("aead: unexpected key epoch")
+	ErrMalformed          = errors.New("aead: malformed payload")
+	ErrAuthenticateFailed = errors.New("aead: message authentication failed")
+)
+
+// Suite is the AEAD cipher suite
+type Suite byte
+
+const (
+	// AESGCM is AES-256-GCM
+	AESGCM Suite = iota + 1
+	// ChaCha20Poly1305 is ChaCha20-Poly1305
+	ChaCha20Poly1305
+)
+
+// String returns the name of suite
+func (s Suite) String() string {
+	switch s {
+	case AESGCM:
+		return "aes-256-gcm"
+	case ChaCha20Poly1305:
+		return "chacha20-poly1305"
+	default:
+		return "unknown"
+	}
+}
+
+func (s Suite) new(key []byte) (cipher.AEAD, error) {
+	switch s {
+	case AESGCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case ChaCha20Poly1305:
+		return chacha20poly1305.New(key)
+	default:
+		return nil, fmt.Errorf("aead: unknown suite %d", s)
+	}
+}
+
+const (
+	magic              uint32 = 0x4c414544 // "LAED"
+	version            byte   = 1
+	kindHello          byte   = 'H'
+	kindData           byte   = 'D'
+	keySize                   = 32
+	headerSize                = 4 + 1 + 4 + 8 // magic, kind, epoch, sequence
+	helloSize                 = 4 + 1 + 1 + 1 + curve25519.PointSize
+	nonceSize                 = 12
+	replayBlock               = 64
+	rotateLabel               = "less-aead rotate"
+	handshakeLabel            = "less-aead handshake"
+	defaultTimeout            = 10 * time.Second
+	defaultWindow             = 1024
+	defaultRotateAfter        = 1 << 20
+)
+
+type options struct {
+	suite            Suite
+	psk              []byte
+	rotateAfter      uint64
+	handshakeTimeout time.Duration
+	replayWindow     uint64
+}
+
+// Option sets aead codec options
+type Option func(ops *options)
+
+// WithSuite sets the cipher suite, AES-256-GCM by default. Both sides should use the same suite.
+func WithSuite(suite Suite) Option {
+	return func(ops *options) {
+		ops.suite = suite
+	}
+}
+
+// WithPSK sets the pre-shared key mixed into the key derivation, which authenticates the peer
+func WithPSK(psk []byte) Option {
+	return func(ops *options) {
+		ops.psk = psk
+	}
+}
+
+// WithRotateAfter sets the number of messages encrypted by a key before rotating, 1<<20 by default.
+// Zero means never rotate.
+func WithRotateAfter(messages uint64) Option {
+	return func(ops *options) {
+		ops.rotateAfter = messages
+	}
+}
+
+// WithHandshakeTimeout sets the maximum duration of waiting for the Hello of peer, 10 seconds by default
+func WithHandshakeTimeout(d time.Duration) Option {
+	return func(ops *options) {
+		ops.handshakeTimeout = d
+	}
+}
+
+// WithReplayWindow sets the number of recent sequence numbers tracked for replay rejection,
+// 1024 by default. The payloads written concurrently may arrive out of order within the window.
+func WithReplayWindow(size uint64) Option {
+	return func(ops *options) {
+		if size > 0 {
+			ops.replayWindow = size
+		}
+	}
+}
+
+func newOptions(op ...Option) *options {
+	ops := &options{
+		suite:            AESGCM,
+		rotateAfter:      defaultRotateAfter,
+		handshakeTimeout: defaultTimeout,
+		replayWindow:     defaultWindow,
+	}
+	for _, o := range op {
+		o(ops)
+	}
+	// rounds up to blocks of bitmap
+	ops.replayWindow = (ops.replayWindow + replayBlock - 1) / replayBlock * replayBlock
+	return ops
+}
+
+// Hello carries the ephemeral public key, it's exchanged before the encrypted payloads
+type Hello struct {
+	Suite     Suite
+	PublicKey []byte
+}
+
+// Codec is a payload codec which encrypts the payloads marshaled by the inner codec,
+// each channel owns a Codec with its keys.
+type Codec struct {
+	inner      codec.PayloadCodec
+	ops        *options
+	privateKey []byte
+	hello      *Hello
+	ready      chan struct{} // closed after the keys established
+
+	sendMu sync.Mutex // guard the following
+	send   *sendState
+	rotate bool
+
+	recvMu sync.Mutex // guard the following
+	recv   *recvState
+}
+
+type sendState struct {
+	key   []byte
+	aead  cipher.AEAD
+	epoch uint32
+	seq   uint64
+	count uint64 // the number of messages encrypted by the current key
+}
+
+type recvState struct {
+	key    []byte
+	aead   cipher.AEAD
+	prev   cipher.AEAD // decrypts the payloads encrypted before rotation
+	epoch  uint32
+	replay *replayWindow
+}
+
+// NewCodec returns a Codec with an ephemeral X25519 key, the Hello returned by Codec.Hello should be
+// sent to the peer before any other messages, and the Hello of peer should be unmarshalled by it
+func NewCodec(inner codec.PayloadCodec, op ...Option) (*Codec, error) {
+	if inner == nil {
+		panic("inner codec can not be nil")
+	}
+	ops := newOptions(op...)
+	if _, err := ops.suite.new(make([]byte, keySize)); err != nil {
+		return nil, err
+	}
+
+	privateKey := make([]byte, curve25519.ScalarSize)
+	if _, err := stdio.ReadFull(rand.Reader, privateKey); err != nil {
+		return nil, err
+	}
+	publicKey, err := curve25519.X25519(privateKey, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	return &Codec{
+		inner:      inner,
+		ops:        ops,
+		privateKey: privateKey,
+		hello:      &Hello{Suite: ops.suite, PublicKey: publicKey},
+		ready:      make(chan struct{}),
+	}, nil
+}
+
+var _ codec.PayloadCodec = (*Codec)(nil)
+
+// Hello returns the Hello of this side
+func (c *Codec) Hello() *Hello {
+	return c.hello
+}
+
+// Established returns whether the keys have been established
+func (c *Codec) Established() bool {
+	select {
+	case <-c.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// Rotate rotates the sending key before the next message
+func (c *Codec) Rotate() {
+	c.sendMu.Lock()
+	c.rotate = true
+	c.sendMu.Unlock()
+}
+
+func (c *Codec) Name() string {
+	return "aead-" + c.inner.Name()
+}
+
+func (c *Codec) Marshal(message interface{}, w io.Writer) (err error) {
+	if hello, ok := message.(*Hello); ok {
+		buf := make([]byte, helloSize)
+		binary.BigEndian.PutUint32(buf, magic)
+		buf[4], buf[5], buf[6] = kindHello, version, byte(hello.Suite)
+		copy(buf[7:], hello.PublicKey)
+		_, err = w.Write(buf)
+		return err
+	}
+
+	if err = c.awaitReady(); err != nil {
+		return err
+	}
+
+	buff := &bytes.Buffer{}
+	bw := writer.NewBufferWriter(buff)
+	if err = c.inner.Marshal(message, bw); err != nil {
+		return err
+	}
+	if err = bw.Flush(); err != nil {
+		return err
+	}
+	bw.Release()
+
+	header, sealed, err := c.seal(buff.Bytes())
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(header); err != nil {
+		return err
+	}
+	_, err = w.Write(sealed)
+	return err
+}
+
+// seal encrypts the plaintext in place, returns the header and the sealed payload
+func (c *Codec) seal(plaintext []byte) (header, sealed []byte, err error) {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	s := c.send
+	if c.rotate || (c.ops.rotateAfter > 0 && s.count >= c.ops.rotateAfter) {
+		key, aead, err := c.nextKey(s.key)
+		if err != nil {
+			return nil, nil, err
+		}
+		s.key, s.aead = key, aead
+		s.epoch++
+		s.count = 0
+		c.rotate = false
+	}
+	s.seq++
+	s.count++
+
+	header = make([]byte, headerSize)
+	binary.BigEndian.PutUint32(header, magic)
+	header[4] = kindData
+	binary.BigEndian.PutUint32(header[5:9], s.epoch)
+	binary.BigEndian.PutUint64(header[9:], s.seq)
+
+	return header, s.aead.Seal(plaintext[:0], nonce(s.seq), plaintext, header), nil
+}
+
+func (c *Codec) UnMarshal(r io.Reader) (message interface{}, err error) {
+	if r.Length() < 5 {
+		return nil, ErrUnencrypted
+	}
+	head, err := r.Peek(5)
+	if err != nil {
+		return nil, err
+	}
+	if binary.BigEndian.Uint32(head) != magic {
+		return nil, ErrUnencrypted
+	}
+	switch head[4] {
+	case kindHello:
+		return c.recvHello(r)
+	case kindData:
+		return c.open(r)
+	default:
+		return nil, fmt.Errorf("%w, kind: %d", ErrMalformed, head[4])
+	}
+}
+
+// recvHello receives the Hello of peer and establishes the keys
+func (c *Codec) recvHello(r io.Reader) (*Hello, error) {
+	if r.Length() != helloSize {
+		return nil, ErrMalformed
+	}
+	buf, err := r.Next(helloSize)
+	if err != nil {
+		return nil, err
+	}
+	if buf[5] != version {
+		return nil, fmt.Errorf("%w, unsupported version: %d", ErrMalformed, buf[5])
+	}
+	hello := &Hello{Suite: Suite(buf[6]), PublicKey: append([]byte(nil), buf[7:]...)}
+	if hello.Suite != c.ops.suite {
+		return nil, fmt.Errorf("%w, local: %s, remote: %s", ErrSuiteMismatch, c.ops.suite, hello.Suite)
+	}
+	if c.Established() {
+		return nil, ErrUnexpectedHello
+	}
+
+	sendKey, recvKey, err := c.deriveKeys(hello.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	sendAEAD, err := c.ops.suite.new(sendKey)
+	if err != nil {
+		return nil, err
+	}
+	recvAEAD, err := c.ops.suite.new(recvKey)
+	if err != nil {
+		return nil, err
+	}
+	c.send = &sendState{key: sendKey, aead: sendAEAD}
+	c.recv = &recvState{key: recvKey, aead: recvAEAD, replay: newReplayWindow(c.ops.replayWindow)}
+	close(c.ready)
+	return hello, nil
+}
+
+// deriveKeys derives the keys of both directions from the shared secret
+func (c *Codec) deriveKeys(peerKey []byte) (sendKey, recvKey []byte, err error) {
+	secret, err := curve25519.X25519(c.privateKey, peerKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// both sides order the public keys in the same way, the side with the smaller
+	// public key sends with the first key
+	local := c.hello.PublicKey
+	first, second := local, peerKey
+	if bytes.Compare(local, peerKey) > 0 {
+		first, second = peerKey, local
+	}
+	info := append([]byte(handshakeLabel), first...)
+	info = append(info, second...)
+
+	keys := make([]byte, 2*keySize)
+	if _, err = stdio.ReadFull(hkdf.New(sha256.New, secret, c.ops.psk, info), keys); err != nil {
+		return nil, nil, err
+	}
+	if bytes.Equal(first, local) {
+		return keys[:keySize], keys[keySize:], nil
+	}
+	return keys[keySize:], keys[:keySize], nil
+}
+
+// nextKey derives the key of the next epoch
+func (c *Codec) nextKey(key []byte) ([]byte, cipher.AEAD, error) {
+	next := make([]byte, keySize)
+	if _, err := stdio.ReadFull(hkdf.New(sha256.New, key, nil, []byte(rotateLabel)), next); err != nil {
+		return nil, nil, err
+	}
+	aead, err := c.ops.suite.new(next)
+	return next, aead, err
+}
+
+// open decrypts the payload and unmarshals the plaintext by the inner codec
+func (c *Codec) open(r io.Reader) (interface{}, error) {
+	if !c.Established() {
+		return nil, fmt.Errorf("%w, received encrypted payload before hello", ErrMalformed)
+	}
+	buf, err := r.Next(r.Length())
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < headerSize {
+		return nil, ErrMalformed
+	}
+	header, sealed := buf[:headerSize], buf[headerSize:]
+	epoch := binary.BigEndian.Uint32(header[5:9])
+	seq := binary.BigEndian.Uint64(header[9:])
+
+	plaintext, err := c.decrypt(header, sealed, epoch, seq)
+	if err != nil {
+		return nil, err
+	}
+
+	br := reader.NewBufferReaderWithBuf(bytes.NewReader(plaintext), make([]byte, len(plaintext)))
+	defer br.Release()
+	return c.inner.UnMarshal(reader.NewLimitReader(br, uint32(len(plaintext))))
+}
+
+func (c *Codec) decrypt(header, sealed []byte, epoch uint32, seq uint64) ([]byte, error) {
+	c.recvMu.Lock()
+	defer c.recvMu.Unlock()
+
+	s := c.recv
+	if !s.replay.check(seq) {
+		return nil, fmt.Errorf("%w, sequence: %d", ErrReplay, seq)
+	}
+
+	var aead cipher.AEAD
+	var rotated bool
+	var key []byte
+	switch {
+	case epoch == s.epoch:
+		aead = s.aead
+	case epoch+1 == s.epoch && s.prev != nil:
+		aead = s.prev
+	case epoch == s.epoch+1:
+		var err error
+		if key, aead, err = c.nextKey(s.key); err != nil {
+			return nil, err
+		}
+		rotated = true
+	default:
+		return nil, fmt.Errorf("%w, local: %d, remote: %d", ErrEpoch, s.epoch, epoch)
+	}
+
+	plaintext, err := aead.Open(nil, nonce(seq), sealed, header)
+	if err != nil {
+		return nil, ErrAuthenticateFailed
+	}
+	// commits the state after the payload authenticated
+	s.replay.accept(seq)
+	if rotated {
+		s.prev, s.aead, s.key = s.aead, aead, key
+		s.epoch++
+	}
+	return plaintext, nil
+}
+
+// awaitReady waits for the keys established
+func (c *Codec) awaitReady() error {
+	if c.Established() {
+		return nil
+	}
+	timer := time.NewTimer(c.ops.handshakeTimeout)
+	defer timer.Stop()
+	select {
+	case <-c.ready:
+		return nil
+	case <-timer.C:
+		return ErrHandshakeTimeout
+	}
+}
+
+// nonce returns the nonce of sequence, the sequence numbers of a direction never repeat
+// and each direction has its own keys
+func nonce(seq uint64) []byte {
+	n := make([]byte, nonceSize)
+	binary.BigEndian.PutUint64(n[nonceSize-8:], seq)
+	return n
+}
+
+// replayWindow tracks the recent sequence numbers, the sequence numbers start from 1
+type replayWindow struct {
+	size   uint64
+	max    uint64
+	bitmap []uint64
+}
+
+func newReplayWindow(size uint64) *replayWindow {
+	// one more block, so that the block of max never overlaps the oldest block in window
+	return &replayWindow{size: size, bitmap: make([]uint64, size/replayBlock+1)}
+}
+
+// check returns false if seq has been accepted or it's too old
+func (w *replayWindow) check(seq uint64) bool {
+	if seq == 0 {
+		return false
+	}
+	if seq > w.max {
+		return true
+	}
+	if w.max-seq >= w.size {
+		return false
+	}
+	return w.bitmap[w.index(seq)]&w.bit(seq) == 0
+}
+
+// accept marks seq as received
+func (w *replayWindow) accept(seq uint64) {
+	if seq > w.max {
+		// clears the blocks slid in
+		cur, next, n := w.max/replayBlock, seq/replayBlock, uint64(len(w.bitmap))
+		if next-cur > n {
+			cur = next - n
+		}
+		for b := cur + 1; b <= next; b++ {
+			w.bitmap[b%n] = 0
+		}
+		w.max = seq
+	}
+	w.bitmap[w.index(seq)] |= w.bit(seq)
+}
+
+func (w *replayWindow) index(seq uint64) uint64 {
+	return seq / replayBlock % uint64(len(w.bitmap))
+}
+
+func (w *replayWindow) bit(seq uint64) uint64 {
+	return 1 << (seq % replayBlock)
+}
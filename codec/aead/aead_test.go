@@ -0,0 +1,222 @@
+package aead
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/emove/less"
+	"github.com/emove/less/codec/packet"
+	"github.com/emove/less/codec/payload"
+	"github.com/emove/less/internal/trans"
+	ior "github.com/emove/less/pkg/io/reader"
+	"github.com/emove/less/pkg/io/writer"
+	"github.com/emove/less/transport/tcp"
+)
+
+func marshal(t *testing.T, c *Codec, msg interface{}) []byte {
+	buff := &bytes.Buffer{}
+	w := writer.NewBufferWriter(buff)
+	if err := c.Marshal(msg, w); err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	_ = w.Flush()
+	return buff.Bytes()
+}
+
+func unmarshal(c *Codec, buf []byte) (interface{}, error) {
+	return c.UnMarshal(ior.NewLimitReader(ior.NewBufferReader(bytes.NewReader(buf)), uint32(len(buf))))
+}
+
+// pair returns two codecs whose keys have been established
+func pair(t *testing.T, aops, bops []Option) (a, b *Codec) {
+	var err error
+	if a, err = NewCodec(payload.NewTextCodec(), aops...); err != nil {
+		t.Fatalf("NewCodec() error = %v", err)
+	}
+	if b, err = NewCodec(payload.NewTextCodec(), bops...); err != nil {
+		t.Fatalf("NewCodec() error = %v", err)
+	}
+	if _, err = unmarshal(b, marshal(t, a, a.Hello())); err != nil {
+		t.Fatalf("UnMarshal(hello) error = %v", err)
+	}
+	if _, err = unmarshal(a, marshal(t, b, b.Hello())); err != nil {
+		t.Fatalf("UnMarshal(hello) error = %v", err)
+	}
+	return a, b
+}
+
+func TestCodec(t *testing.T) {
+	for _, suite := range []Suite{AESGCM, ChaCha20Poly1305} {
+		t.Run(suite.String(), func(t *testing.T) {
+			a, b := pair(t, []Option{WithSuite(suite)}, []Option{WithSuite(suite)})
+			for i, c := range []struct{ from, to *Codec }{{a, b}, {b, a}} {
+				buf := marshal(t, c.from, "secret message")
+				if bytes.Contains(buf, []byte("secret")) {
+					t.Fatalf("payload is not encrypted")
+				}
+				got, err := unmarshal(c.to, buf)
+				if err != nil || got != "secret message" {
+					t.Fatalf("direction %d: want: secret message, but: %v, err: %v", i, got, err)
+				}
+			}
+		})
+	}
+}
+
+func TestCodec_Reject(t *testing.T) {
+	a, b := pair(t, nil, nil)
+
+	// replay
+	buf := marshal(t, a, "once")
+	if _, err := unmarshal(b, buf); err != nil {
+		t.Fatalf("UnMarshal() error = %v", err)
+	}
+	if _, err := unmarshal(b, buf); !errors.Is(err, ErrReplay) {
+		t.Fatalf("want: %v, but: %v", ErrReplay, err)
+	}
+
+	// tampered
+	buf = marshal(t, a, "tampered")
+	buf[len(buf)-1] ^= 0xff
+	if _, err := unmarshal(b, buf); !errors.Is(err, ErrAuthenticateFailed) {
+		t.Fatalf("want: %v, but: %v", ErrAuthenticateFailed, err)
+	}
+
+	// unencrypted
+	if _, err := unmarshal(b, []byte("plain text")); !errors.Is(err, ErrUnencrypted) {
+		t.Fatalf("want: %v, but: %v", ErrUnencrypted, err)
+	}
+
+	// different pre-shared keys
+	a, b = pair(t, []Option{WithPSK([]byte("a"))}, []Option{WithPSK([]byte("b"))})
+	if _, err := unmarshal(b, marshal(t, a, "psk")); !errors.Is(err, ErrAuthenticateFailed) {
+		t.Fatalf("want: %v, but: %v", ErrAuthenticateFailed, err)
+	}
+
+	// the message is held until the handshake timeout
+	c, _ := NewCodec(payload.NewTextCodec(), WithHandshakeTimeout(10*time.Millisecond))
+	if err := c.Marshal("early", writer.NewBufferWriter(&bytes.Buffer{})); err != ErrHandshakeTimeout {
+		t.Fatalf("want: %v, but: %v", ErrHandshakeTimeout, err)
+	}
+}
+
+func TestCodec_OutOfOrderAndRotation(t *testing.T) {
+	a, b := pair(t, []Option{WithRotateAfter(2), WithReplayWindow(64)}, nil)
+
+	var bufs [][]byte
+	for i := 0; i < 5; i++ {
+		bufs = append(bufs, marshal(t, a, "message"))
+	}
+	a.Rotate()
+	bufs = append(bufs, marshal(t, a, "message"))
+
+	// the second message arrives after the third one, which is encrypted by the rotated key
+	for _, i := range []int{0, 2, 1, 3, 4, 5} {
+		if _, err := unmarshal(b, bufs[i]); err != nil {
+			t.Fatalf("UnMarshal(%d) error = %v", i, err)
+		}
+	}
+	if b.recv.epoch != 3 {
+		t.Fatalf("want epoch: 3, but: %d", b.recv.epoch)
+	}
+
+	// too old
+	for i := 0; i < 64; i++ {
+		if _, err := unmarshal(b, marshal(t, a, "message")); err != nil {
+			t.Fatalf("UnMarshal() error = %v", err)
+		}
+	}
+	if _, err := unmarshal(b, bufs[0]); !errors.Is(err, ErrReplay) {
+		t.Fatalf("want: %v, but: %v", ErrReplay, err)
+	}
+}
+
+func TestReplayWindow(t *testing.T) {
+	w := newReplayWindow(128)
+	for _, seq := range []uint64{1, 3, 2, 200, 100} {
+		if !w.check(seq) {
+			t.Fatalf("sequence %d should be accepted", seq)
+		}
+		w.accept(seq)
+	}
+	for _, seq := range []uint64{0, 1, 3, 200, 100, 72} {
+		if w.check(seq) {
+			t.Fatalf("sequence %d should be rejected", seq)
+		}
+	}
+	if !w.check(73) || !w.check(199) || !w.check(1000) {
+		t.Fatalf("the sequences in window should be accepted")
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	newHandler := func(ops ...trans.Option) trans.TransHandler {
+		return trans.NewTransHandler(append([]trans.Option{
+			trans.WithPacketCodec(packet.NewVariableLengthCodec()),
+			trans.WithPayloadCodec(payload.NewTextCodec()),
+			trans.AddOnChannel(Handshake(WithSuite(ChaCha20Poly1305), WithPSK([]byte("psk")))),
+		}, ops...)...)
+	}
+
+	server := newHandler(trans.WithRouter(func(ctx context.Context, ch less.Channel, msg interface{}) (less.Handler, error) {
+		return func(ctx context.Context, ch less.Channel, message interface{}) error {
+			return ch.Write("echo: " + message.(string))
+		}, nil
+	}))
+	defer server.Close(context.Background(), nil)
+
+	chs := make(chan less.Channel, 1)
+	replies := make(chan interface{}, 1)
+	client := newHandler(
+		trans.AddOnChannel(func(ctx context.Context, ch less.Channel) (context.Context, error) {
+			chs <- ch
+			return ctx, nil
+		}),
+		trans.WithRouter(func(ctx context.Context, ch less.Channel, msg interface{}) (less.Handler, error) {
+			return func(ctx context.Context, ch less.Channel, message interface{}) error {
+				replies <- message
+				return nil
+			}, nil
+		}),
+	)
+	defer client.Close(context.Background(), nil)
+
+	s, c := net.Pipe()
+	for _, p := range []struct {
+		th   trans.TransHandler
+		conn net.Conn
+	}{{server, s}, {client, c}} {
+		conn := tcp.WrapConnection(p.conn)
+		ctx, err := p.th.OnConnect(context.Background(), conn)
+		if err != nil {
+			t.Fatalf("OnConnect() error = %v", err)
+		}
+		th := p.th
+		go func() {
+			for th.OnMessage(ctx, conn) == nil {
+			}
+		}()
+	}
+
+	ch := <-chs
+	for _, msg := range []string{"hello", "world"} {
+		if err := ch.Write(msg); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		select {
+		case reply := <-replies:
+			if reply != "echo: "+msg {
+				t.Fatalf("want: %q, but: %v", "echo: "+msg, reply)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("wait for reply timeout")
+		}
+	}
+	if _, pc := ch.Codec(); !pc.(*Codec).Established() {
+		t.Fatalf("the keys should be established")
+	}
+}
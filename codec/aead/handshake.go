@@ -0,0 +1,61 @@
+package aead
+
+import (
+	"context"
+	"sync"
+
+	"github.com/emove/less"
+)
+
+// Handshake returns an OnChannel hook which replaces the payload codec of channel with a Codec
+// decorating it, and adds the middlewares exchanging the Hello with peer
+func Handshake(op ...Option) less.OnChannel {
+	return func(ctx context.Context, ch less.Channel) (context.Context, error) {
+		_, inner := ch.Codec()
+		c, err := NewCodec(inner, op...)
+		if err != nil {
+			return ctx, err
+		}
+		ch.SetCodec(nil, c)
+
+		h := &handshake{codec: c}
+		ch.AddInboundMiddleware(h.inbound)
+		ch.AddOutboundMiddleware(h.outbound)
+		return ctx, nil
+	}
+}
+
+// handshake sends the Hello once before the first message
+type handshake struct {
+	codec *Codec
+	once  sync.Once
+	err   error
+}
+
+// inbound replies the Hello of peer and drops it
+func (h *handshake) inbound(next less.Handler) less.Handler {
+	return func(ctx context.Context, ch less.Channel, message interface{}) error {
+		if _, ok := message.(*Hello); ok {
+			return ch.Write(h.codec.Hello())
+		}
+		return next(ctx, ch, message)
+	}
+}
+
+// outbound sends the Hello before the first message, the message will be encrypted after
+// the Hello of peer received
+func (h *handshake) outbound(next less.Handler) less.Handler {
+	return func(ctx context.Context, ch less.Channel, message interface{}) error {
+		h.once.Do(func() {
+			h.err = next(ctx, ch, h.codec.Hello())
+		})
+		if h.err != nil {
+			return h.err
+		}
+		if _, ok := message.(*Hello); ok {
+			// has been sent
+			return nil
+		}
+		return next(ctx, ch, message)
+	}
+}
@@ -15,3 +15,17 @@ type PayloadCodec interface {
 	Marshal(message interface{}, writer io.Writer) (err error)
 	UnMarshal(reader io.Reader) (message interface{}, err error)
 }
+
+// Ordered is implemented by the packet codecs whose peers expect the replies in the order of requests,
+// such as HTTP/1.1 with pipelining. The inbound messages decoded by an Ordered codec are handled one by
+// one in each channel, the next message is not read until the previous one handled.
+type Ordered interface {
+	// Ordered reports whether the inbound messages should be handled in order
+	Ordered() bool
+}
+
+// IsOrdered reports whether the packet codec requires the inbound messages handled in order
+func IsOrdered(packetCodec PacketCodec) bool {
+	o, ok := packetCodec.(Ordered)
+	return ok && o.Ordered()
+}
@@ -0,0 +1,439 @@
+// Package http implements an HTTP/1.1 packet codec, so a less server can answer plain
+// HTTP requests, such as health checks, without a separate net/http listener.
+package http
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	nethttp "net/http"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/emove/less"
+	"github.com/emove/less/codec"
+	"github.com/emove/less/pkg/io"
+	ior "github.com/emove/less/pkg/io/reader"
+	iow "github.com/emove/less/pkg/io/writer"
+)
+
+const (
+	defaultMaxHeaderBytes = 1 << 20
+	defaultMaxBodyBytes   = 4 << 20
+	// maxChunkLineBytes is the max bytes of chunk size line, and all trailers
+	maxChunkLineBytes = 4 << 10
+)
+
+var (
+	ErrMalformedRequest = errors.New("malformed http request")
+	ErrHeaderTooLarge   = errors.New("http header too large")
+	ErrBodyTooLarge     = errors.New("http body too large")
+	ErrNotResponse      = errors.New("message is not a *Response")
+	ErrNotRequest       = errors.New("message is not a *Request")
+)
+
+// Request is the decoded HTTP request
+type Request struct {
+	// Method is the request method, such as GET
+	Method string
+	// RequestURI is the unmodified request target of the request line
+	RequestURI string
+	// Proto is the protocol version, such as HTTP/1.1
+	Proto      string
+	ProtoMajor int
+	ProtoMinor int
+	// Header contains the request header fields with canonical keys
+	Header nethttp.Header
+	// Body is the request body unmarshalled by the payload codec, nil if the request has no body
+	Body interface{}
+	// ContentLength is the length of body, including the chunked body
+	ContentLength int
+	// Close reports whether the connection should be closed after replying to this request
+	Close bool
+}
+
+// KeepAlive reports whether the connection can be reused after replying to this request
+func (r *Request) KeepAlive() bool {
+	return !r.Close
+}
+
+// Response is the HTTP response to be encoded
+type Response struct {
+	// StatusCode is the status code, 200 if zero
+	StatusCode int
+	// Status is the reason phrase, uses the text of status code if empty
+	Status string
+	// ProtoMinor is the minor version of HTTP/1.x, 1 by default
+	ProtoMinor int
+	// Header contains the response header fields, Content-Length is set by the codec
+	Header nethttp.Header
+	// Body is marshaled by the payload codec, nil means no body
+	Body interface{}
+	// Close adds the Connection: close header, the channel should be closed after writing the response
+	Close bool
+
+	// noBody suppresses the body of the response to a HEAD request, the Content-Length is kept
+	noBody bool
+}
+
+// Option sets http codec options
+type Option func(hc *httpCodec)
+
+// WithMaxHeaderBytes sets the max bytes of request line and headers, 1MB by default
+func WithMaxHeaderBytes(n int) Option {
+	return func(hc *httpCodec) {
+		hc.maxHeaderBytes = n
+	}
+}
+
+// WithMaxBodyBytes sets the max bytes of request body, 4MB by default
+func WithMaxBodyBytes(n int) Option {
+	return func(hc *httpCodec) {
+		hc.maxBodyBytes = n
+	}
+}
+
+// NewCodec returns a HTTP/1.1 packet codec which decodes requests into *Request, and encodes *Response.
+// Bodies are unmarshalled and marshaled by the payload codec, such as payload.NewTextCodec. Both
+// Content-Length and chunked request bodies are supported.
+//
+// Pipelined requests are decoded one by one, the codec is codec.Ordered, so the requests of a channel
+// are handled one by one and the responses are written in the order of requests.
+func NewCodec(ops ...Option) codec.PacketCodec {
+	hc := &httpCodec{
+		maxHeaderBytes: defaultMaxHeaderBytes,
+		maxBodyBytes:   defaultMaxBodyBytes,
+	}
+	for _, op := range ops {
+		op(hc)
+	}
+	return hc
+}
+
+// Serve returns a Handler which invokes handler with the *Request message and writes the returned Response
+// to channel, and closes the channel after writing if the connection is not keep-alive. The returned Response
+// is not modified, so it could be shared. The body of the response to a HEAD request is not written.
+func Serve(handler func(ctx context.Context, req *Request) *Response) less.Handler {
+	return func(ctx context.Context, ch less.Channel, message interface{}) error {
+		req, ok := message.(*Request)
+		if !ok {
+			return ErrNotRequest
+		}
+		resp := &Response{StatusCode: nethttp.StatusNoContent}
+		if r := handler(ctx, req); r != nil {
+			// copies it due to the response may be shared by handler
+			*resp = *r
+		}
+		resp.noBody = req.Method == nethttp.MethodHead
+		if req.ProtoMajor == 1 && req.ProtoMinor == 0 {
+			resp.ProtoMinor = 0
+		} else {
+			resp.ProtoMinor = 1
+		}
+		if req.Close {
+			resp.Close = true
+		}
+		if err := ch.Write(resp); err != nil {
+			return err
+		}
+		if resp.Close {
+			return ch.Close(ctx, nil)
+		}
+		return nil
+	}
+}
+
+var _ codec.PacketCodec = (*httpCodec)(nil)
+
+type httpCodec struct {
+	maxHeaderBytes int
+	maxBodyBytes   int
+}
+
+func (*httpCodec) Name() string {
+	return "http-packet-codec"
+}
+
+// Ordered implements codec.Ordered, the responses must be written in the order of pipelined requests
+func (*httpCodec) Ordered() bool {
+	return true
+}
+
+func (hc *httpCodec) Encode(message interface{}, writer io.Writer, payloadCodec codec.PayloadCodec) (err error) {
+	resp, ok := message.(*Response)
+	if !ok {
+		return ErrNotResponse
+	}
+
+	// marshals body first to get the Content-Length
+	body := &bytes.Buffer{}
+	if resp.Body != nil {
+		bw := iow.NewBufferWriter(body)
+		if err = payloadCodec.Marshal(resp.Body, bw); err != nil {
+			return err
+		}
+		if err = bw.Flush(); err != nil {
+			return err
+		}
+		bw.Release()
+	}
+
+	code := resp.StatusCode
+	if code == 0 {
+		code = nethttp.StatusOK
+	}
+	status := resp.Status
+	if status == "" {
+		status = nethttp.StatusText(code)
+	}
+
+	head := &strings.Builder{}
+	fmt.Fprintf(head, "HTTP/1.%d %03d %s\r\n", resp.ProtoMinor, code, status)
+
+	keys := make([]string, 0, len(resp.Header))
+	for key := range resp.Header {
+		switch textproto.CanonicalMIMEHeaderKey(key) {
+		case "Content-Length", "Transfer-Encoding", "Connection":
+			// managed by the codec
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, value := range resp.Header[key] {
+			if strings.ContainsAny(value, "\r\n") {
+				return fmt.Errorf("invalid header value of %s: %q", key, value)
+			}
+			fmt.Fprintf(head, "%s: %s\r\n", key, value)
+		}
+	}
+	if code >= 200 && code != nethttp.StatusNoContent && code != nethttp.StatusNotModified {
+		fmt.Fprintf(head, "Content-Length: %d\r\n", body.Len())
+	}
+	if resp.Close {
+		head.WriteString("Connection: close\r\n")
+	} else if resp.ProtoMinor == 0 {
+		head.WriteString("Connection: keep-alive\r\n")
+	}
+	head.WriteString("\r\n")
+
+	if _, err = writer.Write([]byte(head.String())); err != nil {
+		return err
+	}
+	if body.Len() > 0 && !resp.noBody {
+		if _, err = writer.Write(body.Bytes()); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+func (hc *httpCodec) Decode(reader io.Reader, payloadCodec codec.PayloadCodec) (message interface{}, err error) {
+	p := &parser{r: reader, remain: hc.maxHeaderBytes}
+
+	// ignores the empty lines before request line, as RFC 7230 recommended
+	var line string
+	for line == "" {
+		if line, err = p.readLine(); err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := parseRequestLine(line)
+	if err != nil {
+		return nil, err
+	}
+	if req.Header, err = p.readHeader(); err != nil {
+		return nil, err
+	}
+	req.Close = shouldClose(req.ProtoMajor, req.ProtoMinor, req.Header)
+
+	chunked, length, err := bodyLength(req.Header)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case chunked:
+		body, err := p.readChunked(hc.maxBodyBytes)
+		if err != nil {
+			return nil, err
+		}
+		req.ContentLength = len(body)
+		if len(body) > 0 {
+			br := ior.NewBufferReaderWithBuf(bytes.NewReader(body), make([]byte, len(body)))
+			defer br.Release()
+			req.Body, err = unmarshalBody(br, len(body), payloadCodec)
+		}
+		return req, err
+	case length > 0:
+		if length > hc.maxBodyBytes {
+			return nil, fmt.Errorf("%w, length: %d, max: %d", ErrBodyTooLarge, length, hc.maxBodyBytes)
+		}
+		req.ContentLength = length
+		req.Body, err = unmarshalBody(reader, length, payloadCodec)
+		return req, err
+	}
+	return req, nil
+}
+
+func unmarshalBody(reader io.Reader, length int, payloadCodec codec.PayloadCodec) (interface{}, error) {
+	limitReader := ior.NewLimitReader(reader, uint32(length))
+	defer limitReader.Release()
+	return payloadCodec.UnMarshal(limitReader)
+}
+
+func parseRequestLine(line string) (*Request, error) {
+	method, rest, ok1 := strings.Cut(line, " ")
+	uri, proto, ok2 := strings.Cut(rest, " ")
+	if !ok1 || !ok2 || method == "" || uri == "" {
+		return nil, fmt.Errorf("%w, request line: %q", ErrMalformedRequest, line)
+	}
+	major, minor, ok := nethttp.ParseHTTPVersion(proto)
+	if !ok || major != 1 {
+		return nil, fmt.Errorf("%w, unsupported protocol: %q", ErrMalformedRequest, proto)
+	}
+	return &Request{Method: method, RequestURI: uri, Proto: proto, ProtoMajor: major, ProtoMinor: minor}, nil
+}
+
+// shouldClose reports whether the connection should be closed after the request
+func shouldClose(major, minor int, header nethttp.Header) bool {
+	for _, v := range header["Connection"] {
+		for _, token := range strings.Split(v, ",") {
+			switch strings.ToLower(strings.TrimSpace(token)) {
+			case "close":
+				return true
+			case "keep-alive":
+				if major == 1 && minor == 0 {
+					return false
+				}
+			}
+		}
+	}
+	// HTTP/1.0 closes the connection by default
+	return major == 1 && minor == 0
+}
+
+// bodyLength returns whether the body is chunked, or the Content-Length
+func bodyLength(header nethttp.Header) (chunked bool, length int, err error) {
+	if te := header["Transfer-Encoding"]; len(te) > 0 {
+		if len(header["Content-Length"]) > 0 {
+			// rejects the ambiguous request to prevent request smuggling
+			return false, 0, fmt.Errorf("%w, both Transfer-Encoding and Content-Length present", ErrMalformedRequest)
+		}
+		if len(te) != 1 || !strings.EqualFold(strings.TrimSpace(te[0]), "chunked") {
+			return false, 0, fmt.Errorf("%w, unsupported Transfer-Encoding: %v", ErrMalformedRequest, te)
+		}
+		return true, 0, nil
+	}
+
+	cl := header["Content-Length"]
+	if len(cl) == 0 {
+		return false, 0, nil
+	}
+	for _, v := range cl[1:] {
+		if v != cl[0] {
+			return false, 0, fmt.Errorf("%w, multiple Content-Length: %v", ErrMalformedRequest, cl)
+		}
+	}
+	length, err = strconv.Atoi(strings.TrimSpace(cl[0]))
+	if err != nil || length < 0 {
+		return false, 0, fmt.Errorf("%w, invalid Content-Length: %q", ErrMalformedRequest, cl[0])
+	}
+	return false, length, nil
+}
+
+type parser struct {
+	r      io.Reader
+	remain int // the remaining bytes of header can be read
+}
+
+// readLine reads a line terminated by CRLF or LF, returns the line without terminator
+func (p *parser) readLine() (string, error) {
+	for n := 1; ; n++ {
+		if n > p.remain {
+			return "", ErrHeaderTooLarge
+		}
+		buf, err := p.r.Peek(n)
+		if err != nil {
+			return "", err
+		}
+		if buf[n-1] != '\n' {
+			continue
+		}
+		end := n - 1
+		if end > 0 && buf[end-1] == '\r' {
+			end--
+		}
+		line := string(buf[:end])
+		p.remain -= n
+		return line, p.r.Skip(n)
+	}
+}
+
+func (p *parser) readHeader() (nethttp.Header, error) {
+	header := make(nethttp.Header)
+	for {
+		line, err := p.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if line == "" {
+			return header, nil
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("%w, header line: %q", ErrMalformedRequest, line)
+		}
+		key = textproto.CanonicalMIMEHeaderKey(key)
+		header[key] = append(header[key], strings.TrimSpace(value))
+	}
+}
+
+// readChunked reads the chunked body, chunk extensions and trailers are discarded
+func (p *parser) readChunked(max int) ([]byte, error) {
+	body := &bytes.Buffer{}
+	for {
+		p.remain = maxChunkLineBytes
+		line, err := p.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if i := strings.IndexByte(line, ';'); i >= 0 {
+			line = line[:i]
+		}
+		size, err := strconv.ParseUint(strings.TrimSpace(line), 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%w, invalid chunk size: %q", ErrMalformedRequest, line)
+		}
+		if size == 0 {
+			break
+		}
+		if body.Len()+int(size) > max {
+			return nil, fmt.Errorf("%w, max: %d", ErrBodyTooLarge, max)
+		}
+		chunk, err := p.r.Next(int(size) + 2)
+		if err != nil {
+			return nil, err
+		}
+		if chunk[size] != '\r' || chunk[size+1] != '\n' {
+			return nil, fmt.Errorf("%w, chunk not terminated by CRLF", ErrMalformedRequest)
+		}
+		body.Write(chunk[:size])
+	}
+
+	// trailers
+	for {
+		line, err := p.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if line == "" {
+			return body.Bytes(), nil
+		}
+	}
+}
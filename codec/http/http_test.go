@@ -0,0 +1,162 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	nethttp "net/http"
+	"reflect"
+	"testing"
+
+	"github.com/emove/less"
+	"github.com/emove/less/codec/payload"
+	ior "github.com/emove/less/pkg/io/reader"
+	"github.com/emove/less/pkg/io/writer"
+)
+
+func TestCodec_Decode(t *testing.T) {
+	pipelined := "GET /health HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+		"POST /echo HTTP/1.1\r\nContent-Length: 5\r\ncontent-type: text/plain\r\n\r\nhello" +
+		"POST /chunked HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5;ext=1\r\nhello\r\n6\r\n world\r\n0\r\nX-Trailer: 1\r\n\r\n" +
+		"GET / HTTP/1.0\r\n\r\n"
+
+	want := []*Request{
+		{Method: "GET", RequestURI: "/health", Proto: "HTTP/1.1", ProtoMajor: 1, ProtoMinor: 1,
+			Header: nethttp.Header{"Host": {"localhost"}}},
+		{Method: "POST", RequestURI: "/echo", Proto: "HTTP/1.1", ProtoMajor: 1, ProtoMinor: 1,
+			Header: nethttp.Header{"Content-Length": {"5"}, "Content-Type": {"text/plain"}}, Body: "hello", ContentLength: 5},
+		{Method: "POST", RequestURI: "/chunked", Proto: "HTTP/1.1", ProtoMajor: 1, ProtoMinor: 1,
+			Header: nethttp.Header{"Transfer-Encoding": {"chunked"}}, Body: "hello world", ContentLength: 11},
+		{Method: "GET", RequestURI: "/", Proto: "HTTP/1.0", ProtoMajor: 1, ProtoMinor: 0,
+			Header: nethttp.Header{}, Close: true},
+	}
+
+	hc := NewCodec()
+	r := ior.NewBufferReader(bytes.NewReader([]byte(pipelined)))
+	for i, w := range want {
+		got, err := hc.Decode(r, payload.NewTextCodec())
+		if err != nil {
+			t.Fatalf("Decode() #%d error = %v", i, err)
+		}
+		if !reflect.DeepEqual(got, w) {
+			t.Fatalf("Decode() #%d want: %+v, but: %+v", i, w, got)
+		}
+	}
+}
+
+func TestCodec_DecodeErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		ops     []Option
+		wantErr error
+	}{
+		{name: "bad request line", data: "GET\r\n\r\n", wantErr: ErrMalformedRequest},
+		{name: "unsupported protocol", data: "GET / HTTP/2.0\r\n\r\n", wantErr: ErrMalformedRequest},
+		{name: "smuggling", data: "POST / HTTP/1.1\r\nContent-Length: 1\r\nTransfer-Encoding: chunked\r\n\r\n", wantErr: ErrMalformedRequest},
+		{name: "header too large", data: "GET / HTTP/1.1\r\nX-Long: 0123456789\r\n\r\n", ops: []Option{WithMaxHeaderBytes(20)}, wantErr: ErrHeaderTooLarge},
+		{name: "body too large", data: "POST / HTTP/1.1\r\nContent-Length: 10\r\n\r\n0123456789", ops: []Option{WithMaxBodyBytes(5)}, wantErr: ErrBodyTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := ior.NewBufferReader(bytes.NewReader([]byte(tt.data)))
+			if _, err := NewCodec(tt.ops...).Decode(r, payload.NewTextCodec()); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("want: %v, but: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestCodec_Encode(t *testing.T) {
+	tests := []struct {
+		name string
+		resp *Response
+		want string
+	}{
+		{
+			name: "ok",
+			resp: &Response{ProtoMinor: 1, Header: nethttp.Header{"Content-Type": {"text/plain"}}, Body: "ok"},
+			want: "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 2\r\n\r\nok",
+		},
+		{
+			name: "close",
+			resp: &Response{StatusCode: nethttp.StatusNotFound, ProtoMinor: 1, Close: true},
+			want: "HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\nConnection: close\r\n\r\n",
+		},
+		{
+			name: "no content",
+			resp: &Response{StatusCode: nethttp.StatusNoContent, ProtoMinor: 0},
+			want: "HTTP/1.0 204 No Content\r\nConnection: keep-alive\r\n\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buff := &bytes.Buffer{}
+			if err := NewCodec().Encode(tt.resp, writer.NewBufferWriter(buff), payload.NewTextCodec()); err != nil {
+				t.Fatalf("Encode() error = %v", err)
+			}
+			if buff.String() != tt.want {
+				t.Fatalf("want: %q, but: %q", tt.want, buff.String())
+			}
+		})
+	}
+
+	if err := NewCodec().Encode("not a response", writer.NewBufferWriter(&bytes.Buffer{}), payload.NewTextCodec()); err != ErrNotResponse {
+		t.Fatalf("want: %v, but: %v", ErrNotResponse, err)
+	}
+}
+
+type fakeChannel struct {
+	less.Channel
+	written []interface{}
+	closed  bool
+}
+
+func (ch *fakeChannel) Write(message interface{}) error {
+	ch.written = append(ch.written, message)
+	return nil
+}
+
+func (ch *fakeChannel) Close(context.Context, error) error {
+	ch.closed = true
+	return nil
+}
+
+func TestServe(t *testing.T) {
+	shared := &Response{Header: nethttp.Header{"Content-Type": {"text/plain"}}, Body: "ok"}
+	handler := Serve(func(ctx context.Context, req *Request) *Response {
+		return shared
+	})
+
+	ch := &fakeChannel{}
+	requests := []*Request{
+		{Method: "GET", ProtoMajor: 1, ProtoMinor: 0, Close: true},
+		{Method: "HEAD", ProtoMajor: 1, ProtoMinor: 1},
+	}
+	for _, req := range requests {
+		if err := handler(context.Background(), ch, req); err != nil {
+			t.Fatalf("handle error = %v", err)
+		}
+	}
+	if shared.ProtoMinor != 0 || shared.Close || shared.noBody {
+		t.Fatalf("the shared response should not be modified: %+v", shared)
+	}
+	if !ch.closed {
+		t.Fatalf("the channel should be closed after replying to HTTP/1.0 request")
+	}
+
+	want := []string{
+		"HTTP/1.0 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 2\r\nConnection: close\r\n\r\nok",
+		// the body of HEAD response is suppressed
+		"HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 2\r\n\r\n",
+	}
+	for i, w := range want {
+		buff := &bytes.Buffer{}
+		if err := NewCodec().Encode(ch.written[i], writer.NewBufferWriter(buff), payload.NewTextCodec()); err != nil {
+			t.Fatalf("Encode() error = %v", err)
+		}
+		if buff.String() != w {
+			t.Fatalf("want: %q, but: %q", w, buff.String())
+		}
+	}
+}
@@ -0,0 +1,720 @@
+// Package mqtt implements the MQTT 3.1.1 and 5 packet codec, packets are decoded into
+// typed structs, such as *Connect and *Publish, so they can be routed by router.TypeRouter.
+package mqtt
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/emove/less"
+	"github.com/emove/less/codec"
+	"github.com/emove/less/keepalive"
+	"github.com/emove/less/pkg/io"
+)
+
+const (
+	// maxRemainingLength is the max value of remaining length, which is encoded in 4 bytes
+	maxRemainingLength = 268435455
+	// defaultMaxPacketSize is the default max packet size
+	defaultMaxPacketSize = 1 << 20
+)
+
+var (
+	ErrMalformedPacket   = errors.New("malformed mqtt packet")
+	ErrPacketTooLarge    = errors.New("mqtt packet size greater than max packet size")
+	ErrUnsupportedPacket = errors.New("unsupported mqtt packet")
+)
+
+// Option sets mqtt codec options
+type Option func(mc *mqttCodec)
+
+// WithVersion sets the protocol version of packets except CONNECT, which carries its own version.
+// Version311 by default, it's the version before Connect decoded if the codec set by Negotiate.
+func WithVersion(version byte) Option {
+	return func(mc *mqttCodec) {
+		mc.version = version
+	}
+}
+
+// WithMaxPacketSize sets the max size of packet, 1MB by default
+func WithMaxPacketSize(size int) Option {
+	return func(mc *mqttCodec) {
+		mc.maxPacketSize = size
+	}
+}
+
+// NewCodec returns a MQTT packet codec, the payload codec is not used due to the application
+// message of Publish is kept as []byte.
+//
+// The version of packets is not negotiated by the codec, which is shared by channels. A server serving
+// both 3.1.1 and 5 clients should use Negotiate to set a negotiating codec for each channel.
+func NewCodec(ops ...Option) codec.PacketCodec {
+	return newCodec(ops...)
+}
+
+func newCodec(ops ...Option) *mqttCodec {
+	mc := &mqttCodec{
+		version:       Version311,
+		maxPacketSize: defaultMaxPacketSize,
+	}
+	for _, op := range ops {
+		op(mc)
+	}
+	if mc.version != Version311 && mc.version != Version5 {
+		panic(fmt.Sprintf("unsupported mqtt version: %d", mc.version))
+	}
+	return mc
+}
+
+// Negotiate returns an OnChannel hook which sets a codec of its own for each channel. The codec
+// stores the ProtocolVersion of the Connect it decoded, and uses it to decode the packets received
+// after and encode the packets written after, the version of options is used before the Connect.
+func Negotiate(ops ...Option) less.OnChannel {
+	return func(ctx context.Context, ch less.Channel) (context.Context, error) {
+		mc := newCodec(ops...)
+		mc.negotiate = true
+		ch.SetCodec(mc, nil)
+		return ctx, nil
+	}
+}
+
+// HealthParams returns the keepalive health parameters for MQTT servers, which replies PINGRESP
+// to PINGREQ, and closes the channel if no packet received within one and a half times of the
+// keep alive, as the MQTT specification requires.
+func HealthParams(keepAlive time.Duration) *keepalive.HealthParams {
+	return &keepalive.HealthParams{
+		Time: keepAlive * 3 / 2,
+		Pong: &Pingresp{},
+		PingRecognizer: func(message interface{}) bool {
+			_, ok := message.(*Pingreq)
+			return ok
+		},
+		PongRecognizer: func(message interface{}) bool {
+			_, ok := message.(*Pingresp)
+			return ok
+		},
+	}
+}
+
+var _ codec.PacketCodec = (*mqttCodec)(nil)
+
+type mqttCodec struct {
+	version       byte
+	maxPacketSize int
+	negotiate     bool   // if true, the version of Connect is stored in negotiated
+	negotiated    uint32 // the negotiated version, 0 if no Connect decoded
+}
+
+// currentVersion returns the negotiated version if any, otherwise the version of options
+func (mc *mqttCodec) currentVersion() byte {
+	if v := atomic.LoadUint32(&mc.negotiated); v != 0 {
+		return byte(v)
+	}
+	return mc.version
+}
+
+func (*mqttCodec) Name() string {
+	return "mqtt-packet-codec"
+}
+
+func (mc *mqttCodec) Decode(reader io.Reader, _ codec.PayloadCodec) (message interface{}, err error) {
+	// peeks the fixed header
+	var remaining, shift int
+	var headerLength int
+	for i := 2; ; i++ {
+		if i > 5 {
+			return nil, fmt.Errorf("%w, remaining length exceeds 4 bytes", ErrMalformedPacket)
+		}
+		header, err := reader.Peek(i)
+		if err != nil {
+			return nil, err
+		}
+		b := header[i-1]
+		remaining |= int(b&0x7f) << shift
+		if b < 0x80 {
+			headerLength = i
+			break
+		}
+		shift += 7
+	}
+	if headerLength+remaining > mc.maxPacketSize {
+		return nil, fmt.Errorf("%w, size: %d, max: %d", ErrPacketTooLarge, headerLength+remaining, mc.maxPacketSize)
+	}
+
+	buf, err := reader.Next(headerLength + remaining)
+	if err != nil {
+		return nil, err
+	}
+
+	typ, flags := buf[0]>>4, buf[0]&0x0f
+	d := &decoder{buf: buf[headerLength:], version: mc.currentVersion()}
+	message = d.packet(typ, flags)
+	if d.err != nil {
+		return nil, d.err
+	}
+	if len(d.buf) > 0 {
+		return nil, fmt.Errorf("%w, %d bytes remained in packet type %d", ErrMalformedPacket, len(d.buf), typ)
+	}
+	if c, ok := message.(*Connect); ok && mc.negotiate {
+		atomic.StoreUint32(&mc.negotiated, uint32(c.ProtocolVersion))
+	}
+	return message, nil
+}
+
+func (mc *mqttCodec) Encode(message interface{}, writer io.Writer, _ codec.PayloadCodec) (err error) {
+	e := &encoder{version: mc.currentVersion()}
+	typ, flags := e.packet(message)
+	if e.err != nil {
+		return e.err
+	}
+	if len(e.buf) > maxRemainingLength {
+		return fmt.Errorf("%w, remaining length: %d", ErrPacketTooLarge, len(e.buf))
+	}
+
+	header := &encoder{buf: make([]byte, 0, 5)}
+	header.byte(typ<<4 | flags)
+	header.varint(len(e.buf))
+
+	if _, err = writer.Write(header.buf); err != nil {
+		return err
+	}
+	if len(e.buf) > 0 {
+		if _, err = writer.Write(e.buf); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+// ====================================== decoder ============================================ //
+
+// decoder decodes packet fields from buf, the first error is kept in err
+type decoder struct {
+	buf     []byte
+	version byte
+	err     error
+}
+
+func (d *decoder) fail(reason string) {
+	if d.err == nil {
+		d.err = fmt.Errorf("%w, %s", ErrMalformedPacket, reason)
+	}
+	d.buf = nil
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if len(d.buf) < n {
+		d.fail("unexpected end of packet")
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) byte() byte {
+	if b := d.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) uint16() uint16 {
+	if b := d.next(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *decoder) uint32() uint32 {
+	if b := d.next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *decoder) varint() int {
+	var v, shift int
+	for i := 0; i < 4; i++ {
+		b := d.byte()
+		if d.err != nil {
+			return 0
+		}
+		v |= int(b&0x7f) << shift
+		if b < 0x80 {
+			return v
+		}
+		shift += 7
+	}
+	d.fail("variable byte integer exceeds 4 bytes")
+	return 0
+}
+
+// binary returns a copy of the length prefixed binary data
+func (d *decoder) binary() []byte {
+	n := int(d.uint16())
+	b := d.next(n)
+	if b == nil {
+		return nil
+	}
+	return append(make([]byte, 0, n), b...)
+}
+
+func (d *decoder) string() string {
+	n := int(d.uint16())
+	b := d.next(n)
+	if b == nil {
+		return ""
+	}
+	if !utf8.Valid(b) {
+		d.fail("invalid utf-8 string")
+		return ""
+	}
+	return string(b)
+}
+
+func (d *decoder) v5() bool {
+	return d.version == Version5
+}
+
+func (d *decoder) packet(typ, flags byte) interface{} {
+	switch typ {
+	case PUBLISH:
+		return d.publish(flags)
+	case PUBREL, SUBSCRIBE, UNSUBSCRIBE:
+		if flags != 0x02 {
+			d.fail(fmt.Sprintf("invalid flags 0x%x of packet type %d", flags, typ))
+			return nil
+		}
+	default:
+		if flags != 0 {
+			d.fail(fmt.Sprintf("invalid flags 0x%x of packet type %d", flags, typ))
+			return nil
+		}
+	}
+
+	switch typ {
+	case CONNECT:
+		return d.connect()
+	case CONNACK:
+		p := &Connack{SessionPresent: d.byte()&0x01 == 1, ReasonCode: d.byte()}
+		if d.v5() && len(d.buf) > 0 {
+			p.Properties = d.properties()
+		}
+		return p
+	case PUBACK:
+		return (*Puback)(d.ack())
+	case PUBREC:
+		return (*Pubrec)(d.ack())
+	case PUBREL:
+		return (*Pubrel)(d.ack())
+	case PUBCOMP:
+		return (*Pubcomp)(d.ack())
+	case SUBSCRIBE:
+		return d.subscribe()
+	case SUBACK:
+		p := &Suback{PacketID: d.uint16()}
+		if d.v5() {
+			p.Properties = d.properties()
+		}
+		p.ReasonCodes = append([]byte{}, d.next(len(d.buf))...)
+		return p
+	case UNSUBSCRIBE:
+		p := &Unsubscribe{PacketID: d.uint16()}
+		if d.v5() {
+			p.Properties = d.properties()
+		}
+		for len(d.buf) > 0 && d.err == nil {
+			p.Topics = append(p.Topics, d.string())
+		}
+		if len(p.Topics) == 0 {
+			d.fail("unsubscribe without topic")
+		}
+		return p
+	case UNSUBACK:
+		p := &Unsuback{PacketID: d.uint16()}
+		if d.v5() {
+			p.Properties = d.properties()
+			p.ReasonCodes = append([]byte{}, d.next(len(d.buf))...)
+		}
+		return p
+	case PINGREQ:
+		return &Pingreq{}
+	case PINGRESP:
+		return &Pingresp{}
+	case DISCONNECT:
+		p := &Disconnect{}
+		if d.v5() && len(d.buf) > 0 {
+			p.ReasonCode = d.byte()
+			if len(d.buf) > 0 {
+				p.Properties = d.properties()
+			}
+		}
+		return p
+	case AUTH:
+		if !d.v5() {
+			break
+		}
+		p := &Auth{}
+		if len(d.buf) > 0 {
+			p.ReasonCode = d.byte()
+			if len(d.buf) > 0 {
+				p.Properties = d.properties()
+			}
+		}
+		return p
+	}
+	d.err = fmt.Errorf("%w, packet type: %d", ErrUnsupportedPacket, typ)
+	return nil
+}
+
+func (d *decoder) connect() *Connect {
+	p := &Connect{ProtocolName: d.string(), ProtocolVersion: d.byte()}
+	if d.err != nil {
+		return nil
+	}
+	if p.ProtocolName != "MQTT" || (p.ProtocolVersion != Version311 && p.ProtocolVersion != Version5) {
+		d.err = fmt.Errorf("%w, protocol: %s, version: %d", ErrUnsupportedPacket, p.ProtocolName, p.ProtocolVersion)
+		return nil
+	}
+	// the connect packet carries its own version
+	d.version = p.ProtocolVersion
+
+	flags := d.byte()
+	if flags&0x01 != 0 {
+		d.fail("reserved connect flag is set")
+		return nil
+	}
+	p.CleanStart = flags&0x02 != 0
+	p.KeepAlive = d.uint16()
+	if d.v5() {
+		p.Properties = d.properties()
+	}
+	p.ClientID = d.string()
+
+	if flags&0x04 != 0 {
+		w := &Will{QoS: flags >> 3 & 0x03, Retain: flags&0x20 != 0}
+		if d.v5() {
+			w.Properties = d.properties()
+		}
+		w.Topic = d.string()
+		w.Payload = d.binary()
+		p.Will = w
+	}
+	if flags&0x80 != 0 {
+		username := d.string()
+		p.Username = &username
+	}
+	if flags&0x40 != 0 {
+		p.Password = d.binary()
+		if p.Password == nil {
+			p.Password = []byte{}
+		}
+	}
+	return p
+}
+
+func (d *decoder) publish(flags byte) *Publish {
+	p := &Publish{Dup: flags&0x08 != 0, QoS: flags >> 1 & 0x03, Retain: flags&0x01 != 0}
+	if p.QoS > 2 {
+		d.fail("invalid publish qos 3")
+		return nil
+	}
+	p.Topic = d.string()
+	if p.QoS > 0 {
+		p.PacketID = d.uint16()
+	}
+	if d.v5() {
+		p.Properties = d.properties()
+	}
+	if d.err == nil {
+		p.Payload = append([]byte{}, d.buf...)
+		d.buf = nil
+	}
+	return p
+}
+
+func (d *decoder) ack() *Ack {
+	p := &Ack{PacketID: d.uint16()}
+	if d.v5() && len(d.buf) > 0 {
+		p.ReasonCode = d.byte()
+		if len(d.buf) > 0 {
+			p.Properties = d.properties()
+		}
+	}
+	return p
+}
+
+func (d *decoder) subscribe() *Subscribe {
+	p := &Subscribe{PacketID: d.uint16()}
+	if d.v5() {
+		p.Properties = d.properties()
+	}
+	for len(d.buf) > 0 && d.err == nil {
+		s := Subscription{Topic: d.string()}
+		options := d.byte()
+		s.QoS = options & 0x03
+		if d.v5() {
+			s.NoLocal = options&0x04 != 0
+			s.RetainAsPublished = options&0x08 != 0
+			s.RetainHandling = options >> 4 & 0x03
+		}
+		p.Subscriptions = append(p.Subscriptions, s)
+	}
+	if len(p.Subscriptions) == 0 {
+		d.fail("subscribe without subscription")
+	}
+	return p
+}
+
+// ====================================== encoder ============================================ //
+
+// encoder encodes packet fields into buf, the first error is kept in err
+type encoder struct {
+	buf     []byte
+	version byte
+	err     error
+}
+
+func (e *encoder) fail(err error) {
+	if e.err == nil {
+		e.err = err
+	}
+}
+
+func (e *encoder) byte(b byte) {
+	e.buf = append(e.buf, b)
+}
+
+func (e *encoder) uint16(v uint16) {
+	e.buf = append(e.buf, byte(v>>8), byte(v))
+}
+
+func (e *encoder) uint32(v uint32) {
+	e.buf = append(e.buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (e *encoder) varint(v int) {
+	if v < 0 || v > maxRemainingLength {
+		e.fail(fmt.Errorf("variable byte integer out of range: %d", v))
+		return
+	}
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if v > 0 {
+			b |= 0x80
+		}
+		e.buf = append(e.buf, b)
+		if v == 0 {
+			return
+		}
+	}
+}
+
+func (e *encoder) binary(b []byte) {
+	if len(b) > 0xffff {
+		e.fail(fmt.Errorf("binary data too long: %d", len(b)))
+		return
+	}
+	e.uint16(uint16(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *encoder) string(s string) {
+	if len(s) > 0xffff {
+		e.fail(fmt.Errorf("string too long: %d", len(s)))
+		return
+	}
+	e.uint16(uint16(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) v5() bool {
+	return e.version == Version5
+}
+
+// packet encodes the variable header and payload of message, returns the packet type and flags
+func (e *encoder) packet(message interface{}) (typ, flags byte) {
+	switch p := message.(type) {
+	case *Connect:
+		return CONNECT, e.connect(p)
+	case *Connack:
+		if p.SessionPresent {
+			e.byte(1)
+		} else {
+			e.byte(0)
+		}
+		e.byte(p.ReasonCode)
+		if e.v5() {
+			e.properties(p.Properties)
+		}
+		return CONNACK, 0
+	case *Publish:
+		return PUBLISH, e.publish(p)
+	case *Puback:
+		e.ack((*Ack)(p))
+		return PUBACK, 0
+	case *Pubrec:
+		e.ack((*Ack)(p))
+		return PUBREC, 0
+	case *Pubrel:
+		e.ack((*Ack)(p))
+		return PUBREL, 0x02
+	case *Pubcomp:
+		e.ack((*Ack)(p))
+		return PUBCOMP, 0
+	case *Subscribe:
+		e.uint16(p.PacketID)
+		if e.v5() {
+			e.properties(p.Properties)
+		}
+		for _, s := range p.Subscriptions {
+			e.string(s.Topic)
+			options := s.QoS & 0x03
+			if e.v5() {
+				if s.NoLocal {
+					options |= 0x04
+				}
+				if s.RetainAsPublished {
+					options |= 0x08
+				}
+				options |= (s.RetainHandling & 0x03) << 4
+			}
+			e.byte(options)
+		}
+		return SUBSCRIBE, 0x02
+	case *Suback:
+		e.uint16(p.PacketID)
+		if e.v5() {
+			e.properties(p.Properties)
+		}
+		e.buf = append(e.buf, p.ReasonCodes...)
+		return SUBACK, 0
+	case *Unsubscribe:
+		e.uint16(p.PacketID)
+		if e.v5() {
+			e.properties(p.Properties)
+		}
+		for _, topic := range p.Topics {
+			e.string(topic)
+		}
+		return UNSUBSCRIBE, 0x02
+	case *Unsuback:
+		e.uint16(p.PacketID)
+		if e.v5() {
+			e.properties(p.Properties)
+			e.buf = append(e.buf, p.ReasonCodes...)
+		}
+		return UNSUBACK, 0
+	case *Pingreq:
+		return PINGREQ, 0
+	case *Pingresp:
+		return PINGRESP, 0
+	case *Disconnect:
+		if e.v5() {
+			e.byte(p.ReasonCode)
+			e.properties(p.Properties)
+		}
+		return DISCONNECT, 0
+	case *Auth:
+		if e.v5() {
+			e.byte(p.ReasonCode)
+			e.properties(p.Properties)
+			return AUTH, 0
+		}
+	}
+	e.fail(fmt.Errorf("%w, message type: %T", ErrUnsupportedPacket, message))
+	return 0, 0
+}
+
+func (e *encoder) connect(p *Connect) byte {
+	name := p.ProtocolName
+	if name == "" {
+		name = "MQTT"
+	}
+	version := p.ProtocolVersion
+	if version == 0 {
+		version = e.version
+	}
+	e.string(name)
+	e.byte(version)
+	// the connect packet carries its own version
+	e.version = version
+
+	var flags byte
+	if p.CleanStart {
+		flags |= 0x02
+	}
+	if p.Will != nil {
+		flags |= 0x04 | (p.Will.QoS&0x03)<<3
+		if p.Will.Retain {
+			flags |= 0x20
+		}
+	}
+	if p.Password != nil {
+		flags |= 0x40
+	}
+	if p.Username != nil {
+		flags |= 0x80
+	}
+	e.byte(flags)
+	e.uint16(p.KeepAlive)
+	if e.v5() {
+		e.properties(p.Properties)
+	}
+	e.string(p.ClientID)
+	if p.Will != nil {
+		if e.v5() {
+			e.properties(p.Will.Properties)
+		}
+		e.string(p.Will.Topic)
+		e.binary(p.Will.Payload)
+	}
+	if p.Username != nil {
+		e.string(*p.Username)
+	}
+	if p.Password != nil {
+		e.binary(p.Password)
+	}
+	return 0
+}
+
+func (e *encoder) publish(p *Publish) (flags byte) {
+	if p.QoS > 2 {
+		e.fail(errors.New("invalid publish qos 3"))
+		return 0
+	}
+	flags = p.QoS << 1
+	if p.Dup {
+		flags |= 0x08
+	}
+	if p.Retain {
+		flags |= 0x01
+	}
+	e.string(p.Topic)
+	if p.QoS > 0 {
+		e.uint16(p.PacketID)
+	}
+	if e.v5() {
+		e.properties(p.Properties)
+	}
+	e.buf = append(e.buf, p.Payload...)
+	return flags
+}
+
+func (e *encoder) ack(p *Ack) {
+	e.uint16(p.PacketID)
+	if e.v5() {
+		e.byte(p.ReasonCode)
+		e.properties(p.Properties)
+	}
+}
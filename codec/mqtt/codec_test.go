@@ -0,0 +1,216 @@
+package mqtt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/emove/less"
+	"github.com/emove/less/codec"
+	ior "github.com/emove/less/pkg/io/reader"
+	"github.com/emove/less/pkg/io/writer"
+)
+
+func TestCodec_RoundTrip(t *testing.T) {
+	username := "user"
+	tests := []struct {
+		name    string
+		version byte
+		packets []interface{}
+	}{
+		{
+			name:    "3.1.1",
+			version: Version311,
+			packets: []interface{}{
+				&Connect{ProtocolName: "MQTT", ProtocolVersion: Version311, CleanStart: true, KeepAlive: 60, ClientID: "client",
+					Will: &Will{Topic: "will", Payload: []byte("bye"), QoS: 1, Retain: true}, Username: &username, Password: []byte("pass")},
+				&Connack{SessionPresent: true},
+				&Publish{Topic: "a/b", Payload: []byte("hello")},
+				&Publish{Dup: true, QoS: 2, Retain: true, Topic: "a/b", PacketID: 7, Payload: []byte{}},
+				&Puback{PacketID: 1},
+				&Pubrec{PacketID: 2},
+				&Pubrel{PacketID: 3},
+				&Pubcomp{PacketID: 4},
+				&Subscribe{PacketID: 5, Subscriptions: []Subscription{{Topic: "a/#", QoS: 1}, {Topic: "b/+", QoS: 2}}},
+				&Suback{PacketID: 5, ReasonCodes: []byte{1, 0x80}},
+				&Unsubscribe{PacketID: 6, Topics: []string{"a/#"}},
+				&Unsuback{PacketID: 6},
+				&Pingreq{},
+				&Pingresp{},
+				&Disconnect{},
+			},
+		},
+		{
+			name:    "5",
+			version: Version5,
+			packets: []interface{}{
+				&Connect{ProtocolName: "MQTT", ProtocolVersion: Version5, KeepAlive: 30, ClientID: "client",
+					Properties: Properties{{ID: PropSessionExpiryInterval, Value: uint32(3600)}, {ID: PropReceiveMaximum, Value: uint16(10)}}},
+				&Connack{ReasonCode: 0x87, Properties: Properties{{ID: PropReasonString, Value: "not authorized"}}},
+				&Publish{QoS: 1, Topic: "a/b", PacketID: 9, Payload: []byte("hello"),
+					Properties: Properties{
+						{ID: PropSubscriptionIdentifier, Value: uint32(300)},
+						{ID: PropCorrelationData, Value: []byte{1, 2}},
+						{ID: PropUserProperty, Value: StringPair{Key: "k", Value: "v"}},
+						{ID: PropPayloadFormatIndicator, Value: byte(1)},
+					}},
+				&Puback{PacketID: 9, ReasonCode: 0x10},
+				&Subscribe{PacketID: 5, Subscriptions: []Subscription{{Topic: "a/#", QoS: 1, NoLocal: true, RetainAsPublished: true, RetainHandling: 2}}},
+				&Unsuback{PacketID: 6, ReasonCodes: []byte{0, 0x11}},
+				&Disconnect{ReasonCode: 0x8e},
+				&Auth{ReasonCode: 0x18, Properties: Properties{{ID: PropAuthenticationMethod, Value: "SCRAM-SHA-1"}}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc := NewCodec(WithVersion(tt.version))
+			buff := &bytes.Buffer{}
+			for _, p := range tt.packets {
+				if err := mc.Encode(p, writer.NewBufferWriter(buff), nil); err != nil {
+					t.Fatalf("Encode(%T) error = %v", p, err)
+				}
+			}
+			r := ior.NewBufferReader(bytes.NewReader(buff.Bytes()))
+			for _, want := range tt.packets {
+				got, err := mc.Decode(r, nil)
+				if err != nil {
+					t.Fatalf("Decode(%T) error = %v", want, err)
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Fatalf("want: %+v, but: %+v", want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestCodec_Decode(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		ops     []Option
+		want    interface{}
+		wantErr error
+	}{
+		{name: "pingreq", data: []byte{0xc0, 0x00}, want: &Pingreq{}},
+		{name: "v5 connect on v3.1.1 codec",
+			data: []byte{0x10, 0x0e, 0, 4, 'M', 'Q', 'T', 'T', 5, 0, 0, 10, 0, 0, 1, 'c'},
+			want: &Connect{ProtocolName: "MQTT", ProtocolVersion: Version5, KeepAlive: 10, ClientID: "c"}},
+		{name: "v5 puback without reason code", data: []byte{0x40, 0x02, 0, 1}, ops: []Option{WithVersion(Version5)}, want: &Puback{PacketID: 1}},
+		{name: "invalid flags", data: []byte{0x60, 0x02, 0, 1}, wantErr: ErrMalformedPacket},
+		{name: "invalid subscribe flags", data: []byte{0x80, 0x06, 0, 1, 0, 1, 'a', 0}, wantErr: ErrMalformedPacket},
+		{name: "qos 3", data: []byte{0x36, 0x03, 0, 1, 'a'}, wantErr: ErrMalformedPacket},
+		{name: "truncated body", data: []byte{0x90, 0x01, 0}, wantErr: ErrMalformedPacket},
+		{name: "trailing bytes", data: []byte{0xc0, 0x01, 0}, wantErr: ErrMalformedPacket},
+		{name: "remaining length exceeds 4 bytes", data: []byte{0x30, 0xff, 0xff, 0xff, 0xff, 0x01}, wantErr: ErrMalformedPacket},
+		{name: "too large", data: []byte{0x30, 0x0a}, ops: []Option{WithMaxPacketSize(8)}, wantErr: ErrPacketTooLarge},
+		{name: "auth in 3.1.1", data: []byte{0xf0, 0x00}, wantErr: ErrUnsupportedPacket},
+		{name: "unknown protocol", data: []byte{0x10, 0x0a, 0, 4, 'M', 'Q', 'I', 'T', 4, 0, 0, 0}, wantErr: ErrUnsupportedPacket},
+		{name: "unknown property", data: []byte{0xe0, 0x03, 0, 1, 0x7f}, ops: []Option{WithVersion(Version5)}, wantErr: ErrMalformedPacket},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := ior.NewBufferReader(bytes.NewReader(tt.data))
+			got, err := NewCodec(tt.ops...).Decode(r, nil)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("want: %v, but: %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("want: %+v, but: %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCodec_Encode(t *testing.T) {
+	buff := &bytes.Buffer{}
+	if err := NewCodec().Encode(&Publish{QoS: 1, Topic: "t", PacketID: 1, Payload: make([]byte, 200)}, writer.NewBufferWriter(buff), nil); err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	// remaining length 205 is encoded in 2 bytes
+	if want := []byte{0x32, 0xcd, 0x01, 0, 1, 't', 0, 1}; !bytes.HasPrefix(buff.Bytes(), want) {
+		t.Fatalf("want prefix: %v, but: %v", want, buff.Bytes()[:8])
+	}
+
+	if err := NewCodec().Encode(&Auth{}, writer.NewBufferWriter(&bytes.Buffer{}), nil); !errors.Is(err, ErrUnsupportedPacket) {
+		t.Fatalf("want: %v, but: %v", ErrUnsupportedPacket, err)
+	}
+	invalid := &Publish{Properties: Properties{{ID: PropTopicAlias, Value: 1}}}
+	if err := NewCodec(WithVersion(Version5)).Encode(invalid, writer.NewBufferWriter(&bytes.Buffer{}), nil); err == nil {
+		t.Fatalf("want error of invalid property value type")
+	}
+}
+
+type fakeChannel struct {
+	less.Channel
+	packetCodec codec.PacketCodec
+}
+
+func (ch *fakeChannel) SetCodec(packetCodec codec.PacketCodec, _ codec.PayloadCodec) {
+	ch.packetCodec = packetCodec
+}
+
+func TestNegotiate(t *testing.T) {
+	a, b := &fakeChannel{}, &fakeChannel{}
+	for _, ch := range []*fakeChannel{a, b} {
+		if _, err := Negotiate()(context.Background(), ch); err != nil {
+			t.Fatalf("Negotiate() error = %v", err)
+		}
+	}
+	if a.packetCodec == b.packetCodec {
+		t.Fatalf("want a codec for each channel")
+	}
+
+	// a v5 puback with reason code, which is malformed in 3.1.1
+	puback := []byte{0x40, 0x03, 0, 1, 0x10}
+	if _, err := a.packetCodec.Decode(ior.NewBufferReader(bytes.NewReader(puback)), nil); !errors.Is(err, ErrMalformedPacket) {
+		t.Fatalf("want: %v before connect, but: %v", ErrMalformedPacket, err)
+	}
+
+	connect := []byte{0x10, 0x0e, 0, 4, 'M', 'Q', 'T', 'T', 5, 0, 0, 10, 0, 0, 1, 'c'}
+	r := ior.NewBufferReader(bytes.NewReader(append(connect, puback...)))
+	if _, err := a.packetCodec.Decode(r, nil); err != nil {
+		t.Fatalf("Decode(Connect) error = %v", err)
+	}
+	got, err := a.packetCodec.Decode(r, nil)
+	if err != nil {
+		t.Fatalf("Decode(Puback) error = %v", err)
+	}
+	if want := (&Puback{PacketID: 1, ReasonCode: 0x10}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("want: %+v, but: %+v", want, got)
+	}
+
+	// replies are encoded with the negotiated version
+	buff := &bytes.Buffer{}
+	if err = a.packetCodec.Encode(&Connack{ReasonCode: 0x87}, writer.NewBufferWriter(buff), nil); err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	if want := []byte{0x20, 0x03, 0, 0x87, 0}; !bytes.Equal(buff.Bytes(), want) {
+		t.Fatalf("want: %v, but: %v", want, buff.Bytes())
+	}
+
+	// the other channel is not affected
+	if _, err = b.packetCodec.Decode(ior.NewBufferReader(bytes.NewReader(puback)), nil); !errors.Is(err, ErrMalformedPacket) {
+		t.Fatalf("want: %v, but: %v", ErrMalformedPacket, err)
+	}
+}
+
+func TestHealthParams(t *testing.T) {
+	hp := HealthParams(10 * time.Second)
+	if hp.Time != 15*time.Second || hp.Ping != nil {
+		t.Fatalf("unexpected health params: %+v", hp)
+	}
+	if !hp.PingRecognizer(&Pingreq{}) || hp.PingRecognizer(&Pingresp{}) || !hp.PongRecognizer(hp.Pong) {
+		t.Fatalf("unexpected recognizers")
+	}
+}
@@ -0,0 +1,151 @@
+package mqtt
+
+// Control packet types
+const (
+	CONNECT     byte = 1
+	CONNACK     byte = 2
+	PUBLISH     byte = 3
+	PUBACK      byte = 4
+	PUBREC      byte = 5
+	PUBREL      byte = 6
+	PUBCOMP     byte = 7
+	SUBSCRIBE   byte = 8
+	SUBACK      byte = 9
+	UNSUBSCRIBE byte = 10
+	UNSUBACK    byte = 11
+	PINGREQ     byte = 12
+	PINGRESP    byte = 13
+	DISCONNECT  byte = 14
+	AUTH        byte = 15
+)
+
+// Protocol versions
+const (
+	Version311 byte = 4
+	Version5   byte = 5
+)
+
+// Connect is the CONNECT packet
+type Connect struct {
+	// ProtocolName is "MQTT" for 3.1.1 and 5
+	ProtocolName string
+	// ProtocolVersion is the protocol level, Version311 or Version5
+	ProtocolVersion byte
+	CleanStart      bool
+	KeepAlive       uint16
+	Properties      Properties
+	ClientID        string
+	// Will is the will message, nil if the will flag is not set
+	Will *Will
+	// Username is nil if the username flag is not set
+	Username *string
+	// Password is nil if the password flag is not set
+	Password []byte
+}
+
+// Will is the will message of Connect
+type Will struct {
+	Properties Properties
+	Topic      string
+	Payload    []byte
+	QoS        byte
+	Retain     bool
+}
+
+// Connack is the CONNACK packet
+type Connack struct {
+	SessionPresent bool
+	// ReasonCode is the return code in 3.1.1
+	ReasonCode byte
+	Properties Properties
+}
+
+// Publish is the PUBLISH packet
+type Publish struct {
+	Dup    bool
+	QoS    byte
+	Retain bool
+	Topic  string
+	// PacketID is only present when QoS is 1 or 2
+	PacketID   uint16
+	Properties Properties
+	Payload    []byte
+}
+
+// Ack is the common variable header of PUBACK, PUBREC, PUBREL and PUBCOMP packets
+type Ack struct {
+	PacketID uint16
+	// ReasonCode and Properties are only present in version 5
+	ReasonCode byte
+	Properties Properties
+}
+
+// Puback is the PUBACK packet
+type Puback Ack
+
+// Pubrec is the PUBREC packet
+type Pubrec Ack
+
+// Pubrel is the PUBREL packet
+type Pubrel Ack
+
+// Pubcomp is the PUBCOMP packet
+type Pubcomp Ack
+
+// Subscription is a topic filter with its options of Subscribe
+type Subscription struct {
+	Topic string
+	QoS   byte
+	// NoLocal, RetainAsPublished and RetainHandling are only present in version 5
+	NoLocal           bool
+	RetainAsPublished bool
+	RetainHandling    byte
+}
+
+// Subscribe is the SUBSCRIBE packet
+type Subscribe struct {
+	PacketID      uint16
+	Properties    Properties
+	Subscriptions []Subscription
+}
+
+// Suback is the SUBACK packet
+type Suback struct {
+	PacketID    uint16
+	Properties  Properties
+	ReasonCodes []byte
+}
+
+// Unsubscribe is the UNSUBSCRIBE packet
+type Unsubscribe struct {
+	PacketID   uint16
+	Properties Properties
+	Topics     []string
+}
+
+// Unsuback is the UNSUBACK packet
+type Unsuback struct {
+	PacketID   uint16
+	Properties Properties
+	// ReasonCodes are only present in version 5
+	ReasonCodes []byte
+}
+
+// Pingreq is the PINGREQ packet
+type Pingreq struct{}
+
+// Pingresp is the PINGRESP packet
+type Pingresp struct{}
+
+// Disconnect is the DISCONNECT packet
+type Disconnect struct {
+	// ReasonCode and Properties are only present in version 5
+	ReasonCode byte
+	Properties Properties
+}
+
+// Auth is the AUTH packet, only present in version 5
+type Auth struct {
+	ReasonCode byte
+	Properties Properties
+}
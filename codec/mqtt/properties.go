@@ -0,0 +1,220 @@
+package mqtt
+
+import "fmt"
+
+// Property identifiers of version 5
+const (
+	PropPayloadFormatIndicator          byte = 0x01
+	PropMessageExpiryInterval           byte = 0x02
+	PropContentType                     byte = 0x03
+	PropResponseTopic                   byte = 0x08
+	PropCorrelationData                 byte = 0x09
+	PropSubscriptionIdentifier          byte = 0x0B
+	PropSessionExpiryInterval           byte = 0x11
+	PropAssignedClientIdentifier        byte = 0x12
+	PropServerKeepAlive                 byte = 0x13
+	PropAuthenticationMethod            byte = 0x15
+	PropAuthenticationData              byte = 0x16
+	PropRequestProblemInformation       byte = 0x17
+	PropWillDelayInterval               byte = 0x18
+	PropRequestResponseInformation      byte = 0x19
+	PropResponseInformation             byte = 0x1A
+	PropServerReference                 byte = 0x1C
+	PropReasonString                    byte = 0x1F
+	PropReceiveMaximum                  byte = 0x21
+	PropTopicAliasMaximum               byte = 0x22
+	PropTopicAlias                      byte = 0x23
+	PropMaximumQoS                      byte = 0x24
+	PropRetainAvailable                 byte = 0x25
+	PropUserProperty                    byte = 0x26
+	PropMaximumPacketSize               byte = 0x27
+	PropWildcardSubscriptionAvailable   byte = 0x28
+	PropSubscriptionIdentifierAvailable byte = 0x29
+	PropSharedSubscriptionAvailable     byte = 0x2A
+)
+
+// property value types
+const (
+	propByte = iota
+	propUint16
+	propUint32
+	propVarint
+	propString
+	propBinary
+	propStringPair
+)
+
+var propTypes = map[byte]int{
+	PropPayloadFormatIndicator:          propByte,
+	PropMessageExpiryInterval:           propUint32,
+	PropContentType:                     propString,
+	PropResponseTopic:                   propString,
+	PropCorrelationData:                 propBinary,
+	PropSubscriptionIdentifier:          propVarint,
+	PropSessionExpiryInterval:           propUint32,
+	PropAssignedClientIdentifier:        propString,
+	PropServerKeepAlive:                 propUint16,
+	PropAuthenticationMethod:            propString,
+	PropAuthenticationData:              propBinary,
+	PropRequestProblemInformation:       propByte,
+	PropWillDelayInterval:               propUint32,
+	PropRequestResponseInformation:      propByte,
+	PropResponseInformation:             propString,
+	PropServerReference:                 propString,
+	PropReasonString:                    propString,
+	PropReceiveMaximum:                  propUint16,
+	PropTopicAliasMaximum:               propUint16,
+	PropTopicAlias:                      propUint16,
+	PropMaximumQoS:                      propByte,
+	PropRetainAvailable:                 propByte,
+	PropUserProperty:                    propStringPair,
+	PropMaximumPacketSize:               propUint32,
+	PropWildcardSubscriptionAvailable:   propByte,
+	PropSubscriptionIdentifierAvailable: propByte,
+	PropSharedSubscriptionAvailable:     propByte,
+}
+
+// StringPair is the value of user property
+type StringPair struct {
+	Key   string
+	Value string
+}
+
+// Property is a version 5 property. The type of Value depends on the identifier: byte, uint16,
+// uint32 (four byte integer and variable byte integer), string, []byte or StringPair.
+type Property struct {
+	ID    byte
+	Value interface{}
+}
+
+// Properties is the properties in order of a packet
+type Properties []Property
+
+// Get returns the value of the first property with id
+func (ps Properties) Get(id byte) (interface{}, bool) {
+	for _, p := range ps {
+		if p.ID == id {
+			return p.Value, true
+		}
+	}
+	return nil, false
+}
+
+// UserProperties returns all user properties
+func (ps Properties) UserProperties() []StringPair {
+	var pairs []StringPair
+	for _, p := range ps {
+		if pair, ok := p.Value.(StringPair); ok && p.ID == PropUserProperty {
+			pairs = append(pairs, pair)
+		}
+	}
+	return pairs
+}
+
+func (e *encoder) properties(ps Properties) {
+	pe := &encoder{}
+	for _, p := range ps {
+		typ, ok := propTypes[p.ID]
+		if !ok {
+			e.fail(fmt.Errorf("unknown property identifier: 0x%x", p.ID))
+			return
+		}
+		pe.byte(p.ID)
+		var valid bool
+		switch typ {
+		case propByte:
+			var v byte
+			if v, valid = p.Value.(byte); valid {
+				pe.byte(v)
+			}
+		case propUint16:
+			var v uint16
+			if v, valid = p.Value.(uint16); valid {
+				pe.uint16(v)
+			}
+		case propUint32:
+			var v uint32
+			if v, valid = p.Value.(uint32); valid {
+				pe.uint32(v)
+			}
+		case propVarint:
+			var v uint32
+			if v, valid = p.Value.(uint32); valid {
+				pe.varint(int(v))
+			}
+		case propString:
+			var v string
+			if v, valid = p.Value.(string); valid {
+				pe.string(v)
+			}
+		case propBinary:
+			var v []byte
+			if v, valid = p.Value.([]byte); valid {
+				pe.binary(v)
+			}
+		case propStringPair:
+			var v StringPair
+			if v, valid = p.Value.(StringPair); valid {
+				pe.string(v.Key)
+				pe.string(v.Value)
+			}
+		}
+		if !valid {
+			e.fail(fmt.Errorf("invalid value type %T of property 0x%x", p.Value, p.ID))
+			return
+		}
+	}
+	if pe.err != nil {
+		e.fail(pe.err)
+		return
+	}
+	e.varint(len(pe.buf))
+	e.buf = append(e.buf, pe.buf...)
+}
+
+func (d *decoder) properties() Properties {
+	length := d.varint()
+	if d.err != nil {
+		return nil
+	}
+	if length > len(d.buf) {
+		d.fail("properties length out of bounds")
+		return nil
+	}
+
+	pd := &decoder{buf: d.buf[:length]}
+	d.buf = d.buf[length:]
+
+	var ps Properties
+	for len(pd.buf) > 0 && pd.err == nil {
+		id := pd.byte()
+		typ, ok := propTypes[id]
+		if !ok {
+			d.fail(fmt.Sprintf("unknown property identifier: 0x%x", id))
+			return nil
+		}
+		var v interface{}
+		switch typ {
+		case propByte:
+			v = pd.byte()
+		case propUint16:
+			v = pd.uint16()
+		case propUint32:
+			v = pd.uint32()
+		case propVarint:
+			v = uint32(pd.varint())
+		case propString:
+			v = pd.string()
+		case propBinary:
+			v = pd.binary()
+		case propStringPair:
+			v = StringPair{Key: pd.string(), Value: pd.string()}
+		}
+		ps = append(ps, Property{ID: id, Value: v})
+	}
+	if pd.err != nil {
+		d.err = pd.err
+		return nil
+	}
+	return ps
+}
@@ -0,0 +1,226 @@
+// Package negotiate implements a codec negotiation handshake exchanged right after the channel
+// connected, before any packet decoded. The client starts the handshake by sending a line:
+//
+//	LESS-CODEC packet=<names> payload=<names>\r\n
+//
+// names are codec names, such as json-payload-codec, separated by comma in preference order. The
+// server selects the first one it supports for each kind, an omitted kind keeps the current codec,
+// and replies with the names of codecs in use:
+//
+//	LESS-CODEC packet=<name> payload=<name>\r\n
+//
+// or replies "LESS-CODEC ERR <reason>\r\n" and closes the channel if no codec is acceptable.
+package negotiate
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	stdio "io"
+	"strings"
+
+	"github.com/emove/less"
+	"github.com/emove/less/codec"
+	"github.com/emove/less/pkg/io"
+	"github.com/emove/less/protocol"
+)
+
+const (
+	magic = "LESS-CODEC "
+	// maxLineLength is the max length of handshake line
+	maxLineLength = 4096
+)
+
+var (
+	ErrNotAcceptable = errors.New("no acceptable codec")
+	ErrMalformed     = errors.New("malformed codec negotiation")
+	ErrRequired      = errors.New("codec negotiation is required")
+)
+
+// Negotiator negotiates the codecs of channel with the peer by reading and writing the raw bytes of
+// channel, returns the selected codecs, a nil codec keeps the current one
+type Negotiator func(ch less.Channel, reader io.Reader, writer io.Writer) (codec.PacketCodec, codec.PayloadCodec, error)
+
+// Option sets negotiator options
+type Option func(n *negotiator)
+
+// WithPacketCodecs adds the packet codecs could be selected
+func WithPacketCodecs(codecs ...codec.PacketCodec) Option {
+	return func(n *negotiator) {
+		n.packetCodecs = append(n.packetCodecs, codecs...)
+	}
+}
+
+// WithPayloadCodecs adds the payload codecs could be selected
+func WithPayloadCodecs(codecs ...codec.PayloadCodec) Option {
+	return func(n *negotiator) {
+		n.payloadCodecs = append(n.payloadCodecs, codecs...)
+	}
+}
+
+// Required closes the channel which does not start with a negotiation,
+// otherwise the channel keeps the current codecs
+func Required() Option {
+	return func(n *negotiator) {
+		n.required = true
+	}
+}
+
+// New returns a Negotiator selecting the codecs by names
+func New(ops ...Option) Negotiator {
+	n := &negotiator{match: protocol.Prefix(magic)}
+	for _, op := range ops {
+		op(n)
+	}
+	return n.negotiate
+}
+
+type negotiator struct {
+	match         protocol.Matcher
+	packetCodecs  []codec.PacketCodec
+	payloadCodecs []codec.PayloadCodec
+	required      bool
+}
+
+func (n *negotiator) negotiate(ch less.Channel, reader io.Reader, writer io.Writer) (packetCodec codec.PacketCodec, payloadCodec codec.PayloadCodec, err error) {
+	if !n.match(reader) {
+		if n.required {
+			return nil, nil, ErrRequired
+		}
+		return nil, nil, nil
+	}
+
+	line, err := readLine(reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	packetNames, payloadNames, err := parse(line)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	currentPacket, currentPayload := ch.Codec()
+	if packetNames != nil {
+		if packetCodec = selectCodec(packetNames, n.packetCodecs); packetCodec == nil {
+			return nil, nil, n.reject(writer, fmt.Errorf("%w, packet codecs: %s", ErrNotAcceptable, strings.Join(packetNames, ",")))
+		}
+		currentPacket = packetCodec
+	}
+	if payloadNames != nil {
+		if payloadCodec = selectCodec(payloadNames, n.payloadCodecs); payloadCodec == nil {
+			return nil, nil, n.reject(writer, fmt.Errorf("%w, payload codecs: %s", ErrNotAcceptable, strings.Join(payloadNames, ",")))
+		}
+		currentPayload = payloadCodec
+	}
+
+	reply := fmt.Sprintf("%spacket=%s payload=%s\r\n", magic, name(currentPacket), name(currentPayload))
+	if _, err = writer.Write([]byte(reply)); err != nil {
+		return nil, nil, err
+	}
+	return packetCodec, payloadCodec, writer.Flush()
+}
+
+// reject replies the reason and returns the error
+func (n *negotiator) reject(writer io.Writer, err error) error {
+	if _, e := writer.Write([]byte(magic + "ERR " + err.Error() + "\r\n")); e == nil {
+		_ = writer.Flush()
+	}
+	return err
+}
+
+// Handshake starts a negotiation on conn as a client, the codec names are in preference order and a
+// nil list keeps the codec of that kind, returns the names of codecs used by the server
+func Handshake(conn stdio.ReadWriter, packetCodecs, payloadCodecs []string) (packetCodec, payloadCodec string, err error) {
+	request := magic
+	if packetCodecs != nil {
+		request += "packet=" + strings.Join(packetCodecs, ",") + " "
+	}
+	if payloadCodecs != nil {
+		request += "payload=" + strings.Join(payloadCodecs, ",") + " "
+	}
+	if _, err = conn.Write([]byte(strings.TrimSuffix(request, " ") + "\r\n")); err != nil {
+		return
+	}
+
+	// reads the reply byte by byte to leave the following data in conn
+	line := make([]byte, 0, 128)
+	b := make([]byte, 1)
+	for len(line) < maxLineLength {
+		if _, err = stdio.ReadFull(conn, b); err != nil {
+			return
+		}
+		if b[0] == '\n' {
+			break
+		}
+		line = append(line, b[0])
+	}
+	reply := strings.TrimSuffix(string(line), "\r")
+	if !strings.HasPrefix(reply, magic) {
+		return "", "", fmt.Errorf("%w, reply: %q", ErrMalformed, reply)
+	}
+	if reason := strings.TrimPrefix(reply, magic); strings.HasPrefix(reason, "ERR ") {
+		return "", "", errors.New(strings.TrimPrefix(reason, "ERR "))
+	}
+	packets, payloads, err := parse([]byte(reply))
+	if err != nil {
+		return "", "", err
+	}
+	if len(packets) != 1 || len(payloads) != 1 {
+		return "", "", fmt.Errorf("%w, reply: %q", ErrMalformed, reply)
+	}
+	return packets[0], payloads[0], nil
+}
+
+// readLine reads the handshake line without the line terminator
+func readLine(reader io.Reader) ([]byte, error) {
+	for n := len(magic) + 1; n <= maxLineLength; n++ {
+		buf, err := reader.Peek(n)
+		if err != nil {
+			return nil, err
+		}
+		if buf[n-1] != '\n' {
+			continue
+		}
+		line := bytes.TrimSuffix(buf[:n-1], []byte("\r"))
+		line = append([]byte{}, line...)
+		return line, reader.Skip(n)
+	}
+	return nil, fmt.Errorf("%w, line too long", ErrMalformed)
+}
+
+// parse parses the codec names of handshake line, a nil list means the kind is omitted
+func parse(line []byte) (packetNames, payloadNames []string, err error) {
+	fields := strings.Fields(strings.TrimPrefix(string(line), magic))
+	for _, field := range fields {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 || len(kv[1]) == 0 {
+			return nil, nil, fmt.Errorf("%w, field: %q", ErrMalformed, field)
+		}
+		switch kv[0] {
+		case "packet":
+			packetNames = strings.Split(kv[1], ",")
+		case "payload":
+			payloadNames = strings.Split(kv[1], ",")
+		}
+	}
+	return packetNames, payloadNames, nil
+}
+
+// selectCodec returns the first codec supported in names order
+func selectCodec[C interface{ Name() string }](names []string, codecs []C) (selected C) {
+	for _, name := range names {
+		for _, c := range codecs {
+			if c.Name() == name {
+				return c
+			}
+		}
+	}
+	return selected
+}
+
+func name(c interface{ Name() string }) string {
+	if c == nil {
+		return "none"
+	}
+	return c.Name()
+}
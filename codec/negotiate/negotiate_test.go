@@ -0,0 +1,122 @@
+package negotiate
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/emove/less"
+	"github.com/emove/less/codec"
+	"github.com/emove/less/codec/packet"
+	"github.com/emove/less/codec/payload"
+	ior "github.com/emove/less/pkg/io/reader"
+	"github.com/emove/less/pkg/io/writer"
+)
+
+type fakeChannel struct {
+	less.Channel
+}
+
+func (fakeChannel) Codec() (codec.PacketCodec, codec.PayloadCodec) {
+	return packet.NewVariableLengthCodec(), payload.NewTextCodec()
+}
+
+func TestNegotiator(t *testing.T) {
+	n := New(
+		WithPacketCodecs(packet.NewVariableLengthCodec(), packet.NewDelimiterCodec("\n", 1024)),
+		WithPayloadCodecs(payload.NewTextCodec(), payload.NewJSONCodec()),
+	)
+	tests := []struct {
+		name        string
+		request     string
+		wantPacket  string
+		wantPayload string
+		wantReply   string
+		wantErr     error
+	}{
+		{
+			name:        "select in client order",
+			request:     "LESS-CODEC packet=unknown,delimiter-packet-codec payload=json-payload-codec,text-payload-codec\r\nhello",
+			wantPacket:  "delimiter-packet-codec",
+			wantPayload: "json-payload-codec",
+			wantReply:   "LESS-CODEC packet=delimiter-packet-codec payload=json-payload-codec\r\n",
+		},
+		{
+			name:        "keep omitted kind",
+			request:     "LESS-CODEC payload=json-payload-codec\n",
+			wantPayload: "json-payload-codec",
+			wantReply:   "LESS-CODEC packet=variable-length-packet-codec payload=json-payload-codec\r\n",
+		},
+		{
+			name:      "not acceptable",
+			request:   "LESS-CODEC packet=unknown\r\n",
+			wantReply: "LESS-CODEC ERR no acceptable codec, packet codecs: unknown\r\n",
+			wantErr:   ErrNotAcceptable,
+		},
+		{name: "malformed", request: "LESS-CODEC packet\r\n", wantErr: ErrMalformed},
+		{name: "no negotiation", request: "hello"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := ior.NewBufferReader(bytes.NewReader([]byte(tt.request)))
+			buff := &bytes.Buffer{}
+			packetCodec, payloadCodec, err := n(fakeChannel{}, reader, writer.NewBufferWriter(buff))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("want: %v, but: %v", tt.wantErr, err)
+			}
+			if got := codecName(packetCodec); got != tt.wantPacket {
+				t.Fatalf("want packet codec: %q, but: %q", tt.wantPacket, got)
+			}
+			if got := codecName(payloadCodec); got != tt.wantPayload {
+				t.Fatalf("want payload codec: %q, but: %q", tt.wantPayload, got)
+			}
+			if buff.String() != tt.wantReply {
+				t.Fatalf("want reply: %q, but: %q", tt.wantReply, buff.String())
+			}
+		})
+	}
+
+	reader := ior.NewBufferReader(bytes.NewReader([]byte("hello")))
+	if _, _, err := New(Required())(fakeChannel{}, reader, writer.NewBufferWriter(&bytes.Buffer{})); err != ErrRequired {
+		t.Fatalf("want: %v, but: %v", ErrRequired, err)
+	}
+}
+
+// codecName returns the name of codec, or empty if nil
+func codecName(c interface{ Name() string }) string {
+	if c == nil {
+		return ""
+	}
+	return c.Name()
+}
+
+type conn struct {
+	bytes.Buffer
+	reply *bytes.Reader
+}
+
+func (c *conn) Read(p []byte) (int, error) {
+	return c.reply.Read(p)
+}
+
+func TestHandshake(t *testing.T) {
+	c := &conn{reply: bytes.NewReader([]byte("LESS-CODEC packet=delimiter-packet-codec payload=text-payload-codec\r\nremains"))}
+	packetCodec, payloadCodec, err := Handshake(c, []string{"delimiter-packet-codec"}, nil)
+	if err != nil {
+		t.Fatalf("Handshake() error = %v", err)
+	}
+	if packetCodec != "delimiter-packet-codec" || payloadCodec != "text-payload-codec" {
+		t.Fatalf("unexpected codecs: %s, %s", packetCodec, payloadCodec)
+	}
+	if want := "LESS-CODEC packet=delimiter-packet-codec\r\n"; c.String() != want {
+		t.Fatalf("want request: %q, but: %q", want, c.String())
+	}
+	if c.reply.Len() != len("remains") {
+		t.Fatalf("the data following the reply should be kept")
+	}
+
+	c = &conn{reply: bytes.NewReader([]byte("LESS-CODEC ERR no acceptable codec\r\n"))}
+	if _, _, err = Handshake(c, nil, []string{"unknown"}); err == nil || err.Error() != "no acceptable codec" {
+		t.Fatalf("want error: no acceptable codec, but: %v", err)
+	}
+}
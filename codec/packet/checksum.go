@@ -0,0 +1,83 @@
+package packet
+
+import (
+	"errors"
+	"hash"
+	"hash/crc32"
+
+	"github.com/emove/less/pkg/io"
+)
+
+// ErrChecksumMismatch is returned by Decode when the checksum trailer of frame mismatched,
+// the channel will be closed with it.
+var ErrChecksumMismatch = errors.New("frame checksum mismatch")
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// CRC32C returns a hash constructor of CRC-32 checksum with Castagnoli polynomial
+func CRC32C() func() hash.Hash {
+	return func() hash.Hash {
+		return crc32.New(crc32cTable)
+	}
+}
+
+// WithChecksum appends a checksum trailer computed by the hash to each frame, and verifies it when decoding.
+// The trailer is part of frame, so the length field value counts it.
+func WithChecksum(newHash func() hash.Hash) LengthFieldOption {
+	return func(lc *lengthFieldCodec) {
+		lc.newHash = newHash
+		lc.checksumSize = newHash().Size()
+	}
+}
+
+// checksumWriter records the slices written by payload codec, and computes the checksum of them
+// after the payload has been marshaled, the slices stay valid until the writer flushed.
+type checksumWriter struct {
+	io.Writer
+	segments [][]byte
+}
+
+func (cw *checksumWriter) Write(buf []byte) (n int, err error) {
+	b, err := cw.Malloc(len(buf))
+	if err != nil {
+		return 0, err
+	}
+	return copy(b, buf), nil
+}
+
+func (cw *checksumWriter) Malloc(n int) (buf []byte, err error) {
+	if buf, err = cw.Writer.Malloc(n); err == nil {
+		cw.segments = append(cw.segments, buf)
+	}
+	return
+}
+
+// Flush does nothing, the frame will be flushed by packet codec after the trailer appended
+func (cw *checksumWriter) Flush() error {
+	return nil
+}
+
+func (cw *checksumWriter) Release() {
+	cw.segments = nil
+}
+
+// sum writes the checksum of all written slices into dst
+func (cw *checksumWriter) sum(h hash.Hash, dst []byte) {
+	for _, seg := range cw.segments {
+		_, _ = h.Write(seg)
+	}
+	h.Sum(dst[:0])
+}
+
+// verify checks the checksum trailer of data
+func verifyChecksum(h hash.Hash, data []byte) error {
+	size := h.Size()
+	_, _ = h.Write(data[:len(data)-size])
+	sum := h.Sum(nil)
+	for i, b := range data[len(data)-size:] {
+		if sum[i] != b {
+			return ErrChecksumMismatch
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package packet
+
+import (
+	"bytes"
+	"errors"
+	"hash/crc32"
+	"strings"
+	"testing"
+
+	"github.com/emove/less/codec/payload"
+	ior "github.com/emove/less/pkg/io/reader"
+	"github.com/emove/less/pkg/io/writer"
+)
+
+func TestLengthFieldCodec_Checksum(t *testing.T) {
+	long := strings.Repeat("less", 100)
+	tests := []struct {
+		name string
+		ops  []LengthFieldOption
+	}{
+		{name: "legacy", ops: append(LegacyVariableLength(), WithChecksum(CRC32C()))},
+		{name: "varint", ops: []LengthFieldOption{WithLengthFieldLength(VarintLengthField), WithInitialBytesToStrip(2), WithChecksum(CRC32C())}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lc := NewLengthFieldCodec(tt.ops...)
+			buff := &bytes.Buffer{}
+			w := writer.NewBufferWriter(buff)
+			for _, msg := range []string{long, "short"} {
+				if err := lc.Encode(msg, w, payload.NewTextCodec()); err != nil {
+					t.Fatalf("Encode() error = %v", err)
+				}
+			}
+
+			data := buff.Bytes()
+			sum := crc32.Checksum([]byte(long), crc32cTable)
+			trailer := data[len(data)-len("short")-4-5-4 : len(data)-len("short")-4-5]
+			if tt.name == "legacy" && (trailer[0] != byte(sum>>24) || trailer[3] != byte(sum)) {
+				t.Fatalf("want checksum: %x, but: %x", sum, trailer)
+			}
+
+			r := ior.NewBufferReader(bytes.NewReader(data))
+			for _, want := range []string{long, "short"} {
+				got, err := lc.Decode(r, payload.NewTextCodec())
+				if err != nil {
+					t.Fatalf("Decode() error = %v", err)
+				}
+				if got != want {
+					t.Fatalf("want: %s, but: %s", want, got)
+				}
+			}
+
+			// corrupts the payload of first frame
+			data[10] ^= 0xff
+			r = ior.NewBufferReader(bytes.NewReader(data))
+			if _, err := lc.Decode(r, payload.NewTextCodec()); !errors.Is(err, ErrChecksumMismatch) {
+				t.Fatalf("want: %v, but: %v", ErrChecksumMismatch, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,421 @@
+package packet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+
+	"github.com/emove/less/codec"
+	"github.com/emove/less/pkg/io"
+	ior "github.com/emove/less/pkg/io/reader"
+	iow "github.com/emove/less/pkg/io/writer"
+)
+
+// VarintLengthField is the length field length which means the length field is encoded as uvarint
+const VarintLengthField = -1
+
+var (
+	ErrFrameTooLarge          = errors.New("frame length greater than max frame length")
+	ErrInvalidFrameLength     = errors.New("invalid frame length")
+	ErrMalformedLengthField   = errors.New("malformed varint length field")
+	ErrHeaderNotStripped      = errors.New("can not encode frame whose varint length field is not stripped")
+	ErrNegativeLengthField    = errors.New("length field value is negative")
+	ErrLengthFieldOutOfBounds = errors.New("length field value out of bounds")
+)
+
+// LengthFieldOption sets length field codec options
+type LengthFieldOption func(lc *lengthFieldCodec)
+
+// WithLengthFieldOffset sets the offset of length field in the frame, 0 by default
+func WithLengthFieldOffset(offset int) LengthFieldOption {
+	return func(lc *lengthFieldCodec) {
+		lc.offset = offset
+	}
+}
+
+// WithLengthFieldLength sets the length of length field, 1, 2, 3, 4, 8 and VarintLengthField are supported,
+// 4 by default
+func WithLengthFieldLength(length int) LengthFieldOption {
+	return func(lc *lengthFieldCodec) {
+		lc.length = length
+	}
+}
+
+// WithByteOrder sets the byte order of length field, binary.BigEndian by default
+func WithByteOrder(order binary.ByteOrder) LengthFieldOption {
+	return func(lc *lengthFieldCodec) {
+		lc.order = order
+	}
+}
+
+// WithLengthAdjustment sets the compensation value to add to the value of the length field,
+// such as the length of the header if the length field value contains it as negative
+func WithLengthAdjustment(adjustment int) LengthFieldOption {
+	return func(lc *lengthFieldCodec) {
+		lc.adjustment = adjustment
+	}
+}
+
+// WithInitialBytesToStrip sets the number of first bytes to strip out from the decoded frame,
+// the remaining bytes are passed to the payload codec. 0 by default
+func WithInitialBytesToStrip(strip int) LengthFieldOption {
+	return func(lc *lengthFieldCodec) {
+		lc.strip = strip
+	}
+}
+
+// WithMaxFrameLength sets the max length of frame, 0 means no limit
+func WithMaxFrameLength(length uint64) LengthFieldOption {
+	return func(lc *lengthFieldCodec) {
+		lc.maxFrameLength = length
+	}
+}
+
+// LegacyVariableLength returns the options reproduce the NewVariableLengthCodec frame, whose header is
+// a 4 bytes big endian length of payload followed by a padding byte.
+func LegacyVariableLength() []LengthFieldOption {
+	return []LengthFieldOption{
+		WithLengthFieldOffset(0),
+		WithLengthFieldLength(4),
+		WithByteOrder(binary.BigEndian),
+		WithLengthAdjustment(1),
+		WithInitialBytesToStrip(5),
+	}
+}
+
+// NewLengthFieldCodec returns a packet codec which splits frames by the value of the length field,
+// the frame length is computed as: offset + length field length + length field value + adjustment.
+//
+// Encode writes the stripped header with zero bytes except the length field, then the payload. If the
+// header is not stripped entirely, the payload codec should marshal the remaining header, including the
+// placeholder bytes of the length field which are filled by Encode. The varint length field must be
+// stripped due to its length depends on the value.
+func NewLengthFieldCodec(ops ...LengthFieldOption) codec.PacketCodec {
+	lc := &lengthFieldCodec{
+		length: 4,
+		order:  binary.BigEndian,
+	}
+	for _, op := range ops {
+		op(lc)
+	}
+
+	switch lc.length {
+	case 1, 2, 3, 4, 8, VarintLengthField:
+	default:
+		panic(fmt.Sprintf("unsupported length field length: %d", lc.length))
+	}
+	if lc.offset < 0 || lc.strip < 0 {
+		panic("length field offset and initial bytes to strip can not be negative")
+	}
+	if lc.order == nil {
+		panic("byte order can not be nil")
+	}
+	return lc
+}
+
+var _ codec.PacketCodec = (*lengthFieldCodec)(nil)
+
+type lengthFieldCodec struct {
+	offset         int
+	length         int
+	order          binary.ByteOrder
+	adjustment     int
+	strip          int
+	maxFrameLength uint64
+	newHash        func() hash.Hash
+	checksumSize   int
+}
+
+func (*lengthFieldCodec) Name() string {
+	return "length-field-packet-codec"
+}
+
+func (lc *lengthFieldCodec) Encode(message interface{}, writer io.Writer, payloadCodec codec.PayloadCodec) (err error) {
+	if lc.length == VarintLengthField {
+		return lc.encodeVarint(message, writer, payloadCodec)
+	}
+
+	if lc.strip < lc.offset+lc.length {
+		return lc.encodeHeader(message, writer, payloadCodec)
+	}
+	header, err := writer.Malloc(lc.strip)
+	if err != nil {
+		return err
+	}
+	for i := range header {
+		header[i] = 0
+	}
+
+	bufferWriter := iow.WrapBufferWriter(writer)
+	defer bufferWriter.Release()
+
+	if lc.newHash == nil {
+		err = payloadCodec.Marshal(message, bufferWriter)
+	} else {
+		// computes the checksum of slices written by payload codec, and writes it into the reserved trailer
+		cw := &checksumWriter{Writer: bufferWriter}
+		if err = payloadCodec.Marshal(message, cw); err == nil {
+			var trailer []byte
+			if trailer, err = bufferWriter.Malloc(lc.checksumSize); err == nil {
+				cw.sum(lc.newHash(), trailer)
+			}
+		}
+		cw.Release()
+	}
+	if err != nil {
+		return err
+	}
+
+	value, err := lc.fieldValue(bufferWriter.MallocLength(), lc.length)
+	if err != nil {
+		return err
+	}
+	if lc.length < 8 && value >= 1<<(8*lc.length) {
+		return fmt.Errorf("%w, value: %d, field length: %d", ErrLengthFieldOutOfBounds, value, lc.length)
+	}
+	lc.putLength(header[lc.offset:lc.offset+lc.length], value)
+
+	return writer.Flush()
+}
+
+// encodeHeader marshals the payload first, then fills the length field lies in the header marshaled
+// by payload codec
+func (lc *lengthFieldCodec) encodeHeader(message interface{}, writer io.Writer, payloadCodec codec.PayloadCodec) (err error) {
+	buff := &bytes.Buffer{}
+	bufferWriter := iow.NewBufferWriter(buff)
+	defer bufferWriter.Release()
+
+	if err = payloadCodec.Marshal(message, bufferWriter); err != nil {
+		return err
+	}
+	if err = bufferWriter.Flush(); err != nil {
+		return err
+	}
+	payload := buff.Bytes()
+	if len(payload) < lc.offset+lc.length-lc.strip {
+		return fmt.Errorf("%w, payload length: %d less than the header not stripped", ErrInvalidFrameLength, len(payload))
+	}
+
+	value, err := lc.fieldValue(len(payload)+lc.checksumSize, lc.length)
+	if err != nil {
+		return err
+	}
+	if lc.length < 8 && value >= 1<<(8*lc.length) {
+		return fmt.Errorf("%w, value: %d, field length: %d", ErrLengthFieldOutOfBounds, value, lc.length)
+	}
+
+	header, err := writer.Malloc(lc.strip)
+	if err != nil {
+		return err
+	}
+	for i := range header {
+		header[i] = 0
+	}
+	// the length field may cross the stripped header and the payload
+	var field [8]byte
+	lc.putLength(field[:lc.length], value)
+	for i, b := range field[:lc.length] {
+		if pos := lc.offset + i; pos < lc.strip {
+			header[pos] = b
+		} else {
+			payload[pos-lc.strip] = b
+		}
+	}
+
+	if _, err = writer.Write(payload); err != nil {
+		return err
+	}
+	if lc.newHash != nil {
+		trailer, err := writer.Malloc(lc.checksumSize)
+		if err != nil {
+			return err
+		}
+		cw := &checksumWriter{segments: [][]byte{payload}}
+		cw.sum(lc.newHash(), trailer)
+	}
+	return writer.Flush()
+}
+
+// encodeVarint marshals the payload first due to the length of length field depends on the payload length
+func (lc *lengthFieldCodec) encodeVarint(message interface{}, writer io.Writer, payloadCodec codec.PayloadCodec) (err error) {
+	buff := &bytes.Buffer{}
+	bufferWriter := iow.NewBufferWriter(buff)
+	defer bufferWriter.Release()
+
+	if err = payloadCodec.Marshal(message, bufferWriter); err != nil {
+		return err
+	}
+	if err = bufferWriter.Flush(); err != nil {
+		return err
+	}
+
+	// the length of varint length field depends on its value, tries until they match
+	var field [binary.MaxVarintLen64]byte
+	var n int
+	for length := 1; length <= binary.MaxVarintLen64; length++ {
+		if lc.strip < lc.offset+length {
+			return ErrHeaderNotStripped
+		}
+		value, err := lc.fieldValue(buff.Len()+lc.checksumSize, length)
+		if err != nil {
+			return err
+		}
+		if n = binary.PutUvarint(field[:], value); n == length {
+			break
+		}
+	}
+
+	header, err := writer.Malloc(lc.strip)
+	if err != nil {
+		return err
+	}
+	for i := range header {
+		header[i] = 0
+	}
+	copy(header[lc.offset:], field[:n])
+	if _, err = writer.Write(buff.Bytes()); err != nil {
+		return err
+	}
+	if lc.newHash != nil {
+		trailer, err := writer.Malloc(lc.checksumSize)
+		if err != nil {
+			return err
+		}
+		cw := &checksumWriter{segments: [][]byte{buff.Bytes()}}
+		cw.sum(lc.newHash(), trailer)
+	}
+	return writer.Flush()
+}
+
+// fieldValue returns the length field value of payload
+func (lc *lengthFieldCodec) fieldValue(payloadLength, fieldLength int) (uint64, error) {
+	value := payloadLength + lc.strip - lc.offset - fieldLength - lc.adjustment
+	if value < 0 {
+		return 0, fmt.Errorf("%w, value: %d", ErrNegativeLengthField, value)
+	}
+	if lc.maxFrameLength > 0 && uint64(payloadLength+lc.strip) > lc.maxFrameLength {
+		return 0, fmt.Errorf("%w, length: %d, max: %d", ErrFrameTooLarge, payloadLength+lc.strip, lc.maxFrameLength)
+	}
+	return uint64(value), nil
+}
+
+func (lc *lengthFieldCodec) Decode(reader io.Reader, payloadCodec codec.PayloadCodec) (message interface{}, err error) {
+	value, fieldLength, err := lc.readLength(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	headerLength := uint64(lc.offset + fieldLength)
+	frameLength := int64(value) + int64(lc.adjustment) + int64(headerLength)
+	if value > 1<<62 || frameLength < int64(headerLength) || frameLength < int64(lc.strip) {
+		return nil, fmt.Errorf("%w, length field value: %d", ErrInvalidFrameLength, value)
+	}
+	if lc.maxFrameLength > 0 && uint64(frameLength) > lc.maxFrameLength {
+		return nil, fmt.Errorf("%w, length: %d, max: %d", ErrFrameTooLarge, frameLength, lc.maxFrameLength)
+	}
+	bodyLength := frameLength - int64(lc.strip)
+	if bodyLength > 1<<32-1 {
+		return nil, fmt.Errorf("%w, length: %d", ErrFrameTooLarge, frameLength)
+	}
+	if bodyLength < int64(lc.checksumSize) {
+		return nil, fmt.Errorf("%w, length: %d less than checksum size", ErrInvalidFrameLength, bodyLength)
+	}
+
+	if lc.strip > 0 {
+		if err = reader.Skip(lc.strip); err != nil {
+			return nil, err
+		}
+	}
+
+	if lc.newHash != nil {
+		body, err := reader.Peek(int(bodyLength))
+		if err != nil {
+			return nil, err
+		}
+		if err = verifyChecksum(lc.newHash(), body); err != nil {
+			return nil, err
+		}
+		bodyLength -= int64(lc.checksumSize)
+	}
+
+	limitReader := ior.NewLimitReader(reader, uint32(bodyLength))
+	message, err = payloadCodec.UnMarshal(limitReader)
+	limitReader.Release()
+	if err != nil {
+		return nil, err
+	}
+
+	if lc.checksumSize > 0 {
+		err = reader.Skip(lc.checksumSize)
+	}
+	return message, err
+}
+
+// readLength peeks the length field, returns the value and the length of the length field
+func (lc *lengthFieldCodec) readLength(reader io.Reader) (value uint64, fieldLength int, err error) {
+	if lc.length != VarintLengthField {
+		buf, err := reader.Peek(lc.offset + lc.length)
+		if err != nil {
+			return 0, 0, err
+		}
+		return lc.getLength(buf[lc.offset:]), lc.length, nil
+	}
+
+	var shift uint
+	for i := 1; i <= binary.MaxVarintLen64; i++ {
+		buf, err := reader.Peek(lc.offset + i)
+		if err != nil {
+			return 0, 0, err
+		}
+		b := buf[lc.offset+i-1]
+		value |= uint64(b&0x7f) << shift
+		if b < 0x80 {
+			return value, i, nil
+		}
+		shift += 7
+	}
+	return 0, 0, ErrMalformedLengthField
+}
+
+func (lc *lengthFieldCodec) littleEndian() bool {
+	return lc.order.Uint16([]byte{1, 0}) == 1
+}
+
+func (lc *lengthFieldCodec) getLength(buf []byte) uint64 {
+	switch lc.length {
+	case 1:
+		return uint64(buf[0])
+	case 2:
+		return uint64(lc.order.Uint16(buf))
+	case 3:
+		if lc.littleEndian() {
+			return uint64(buf[0]) | uint64(buf[1])<<8 | uint64(buf[2])<<16
+		}
+		return uint64(buf[2]) | uint64(buf[1])<<8 | uint64(buf[0])<<16
+	case 4:
+		return uint64(lc.order.Uint32(buf))
+	default:
+		return lc.order.Uint64(buf)
+	}
+}
+
+func (lc *lengthFieldCodec) putLength(buf []byte, value uint64) {
+	switch lc.length {
+	case 1:
+		buf[0] = byte(value)
+	case 2:
+		lc.order.PutUint16(buf, uint16(value))
+	case 3:
+		if lc.littleEndian() {
+			buf[0], buf[1], buf[2] = byte(value), byte(value>>8), byte(value>>16)
+		} else {
+			buf[0], buf[1], buf[2] = byte(value>>16), byte(value>>8), byte(value)
+		}
+	case 4:
+		lc.order.PutUint32(buf, uint32(value))
+	default:
+		lc.order.PutUint64(buf, value)
+	}
+}
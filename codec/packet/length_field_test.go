@@ -0,0 +1,200 @@
+package packet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"strings"
+	"testing"
+
+	"github.com/emove/less/codec/payload"
+	ior "github.com/emove/less/pkg/io/reader"
+	"github.com/emove/less/pkg/io/writer"
+)
+
+func TestLengthFieldCodec_Decode(t *testing.T) {
+	tests := []struct {
+		name  string
+		ops   []LengthFieldOption
+		frame []byte
+		want  string
+	}{
+		{
+			name:  "2 bytes length, strip header",
+			ops:   []LengthFieldOption{WithLengthFieldLength(2), WithInitialBytesToStrip(2)},
+			frame: []byte{0x00, 0x05, 'h', 'e', 'l', 'l', 'o'},
+			want:  "hello",
+		},
+		{
+			name:  "length includes header",
+			ops:   []LengthFieldOption{WithLengthFieldLength(2), WithLengthAdjustment(-2)},
+			frame: []byte{0x00, 0x07, 'h', 'e', 'l', 'l', 'o'},
+			want:  "\x00\x07hello",
+		},
+		{
+			name:  "offset with header before length field",
+			ops:   []LengthFieldOption{WithLengthFieldOffset(2), WithLengthFieldLength(3), WithInitialBytesToStrip(5)},
+			frame: []byte{0xca, 0xfe, 0x00, 0x00, 0x05, 'h', 'e', 'l', 'l', 'o'},
+			want:  "hello",
+		},
+		{
+			name:  "little endian 3 bytes",
+			ops:   []LengthFieldOption{WithLengthFieldLength(3), WithByteOrder(binary.LittleEndian), WithInitialBytesToStrip(3)},
+			frame: []byte{0x05, 0x00, 0x00, 'h', 'e', 'l', 'l', 'o'},
+			want:  "hello",
+		},
+		{
+			name:  "varint",
+			ops:   []LengthFieldOption{WithLengthFieldLength(VarintLengthField), WithInitialBytesToStrip(1)},
+			frame: []byte{0x05, 'h', 'e', 'l', 'l', 'o'},
+			want:  "hello",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lc := NewLengthFieldCodec(tt.ops...)
+			// two frames to make sure the codec consumes exactly one frame each time
+			r := ior.NewBufferReader(bytes.NewReader(append(append([]byte{}, tt.frame...), tt.frame...)))
+			for i := 0; i < 2; i++ {
+				got, err := lc.Decode(r, payload.NewTextCodec())
+				if err != nil {
+					t.Fatalf("Decode() error = %v", err)
+				}
+				if got != tt.want {
+					t.Fatalf("want: %q, but: %q", tt.want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestLengthFieldCodec_RoundTrip(t *testing.T) {
+	long := strings.Repeat("less", 100)
+	tests := []struct {
+		name string
+		ops  []LengthFieldOption
+		want []byte // the header of long message
+	}{
+		{name: "legacy", ops: LegacyVariableLength(), want: []byte{0x00, 0x00, 0x01, 0x90, 0x00}},
+		{name: "1 byte", ops: []LengthFieldOption{WithLengthFieldLength(1), WithInitialBytesToStrip(1)}},
+		{name: "8 bytes little endian", ops: []LengthFieldOption{WithLengthFieldLength(8), WithByteOrder(binary.LittleEndian), WithInitialBytesToStrip(8)},
+			want: []byte{0x90, 0x01, 0, 0, 0, 0, 0, 0}},
+		{name: "varint with offset", ops: []LengthFieldOption{WithLengthFieldOffset(1), WithLengthFieldLength(VarintLengthField), WithInitialBytesToStrip(3)},
+			want: []byte{0x00, 0x90, 0x03}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lc := NewLengthFieldCodec(tt.ops...)
+			buff := &bytes.Buffer{}
+			w := writer.NewBufferWriter(buff)
+
+			if tt.want == nil {
+				// long message overflows the 1 byte length field
+				if err := lc.Encode(long, w, payload.NewTextCodec()); !errors.Is(err, ErrLengthFieldOutOfBounds) {
+					t.Fatalf("want: %v, but: %v", ErrLengthFieldOutOfBounds, err)
+				}
+				return
+			}
+			if err := lc.Encode(long, w, payload.NewTextCodec()); err != nil {
+				t.Fatalf("Encode() error = %v", err)
+			}
+			if !bytes.Equal(buff.Bytes()[:len(tt.want)], tt.want) {
+				t.Fatalf("want header: %v, but: %v", tt.want, buff.Bytes()[:len(tt.want)])
+			}
+			if err := lc.Encode("short", w, payload.NewTextCodec()); err != nil {
+				t.Fatalf("Encode() error = %v", err)
+			}
+
+			r := ior.NewBufferReader(bytes.NewReader(buff.Bytes()))
+			for _, want := range []string{long, "short"} {
+				got, err := lc.Decode(r, payload.NewTextCodec())
+				if err != nil {
+					t.Fatalf("Decode() error = %v", err)
+				}
+				if got != want {
+					t.Fatalf("want: %s, but: %s", want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestLengthFieldCodec_Errors(t *testing.T) {
+	lc := NewLengthFieldCodec(WithMaxFrameLength(8), WithInitialBytesToStrip(4))
+	r := ior.NewBufferReader(bytes.NewReader([]byte{0x00, 0x00, 0x00, 0x10}))
+	if _, err := lc.Decode(r, payload.NewTextCodec()); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("want: %v, but: %v", ErrFrameTooLarge, err)
+	}
+
+	lc = NewLengthFieldCodec(WithLengthAdjustment(-8))
+	r = ior.NewBufferReader(bytes.NewReader([]byte{0x00, 0x00, 0x00, 0x01}))
+	if _, err := lc.Decode(r, payload.NewTextCodec()); !errors.Is(err, ErrInvalidFrameLength) {
+		t.Fatalf("want: %v, but: %v", ErrInvalidFrameLength, err)
+	}
+
+	lc = NewLengthFieldCodec(WithLengthFieldLength(VarintLengthField))
+	if err := lc.Encode("hello", writer.NewBufferWriter(&bytes.Buffer{}), payload.NewTextCodec()); !errors.Is(err, ErrHeaderNotStripped) {
+		t.Fatalf("want: %v, but: %v", ErrHeaderNotStripped, err)
+	}
+}
+
+func TestLengthFieldCodec_EncodeHeader(t *testing.T) {
+	tests := []struct {
+		name string
+		ops  []LengthFieldOption
+		msg  string // includes the header not stripped
+		want []byte
+		// the decoded message with the filled length field
+		decoded string
+	}{
+		{
+			name:    "length includes header",
+			ops:     []LengthFieldOption{WithLengthFieldLength(2), WithLengthAdjustment(-2)},
+			msg:     "\x00\x00hello",
+			want:    []byte{0x00, 0x07, 'h', 'e', 'l', 'l', 'o'},
+			decoded: "\x00\x07hello",
+		},
+		{
+			name:    "length field crosses the stripped header",
+			ops:     []LengthFieldOption{WithLengthFieldOffset(1), WithLengthFieldLength(2), WithInitialBytesToStrip(2)},
+			msg:     "\x00hello",
+			want:    []byte{0x00, 0x00, 0x05, 'h', 'e', 'l', 'l', 'o'},
+			decoded: "\x05hello",
+		},
+		{
+			name:    "checksum",
+			ops:     []LengthFieldOption{WithLengthFieldLength(1), WithChecksum(func() hash.Hash { return crc32.NewIEEE() })},
+			msg:     "\x00hi",
+			want:    []byte{0x06, 'h', 'i', 0x62, 0x86, 0x9d, 0xf3},
+			decoded: "\x06hi",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lc := NewLengthFieldCodec(tt.ops...)
+			buff := &bytes.Buffer{}
+			if err := lc.Encode(tt.msg, writer.NewBufferWriter(buff), payload.NewTextCodec()); err != nil {
+				t.Fatalf("Encode() error = %v", err)
+			}
+			if !bytes.Equal(buff.Bytes(), tt.want) {
+				t.Fatalf("want frame: %x, but: %x", tt.want, buff.Bytes())
+			}
+
+			// the header not stripped is passed to payload codec
+			got, err := lc.Decode(ior.NewBufferReader(bytes.NewReader(tt.want)), payload.NewTextCodec())
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if got != tt.decoded {
+				t.Fatalf("want: %q, but: %q", tt.decoded, got)
+			}
+		})
+	}
+
+	lc := NewLengthFieldCodec(WithLengthFieldOffset(2), WithLengthFieldLength(2))
+	if err := lc.Encode("abc", writer.NewBufferWriter(&bytes.Buffer{}), payload.NewTextCodec()); !errors.Is(err, ErrInvalidFrameLength) {
+		t.Fatalf("want: %v, but: %v", ErrInvalidFrameLength, err)
+	}
+}
@@ -0,0 +1,182 @@
+package packet
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/emove/less/codec"
+	"github.com/emove/less/codec/payload"
+	"github.com/emove/less/pkg/io"
+	ior "github.com/emove/less/pkg/io/reader"
+)
+
+const (
+	defaultRESPMaxFrameLength = 512 << 20
+	// respMaxInlineLength is the max length of inline command and type line, the same as redis
+	respMaxInlineLength = 64 << 10
+)
+
+// RESPOption sets resp packet codec options
+type RESPOption func(rc *respCodec)
+
+// WithRESPMaxFrameLength sets the max length of a RESP value, 512MB by default
+func WithRESPMaxFrameLength(length int) RESPOption {
+	return func(rc *respCodec) {
+		rc.maxFrameLength = length
+	}
+}
+
+// NewRESPCodec returns a redis serialization protocol packet codec, which splits each RESP value, or inline
+// command terminated by newline, as a frame by peeking. So pipelined commands are decoded one by one.
+// It should be used with payload.NewRESPCodec.
+func NewRESPCodec(ops ...RESPOption) codec.PacketCodec {
+	rc := &respCodec{maxFrameLength: defaultRESPMaxFrameLength}
+	for _, op := range ops {
+		op(rc)
+	}
+	return rc
+}
+
+var _ codec.PacketCodec = (*respCodec)(nil)
+
+type respCodec struct {
+	maxFrameLength int
+}
+
+func (*respCodec) Name() string {
+	return "resp-packet-codec"
+}
+
+func (*respCodec) Encode(message interface{}, writer io.Writer, payloadCodec codec.PayloadCodec) (err error) {
+	// RESP values are self-delimiting
+	if err = payloadCodec.Marshal(message, writer); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+func (rc *respCodec) Decode(reader io.Reader, payloadCodec codec.PayloadCodec) (message interface{}, err error) {
+	length, err := rc.scan(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	limitReader := ior.NewLimitReader(reader, uint32(length))
+	defer limitReader.Release()
+
+	return payloadCodec.UnMarshal(limitReader)
+}
+
+// scan peeks the reader to find the length of the next RESP value without consuming it
+func (rc *respCodec) scan(reader io.Reader) (int, error) {
+	s := &respScanner{r: reader, max: rc.maxFrameLength}
+
+	first, err := reader.Peek(1)
+	if err != nil {
+		return 0, err
+	}
+	if !payload.IsRESPPrefix(first[0]) {
+		// inline command
+		if _, err = s.line(); err != nil {
+			return 0, err
+		}
+		return s.pos, nil
+	}
+
+	// the number of values remain to scan, aggregate types add their elements
+	for pending := 1; pending > 0; pending-- {
+		line, err := s.line()
+		if err != nil {
+			return 0, err
+		}
+		if len(line) == 0 {
+			return 0, fmt.Errorf("%w, empty type line", payload.ErrRESPMalformed)
+		}
+
+		switch line[0] {
+		case '$', '!', '=':
+			n, err := parseRESPLength(line)
+			if err != nil {
+				return 0, err
+			}
+			if n >= 0 {
+				// bulk data followed by CRLF
+				if err = s.skip(n + 2); err != nil {
+					return 0, err
+				}
+			}
+		case '*', '~', '>':
+			n, err := parseRESPLength(line)
+			if err != nil {
+				return 0, err
+			}
+			if n > 0 {
+				pending += n
+			}
+		case '%', '|':
+			n, err := parseRESPLength(line)
+			if err != nil {
+				return 0, err
+			}
+			if n > 0 {
+				pending += n * 2
+			}
+			if line[0] == '|' {
+				// the attribute is followed by the actual value
+				pending++
+			}
+		case '+', '-', ':', '_', '#', ',', '(':
+		default:
+			return 0, fmt.Errorf("%w, unknown type prefix: %q", payload.ErrRESPMalformed, line[0])
+		}
+		if pending > s.max {
+			return 0, fmt.Errorf("%w, too many elements", payload.ErrRESPMalformed)
+		}
+	}
+	return s.pos, nil
+}
+
+func parseRESPLength(line []byte) (int, error) {
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n < -1 {
+		return 0, fmt.Errorf("%w, invalid length: %q", payload.ErrRESPMalformed, line)
+	}
+	return n, nil
+}
+
+type respScanner struct {
+	r   io.Reader
+	pos int
+	max int
+}
+
+// line peeks the line starts at pos, and moves pos to the next line
+func (s *respScanner) line() ([]byte, error) {
+	start := s.pos
+	for n := start + 1; n-start <= respMaxInlineLength; n++ {
+		if n > s.max {
+			return nil, fmt.Errorf("%w, max: %d", ErrFrameTooLarge, s.max)
+		}
+		buf, err := s.r.Peek(n)
+		if err != nil {
+			return nil, err
+		}
+		if buf[n-1] == '\n' {
+			s.pos = n
+			end := n - 1
+			if end > start && buf[end-1] == '\r' {
+				end--
+			}
+			return buf[start:end], nil
+		}
+	}
+	return nil, fmt.Errorf("%w, line too long", payload.ErrRESPMalformed)
+}
+
+func (s *respScanner) skip(n int) error {
+	if s.pos+n > s.max {
+		return fmt.Errorf("%w, max: %d", ErrFrameTooLarge, s.max)
+	}
+	s.pos += n
+	return nil
+}
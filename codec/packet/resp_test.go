@@ -0,0 +1,100 @@
+package packet
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/emove/less/codec/payload"
+	ior "github.com/emove/less/pkg/io/reader"
+	"github.com/emove/less/pkg/io/writer"
+)
+
+func TestRESPCodec_Decode(t *testing.T) {
+	pipelined := "*2\r\n$3\r\nGET\r\n$3\r\nkey\r\n" +
+		"PING \"hello world\"\r\n" +
+		"%2\r\n+name\r\n$4\r\nless\r\n:1\r\n*-1\r\n" +
+		"|1\r\n+ttl\r\n:3\r\n$2\r\nok\r\n" +
+		"~2\r\n#t\r\n,1.5\r\n" +
+		">2\r\n(12345678901234567890\r\n_\r\n" +
+		"-ERR unknown\r\n" +
+		"=8\r\ntxt:less\r\n"
+
+	bigNum, _ := new(big.Int).SetString("12345678901234567890", 10)
+	want := []interface{}{
+		[]interface{}{"GET", "key"},
+		[]interface{}{"PING", "hello world"},
+		map[interface{}]interface{}{"name": "less", int64(1): nil},
+		"ok",
+		payload.RESPSet{true, 1.5},
+		payload.RESPPush{bigNum, nil},
+		payload.RESPError("ERR unknown"),
+		"less",
+	}
+
+	rc := NewRESPCodec()
+	r := ior.NewBufferReader(bytes.NewReader([]byte(pipelined)))
+	for i, w := range want {
+		got, err := rc.Decode(r, payload.NewRESPCodec())
+		if err != nil {
+			t.Fatalf("Decode() #%d error = %v", i, err)
+		}
+		if !reflect.DeepEqual(got, w) {
+			t.Fatalf("Decode() #%d want: %#v, but: %#v", i, w, got)
+		}
+	}
+}
+
+func TestRESPCodec_DecodeErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		ops     []RESPOption
+		wantErr error
+	}{
+		{name: "unknown prefix in array", data: "*1\r\n?x\r\n", wantErr: payload.ErrRESPMalformed},
+		{name: "invalid length", data: "$x\r\n", wantErr: payload.ErrRESPMalformed},
+		{name: "frame too large", data: "$100\r\n", ops: []RESPOption{WithRESPMaxFrameLength(64)}, wantErr: ErrFrameTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := ior.NewBufferReader(bytes.NewReader([]byte(tt.data)))
+			if _, err := NewRESPCodec(tt.ops...).Decode(r, payload.NewRESPCodec()); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("want: %v, but: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestRESPCodec_Encode(t *testing.T) {
+	tests := []struct {
+		name string
+		ops  []payload.RESPOption
+		msg  interface{}
+		want string
+	}{
+		{name: "simple string", msg: payload.RESPSimpleString("OK"), want: "+OK\r\n"},
+		{name: "error", msg: errors.New("ERR wrong"), want: "-ERR wrong\r\n"},
+		{name: "bulk string", msg: "less", want: "$4\r\nless\r\n"},
+		{name: "integer", msg: 42, want: ":42\r\n"},
+		{name: "nil", msg: nil, want: "$-1\r\n"},
+		{name: "array", msg: []interface{}{"a", int64(1), nil}, want: "*3\r\n$1\r\na\r\n:1\r\n$-1\r\n"},
+		{name: "resp2 map", msg: map[string]int{"b": 2, "a": 1}, want: "*4\r\n$1\r\na\r\n:1\r\n$1\r\nb\r\n:2\r\n"},
+		{name: "resp3 map", ops: []payload.RESPOption{payload.WithRESP3()}, msg: map[string]bool{"a": true}, want: "%1\r\n$1\r\na\r\n#t\r\n"},
+		{name: "resp3 null", ops: []payload.RESPOption{payload.WithRESP3()}, msg: nil, want: "_\r\n"},
+		{name: "resp3 double", ops: []payload.RESPOption{payload.WithRESP3()}, msg: 1.5, want: ",1.5\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buff := &bytes.Buffer{}
+			if err := NewRESPCodec().Encode(tt.msg, writer.NewBufferWriter(buff), payload.NewRESPCodec(tt.ops...)); err != nil {
+				t.Fatalf("Encode() error = %v", err)
+			}
+			if buff.String() != tt.want {
+				t.Fatalf("want: %q, but: %q", tt.want, buff.String())
+			}
+		})
+	}
+}
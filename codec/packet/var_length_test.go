@@ -30,7 +30,7 @@ func TestVariableLengthCodec_Decode(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run("", func(t *testing.T) {
-			va := &variableLengthCodec{}
+			va := NewVariableLengthCodec()
 			gotMessage, err := va.Decode(tt.args.reader, tt.args.payloadCodec)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Decode() error = %v, wantErr %v", err, tt.wantErr)
@@ -63,7 +63,7 @@ func TestVariableLengthCodec_Encode(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run("", func(t *testing.T) {
-			va := &variableLengthCodec{}
+			va := NewVariableLengthCodec()
 			if err := va.Encode(tt.args.message, tt.args.writer, tt.args.payloadCodec); (err != nil) != tt.wantErr {
 				t.Errorf("Encode() error = %v, wantErr %v", err, tt.wantErr)
 			}
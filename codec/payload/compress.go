@@ -0,0 +1,348 @@
+package payload
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	stdio "io"
+	"sync"
+
+	"github.com/emove/less/codec"
+	"github.com/emove/less/pkg/io"
+	"github.com/emove/less/pkg/io/reader"
+	"github.com/emove/less/pkg/io/writer"
+)
+
+const (
+	// FlagUncompressed marks the payload is not compressed
+	FlagUncompressed byte = 0x00
+	// FlagGzip marks the payload is compressed by gzip
+	FlagGzip byte = 0x01
+	// FlagZlib marks the payload is compressed by zlib
+	FlagZlib byte = 0x02
+	// FlagFlate marks the payload is compressed by flate
+	FlagFlate byte = 0x03
+
+	defaultCompressThreshold   = 1024
+	defaultMaxDecompressedSize = 4 << 20
+	// maxPooledBufferSize is the max capacity of buffers put back to pool
+	maxPooledBufferSize = 64 << 10
+)
+
+var (
+	ErrUnknownCompressor    = errors.New("unknown compressor flag")
+	ErrDecompressedTooLarge = errors.New("decompressed size greater than max size")
+)
+
+// Compressor compresses and decompresses payloads
+type Compressor interface {
+	// Flag returns the header flag byte which marks payloads compressed by the compressor,
+	// FlagUncompressed is reserved
+	Flag() byte
+	// NewWriter returns a writer which compresses data into w
+	NewWriter(w stdio.Writer) (stdio.WriteCloser, error)
+	// NewReader returns a reader which decompresses data from r
+	NewReader(r stdio.Reader) (stdio.ReadCloser, error)
+}
+
+// NewGzipCompressor returns a gzip Compressor with the compression level
+func NewGzipCompressor(level int) Compressor {
+	return &stdCompressor{
+		flag: FlagGzip,
+		newWriter: func(w stdio.Writer) (resetWriter, error) {
+			return gzip.NewWriterLevel(w, level)
+		},
+		newReader: func(r stdio.Reader) (stdio.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+		resetReader: func(rc stdio.ReadCloser, r stdio.Reader) error {
+			return rc.(*gzip.Reader).Reset(r)
+		},
+	}
+}
+
+// NewZlibCompressor returns a zlib Compressor with the compression level
+func NewZlibCompressor(level int) Compressor {
+	return &stdCompressor{
+		flag: FlagZlib,
+		newWriter: func(w stdio.Writer) (resetWriter, error) {
+			return zlib.NewWriterLevel(w, level)
+		},
+		newReader: zlib.NewReader,
+		resetReader: func(rc stdio.ReadCloser, r stdio.Reader) error {
+			return rc.(zlib.Resetter).Reset(r, nil)
+		},
+	}
+}
+
+// NewFlateCompressor returns a flate Compressor with the compression level
+func NewFlateCompressor(level int) Compressor {
+	return &stdCompressor{
+		flag: FlagFlate,
+		newWriter: func(w stdio.Writer) (resetWriter, error) {
+			return flate.NewWriter(w, level)
+		},
+		newReader: func(r stdio.Reader) (stdio.ReadCloser, error) {
+			return flate.NewReader(r), nil
+		},
+		resetReader: func(rc stdio.ReadCloser, r stdio.Reader) error {
+			return rc.(flate.Resetter).Reset(r, nil)
+		},
+	}
+}
+
+// resetWriter is a compress writer which could be reused by Reset
+type resetWriter interface {
+	stdio.WriteCloser
+	Reset(w stdio.Writer)
+}
+
+// stdCompressor pools the writers and readers of std compress packages,
+// they are put back to the pools when closed
+type stdCompressor struct {
+	flag        byte
+	newWriter   func(w stdio.Writer) (resetWriter, error)
+	newReader   func(r stdio.Reader) (stdio.ReadCloser, error)
+	resetReader func(rc stdio.ReadCloser, r stdio.Reader) error
+	writers     sync.Pool
+	readers     sync.Pool
+}
+
+func (sc *stdCompressor) Flag() byte {
+	return sc.flag
+}
+
+func (sc *stdCompressor) NewWriter(w stdio.Writer) (stdio.WriteCloser, error) {
+	if v := sc.writers.Get(); v != nil {
+		zw := v.(resetWriter)
+		zw.Reset(w)
+		return &pooledWriter{resetWriter: zw, pool: &sc.writers}, nil
+	}
+	zw, err := sc.newWriter(w)
+	if err != nil {
+		return nil, err
+	}
+	return &pooledWriter{resetWriter: zw, pool: &sc.writers}, nil
+}
+
+func (sc *stdCompressor) NewReader(r stdio.Reader) (stdio.ReadCloser, error) {
+	if v := sc.readers.Get(); v != nil {
+		zr := v.(stdio.ReadCloser)
+		if err := sc.resetReader(zr, r); err != nil {
+			sc.readers.Put(zr)
+			return nil, err
+		}
+		return &pooledReader{ReadCloser: zr, pool: &sc.readers}, nil
+	}
+	zr, err := sc.newReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return &pooledReader{ReadCloser: zr, pool: &sc.readers}, nil
+}
+
+type pooledWriter struct {
+	resetWriter
+	pool *sync.Pool
+}
+
+// Close flushes the compressed data and puts the writer back to pool
+func (pw *pooledWriter) Close() error {
+	if pw.resetWriter == nil {
+		return nil
+	}
+	err := pw.resetWriter.Close()
+	pw.pool.Put(pw.resetWriter)
+	pw.resetWriter = nil
+	return err
+}
+
+type pooledReader struct {
+	stdio.ReadCloser
+	pool *sync.Pool
+}
+
+// Close puts the reader back to pool
+func (pr *pooledReader) Close() error {
+	if pr.ReadCloser == nil {
+		return nil
+	}
+	err := pr.ReadCloser.Close()
+	pr.pool.Put(pr.ReadCloser)
+	pr.ReadCloser = nil
+	return err
+}
+
+// CompressOption sets compress codec options
+type CompressOption func(cc *compressPayloadCodec)
+
+// WithCompressor sets the Compressor used to compress payloads, gzip with default compression by default.
+// The compressor is also used to decompress payloads marked with its flag.
+func WithCompressor(c Compressor) CompressOption {
+	return func(cc *compressPayloadCodec) {
+		cc.compressor = c
+		cc.decompressors[c.Flag()] = c
+	}
+}
+
+// WithDecompressors adds Compressors which are only used to decompress payloads marked with their flags,
+// gzip, zlib and flate are supported by default.
+func WithDecompressors(cs ...Compressor) CompressOption {
+	return func(cc *compressPayloadCodec) {
+		for _, c := range cs {
+			cc.decompressors[c.Flag()] = c
+		}
+	}
+}
+
+// WithCompressThreshold sets the min size of payload to be compressed, 1024 bytes by default
+func WithCompressThreshold(size int) CompressOption {
+	return func(cc *compressPayloadCodec) {
+		cc.threshold = size
+	}
+}
+
+// WithMaxDecompressedSize sets the max size of decompressed payload, 4MB by default
+func WithMaxDecompressedSize(size int) CompressOption {
+	return func(cc *compressPayloadCodec) {
+		cc.maxSize = size
+	}
+}
+
+// NewCompressCodec returns a payload codec which compresses the payloads marshaled by inner codec when its
+// size reaches the threshold. Each payload is prefixed with a flag byte marks whether and how it's compressed,
+// so peers need no negotiation and small payloads stay uncompressed.
+func NewCompressCodec(inner codec.PayloadCodec, ops ...CompressOption) codec.PayloadCodec {
+	if inner == nil {
+		panic("inner codec can not be nil")
+	}
+	gz := NewGzipCompressor(gzip.DefaultCompression)
+	cc := &compressPayloadCodec{
+		inner:      inner,
+		compressor: gz,
+		decompressors: map[byte]Compressor{
+			FlagGzip:  gz,
+			FlagZlib:  NewZlibCompressor(zlib.DefaultCompression),
+			FlagFlate: NewFlateCompressor(flate.DefaultCompression),
+		},
+		threshold: defaultCompressThreshold,
+		maxSize:   defaultMaxDecompressedSize,
+	}
+	for _, op := range ops {
+		op(cc)
+	}
+	if cc.compressor.Flag() == FlagUncompressed {
+		panic("compressor flag can not be FlagUncompressed")
+	}
+	return cc
+}
+
+var _ codec.PayloadCodec = (*compressPayloadCodec)(nil)
+
+type compressPayloadCodec struct {
+	inner         codec.PayloadCodec
+	compressor    Compressor
+	decompressors map[byte]Compressor
+	threshold     int
+	maxSize       int
+}
+
+var compressBufferPool = sync.Pool{
+	New: func() interface{} { return &bytes.Buffer{} },
+}
+
+func getCompressBuffer() *bytes.Buffer {
+	buff := compressBufferPool.Get().(*bytes.Buffer)
+	buff.Reset()
+	return buff
+}
+
+// putCompressBuffer puts the buffer back to pool, the large one is dropped to avoid holding memory
+func putCompressBuffer(buff *bytes.Buffer) {
+	if buff.Cap() <= maxPooledBufferSize {
+		compressBufferPool.Put(buff)
+	}
+}
+
+func (cc *compressPayloadCodec) Name() string {
+	return "compress-" + cc.inner.Name()
+}
+
+func (cc *compressPayloadCodec) Marshal(message interface{}, w io.Writer) (err error) {
+	buff := getCompressBuffer()
+	defer putCompressBuffer(buff)
+
+	bw := writer.NewBufferWriter(buff)
+	if err = cc.inner.Marshal(message, bw); err != nil {
+		return err
+	}
+	if err = bw.Flush(); err != nil {
+		return err
+	}
+	bw.Release()
+
+	if buff.Len() < cc.threshold {
+		if _, err = w.Write([]byte{FlagUncompressed}); err != nil {
+			return err
+		}
+		_, err = w.Write(buff.Bytes())
+		return err
+	}
+
+	if _, err = w.Write([]byte{cc.compressor.Flag()}); err != nil {
+		return err
+	}
+	cw, err := cc.compressor.NewWriter(w)
+	if err != nil {
+		return err
+	}
+	if _, err = cw.Write(buff.Bytes()); err != nil {
+		return err
+	}
+	return cw.Close()
+}
+
+func (cc *compressPayloadCodec) UnMarshal(r io.Reader) (message interface{}, err error) {
+	flag, err := r.Next(1)
+	if err != nil {
+		return nil, err
+	}
+	length := r.Length() - 1
+
+	if flag[0] == FlagUncompressed {
+		return cc.inner.UnMarshal(reader.NewLimitReader(r, uint32(length)))
+	}
+
+	c, ok := cc.decompressors[flag[0]]
+	if !ok {
+		return nil, fmt.Errorf("%w, flag: 0x%x", ErrUnknownCompressor, flag[0])
+	}
+	body, err := r.Next(length)
+	if err != nil {
+		return nil, err
+	}
+	cr, err := c.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer cr.Close()
+
+	buff := getCompressBuffer()
+	defer putCompressBuffer(buff)
+
+	// read one more byte to detect the oversize payload
+	n, err := buff.ReadFrom(stdio.LimitReader(cr, int64(cc.maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if n > int64(cc.maxSize) {
+		return nil, fmt.Errorf("%w, max: %d", ErrDecompressedTooLarge, cc.maxSize)
+	}
+
+	br := reader.NewBytesReader(buff.Bytes())
+	defer br.Release()
+	return cc.inner.UnMarshal(reader.NewLimitReader(br, uint32(n)))
+}
@@ -0,0 +1,83 @@
+package payload
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/emove/less/pkg/io/reader"
+	"github.com/emove/less/pkg/io/writer"
+)
+
+func TestCompressCodec(t *testing.T) {
+	small := "hello"
+	large := strings.Repeat("less is more. ", 200)
+
+	tests := []struct {
+		name     string
+		ops      []CompressOption
+		msg      string
+		wantFlag byte
+	}{
+		{name: "below threshold", msg: small, wantFlag: FlagUncompressed},
+		{name: "gzip", msg: large, wantFlag: FlagGzip},
+		{name: "zlib", ops: []CompressOption{WithCompressor(NewZlibCompressor(1))}, msg: large, wantFlag: FlagZlib},
+		{name: "flate", ops: []CompressOption{WithCompressor(NewFlateCompressor(1)), WithCompressThreshold(1)}, msg: small, wantFlag: FlagFlate},
+	}
+	// decodes by the default codec, which accepts all built-in compressors
+	decoder := NewCompressCodec(NewTextCodec())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cc := NewCompressCodec(NewTextCodec(), tt.ops...)
+
+			// the pooled writers and readers are reused
+			for i := 0; i < 3; i++ {
+				buff := &bytes.Buffer{}
+				w := writer.NewBufferWriter(buff)
+				if err := cc.Marshal(tt.msg, w); err != nil {
+					t.Fatalf("Marshal() error = %v", err)
+				}
+				_ = w.Flush()
+				data := buff.Bytes()
+				if data[0] != tt.wantFlag {
+					t.Fatalf("want flag: %d, but: %d", tt.wantFlag, data[0])
+				}
+				if tt.wantFlag != FlagUncompressed && len(tt.msg) > 1024 && len(data) >= len(tt.msg) {
+					t.Fatalf("payload not compressed, size: %d", len(data))
+				}
+
+				r := reader.NewLimitReader(reader.NewBufferReader(bytes.NewReader(data)), uint32(len(data)))
+				got, err := decoder.UnMarshal(r)
+				if err != nil {
+					t.Fatalf("UnMarshal() error = %v", err)
+				}
+				if got != tt.msg {
+					t.Fatalf("want: %s, but: %v", tt.msg, got)
+				}
+			}
+		})
+	}
+}
+
+func TestCompressCodec_ZipBomb(t *testing.T) {
+	buff := &bytes.Buffer{}
+	buff.WriteByte(FlagGzip)
+	gw := gzip.NewWriter(buff)
+	_, _ = gw.Write(make([]byte, 1<<20))
+	_ = gw.Close()
+	data := buff.Bytes()
+
+	cc := NewCompressCodec(NewTextCodec(), WithMaxDecompressedSize(1<<10))
+	r := reader.NewLimitReader(reader.NewBufferReader(bytes.NewReader(data)), uint32(len(data)))
+	if _, err := cc.UnMarshal(r); !errors.Is(err, ErrDecompressedTooLarge) {
+		t.Fatalf("want: %v, but: %v", ErrDecompressedTooLarge, err)
+	}
+
+	data = []byte{0x7f, 'a'}
+	r = reader.NewLimitReader(reader.NewBufferReader(bytes.NewReader(data)), uint32(len(data)))
+	if _, err := cc.UnMarshal(r); !errors.Is(err, ErrUnknownCompressor) {
+		t.Fatalf("want: %v, but: %v", ErrUnknownCompressor, err)
+	}
+}
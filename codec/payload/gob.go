@@ -0,0 +1,61 @@
+package payload
+
+import (
+	"bytes"
+	"encoding/gob"
+	"reflect"
+
+	"github.com/emove/less/codec"
+	"github.com/emove/less/pkg/io"
+)
+
+// NewGobCodecWithType returns a gob payload codec which unmarshals messages into a new instance
+// of the type of msg, it can be used as the CodecFactory of TypedCodec.
+func NewGobCodecWithType(msg interface{}) codec.PayloadCodec {
+	return &gobPayloadCodec{msgType: parseType(msg)}
+}
+
+// NewGobCodec returns a gob payload codec which marshals messages as interface values, the
+// concrete types of messages must be registered by gob.Register on both sides.
+func NewGobCodec() codec.PayloadCodec {
+	return &gobPayloadCodec{}
+}
+
+var _ codec.PayloadCodec = (*gobPayloadCodec)(nil)
+
+type gobPayloadCodec struct {
+	msgType reflect.Type
+}
+
+func (*gobPayloadCodec) Name() string {
+	return "gob-payload-codec"
+}
+
+func (gc *gobPayloadCodec) Marshal(message interface{}, writer io.Writer) error {
+	// each payload is self-described, due to the messages may be decoded by different decoders
+	enc := gob.NewEncoder(writer)
+	if gc.msgType != nil {
+		return enc.Encode(message)
+	}
+	return enc.Encode(&message)
+}
+
+func (gc *gobPayloadCodec) UnMarshal(reader io.Reader) (message interface{}, err error) {
+	data, err := reader.Next(reader.Length())
+	if err != nil {
+		return nil, err
+	}
+	dec := gob.NewDecoder(bytes.NewReader(data))
+
+	if gc.msgType != nil {
+		v := reflect.New(gc.msgType)
+		if err = dec.Decode(v.Interface()); err != nil {
+			return nil, err
+		}
+		return v.Interface(), nil
+	}
+	if err = dec.Decode(&message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
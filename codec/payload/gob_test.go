@@ -0,0 +1,47 @@
+package payload
+
+import (
+	"bytes"
+	"encoding/gob"
+	"reflect"
+	"testing"
+
+	"github.com/emove/less/codec"
+	"github.com/emove/less/pkg/io/reader"
+	"github.com/emove/less/pkg/io/writer"
+)
+
+type gobMessage struct {
+	Name string
+	Tags []string
+}
+
+func gobRoundTrip(t *testing.T, c codec.PayloadCodec, msg interface{}) interface{} {
+	buff := &bytes.Buffer{}
+	w := writer.NewBufferWriter(buff)
+	if err := c.Marshal(msg, w); err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	_ = w.Flush()
+
+	r := reader.NewLimitReader(reader.NewBufferReader(buff), uint32(buff.Len()))
+	defer r.Release()
+	got, err := c.UnMarshal(r)
+	if err != nil {
+		t.Fatalf("UnMarshal() error = %v", err)
+	}
+	return got
+}
+
+func Test_gobPayloadCodec(t *testing.T) {
+	msg := &gobMessage{Name: "less", Tags: []string{"a", "b"}}
+
+	if got := gobRoundTrip(t, NewGobCodecWithType(gobMessage{}), msg); !reflect.DeepEqual(got, msg) {
+		t.Fatalf("want: %+v, but: %+v", msg, got)
+	}
+
+	gob.Register(&gobMessage{})
+	if got := gobRoundTrip(t, NewGobCodec(), msg); !reflect.DeepEqual(got, msg) {
+		t.Fatalf("want: %+v, but: %+v", msg, got)
+	}
+}
@@ -0,0 +1,985 @@
+package payload
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emove/less/codec"
+	"github.com/emove/less/pkg/io"
+)
+
+// MessagePack format codes, see https://github.com/msgpack/msgpack/blob/master/spec.md
+const (
+	mpPosFixIntMax = 0x7f
+	mpFixMap       = 0x80
+	mpFixArray     = 0x90
+	mpFixStr       = 0xa0
+	mpNil          = 0xc0
+	mpFalse        = 0xc2
+	mpTrue         = 0xc3
+	mpBin8         = 0xc4
+	mpBin16        = 0xc5
+	mpBin32        = 0xc6
+	mpExt8         = 0xc7
+	mpExt16        = 0xc8
+	mpExt32        = 0xc9
+	mpFloat32      = 0xca
+	mpFloat64      = 0xcb
+	mpUint8        = 0xcc
+	mpUint16       = 0xcd
+	mpUint32       = 0xce
+	mpUint64       = 0xcf
+	mpInt8         = 0xd0
+	mpInt16        = 0xd1
+	mpInt32        = 0xd2
+	mpInt64        = 0xd3
+	mpFixExt1      = 0xd4
+	mpFixExt2      = 0xd5
+	mpFixExt4      = 0xd6
+	mpFixExt8      = 0xd7
+	mpFixExt16     = 0xd8
+	mpStr8         = 0xd9
+	mpStr16        = 0xda
+	mpStr32        = 0xdb
+	mpArray16      = 0xdc
+	mpArray32      = 0xdd
+	mpMap16        = 0xde
+	mpMap32        = 0xdf
+	mpNegFixIntMin = 0xe0
+
+	// mpTimestampExt is the extension type of timestamp
+	mpTimestampExt = -1
+)
+
+var ErrMsgpackMalformed = errors.New("malformed msgpack data")
+
+// MsgpackExt represents a msgpack extension value whose type is not supported
+type MsgpackExt struct {
+	Type int8
+	Data []byte
+}
+
+// NewMsgpackCodec returns a msgpack payload codec which unmarshals maps into map[string]interface{},
+// or map[interface{}]interface{} when the map contains a non-string key. Integers are unmarshalled
+// into int64, or uint64 if greater than math.MaxInt64.
+func NewMsgpackCodec() codec.PayloadCodec {
+	return &msgpackPayloadCodec{}
+}
+
+// NewMsgpackCodecWithType returns a msgpack payload codec which unmarshals messages into
+// a new instance of the type of msg. Struct fields are named by the `msgpack` tag, for
+// example `msgpack:"name,omitempty"`, or the field name if absent, and "-" ignores the field.
+func NewMsgpackCodecWithType(msg interface{}) codec.PayloadCodec {
+	return &msgpackPayloadCodec{msgType: parseType(msg)}
+}
+
+var _ codec.PayloadCodec = (*msgpackPayloadCodec)(nil)
+
+type msgpackPayloadCodec struct {
+	msgType reflect.Type
+}
+
+func (*msgpackPayloadCodec) Name() string {
+	return "msgpack-payload-codec"
+}
+
+func (*msgpackPayloadCodec) Marshal(message interface{}, writer io.Writer) (err error) {
+	e := &msgpackEncoder{w: writer}
+	return e.encode(reflect.ValueOf(message))
+}
+
+func (mc *msgpackPayloadCodec) UnMarshal(reader io.Reader) (message interface{}, err error) {
+	d := &msgpackDecoder{r: reader, limit: reader.Length()}
+	if mc.msgType != nil {
+		v := reflect.New(mc.msgType)
+		if err = d.decodeValue(v.Elem()); err != nil {
+			return nil, err
+		}
+		return v.Interface(), nil
+	}
+	return d.decode()
+}
+
+// ====================================== encoder ============================================ //
+
+var timeType = reflect.TypeOf(time.Time{})
+
+type msgpackEncoder struct {
+	w io.Writer
+}
+
+func (e *msgpackEncoder) encode(v reflect.Value) error {
+	if !v.IsValid() {
+		return e.writeCode(mpNil)
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return e.writeCode(mpNil)
+		}
+		return e.encode(v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			return e.writeCode(mpTrue)
+		}
+		return e.writeCode(mpFalse)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return e.encodeInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return e.encodeUint(v.Uint())
+	case reflect.Float32:
+		buf, err := e.w.Malloc(5)
+		if err != nil {
+			return err
+		}
+		buf[0] = mpFloat32
+		binary.BigEndian.PutUint32(buf[1:], math.Float32bits(float32(v.Float())))
+		return nil
+	case reflect.Float64:
+		buf, err := e.w.Malloc(9)
+		if err != nil {
+			return err
+		}
+		buf[0] = mpFloat64
+		binary.BigEndian.PutUint64(buf[1:], math.Float64bits(v.Float()))
+		return nil
+	case reflect.String:
+		return e.encodeString(v.String())
+	case reflect.Slice:
+		if v.IsNil() {
+			return e.writeCode(mpNil)
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return e.encodeBytes(v.Bytes())
+		}
+		return e.encodeArray(v)
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			buf := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(buf), v)
+			return e.encodeBytes(buf)
+		}
+		return e.encodeArray(v)
+	case reflect.Map:
+		if v.IsNil() {
+			return e.writeCode(mpNil)
+		}
+		if err := e.writeLength(v.Len(), mpFixMap, 0x0f, mpMap16, mpMap32); err != nil {
+			return err
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			if err := e.encode(iter.Key()); err != nil {
+				return err
+			}
+			if err := e.encode(iter.Value()); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Struct:
+		if v.Type() == timeType {
+			return e.encodeTime(v.Interface().(time.Time))
+		}
+		return e.encodeStruct(v)
+	default:
+		return fmt.Errorf("msgpack: unsupported type %v", v.Type())
+	}
+}
+
+func (e *msgpackEncoder) encodeInt(i int64) error {
+	if i >= 0 {
+		return e.encodeUint(uint64(i))
+	}
+	if i >= -32 {
+		return e.writeCode(byte(i))
+	}
+	switch {
+	case i >= math.MinInt8:
+		buf, err := e.w.Malloc(2)
+		if err != nil {
+			return err
+		}
+		buf[0], buf[1] = mpInt8, byte(i)
+	case i >= math.MinInt16:
+		buf, err := e.w.Malloc(3)
+		if err != nil {
+			return err
+		}
+		buf[0] = mpInt16
+		binary.BigEndian.PutUint16(buf[1:], uint16(i))
+	case i >= math.MinInt32:
+		buf, err := e.w.Malloc(5)
+		if err != nil {
+			return err
+		}
+		buf[0] = mpInt32
+		binary.BigEndian.PutUint32(buf[1:], uint32(i))
+	default:
+		buf, err := e.w.Malloc(9)
+		if err != nil {
+			return err
+		}
+		buf[0] = mpInt64
+		binary.BigEndian.PutUint64(buf[1:], uint64(i))
+	}
+	return nil
+}
+
+func (e *msgpackEncoder) encodeUint(u uint64) error {
+	switch {
+	case u <= mpPosFixIntMax:
+		return e.writeCode(byte(u))
+	case u <= math.MaxUint8:
+		buf, err := e.w.Malloc(2)
+		if err != nil {
+			return err
+		}
+		buf[0], buf[1] = mpUint8, byte(u)
+	case u <= math.MaxUint16:
+		buf, err := e.w.Malloc(3)
+		if err != nil {
+			return err
+		}
+		buf[0] = mpUint16
+		binary.BigEndian.PutUint16(buf[1:], uint16(u))
+	case u <= math.MaxUint32:
+		buf, err := e.w.Malloc(5)
+		if err != nil {
+			return err
+		}
+		buf[0] = mpUint32
+		binary.BigEndian.PutUint32(buf[1:], uint32(u))
+	default:
+		buf, err := e.w.Malloc(9)
+		if err != nil {
+			return err
+		}
+		buf[0] = mpUint64
+		binary.BigEndian.PutUint64(buf[1:], u)
+	}
+	return nil
+}
+
+func (e *msgpackEncoder) encodeString(s string) error {
+	n := len(s)
+	var err error
+	switch {
+	case n <= 31:
+		err = e.writeCode(mpFixStr | byte(n))
+	case n <= math.MaxUint8:
+		err = e.writeHeader(mpStr8, 1, uint64(n))
+	case n <= math.MaxUint16:
+		err = e.writeHeader(mpStr16, 2, uint64(n))
+	default:
+		err = e.writeHeader(mpStr32, 4, uint64(n))
+	}
+	if err != nil || n == 0 {
+		return err
+	}
+	buf, err := e.w.Malloc(n)
+	if err != nil {
+		return err
+	}
+	copy(buf, s)
+	return nil
+}
+
+func (e *msgpackEncoder) encodeBytes(b []byte) error {
+	n := len(b)
+	var err error
+	switch {
+	case n <= math.MaxUint8:
+		err = e.writeHeader(mpBin8, 1, uint64(n))
+	case n <= math.MaxUint16:
+		err = e.writeHeader(mpBin16, 2, uint64(n))
+	default:
+		err = e.writeHeader(mpBin32, 4, uint64(n))
+	}
+	if err != nil || n == 0 {
+		return err
+	}
+	_, err = e.w.Write(b)
+	return err
+}
+
+func (e *msgpackEncoder) encodeArray(v reflect.Value) error {
+	if err := e.writeLength(v.Len(), mpFixArray, 0x0f, mpArray16, mpArray32); err != nil {
+		return err
+	}
+	for i := 0; i < v.Len(); i++ {
+		if err := e.encode(v.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *msgpackEncoder) encodeStruct(v reflect.Value) error {
+	fields := cachedFields(v.Type())
+
+	n := 0
+	for _, f := range fields {
+		if !f.omitEmpty || !v.FieldByIndex(f.index).IsZero() {
+			n++
+		}
+	}
+	if err := e.writeLength(n, mpFixMap, 0x0f, mpMap16, mpMap32); err != nil {
+		return err
+	}
+	for _, f := range fields {
+		fv := v.FieldByIndex(f.index)
+		if f.omitEmpty && fv.IsZero() {
+			continue
+		}
+		if err := e.encodeString(f.name); err != nil {
+			return err
+		}
+		if err := e.encode(fv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeTime encodes time with timestamp 96 format
+func (e *msgpackEncoder) encodeTime(t time.Time) error {
+	buf, err := e.w.Malloc(15)
+	if err != nil {
+		return err
+	}
+	buf[0], buf[1], buf[2] = mpExt8, 12, 0xff // timestamp extension type -1
+	binary.BigEndian.PutUint32(buf[3:], uint32(t.Nanosecond()))
+	binary.BigEndian.PutUint64(buf[7:], uint64(t.Unix()))
+	return nil
+}
+
+func (e *msgpackEncoder) writeCode(code byte) error {
+	buf, err := e.w.Malloc(1)
+	if err != nil {
+		return err
+	}
+	buf[0] = code
+	return nil
+}
+
+func (e *msgpackEncoder) writeHeader(code byte, size int, n uint64) error {
+	buf, err := e.w.Malloc(1 + size)
+	if err != nil {
+		return err
+	}
+	buf[0] = code
+	switch size {
+	case 1:
+		buf[1] = byte(n)
+	case 2:
+		binary.BigEndian.PutUint16(buf[1:], uint16(n))
+	default:
+		binary.BigEndian.PutUint32(buf[1:], uint32(n))
+	}
+	return nil
+}
+
+func (e *msgpackEncoder) writeLength(n int, fix byte, fixMax int, code16, code32 byte) error {
+	switch {
+	case n <= fixMax:
+		return e.writeCode(fix | byte(n))
+	case n <= math.MaxUint16:
+		return e.writeHeader(code16, 2, uint64(n))
+	default:
+		return e.writeHeader(code32, 4, uint64(n))
+	}
+}
+
+type msgpackField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+var fieldCache sync.Map // map[reflect.Type][]msgpackField
+
+func cachedFields(typ reflect.Type) []msgpackField {
+	if f, ok := fieldCache.Load(typ); ok {
+		return f.([]msgpackField)
+	}
+
+	var fields []msgpackField
+	for _, sf := range reflect.VisibleFields(typ) {
+		tag := sf.Tag.Get("msgpack")
+		if tag == "-" || !sf.IsExported() {
+			continue
+		}
+		if sf.Anonymous && tag == "" && sf.Type.Kind() == reflect.Struct {
+			// the fields of embedded struct are promoted
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = sf.Name
+		}
+		fields = append(fields, msgpackField{name: name, index: sf.Index, omitEmpty: opts == "omitempty"})
+	}
+
+	f, _ := fieldCache.LoadOrStore(typ, fields)
+	return f.([]msgpackField)
+}
+
+// ====================================== decoder ============================================ //
+
+// msgpackMaxDepth is the maximum nesting depth of arrays and maps, the deeper data is
+// considered malformed to avoid overflowing the stack
+const msgpackMaxDepth = 512
+
+type msgpackDecoder struct {
+	r     io.Reader
+	limit int // the length of data
+	read  int // the length of data read, the unread bytes are used to check the length of containers
+	depth int // the nesting depth of the current container
+}
+
+// next reads n bytes and counts them
+func (d *msgpackDecoder) next(n int) ([]byte, error) {
+	buf, err := d.r.Next(n)
+	if err != nil {
+		return nil, err
+	}
+	d.read += n
+	return buf, nil
+}
+
+// enter enters a nested container, leave must be called after the container decoded
+func (d *msgpackDecoder) enter() error {
+	if d.depth++; d.depth > msgpackMaxDepth {
+		return fmt.Errorf("%w, nesting depth exceeds %d", ErrMsgpackMalformed, msgpackMaxDepth)
+	}
+	return nil
+}
+
+func (d *msgpackDecoder) leave() {
+	d.depth--
+}
+
+func (d *msgpackDecoder) readCode() (byte, error) {
+	buf, err := d.next(1)
+	if err != nil {
+		return 0, err
+	}
+	return buf[0], nil
+}
+
+func (d *msgpackDecoder) peekCode() (byte, error) {
+	buf, err := d.r.Peek(1)
+	if err != nil {
+		return 0, err
+	}
+	return buf[0], nil
+}
+
+func (d *msgpackDecoder) readUint(size int) (uint64, error) {
+	buf, err := d.next(size)
+	if err != nil {
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return uint64(buf[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(buf)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(buf)), nil
+	default:
+		return binary.BigEndian.Uint64(buf), nil
+	}
+}
+
+// readLength reads the length of value, and checks it with the unread bytes, each element of
+// value takes at least unit bytes, so that a short frame can not claim a huge allocation
+func (d *msgpackDecoder) readLength(size, unit int) (int, error) {
+	n, err := d.readUint(size)
+	if err != nil {
+		return 0, err
+	}
+	if remaining := uint64(d.limit - d.read); n > remaining/uint64(unit) {
+		return 0, fmt.Errorf("%w, length %d greater than unread data length %d", ErrMsgpackMalformed, n, remaining)
+	}
+	return int(n), nil
+}
+
+// containerLength returns the length of array or map, ok is false if code is not the container
+func (d *msgpackDecoder) containerLength(code byte, fix, code16, code32 byte) (n int, ok bool, err error) {
+	// each map entry takes at least 2 bytes, and each array element 1 byte
+	unit := 1
+	if fix == mpFixMap {
+		unit = 2
+	}
+	switch {
+	case code&0xf0 == fix:
+		return int(code & 0x0f), true, nil
+	case code == code16:
+		n, err = d.readLength(2, unit)
+		return n, true, err
+	case code == code32:
+		n, err = d.readLength(4, unit)
+		return n, true, err
+	}
+	return 0, false, nil
+}
+
+// decode decodes the next value into a generic Go value
+func (d *msgpackDecoder) decode() (interface{}, error) {
+	code, err := d.readCode()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case code <= mpPosFixIntMax:
+		return int64(code), nil
+	case code >= mpNegFixIntMin:
+		return int64(int8(code)), nil
+	case code&0xe0 == mpFixStr:
+		return d.readString(int(code & 0x1f))
+	}
+
+	if n, ok, err := d.containerLength(code, mpFixArray, mpArray16, mpArray32); ok {
+		if err != nil {
+			return nil, err
+		}
+		if err = d.enter(); err != nil {
+			return nil, err
+		}
+		defer d.leave()
+		arr := make([]interface{}, n)
+		for i := range arr {
+			if arr[i], err = d.decode(); err != nil {
+				return nil, err
+			}
+		}
+		return arr, nil
+	}
+
+	if n, ok, err := d.containerLength(code, mpFixMap, mpMap16, mpMap32); ok {
+		if err != nil {
+			return nil, err
+		}
+		if err = d.enter(); err != nil {
+			return nil, err
+		}
+		defer d.leave()
+		return d.decodeMap(n)
+	}
+
+	switch code {
+	case mpNil:
+		return nil, nil
+	case mpFalse:
+		return false, nil
+	case mpTrue:
+		return true, nil
+	case mpUint8, mpUint16, mpUint32, mpUint64:
+		u, err := d.readUint(1 << (code - mpUint8))
+		if err != nil || u > math.MaxInt64 {
+			return u, err
+		}
+		return int64(u), nil
+	case mpInt8:
+		u, err := d.readUint(1)
+		return int64(int8(u)), err
+	case mpInt16:
+		u, err := d.readUint(2)
+		return int64(int16(u)), err
+	case mpInt32:
+		u, err := d.readUint(4)
+		return int64(int32(u)), err
+	case mpInt64:
+		u, err := d.readUint(8)
+		return int64(u), err
+	case mpFloat32:
+		u, err := d.readUint(4)
+		return math.Float32frombits(uint32(u)), err
+	case mpFloat64:
+		u, err := d.readUint(8)
+		return math.Float64frombits(u), err
+	case mpStr8, mpStr16, mpStr32:
+		n, err := d.readLength(1<<(code-mpStr8), 1)
+		if err != nil {
+			return nil, err
+		}
+		return d.readString(n)
+	case mpBin8, mpBin16, mpBin32:
+		n, err := d.readLength(1<<(code-mpBin8), 1)
+		if err != nil {
+			return nil, err
+		}
+		return d.readBytes(n)
+	case mpFixExt1, mpFixExt2, mpFixExt4, mpFixExt8, mpFixExt16:
+		return d.decodeExt(1 << (code - mpFixExt1))
+	case mpExt8, mpExt16, mpExt32:
+		n, err := d.readLength(1<<(code-mpExt8), 1)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeExt(n)
+	}
+	return nil, fmt.Errorf("%w, unknown code: 0x%x", ErrMsgpackMalformed, code)
+}
+
+func (d *msgpackDecoder) decodeMap(n int) (interface{}, error) {
+	m := make(map[string]interface{}, n)
+	var im map[interface{}]interface{}
+	for i := 0; i < n; i++ {
+		key, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		value, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		if s, ok := key.(string); ok && im == nil {
+			m[s] = value
+			continue
+		}
+		if im == nil {
+			// non-string key found, converts to map[interface{}]interface{}
+			im = make(map[interface{}]interface{}, n)
+			for k, v := range m {
+				im[k] = v
+			}
+		}
+		switch key.(type) {
+		case []interface{}, map[string]interface{}, map[interface{}]interface{}, []byte:
+			return nil, fmt.Errorf("msgpack: unhashable map key type %T", key)
+		}
+		im[key] = value
+	}
+	if im != nil {
+		return im, nil
+	}
+	return m, nil
+}
+
+func (d *msgpackDecoder) decodeExt(n int) (interface{}, error) {
+	typ, err := d.readUint(1)
+	if err != nil {
+		return nil, err
+	}
+	if int8(typ) == mpTimestampExt {
+		return d.readTime(n)
+	}
+	data, err := d.readBytes(n)
+	if err != nil {
+		return nil, err
+	}
+	return &MsgpackExt{Type: int8(typ), Data: data}, nil
+}
+
+func (d *msgpackDecoder) readTime(n int) (time.Time, error) {
+	buf, err := d.next(n)
+	if err != nil {
+		return time.Time{}, err
+	}
+	switch n {
+	case 4:
+		return time.Unix(int64(binary.BigEndian.Uint32(buf)), 0), nil
+	case 8:
+		v := binary.BigEndian.Uint64(buf)
+		return time.Unix(int64(v&0x3ffffffff), int64(v>>34)), nil
+	case 12:
+		return time.Unix(int64(binary.BigEndian.Uint64(buf[4:])), int64(binary.BigEndian.Uint32(buf))), nil
+	}
+	return time.Time{}, fmt.Errorf("%w, timestamp length: %d", ErrMsgpackMalformed, n)
+}
+
+func (d *msgpackDecoder) readString(n int) (string, error) {
+	if n == 0 {
+		return "", nil
+	}
+	buf, err := d.next(n)
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+func (d *msgpackDecoder) readBytes(n int) ([]byte, error) {
+	buf, err := d.next(n)
+	if err != nil {
+		return nil, err
+	}
+	// copy it due to the reader buffer will be released
+	b := make([]byte, n)
+	copy(b, buf)
+	return b, nil
+}
+
+// decodeValue decodes the next value into v
+func (d *msgpackDecoder) decodeValue(v reflect.Value) error {
+	code, err := d.peekCode()
+	if err != nil {
+		return err
+	}
+
+	if code == mpNil {
+		_, _ = d.readCode()
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return d.decodeValue(v.Elem())
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			break
+		}
+		_, _ = d.readCode()
+		n, ok, err := d.containerLength(code, mpFixArray, mpArray16, mpArray32)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("msgpack: can not decode code 0x%x into %v", code, v.Type())
+		}
+		if err = d.enter(); err != nil {
+			return err
+		}
+		defer d.leave()
+		s := reflect.MakeSlice(v.Type(), n, n)
+		for i := 0; i < n; i++ {
+			if err = d.decodeValue(s.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+		return nil
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			break
+		}
+		_, _ = d.readCode()
+		n, ok, err := d.containerLength(code, mpFixArray, mpArray16, mpArray32)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("msgpack: can not decode code 0x%x into %v", code, v.Type())
+		}
+		if err = d.enter(); err != nil {
+			return err
+		}
+		defer d.leave()
+		for i := 0; i < n; i++ {
+			if i < v.Len() {
+				err = d.decodeValue(v.Index(i))
+			} else {
+				_, err = d.decode()
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		_, _ = d.readCode()
+		n, ok, err := d.containerLength(code, mpFixMap, mpMap16, mpMap32)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("msgpack: can not decode code 0x%x into %v", code, v.Type())
+		}
+		if err = d.enter(); err != nil {
+			return err
+		}
+		defer d.leave()
+		m := reflect.MakeMapWithSize(v.Type(), n)
+		for i := 0; i < n; i++ {
+			key := reflect.New(v.Type().Key()).Elem()
+			if err = d.decodeValue(key); err != nil {
+				return err
+			}
+			value := reflect.New(v.Type().Elem()).Elem()
+			if err = d.decodeValue(value); err != nil {
+				return err
+			}
+			m.SetMapIndex(key, value)
+		}
+		v.Set(m)
+		return nil
+	case reflect.Struct:
+		if v.Type() == timeType {
+			break
+		}
+		_, _ = d.readCode()
+		n, ok, err := d.containerLength(code, mpFixMap, mpMap16, mpMap32)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("msgpack: can not decode code 0x%x into %v", code, v.Type())
+		}
+		if err = d.enter(); err != nil {
+			return err
+		}
+		defer d.leave()
+		return d.decodeStruct(v, n)
+	}
+
+	// scalar values
+	value, err := d.decode()
+	if err != nil {
+		return err
+	}
+	return setValue(v, value)
+}
+
+func (d *msgpackDecoder) decodeStruct(v reflect.Value, n int) error {
+	fields := cachedFields(v.Type())
+	for i := 0; i < n; i++ {
+		key, err := d.decode()
+		if err != nil {
+			return err
+		}
+		name, _ := key.(string)
+
+		var field *msgpackField
+		for j := range fields {
+			if fields[j].name == name {
+				field = &fields[j]
+				break
+			}
+		}
+		if field == nil {
+			// skip unknown field
+			if _, err = d.decode(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		fv, err := fieldByIndex(v, field.index)
+		if err != nil {
+			return err
+		}
+		if err = d.decodeValue(fv); err != nil {
+			return fmt.Errorf("msgpack: decode field %s err: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// fieldByIndex returns the nested field, allocates the embedded struct pointer if nil
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, error) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return v, fmt.Errorf("msgpack: can not set embedded pointer to unexported struct %v", v.Type().Elem())
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, nil
+}
+
+// setValue sets the generic decoded value into v
+func setValue(v reflect.Value, value interface{}) error {
+	if v.Kind() == reflect.Interface && v.NumMethod() == 0 {
+		if value != nil {
+			v.Set(reflect.ValueOf(value))
+		}
+		return nil
+	}
+
+	switch val := value.(type) {
+	case bool:
+		if v.Kind() == reflect.Bool {
+			v.SetBool(val)
+			return nil
+		}
+	case int64:
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if !v.OverflowInt(val) {
+				v.SetInt(val)
+				return nil
+			}
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if val >= 0 && !v.OverflowUint(uint64(val)) {
+				v.SetUint(uint64(val))
+				return nil
+			}
+		case reflect.Float32, reflect.Float64:
+			v.SetFloat(float64(val))
+			return nil
+		}
+	case uint64:
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if val <= math.MaxInt64 && !v.OverflowInt(int64(val)) {
+				v.SetInt(int64(val))
+				return nil
+			}
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if !v.OverflowUint(val) {
+				v.SetUint(val)
+				return nil
+			}
+		case reflect.Float32, reflect.Float64:
+			v.SetFloat(float64(val))
+			return nil
+		}
+	case float32:
+		if v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64 {
+			v.SetFloat(float64(val))
+			return nil
+		}
+	case float64:
+		if v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64 {
+			v.SetFloat(val)
+			return nil
+		}
+	case string:
+		switch {
+		case v.Kind() == reflect.String:
+			v.SetString(val)
+			return nil
+		case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+			v.SetBytes([]byte(val))
+			return nil
+		}
+	case []byte:
+		switch {
+		case v.Kind() == reflect.String:
+			v.SetString(string(val))
+			return nil
+		case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+			v.SetBytes(val)
+			return nil
+		case v.Kind() == reflect.Array && v.Type().Elem().Kind() == reflect.Uint8:
+			reflect.Copy(v, reflect.ValueOf(val))
+			return nil
+		}
+	case time.Time:
+		if v.Type() == timeType {
+			v.Set(reflect.ValueOf(val))
+			return nil
+		}
+	}
+	return fmt.Errorf("msgpack: can not decode %T into %v", value, v.Type())
+}
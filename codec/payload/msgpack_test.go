@@ -0,0 +1,173 @@
+package payload
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/emove/less/codec"
+	"github.com/emove/less/pkg/io/reader"
+	"github.com/emove/less/pkg/io/writer"
+)
+
+type mpBase struct {
+	ID int64 `msgpack:"id"`
+}
+
+type mpStruct struct {
+	mpBase
+	Name    string            `msgpack:"name"`
+	Score   float64           `msgpack:"score"`
+	Tags    []string          `msgpack:"tags"`
+	Attrs   map[string]uint16 `msgpack:"attrs"`
+	Data    []byte            `msgpack:"data"`
+	Created time.Time         `msgpack:"created"`
+	Next    *mpStruct         `msgpack:"next,omitempty"`
+	Ignored string            `msgpack:"-"`
+}
+
+func msgpackRoundTrip(t *testing.T, c codec.PayloadCodec, msg interface{}) interface{} {
+	buff := &bytes.Buffer{}
+	w := writer.NewBufferWriter(buff)
+	if err := c.Marshal(msg, w); err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	data := buff.Bytes()
+	r := reader.NewLimitReader(reader.NewBufferReader(bytes.NewReader(data)), uint32(len(data)))
+	got, err := c.UnMarshal(r)
+	if err != nil {
+		t.Fatalf("UnMarshal() error = %v", err)
+	}
+	return got
+}
+
+func Test_msgpackPayloadCodec_WithType(t *testing.T) {
+	msg := &mpStruct{
+		mpBase:  mpBase{ID: -300},
+		Name:    "less",
+		Score:   99.5,
+		Tags:    []string{"a", "b"},
+		Attrs:   map[string]uint16{"port": 8080},
+		Data:    []byte{1, 2, 3},
+		Created: time.Unix(1650000000, 123),
+		Next:    &mpStruct{Name: "next", Created: time.Unix(0, 0)},
+		Ignored: "ignored",
+	}
+	got := msgpackRoundTrip(t, NewMsgpackCodecWithType(mpStruct{}), msg)
+
+	want := *msg
+	want.Ignored = ""
+	gotMsg := got.(*mpStruct)
+	if !gotMsg.Created.Equal(want.Created) || !gotMsg.Next.Created.Equal(want.Next.Created) {
+		t.Fatalf("want created: %v, but: %v", want.Created, gotMsg.Created)
+	}
+	want.Created, gotMsg.Created = time.Time{}, time.Time{}
+	want.Next = &mpStruct{Name: "next"}
+	gotMsg.Next.Created = time.Time{}
+	if !reflect.DeepEqual(gotMsg, &want) {
+		t.Fatalf("want: %+v, but: %+v", &want, gotMsg)
+	}
+}
+
+func Test_msgpackPayloadCodec_Generic(t *testing.T) {
+	long := string(bytes.Repeat([]byte("x"), 300))
+	msg := map[string]interface{}{
+		"int":    -1,
+		"uint":   uint32(70000),
+		"bool":   true,
+		"nil":    nil,
+		"float":  float32(1.5),
+		"long":   long,
+		"array":  []interface{}{"a", 1},
+		"nested": map[int]string{1: "one"},
+	}
+	got := msgpackRoundTrip(t, NewMsgpackCodec(), msg)
+
+	want := map[string]interface{}{
+		"int":    int64(-1),
+		"uint":   int64(70000),
+		"bool":   true,
+		"nil":    nil,
+		"float":  float32(1.5),
+		"long":   long,
+		"array":  []interface{}{"a", int64(1)},
+		"nested": map[interface{}]interface{}{int64(1): "one"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("want: %v, but: %v", want, got)
+	}
+}
+
+func Test_msgpackPayloadCodec_Malformed(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "unknown code", data: []byte{0xc1}},
+		{name: "array length overflow", data: []byte{0xdd, 0xff, 0xff, 0xff, 0xff}},
+		{name: "short string", data: []byte{0xa5, 'a'}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := reader.NewLimitReader(reader.NewBufferReader(bytes.NewReader(tt.data)), uint32(len(tt.data)))
+			if _, err := NewMsgpackCodec().UnMarshal(r); err == nil {
+				t.Fatalf("want error, but: nil")
+			}
+		})
+	}
+
+	data := []byte{0xdd, 0xff, 0xff, 0xff, 0xff}
+	r := reader.NewLimitReader(reader.NewBufferReader(bytes.NewReader(data)), uint32(len(data)))
+	if _, err := NewMsgpackCodec().UnMarshal(r); !errors.Is(err, ErrMsgpackMalformed) {
+		t.Fatalf("want: %v, but: %v", ErrMsgpackMalformed, err)
+	}
+}
+
+func Test_msgpackPayloadCodec_Depth(t *testing.T) {
+	type nested struct {
+		Next []nested
+	}
+	decode := func(mc codec.PayloadCodec, data []byte) error {
+		r := reader.NewLimitReader(reader.NewBufferReader(bytes.NewReader(data)), uint32(len(data)))
+		_, err := mc.UnMarshal(r)
+		return err
+	}
+
+	// nested fixarrays
+	deep := append(bytes.Repeat([]byte{0x91}, msgpackMaxDepth+1), 0xc0)
+	if err := decode(NewMsgpackCodec(), deep); !errors.Is(err, ErrMsgpackMalformed) {
+		t.Fatalf("want: %v, but: %v", ErrMsgpackMalformed, err)
+	}
+	if err := decode(NewMsgpackCodec(), deep[1:]); err != nil {
+		t.Fatalf("want the maximum depth decoded, but: %v", err)
+	}
+
+	// nested array32 claiming the length of whole data, which allocates a lot if the
+	// length is not checked with the unread bytes
+	n := msgpackMaxDepth * 5
+	deep = make([]byte, 0, n)
+	for len(deep) < n {
+		deep = append(deep, 0xdd, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if err := decode(NewMsgpackCodec(), deep); !errors.Is(err, ErrMsgpackMalformed) {
+		t.Fatalf("want: %v, but: %v", ErrMsgpackMalformed, err)
+	}
+	runtime.ReadMemStats(&after)
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+		t.Fatalf("want less than 1MB allocated, but: %d bytes", allocated)
+	}
+
+	// nested structs
+	deep = append(bytes.Repeat([]byte{0x81, 0xa4, 'N', 'e', 'x', 't', 0x91}, msgpackMaxDepth), 0x80)
+	if err := decode(NewMsgpackCodecWithType(nested{}), deep); !errors.Is(err, ErrMsgpackMalformed) {
+		t.Fatalf("want: %v, but: %v", ErrMsgpackMalformed, err)
+	}
+}
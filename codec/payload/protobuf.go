@@ -0,0 +1,192 @@
+package payload
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/emove/less/codec"
+	"github.com/emove/less/pkg/io"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+var (
+	ErrMessageNotProto     = errors.New("message is not a proto.Message")
+	ErrMessageSizeTooLarge = errors.New("message size greater than max size")
+)
+
+// ProtobufOption sets protobuf codec options
+type ProtobufOption func(pc *protobufPayloadCodec)
+
+// WithProtobufMaxSize sets the max size of message body, a message greater than
+// max size will not be marshaled or unmarshalled. Zero means no limit.
+func WithProtobufMaxSize(size uint32) ProtobufOption {
+	return func(pc *protobufPayloadCodec) {
+		pc.maxSize = size
+	}
+}
+
+// NewProtobufCodec returns a protobuf payload codec which unmarshals messages into the type of msg
+func NewProtobufCodec(msg proto.Message, ops ...ProtobufOption) codec.PayloadCodec {
+	if msg == nil {
+		panic("msg type can not be nil")
+	}
+	pc := &protobufPayloadCodec{msgType: msg.ProtoReflect().Type()}
+	for _, op := range ops {
+		op(pc)
+	}
+	return pc
+}
+
+// ProtobufCodecFactory returns a CodecFactory of TypedCodec creating protobuf payload codecs,
+// the registered messages must be proto.Message
+func ProtobufCodecFactory(ops ...ProtobufOption) CodecFactory {
+	return func(msg interface{}) codec.PayloadCodec {
+		pm, ok := msg.(proto.Message)
+		if !ok {
+			panic(fmt.Sprintf("%v, type: %T", ErrMessageNotProto, msg))
+		}
+		return NewProtobufCodec(pm, ops...)
+	}
+}
+
+// NewProtobufCodecWithResolver returns a protobuf payload codec which prefixes each message with its
+// full name as the type tag, and unmarshals messages into the type found by the tag from resolver.
+// The protoregistry.GlobalTypes contains all linked in message types, or uses NewProtobufTypes to
+// specify types.
+func NewProtobufCodecWithResolver(resolver protoregistry.MessageTypeResolver, ops ...ProtobufOption) codec.PayloadCodec {
+	if resolver == nil {
+		panic("resolver can not be nil")
+	}
+	pc := &protobufPayloadCodec{resolver: resolver}
+	for _, op := range ops {
+		op(pc)
+	}
+	return pc
+}
+
+// NewProtobufTypes returns a registry contains the given message types
+func NewProtobufTypes(msgs ...proto.Message) *protoregistry.Types {
+	types := &protoregistry.Types{}
+	for _, msg := range msgs {
+		if err := types.RegisterMessage(msg.ProtoReflect().Type()); err != nil {
+			panic(err)
+		}
+	}
+	return types
+}
+
+var _ codec.PayloadCodec = (*protobufPayloadCodec)(nil)
+
+type protobufPayloadCodec struct {
+	msgType  protoreflect.MessageType
+	resolver protoregistry.MessageTypeResolver
+	maxSize  uint32
+}
+
+func (*protobufPayloadCodec) Name() string {
+	return "protobuf-payload-codec"
+}
+
+func (pc *protobufPayloadCodec) Marshal(message interface{}, writer io.Writer) (err error) {
+	msg, ok := message.(proto.Message)
+	if !ok {
+		return ErrMessageNotProto
+	}
+
+	size := proto.Size(msg)
+	if pc.maxSize > 0 && uint32(size) > pc.maxSize {
+		return fmt.Errorf("%w, size: %d, max: %d", ErrMessageSizeTooLarge, size, pc.maxSize)
+	}
+
+	if pc.resolver != nil {
+		if err = writeTag(writer, string(msg.ProtoReflect().Descriptor().FullName())); err != nil {
+			return err
+		}
+	}
+
+	if size == 0 {
+		return nil
+	}
+
+	// marshal into the writer buffer directly
+	buf, err := writer.Malloc(size)
+	if err != nil {
+		return err
+	}
+	out, err := proto.MarshalOptions{UseCachedSize: true}.MarshalAppend(buf[:0], msg)
+	if err != nil {
+		return err
+	}
+	if len(out) != size {
+		return fmt.Errorf("protobuf message size changed while marshaling, want: %d, got: %d", size, len(out))
+	}
+	return nil
+}
+
+func (pc *protobufPayloadCodec) UnMarshal(reader io.Reader) (message interface{}, err error) {
+	length := reader.Length()
+
+	msgType := pc.msgType
+	if pc.resolver != nil {
+		var tag string
+		var n int
+		if tag, n, err = readTag(reader); err != nil {
+			return nil, err
+		}
+		length -= n
+		if msgType, err = pc.resolver.FindMessageByName(protoreflect.FullName(tag)); err != nil {
+			return nil, err
+		}
+	}
+
+	if pc.maxSize > 0 && uint32(length) > pc.maxSize {
+		return nil, fmt.Errorf("%w, size: %d, max: %d", ErrMessageSizeTooLarge, length, pc.maxSize)
+	}
+
+	msg := msgType.New().Interface()
+	if length == 0 {
+		return msg, nil
+	}
+	body, err := reader.Next(length)
+	if err != nil {
+		return nil, err
+	}
+	if err = proto.Unmarshal(body, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// writeTag writes the uvarint length prefixed tag
+func writeTag(writer io.Writer, tag string) error {
+	header := make([]byte, binary.MaxVarintLen32, binary.MaxVarintLen32+len(tag))
+	n := binary.PutUvarint(header, uint64(len(tag)))
+	_, err := writer.Write(append(header[:n], tag...))
+	return err
+}
+
+// readTag reads the uvarint length prefixed tag, returns the tag and the number of bytes read
+func readTag(reader io.Reader) (tag string, n int, err error) {
+	var length uint64
+	var shift uint
+	for i := 0; i < binary.MaxVarintLen32; i++ {
+		var b []byte
+		if b, err = reader.Next(1); err != nil {
+			return
+		}
+		n++
+		length |= uint64(b[0]&0x7f) << shift
+		if b[0] < 0x80 {
+			var buf []byte
+			if buf, err = reader.Next(int(length)); err != nil {
+				return
+			}
+			return string(buf), n + int(length), nil
+		}
+		shift += 7
+	}
+	return "", n, errors.New("malformed type tag length")
+}
@@ -0,0 +1,65 @@
+package payload
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/emove/less/codec"
+	"github.com/emove/less/pkg/io/reader"
+	"github.com/emove/less/pkg/io/writer"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func protobufRoundTrip(t *testing.T, c codec.PayloadCodec, msg proto.Message) (interface{}, error) {
+	buff := &bytes.Buffer{}
+	w := writer.NewBufferWriter(buff)
+	if err := c.Marshal(msg, w); err != nil {
+		return nil, err
+	}
+	_ = w.Flush()
+
+	r := reader.NewLimitReader(reader.NewBufferReader(buff), uint32(buff.Len()))
+	defer r.Release()
+	return c.UnMarshal(r)
+}
+
+func Test_protobufPayloadCodec(t *testing.T) {
+	tests := []struct {
+		name  string
+		codec codec.PayloadCodec
+		msg   proto.Message
+	}{
+		{name: "fixed type", codec: NewProtobufCodec(&wrapperspb.StringValue{}), msg: wrapperspb.String("hello less")},
+		{name: "empty", codec: NewProtobufCodec(&wrapperspb.StringValue{}), msg: wrapperspb.String("")},
+		{
+			name:  "resolver",
+			codec: NewProtobufCodecWithResolver(NewProtobufTypes(&wrapperspb.StringValue{}, &durationpb.Duration{})),
+			msg:   durationpb.New(3600),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := protobufRoundTrip(t, tt.codec, tt.msg)
+			if err != nil {
+				t.Fatalf("round trip err: %v", err)
+			}
+			if !proto.Equal(got.(proto.Message), tt.msg) {
+				t.Fatalf("want: %v, but: %v", tt.msg, got)
+			}
+		})
+	}
+}
+
+func Test_protobufPayloadCodec_MaxSize(t *testing.T) {
+	c := NewProtobufCodec(&wrapperspb.StringValue{}, WithProtobufMaxSize(8))
+	if _, err := protobufRoundTrip(t, c, wrapperspb.String("hello less")); !errors.Is(err, ErrMessageSizeTooLarge) {
+		t.Fatalf("want: %v, but: %v", ErrMessageSizeTooLarge, err)
+	}
+
+	if err := c.Marshal("hello less", writer.NewBufferWriter(&bytes.Buffer{})); err != ErrMessageNotProto {
+		t.Fatalf("want: %v, but: %v", ErrMessageNotProto, err)
+	}
+}
@@ -0,0 +1,531 @@
+package payload
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/emove/less/codec"
+	"github.com/emove/less/pkg/io"
+)
+
+// RESP type prefixes, see https://redis.io/docs/reference/protocol-spec
+const (
+	RESPSimpleStringPrefix = '+'
+	RESPErrorPrefix        = '-'
+	RESPIntegerPrefix      = ':'
+	RESPBulkStringPrefix   = '$'
+	RESPArrayPrefix        = '*'
+	RESPNullPrefix         = '_'
+	RESPBooleanPrefix      = '#'
+	RESPDoublePrefix       = ','
+	RESPBigNumberPrefix    = '('
+	RESPBulkErrorPrefix    = '!'
+	RESPVerbatimPrefix     = '='
+	RESPMapPrefix          = '%'
+	RESPSetPrefix          = '~'
+	RESPAttributePrefix    = '|'
+	RESPPushPrefix         = '>'
+)
+
+// defaultRESPMaxDepth is the default max nesting depth of aggregate types
+const defaultRESPMaxDepth = 128
+
+var ErrRESPMalformed = errors.New("malformed resp data")
+
+// RESPSimpleString is a message which is encoded as RESP simple string
+type RESPSimpleString string
+
+// RESPError is the RESP simple error or bulk error
+type RESPError string
+
+func (e RESPError) Error() string {
+	return string(e)
+}
+
+// RESPSet is the RESP3 set
+type RESPSet []interface{}
+
+// RESPPush is the RESP3 push data
+type RESPPush []interface{}
+
+// RESPOption sets resp codec options
+type RESPOption func(rc *respPayloadCodec)
+
+// WithRESP3 marshals nil, bool, float and map messages with RESP3 types, they are marshaled
+// with RESP2 compatible types by default.
+func WithRESP3() RESPOption {
+	return func(rc *respPayloadCodec) {
+		rc.resp3 = true
+	}
+}
+
+// WithRESPMaxDepth sets the max nesting depth of aggregate types, such as arrays and maps, 128 by default.
+// The deeper data is considered malformed.
+func WithRESPMaxDepth(depth int) RESPOption {
+	return func(rc *respPayloadCodec) {
+		if depth > 0 {
+			rc.maxDepth = depth
+		}
+	}
+}
+
+// NewRESPCodec returns a redis serialization protocol payload codec, it should be used with packet.NewRESPCodec.
+//
+// UnMarshal parses bulk strings, simple strings and verbatim strings into string, integers into int64,
+// arrays into []interface{}, nulls into nil, errors into RESPError, booleans into bool, doubles into float64,
+// big numbers into *big.Int, maps into map[interface{}]interface{}, sets into RESPSet, pushes into RESPPush.
+// Attributes are discarded. Inline commands are parsed into []interface{} of string, the same as commands
+// sent as array.
+//
+// Marshal encodes string and []byte as bulk string, RESPSimpleString as simple string, error as simple error,
+// integers as integer, slices as array, RESPSet as set and RESPPush as push.
+func NewRESPCodec(ops ...RESPOption) codec.PayloadCodec {
+	rc := &respPayloadCodec{maxDepth: defaultRESPMaxDepth}
+	for _, op := range ops {
+		op(rc)
+	}
+	return rc
+}
+
+var _ codec.PayloadCodec = (*respPayloadCodec)(nil)
+
+type respPayloadCodec struct {
+	resp3    bool
+	maxDepth int
+}
+
+func (*respPayloadCodec) Name() string {
+	return "resp-payload-codec"
+}
+
+func (rc *respPayloadCodec) Marshal(message interface{}, writer io.Writer) (err error) {
+	return rc.marshal(message, writer)
+}
+
+func (rc *respPayloadCodec) marshal(message interface{}, w io.Writer) (err error) {
+	switch msg := message.(type) {
+	case nil:
+		if rc.resp3 {
+			return writeString(w, "_\r\n")
+		}
+		return writeString(w, "$-1\r\n")
+	case RESPSimpleString:
+		return writeLine(w, RESPSimpleStringPrefix, string(msg))
+	case RESPError:
+		return writeLine(w, RESPErrorPrefix, string(msg))
+	case error:
+		return writeLine(w, RESPErrorPrefix, msg.Error())
+	case string:
+		return writeBulk(w, RESPBulkStringPrefix, msg)
+	case []byte:
+		return writeBulk(w, RESPBulkStringPrefix, string(msg))
+	case bool:
+		if rc.resp3 {
+			if msg {
+				return writeString(w, "#t\r\n")
+			}
+			return writeString(w, "#f\r\n")
+		}
+		if msg {
+			return writeString(w, ":1\r\n")
+		}
+		return writeString(w, ":0\r\n")
+	case *big.Int:
+		if rc.resp3 {
+			return writeLine(w, RESPBigNumberPrefix, msg.String())
+		}
+		return writeBulk(w, RESPBulkStringPrefix, msg.String())
+	case RESPSet:
+		prefix := byte(RESPSetPrefix)
+		if !rc.resp3 {
+			prefix = RESPArrayPrefix
+		}
+		return rc.marshalArray(w, prefix, reflect.ValueOf([]interface{}(msg)))
+	case RESPPush:
+		prefix := byte(RESPPushPrefix)
+		if !rc.resp3 {
+			prefix = RESPArrayPrefix
+		}
+		return rc.marshalArray(w, prefix, reflect.ValueOf([]interface{}(msg)))
+	}
+
+	v := reflect.ValueOf(message)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return writeLine(w, RESPIntegerPrefix, strconv.FormatInt(v.Int(), 10))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v.Uint() > math.MaxInt64 {
+			return rc.marshal(new(big.Int).SetUint64(v.Uint()), w)
+		}
+		return writeLine(w, RESPIntegerPrefix, strconv.FormatUint(v.Uint(), 10))
+	case reflect.Float32, reflect.Float64:
+		f := strconv.FormatFloat(v.Float(), 'g', -1, 64)
+		if rc.resp3 {
+			switch {
+			case math.IsInf(v.Float(), 1):
+				f = "inf"
+			case math.IsInf(v.Float(), -1):
+				f = "-inf"
+			}
+			return writeLine(w, RESPDoublePrefix, f)
+		}
+		return writeBulk(w, RESPBulkStringPrefix, f)
+	case reflect.String:
+		return writeBulk(w, RESPBulkStringPrefix, v.String())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			if rc.resp3 {
+				return writeString(w, "_\r\n")
+			}
+			return writeString(w, "*-1\r\n")
+		}
+		return rc.marshalArray(w, RESPArrayPrefix, v)
+	case reflect.Map:
+		return rc.marshalMap(w, v)
+	case reflect.Ptr:
+		if v.IsNil() {
+			return rc.marshal(nil, w)
+		}
+		return rc.marshal(v.Elem().Interface(), w)
+	}
+	return fmt.Errorf("resp: unsupported message type %T", message)
+}
+
+func (rc *respPayloadCodec) marshalArray(w io.Writer, prefix byte, v reflect.Value) error {
+	if err := writeLine(w, prefix, strconv.Itoa(v.Len())); err != nil {
+		return err
+	}
+	for i := 0; i < v.Len(); i++ {
+		if err := rc.marshal(v.Index(i).Interface(), w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// marshalMap encodes map as RESP3 map, or flat array of key-value pairs in RESP2, keys are sorted
+// to make the output stable
+func (rc *respPayloadCodec) marshalMap(w io.Writer, v reflect.Value) error {
+	keys := v.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+	})
+
+	var err error
+	if rc.resp3 {
+		err = writeLine(w, RESPMapPrefix, strconv.Itoa(len(keys)))
+	} else {
+		err = writeLine(w, RESPArrayPrefix, strconv.Itoa(len(keys)*2))
+	}
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err = rc.marshal(key.Interface(), w); err != nil {
+			return err
+		}
+		if err = rc.marshal(v.MapIndex(key).Interface(), w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeString(w io.Writer, s string) error {
+	buf, err := w.Malloc(len(s))
+	if err != nil {
+		return err
+	}
+	copy(buf, s)
+	return nil
+}
+
+func writeLine(w io.Writer, prefix byte, line string) error {
+	if strings.ContainsAny(line, "\r\n") {
+		return fmt.Errorf("resp: line can not contain CR or LF: %q", line)
+	}
+	buf, err := w.Malloc(len(line) + 3)
+	if err != nil {
+		return err
+	}
+	buf[0] = prefix
+	copy(buf[1:], line)
+	buf[len(buf)-2], buf[len(buf)-1] = '\r', '\n'
+	return nil
+}
+
+func writeBulk(w io.Writer, prefix byte, s string) error {
+	if err := writeLine(w, prefix, strconv.Itoa(len(s))); err != nil {
+		return err
+	}
+	buf, err := w.Malloc(len(s) + 2)
+	if err != nil {
+		return err
+	}
+	copy(buf, s)
+	buf[len(buf)-2], buf[len(buf)-1] = '\r', '\n'
+	return nil
+}
+
+func (rc *respPayloadCodec) UnMarshal(reader io.Reader) (message interface{}, err error) {
+	p := &respParser{r: reader, limit: reader.Length(), maxDepth: rc.maxDepth}
+	first, err := reader.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if !IsRESPPrefix(first[0]) {
+		return p.parseInline()
+	}
+	return p.parse()
+}
+
+// IsRESPPrefix reports whether b is a RESP type prefix, otherwise the data is an inline command
+func IsRESPPrefix(b byte) bool {
+	switch b {
+	case RESPSimpleStringPrefix, RESPErrorPrefix, RESPIntegerPrefix, RESPBulkStringPrefix, RESPArrayPrefix,
+		RESPNullPrefix, RESPBooleanPrefix, RESPDoublePrefix, RESPBigNumberPrefix, RESPBulkErrorPrefix,
+		RESPVerbatimPrefix, RESPMapPrefix, RESPSetPrefix, RESPAttributePrefix, RESPPushPrefix:
+		return true
+	}
+	return false
+}
+
+type respParser struct {
+	r        io.Reader
+	limit    int
+	depth    int // the nesting depth of the current aggregate
+	maxDepth int
+}
+
+// enter enters a nested aggregate, leave must be called after the aggregate parsed
+func (p *respParser) enter() error {
+	if p.depth++; p.depth > p.maxDepth {
+		return fmt.Errorf("%w, nesting depth exceeds %d", ErrRESPMalformed, p.maxDepth)
+	}
+	return nil
+}
+
+func (p *respParser) leave() {
+	p.depth--
+}
+
+// readLine reads a line terminated by CRLF, returns the line without CRLF
+func (p *respParser) readLine() (string, error) {
+	for n := 1; n <= p.limit; n++ {
+		buf, err := p.r.Peek(n)
+		if err != nil {
+			return "", err
+		}
+		if buf[n-1] == '\n' {
+			if n < 2 || buf[n-2] != '\r' {
+				return "", fmt.Errorf("%w, line not terminated by CRLF", ErrRESPMalformed)
+			}
+			line := string(buf[:n-2])
+			return line, p.r.Skip(n)
+		}
+	}
+	return "", fmt.Errorf("%w, line terminator not found", ErrRESPMalformed)
+}
+
+// readLength reads the length line of bulk or aggregate types, -1 means null
+func (p *respParser) readLength() (int, error) {
+	line, err := p.readLine()
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(line)
+	if err != nil || n < -1 || n > p.limit {
+		return 0, fmt.Errorf("%w, invalid length: %q", ErrRESPMalformed, line)
+	}
+	return n, nil
+}
+
+func (p *respParser) readBulk(n int) (string, error) {
+	buf, err := p.r.Next(n + 2)
+	if err != nil {
+		return "", err
+	}
+	if buf[n] != '\r' || buf[n+1] != '\n' {
+		return "", fmt.Errorf("%w, bulk string not terminated by CRLF", ErrRESPMalformed)
+	}
+	return string(buf[:n]), nil
+}
+
+func (p *respParser) parse() (interface{}, error) {
+	prefix, err := p.r.Next(1)
+	if err != nil {
+		return nil, err
+	}
+
+	switch prefix[0] {
+	case RESPSimpleStringPrefix:
+		return p.readLine()
+	case RESPErrorPrefix:
+		line, err := p.readLine()
+		return RESPError(line), err
+	case RESPIntegerPrefix:
+		line, err := p.readLine()
+		if err != nil {
+			return nil, err
+		}
+		i, err := strconv.ParseInt(line, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w, invalid integer: %q", ErrRESPMalformed, line)
+		}
+		return i, nil
+	case RESPBulkStringPrefix, RESPBulkErrorPrefix, RESPVerbatimPrefix:
+		n, err := p.readLength()
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		s, err := p.readBulk(n)
+		if err != nil {
+			return nil, err
+		}
+		switch prefix[0] {
+		case RESPBulkErrorPrefix:
+			return RESPError(s), nil
+		case RESPVerbatimPrefix:
+			// strips the format, such as "txt:"
+			if len(s) < 4 || s[3] != ':' {
+				return nil, fmt.Errorf("%w, invalid verbatim string", ErrRESPMalformed)
+			}
+			return s[4:], nil
+		}
+		return s, nil
+	case RESPArrayPrefix, RESPSetPrefix, RESPPushPrefix:
+		n, err := p.readLength()
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		if err = p.enter(); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+		arr := make([]interface{}, n)
+		for i := range arr {
+			if arr[i], err = p.parse(); err != nil {
+				return nil, err
+			}
+		}
+		switch prefix[0] {
+		case RESPSetPrefix:
+			return RESPSet(arr), nil
+		case RESPPushPrefix:
+			return RESPPush(arr), nil
+		}
+		return arr, nil
+	case RESPMapPrefix, RESPAttributePrefix:
+		n, err := p.readLength()
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		// the value followed the attribute is counted as nested as well
+		if err = p.enter(); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+		m := make(map[interface{}]interface{}, n)
+		for i := 0; i < n; i++ {
+			key, err := p.parse()
+			if err != nil {
+				return nil, err
+			}
+			value, err := p.parse()
+			if err != nil {
+				return nil, err
+			}
+			if key != nil && !reflect.TypeOf(key).Comparable() {
+				return nil, fmt.Errorf("%w, unhashable map key type %T", ErrRESPMalformed, key)
+			}
+			m[key] = value
+		}
+		if prefix[0] == RESPAttributePrefix {
+			// attributes are discarded, returns the value followed
+			return p.parse()
+		}
+		return m, nil
+	case RESPNullPrefix:
+		_, err = p.readLine()
+		return nil, err
+	case RESPBooleanPrefix:
+		line, err := p.readLine()
+		if err != nil {
+			return nil, err
+		}
+		switch line {
+		case "t":
+			return true, nil
+		case "f":
+			return false, nil
+		}
+		return nil, fmt.Errorf("%w, invalid boolean: %q", ErrRESPMalformed, line)
+	case RESPDoublePrefix:
+		line, err := p.readLine()
+		if err != nil {
+			return nil, err
+		}
+		f, err := strconv.ParseFloat(line, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w, invalid double: %q", ErrRESPMalformed, line)
+		}
+		return f, nil
+	case RESPBigNumberPrefix:
+		line, err := p.readLine()
+		if err != nil {
+			return nil, err
+		}
+		i, ok := new(big.Int).SetString(line, 10)
+		if !ok {
+			return nil, fmt.Errorf("%w, invalid big number: %q", ErrRESPMalformed, line)
+		}
+		return i, nil
+	}
+	return nil, fmt.Errorf("%w, unknown type prefix: %q", ErrRESPMalformed, prefix[0])
+}
+
+// parseInline parses the inline command, arguments are separated by spaces and quoted arguments are supported
+func (p *respParser) parseInline() (interface{}, error) {
+	buf, err := p.r.Next(p.limit)
+	if err != nil {
+		return nil, err
+	}
+	line := strings.TrimRight(string(buf), "\r\n")
+
+	var args []interface{}
+	for i := 0; i < len(line); {
+		switch line[i] {
+		case ' ', '\t':
+			i++
+			continue
+		case '"', '\'':
+			quote := line[i]
+			end := strings.IndexByte(line[i+1:], quote)
+			if end < 0 {
+				return nil, fmt.Errorf("%w, unbalanced quotes in inline command", ErrRESPMalformed)
+			}
+			arg := line[i+1 : i+1+end]
+			if quote == '"' {
+				if unquoted, err := strconv.Unquote(`"` + arg + `"`); err == nil {
+					arg = unquoted
+				}
+			}
+			args = append(args, arg)
+			i += end + 2
+		default:
+			end := strings.IndexAny(line[i:], " \t")
+			if end < 0 {
+				end = len(line) - i
+			}
+			args = append(args, line[i:i+end])
+			i += end
+		}
+	}
+	return args, nil
+}
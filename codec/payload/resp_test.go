@@ -0,0 +1,132 @@
+package payload
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/emove/less/pkg/io/reader"
+	"github.com/emove/less/pkg/io/writer"
+)
+
+func respUnMarshal(rc *respPayloadCodec, data string) (interface{}, error) {
+	r := reader.NewLimitReader(reader.NewBufferReader(strings.NewReader(data)), uint32(len(data)))
+	defer r.Release()
+	return rc.UnMarshal(r)
+}
+
+func Test_respPayloadCodec_UnMarshal(t *testing.T) {
+	bigNum, _ := new(big.Int).SetString("12345678901234567890", 10)
+	tests := []struct {
+		name string
+		data string
+		want interface{}
+	}{
+		{name: "simple string", data: "+OK\r\n", want: "OK"},
+		{name: "error", data: "-ERR unknown\r\n", want: RESPError("ERR unknown")},
+		{name: "integer", data: ":-42\r\n", want: int64(-42)},
+		{name: "bulk string", data: "$4\r\nless\r\n", want: "less"},
+		{name: "null bulk string", data: "$-1\r\n", want: nil},
+		{name: "array", data: "*2\r\n$3\r\nGET\r\n$3\r\nkey\r\n", want: []interface{}{"GET", "key"}},
+		{name: "nested array", data: "*2\r\n*1\r\n:1\r\n*0\r\n", want: []interface{}{[]interface{}{int64(1)}, []interface{}{}}},
+		{name: "map", data: "%1\r\n+name\r\n$4\r\nless\r\n", want: map[interface{}]interface{}{"name": "less"}},
+		{name: "attribute", data: "|1\r\n+ttl\r\n:3\r\n$2\r\nok\r\n", want: "ok"},
+		{name: "set", data: "~2\r\n#t\r\n,1.5\r\n", want: RESPSet{true, 1.5}},
+		{name: "push", data: ">2\r\n(12345678901234567890\r\n_\r\n", want: RESPPush{bigNum, nil}},
+		{name: "verbatim", data: "=8\r\ntxt:less\r\n", want: "less"},
+		{name: "inline", data: "SET key \"hello world\"\r\n", want: []interface{}{"SET", "key", "hello world"}},
+	}
+	rc := NewRESPCodec().(*respPayloadCodec)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := respUnMarshal(rc, tt.data)
+			if err != nil {
+				t.Fatalf("UnMarshal() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("want: %#v, but: %#v", tt.want, got)
+			}
+		})
+	}
+}
+
+func Test_respPayloadCodec_Malformed(t *testing.T) {
+	tests := []struct {
+		name string
+		ops  []RESPOption
+		data string
+	}{
+		{name: "line without CR", data: "+OK\n"},
+		{name: "invalid integer", data: ":x\r\n"},
+		{name: "bulk without CRLF", data: "$2\r\nokxx"},
+		{name: "unbalanced quotes", data: "SET \"key\r\n"},
+		{name: "unhashable key", data: "%1\r\n*0\r\n:1\r\n"},
+		{name: "nested arrays", data: strings.Repeat("*1\r\n", defaultRESPMaxDepth+1) + ":1\r\n"},
+		{name: "nested maps", ops: []RESPOption{WithRESPMaxDepth(2)}, data: "%1\r\n:1\r\n%1\r\n:2\r\n%0\r\n"},
+		{name: "nested attributes", ops: []RESPOption{WithRESPMaxDepth(2)}, data: "|0\r\n|0\r\n|0\r\n:1\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := respUnMarshal(NewRESPCodec(tt.ops...).(*respPayloadCodec), tt.data)
+			if !errors.Is(err, ErrRESPMalformed) {
+				t.Fatalf("want: %v, but: %v", ErrRESPMalformed, err)
+			}
+		})
+	}
+
+	// the max depth is allowed
+	data := strings.Repeat("*1\r\n", defaultRESPMaxDepth) + ":1\r\n"
+	if _, err := respUnMarshal(NewRESPCodec().(*respPayloadCodec), data); err != nil {
+		t.Fatalf("want the max depth parsed, but: %v", err)
+	}
+}
+
+func Test_respPayloadCodec_Marshal(t *testing.T) {
+	tests := []struct {
+		name string
+		ops  []RESPOption
+		msg  interface{}
+		want string
+	}{
+		{name: "simple string", msg: RESPSimpleString("OK"), want: "+OK\r\n"},
+		{name: "error", msg: errors.New("ERR wrong"), want: "-ERR wrong\r\n"},
+		{name: "bulk string", msg: []byte("less"), want: "$4\r\nless\r\n"},
+		{name: "integer", msg: 42, want: ":42\r\n"},
+		{name: "array", msg: []interface{}{"a", 1}, want: "*2\r\n$1\r\na\r\n:1\r\n"},
+		{name: "nil", msg: nil, want: "$-1\r\n"},
+		{name: "resp3 nil", ops: []RESPOption{WithRESP3()}, msg: nil, want: "_\r\n"},
+		{name: "map", msg: map[string]int{"b": 2, "a": 1}, want: "*4\r\n$1\r\na\r\n:1\r\n$1\r\nb\r\n:2\r\n"},
+		{name: "resp3 map", ops: []RESPOption{WithRESP3()}, msg: map[string]bool{"a": true}, want: "%1\r\n$1\r\na\r\n#t\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buff := &bytes.Buffer{}
+			w := writer.NewBufferWriter(buff)
+			if err := NewRESPCodec(tt.ops...).Marshal(tt.msg, w); err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			_ = w.Flush()
+			if got := buff.String(); got != tt.want {
+				t.Fatalf("want: %q, but: %q", tt.want, got)
+			}
+		})
+	}
+
+	if err := NewRESPCodec().Marshal(RESPSimpleString("a\r\nb"), writer.NewBufferWriter(&bytes.Buffer{})); err == nil {
+		t.Fatalf("want error on the line contains CRLF")
+	}
+}
+
+func TestIsRESPPrefix(t *testing.T) {
+	for _, b := range []byte("+-:$*_#,(!=%~|>") {
+		if !IsRESPPrefix(b) {
+			t.Fatalf("%q should be a RESP prefix", b)
+		}
+	}
+	if IsRESPPrefix('G') {
+		t.Fatalf("'G' should not be a RESP prefix")
+	}
+}
@@ -0,0 +1,161 @@
+package payload
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/emove/less/codec"
+	"github.com/emove/less/pkg/io"
+	"github.com/emove/less/pkg/io/reader"
+)
+
+var ErrTypeNotRegistered = errors.New("message type not registered")
+
+// TypeID is the type discriminator of TypedCodec, uint64 ids are encoded as uvarint, and
+// string ids are encoded as uvarint length prefixed string.
+type TypeID interface {
+	uint64 | string
+}
+
+// CodecFactory returns a payload codec which unmarshals messages into the type of msg,
+// such as NewJSONCodecWithType, NewMsgpackCodecWithType, NewGobCodecWithType and the
+// one returned by ProtobufCodecFactory.
+type CodecFactory func(msg interface{}) codec.PayloadCodec
+
+// TypedCodec is a payload codec which prefixes each payload with the registered type id of
+// the message, and unmarshals the payload into the registered type of the type id.
+type TypedCodec[K TypeID] struct {
+	factory CodecFactory
+	mu      sync.RWMutex // guard the following
+	byID    map[K]*typedEntry[K]
+	byType  map[reflect.Type]*typedEntry[K]
+}
+
+type typedEntry[K TypeID] struct {
+	id    K
+	codec codec.PayloadCodec
+}
+
+// NewTypedCodec returns a TypedCodec, factory is used to create the inner codec of each registered type
+func NewTypedCodec[K TypeID](factory CodecFactory) *TypedCodec[K] {
+	if factory == nil {
+		panic("codec factory can not be nil")
+	}
+	return &TypedCodec[K]{
+		factory: factory,
+		byID:    make(map[K]*typedEntry[K]),
+		byType:  make(map[reflect.Type]*typedEntry[K]),
+	}
+}
+
+// Register registers the type of msg with id, both T and *T messages are marshaled with the id,
+// and payloads with the id are unmarshalled into *T. Registering an id or a type twice panics.
+func (tc *TypedCodec[K]) Register(id K, msg interface{}) *TypedCodec[K] {
+	typ := parseType(msg)
+
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	if _, ok := tc.byID[id]; ok {
+		panic(fmt.Sprintf("multiple registrations for type id %v", id))
+	}
+	if _, ok := tc.byType[typ]; ok {
+		panic(fmt.Sprintf("multiple registrations for type %v", typ))
+	}
+	entry := &typedEntry[K]{id: id, codec: tc.factory(msg)}
+	tc.byID[id] = entry
+	tc.byType[typ] = entry
+	return tc
+}
+
+// TypeID returns the registered type id of message
+func (tc *TypedCodec[K]) TypeID(message interface{}) (id K, ok bool) {
+	entry, ok := tc.entryOf(message)
+	if !ok {
+		return id, false
+	}
+	return entry.id, true
+}
+
+func (tc *TypedCodec[K]) entryOf(message interface{}) (*typedEntry[K], bool) {
+	if message == nil {
+		return nil, false
+	}
+	typ := reflect.TypeOf(message)
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
+	entry, ok := tc.byType[typ]
+	return entry, ok
+}
+
+func (*TypedCodec[K]) Name() string {
+	return "typed-payload-codec"
+}
+
+func (tc *TypedCodec[K]) Marshal(message interface{}, writer io.Writer) (err error) {
+	entry, ok := tc.entryOf(message)
+	if !ok {
+		return fmt.Errorf("%w, type: %T", ErrTypeNotRegistered, message)
+	}
+
+	switch id := any(entry.id).(type) {
+	case uint64:
+		var buf [binary.MaxVarintLen64]byte
+		_, err = writer.Write(buf[:binary.PutUvarint(buf[:], id)])
+	case string:
+		err = writeTag(writer, id)
+	}
+	if err != nil {
+		return err
+	}
+	return entry.codec.Marshal(message, writer)
+}
+
+func (tc *TypedCodec[K]) UnMarshal(r io.Reader) (message interface{}, err error) {
+	var id K
+	var n int
+	switch p := any(&id).(type) {
+	case *uint64:
+		*p, n, err = readUvarint(r)
+	case *string:
+		*p, n, err = readTag(r)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tc.mu.RLock()
+	entry, ok := tc.byID[id]
+	tc.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w, type id: %v", ErrTypeNotRegistered, id)
+	}
+
+	// the inner codec reads the remaining payload, the outer reader will be released by caller
+	return entry.codec.UnMarshal(reader.NewLimitReader(r, uint32(r.Length()-n)))
+}
+
+// readUvarint reads an uvarint, returns the value and the number of bytes read
+func readUvarint(r io.Reader) (v uint64, n int, err error) {
+	var shift uint
+	for i := 0; i < binary.MaxVarintLen64; i++ {
+		var b []byte
+		if b, err = r.Next(1); err != nil {
+			return
+		}
+		n++
+		v |= uint64(b[0]&0x7f) << shift
+		if b[0] < 0x80 {
+			return v, n, nil
+		}
+		shift += 7
+	}
+	return 0, n, errors.New("malformed uvarint")
+}
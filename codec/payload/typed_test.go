@@ -0,0 +1,120 @@
+package payload
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/emove/less/codec"
+	"github.com/emove/less/pkg/io/reader"
+	"github.com/emove/less/pkg/io/writer"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type typedLogin struct {
+	Name string `json:"name" msgpack:"name"`
+}
+
+type typedLogout struct {
+	Reason string `json:"reason" msgpack:"reason"`
+}
+
+func typedRoundTrip(c codec.PayloadCodec, msg interface{}) (interface{}, error) {
+	buff := &bytes.Buffer{}
+	w := writer.NewBufferWriter(buff)
+	if err := c.Marshal(msg, w); err != nil {
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	data := buff.Bytes()
+	r := reader.NewLimitReader(reader.NewBufferReader(bytes.NewReader(data)), uint32(len(data)))
+	defer r.Release()
+	return c.UnMarshal(r)
+}
+
+func TestTypedCodec(t *testing.T) {
+	numeric := NewTypedCodec[uint64](NewJSONCodecWithType).
+		Register(1, typedLogin{}).
+		Register(300, &typedLogout{})
+	named := NewTypedCodec[string](NewMsgpackCodecWithType).
+		Register("login", typedLogin{}).
+		Register("logout", typedLogout{})
+	gobs := NewTypedCodec[uint64](NewGobCodecWithType).
+		Register(1, typedLogin{}).
+		Register(2, typedLogout{})
+
+	tests := []struct {
+		name  string
+		codec codec.PayloadCodec
+		msg   interface{}
+		want  interface{}
+	}{
+		{name: "uvarint id", codec: numeric, msg: &typedLogin{Name: "less"}, want: &typedLogin{Name: "less"}},
+		{name: "multi-byte uvarint id", codec: numeric, msg: typedLogout{Reason: "bye"}, want: &typedLogout{Reason: "bye"}},
+		{name: "string id", codec: named, msg: &typedLogin{Name: "less"}, want: &typedLogin{Name: "less"}},
+		{name: "string id value", codec: named, msg: typedLogout{Reason: "bye"}, want: &typedLogout{Reason: "bye"}},
+		{name: "gob", codec: gobs, msg: &typedLogout{Reason: "bye"}, want: &typedLogout{Reason: "bye"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := typedRoundTrip(tt.codec, tt.msg)
+			if err != nil {
+				t.Fatalf("round trip err: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("want: %+v, but: %+v", tt.want, got)
+			}
+		})
+	}
+
+	if id, ok := numeric.TypeID(&typedLogout{}); !ok || id != 300 {
+		t.Fatalf("want type id 300, but: %d, %v", id, ok)
+	}
+	if _, err := typedRoundTrip(numeric, "unknown"); !errors.Is(err, ErrTypeNotRegistered) {
+		t.Fatalf("want: %v, but: %v", ErrTypeNotRegistered, err)
+	}
+
+	data := []byte{0x02, '{', '}'}
+	r := reader.NewLimitReader(reader.NewBufferReader(bytes.NewReader(data)), uint32(len(data)))
+	if _, err := numeric.UnMarshal(r); !errors.Is(err, ErrTypeNotRegistered) {
+		t.Fatalf("want: %v, but: %v", ErrTypeNotRegistered, err)
+	}
+}
+
+func TestTypedCodec_Protobuf(t *testing.T) {
+	tc := NewTypedCodec[uint64](ProtobufCodecFactory()).
+		Register(1, &wrapperspb.StringValue{}).
+		Register(2, &durationpb.Duration{})
+
+	for _, msg := range []proto.Message{wrapperspb.String("less"), durationpb.New(time.Second)} {
+		got, err := typedRoundTrip(tc, msg)
+		if err != nil {
+			t.Fatalf("round trip err: %v", err)
+		}
+		if !proto.Equal(got.(proto.Message), msg) {
+			t.Fatalf("want: %v, but: %v", msg, got)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("want panic on registering non-proto message")
+		}
+	}()
+	tc.Register(3, typedLogin{})
+}
+
+func TestTypedCodec_RegisterDuplicate(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("want panic on duplicate registration")
+		}
+	}()
+	NewTypedCodec[uint64](NewJSONCodecWithType).Register(1, typedLogin{}).Register(2, &typedLogin{})
+}
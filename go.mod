@@ -1,8 +1,17 @@
 module github.com/emove/less
 
-go 1.17
+go 1.18
 
 require (
 	github.com/panjf2000/ants/v2 v2.5.0
 	github.com/stretchr/testify v1.7.1
+	golang.org/x/crypto v0.8.0
+	google.golang.org/protobuf v1.33.0
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	golang.org/x/sys v0.7.0 // indirect
+	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b // indirect
 )
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/emove/less"
+	"github.com/emove/less/codec"
 	"github.com/emove/less/log"
 	"github.com/emove/less/pkg/io"
 	_go "github.com/emove/less/pkg/pool/go"
@@ -40,8 +41,40 @@ type Channel struct {
 	side      int // represents client's channel or server's channel
 	lastRead  int64
 	lastWrite int64
-	mu        sync.Mutex // guard the following
-	idle      time.Time  // records channel idle time
+	paused    int32
+	inflight  int64        // the number of inflight read and write tasks
+	inCodecs  atomic.Value // *codecs, decodes inbound packets
+	outCodecs atomic.Value // *codecs, encodes outbound messages
+	upgrading sync.RWMutex // blocks writing while upgrading codecs
+	mu        sync.Mutex   // guard the following
+	idle      time.Time    // records channel idle time
+}
+
+// codecs is the codecs used by a channel
+type codecs struct {
+	packetCodec  codec.PacketCodec
+	payloadCodec codec.PayloadCodec
+}
+
+func (c *codecs) get() (codec.PacketCodec, codec.PayloadCodec) {
+	if c == nil {
+		return nil, nil
+	}
+	return c.packetCodec, c.payloadCodec
+}
+
+// replaceCodecs stores new codecs into v, a nil codec keeps the current one
+func replaceCodecs(v *atomic.Value, packetCodec codec.PacketCodec, payloadCodec codec.PayloadCodec) {
+	c := &codecs{packetCodec: packetCodec, payloadCodec: payloadCodec}
+	if old, ok := v.Load().(*codecs); ok {
+		if c.packetCodec == nil {
+			c.packetCodec = old.packetCodec
+		}
+		if c.payloadCodec == nil {
+			c.payloadCodec = old.payloadCodec
+		}
+	}
+	v.Store(c)
 }
 
 func NewChannel(con transport.Connection, side int, factory PipelineFactory) *Channel {
@@ -74,6 +107,8 @@ func (ch *Channel) LocalAddr() net.Addr {
 
 func (ch *Channel) Write(msg interface{}) error {
 	if ch.calState(writeable) {
+		ch.upgrading.RLock()
+		defer ch.upgrading.RUnlock()
 		return ch.pl.FireOutbound(msg)
 	}
 	return ErrChannelWriterClosed
@@ -99,6 +134,37 @@ func (ch *Channel) Writeable() bool {
 	return ch.calState(writeable)
 }
 
+func (ch *Channel) PauseRead() {
+	if atomic.CompareAndSwapInt32(&ch.paused, 0, 1) {
+		ch.conn.PauseRead()
+	}
+}
+
+func (ch *Channel) ResumeRead() {
+	if atomic.CompareAndSwapInt32(&ch.paused, 1, 0) {
+		// regards resuming as a read activity to restart the keepalive health check
+		atomic.StoreInt64(&ch.lastRead, time.Now().UnixNano())
+		ch.mu.Lock()
+		if !ch.idle.IsZero() {
+			ch.idle = time.Now()
+		}
+		ch.mu.Unlock()
+		ch.conn.ResumeRead()
+	}
+}
+
+func (ch *Channel) ReadPaused() bool {
+	return atomic.LoadInt32(&ch.paused) == 1
+}
+
+func (ch *Channel) SetReadDeadline(t time.Time) error {
+	return ch.conn.SetReadDeadline(t)
+}
+
+func (ch *Channel) SetWriteDeadline(t time.Time) error {
+	return ch.conn.SetWriteDeadline(t)
+}
+
 func (ch *Channel) Close(ctx context.Context, err error) error {
 
 	old := atomic.LoadInt32(&ch.state)
@@ -169,6 +235,47 @@ func (ch *Channel) AddOutboundMiddleware(mw ...less.Middleware) {
 	ch.pl.AddOutbound(mw...)
 }
 
+// SetCodec replaces the codecs of channel, a nil codec keeps the current one
+func (ch *Channel) SetCodec(packetCodec codec.PacketCodec, payloadCodec codec.PayloadCodec) {
+	replaceCodecs(&ch.inCodecs, packetCodec, payloadCodec)
+	replaceCodecs(&ch.outCodecs, packetCodec, payloadCodec)
+}
+
+// UpgradeCodec replaces the inbound codecs, writes the reply with the current outbound codecs,
+// then replaces the outbound codecs. The other writings wait until upgraded, so that the replies
+// of packets decoded with the new codecs are encoded with the new codecs too.
+func (ch *Channel) UpgradeCodec(reply interface{}, packetCodec codec.PacketCodec, payloadCodec codec.PayloadCodec) (err error) {
+	ch.upgrading.Lock()
+	defer ch.upgrading.Unlock()
+
+	replaceCodecs(&ch.inCodecs, packetCodec, payloadCodec)
+	if reply != nil {
+		if !ch.calState(writeable) {
+			return ErrChannelWriterClosed
+		}
+		err = ch.pl.FireOutbound(reply)
+	}
+	replaceCodecs(&ch.outCodecs, packetCodec, payloadCodec)
+	return err
+}
+
+// Codec returns the outbound codecs of channel
+func (ch *Channel) Codec() (codec.PacketCodec, codec.PayloadCodec) {
+	return ch.OutboundCodec()
+}
+
+// InboundCodec returns the codecs to decode inbound packets
+func (ch *Channel) InboundCodec() (codec.PacketCodec, codec.PayloadCodec) {
+	c, _ := ch.inCodecs.Load().(*codecs)
+	return c.get()
+}
+
+// OutboundCodec returns the codecs to encode outbound messages
+func (ch *Channel) OutboundCodec() (codec.PacketCodec, codec.PayloadCodec) {
+	c, _ := ch.outCodecs.Load().(*codecs)
+	return c.get()
+}
+
 // ====================================== implements stater ============================================ //
 
 func (ch *Channel) Channel() *Channel {
@@ -209,6 +316,11 @@ func (ch *Channel) SetContext(ctx context.Context) {
 	ch.ctx = ctx
 }
 
+// SetRouter sets router for current channel only
+func (ch *Channel) SetRouter(router less.Middleware) {
+	ch.pl.SetRouter(router)
+}
+
 func (ch *Channel) Activate(ctx context.Context) error {
 	err := ch.pl.FireOnChannel(ctx)
 	log.Infof("new channel active from: %s", ch.conn.RemoteAddr().String())
@@ -227,6 +339,11 @@ func (ch *Channel) Side() int {
 	return ch.side
 }
 
+// Done returns a chan which will be closed after the channel closed
+func (ch *Channel) Done() <-chan struct{} {
+	return ch.done
+}
+
 // Recorder returns a middleware to record channel tasks
 func Recorder(event int) less.Middleware {
 	return func(handler less.Handler) less.Handler {
@@ -234,7 +351,7 @@ func Recorder(event int) less.Middleware {
 			ch := c.(*Channel)
 			ch.addTask(event)
 			err := handler(ctx, ch, message)
-			ch.tasks.Done(event)
+			ch.doneTask(event)
 			return err
 		}
 	}
@@ -271,39 +388,24 @@ func (ch *Channel) addTask(event int) {
 		atomic.StoreInt64(&ch.lastWrite, time.Now().UnixNano())
 	}
 
-	// indicates channel is busy
-	if ch.idle.IsZero() {
-		return
-	}
-
-	ch.mu.Lock()
-	defer ch.mu.Unlock()
-
-	// check again
-	if ch.idle.IsZero() {
-		return
+	// the channel becomes busy from the first inflight task
+	if atomic.AddInt64(&ch.inflight, 1) == 1 {
+		ch.mu.Lock()
+		ch.idle = time.Time{}
+		ch.mu.Unlock()
 	}
-	ch.idle = time.Time{}
+}
 
-	_go.Submit(func() {
-		fin := make(chan struct{})
-		_go.Submit(func() {
-			ch.tasks.Wait()
-			close(fin)
-		})
+func (ch *Channel) doneTask(event int) {
+	ch.tasks.Done(event)
 
-		for {
-			select {
-			case <-ch.done:
-				return
-			case <-fin:
-				func() {
-					ch.mu.Lock()
-					defer ch.mu.Unlock()
-					ch.idle = time.Now()
-				}()
-				return
-			}
+	// the channel becomes idle after all inflight tasks done
+	if atomic.AddInt64(&ch.inflight, -1) == 0 {
+		ch.mu.Lock()
+		// check again
+		if atomic.LoadInt64(&ch.inflight) == 0 {
+			ch.idle = time.Now()
 		}
-	})
+		ch.mu.Unlock()
+	}
 }
@@ -10,16 +10,17 @@ import (
 // PipelineFactory is a factory to create Pipeline.
 type PipelineFactory func(ch *Channel) *pipeline
 
-var pool = sync.Pool{}
-
 // NewPipelineFactory returns a pipeline factory.
 func NewPipelineFactory(
 	onChannel []less.OnChannel, onChannelClosed []less.OnChannelClosed,
 	inbound []less.Middleware, outbound []less.Middleware,
 	router less.Middleware, outboundHandler less.Handler,
 ) PipelineFactory {
+	// each factory owns a pool, so that pipelines are not reused across factories
+	pool := &sync.Pool{}
 	pool.New = func() interface{} {
 		return &pipeline{
+			pool:                 pool,
 			onChannelChain:       onChannel,
 			onChannelClosedChain: onChannelClosed,
 			inbound:              inbound,
@@ -42,11 +43,13 @@ type pipeline struct {
 	outbound             []less.Middleware
 	router               less.Middleware
 	outboundHandler      less.Handler
+	pool                 *sync.Pool
 
-	ch    *Channel
-	chocc []less.OnChannelClosed
-	chIn  []less.Middleware
-	chOut []less.Middleware
+	ch       *Channel
+	chocc    []less.OnChannelClosed
+	chIn     []less.Middleware
+	chOut    []less.Middleware
+	chRouter less.Middleware
 }
 
 // AddOnChannelClosed adds channel's specific OnChannelClosed hooks
@@ -70,6 +73,11 @@ func (pl *pipeline) AddOutbound(outbound ...less.Middleware) {
 	}
 }
 
+// SetRouter sets channel's specific router which takes place of the common router
+func (pl *pipeline) SetRouter(router less.Middleware) {
+	pl.chRouter = router
+}
+
 // FireOnChannel fires OnChannel hooks
 func (pl *pipeline) FireOnChannel(ctx context.Context) (err error) {
 	pl.ch.SetContext(ctx)
@@ -100,7 +108,9 @@ func (pl *pipeline) FireInbound(message interface{}) error {
 	ch := pl.ch
 	mws := less.Chain(less.Chain(pl.inbound...), less.Chain(pl.chIn...))
 
-	if pl.router != nil {
+	if pl.chRouter != nil {
+		mws = less.Chain(mws, pl.chRouter)
+	} else if pl.router != nil {
 		mws = less.Chain(mws, pl.router)
 	}
 
@@ -130,8 +140,9 @@ func (pl *pipeline) Release() {
 	pl.chocc = nil
 	pl.chIn = nil
 	pl.chOut = nil
+	pl.chRouter = nil
 
-	pool.Put(pl)
+	pl.pool.Put(pl)
 }
 
 func emptyHandler(_ context.Context, _ less.Channel, _ interface{}) error { return nil }
@@ -10,14 +10,12 @@ const (
 type WaitGroup struct {
 	readTask  *sync.WaitGroup
 	writeTask *sync.WaitGroup
-	allTask   *sync.WaitGroup
 }
 
 func NewWaitGroup() *WaitGroup {
 	return &WaitGroup{
 		readTask:  &sync.WaitGroup{},
 		writeTask: &sync.WaitGroup{},
-		allTask:   &sync.WaitGroup{},
 	}
 }
 
@@ -27,10 +25,7 @@ func (wg *WaitGroup) Add(event int) {
 		wg.readTask.Add(1)
 	case WriteEvent:
 		wg.writeTask.Add(1)
-	default:
-		return
 	}
-	wg.allTask.Add(1)
 }
 
 func (wg *WaitGroup) Done(event int) {
@@ -39,10 +34,7 @@ func (wg *WaitGroup) Done(event int) {
 		wg.readTask.Done()
 	case WriteEvent:
 		wg.writeTask.Done()
-	default:
-		return
 	}
-	wg.allTask.Done()
 }
 
 func (wg *WaitGroup) WaitReadTask() {
@@ -52,7 +44,3 @@ func (wg *WaitGroup) WaitReadTask() {
 func (wg *WaitGroup) WaitWriteTask() {
 	wg.writeTask.Wait()
 }
-
-func (wg *WaitGroup) Wait() {
-	wg.allTask.Wait()
-}
@@ -179,8 +179,8 @@ func (k *Keeper) Keepalive() {
 			k.mu.Unlock()
 
 			idleTime := k.state.IdleTime()
-			if idleTime.IsZero() {
-				// the channel is non-idle
+			if idleTime.IsZero() || k.state.ReadPaused() {
+				// the channel is non-idle or reading paused
 				timewheel.Timer.AfterFunc(kp.MaxChannelIdleTime, fn)
 				return
 			}
@@ -227,6 +227,14 @@ func (k *Keeper) Keepalive() {
 				return
 			}
 
+			if k.state.ReadPaused() {
+				// there is no read activity while reading paused, discards the
+				// sent ping and restarts checking after resumed
+				k.lastPing = 0
+				timewheel.Timer.AfterFunc(healthParams.Time, fn)
+				return
+			}
+
 			nowNano := time.Now().UnixNano()
 			internal := nowNano - k.state.LastRead()
 			if internal < int64(healthParams.Time) {
@@ -0,0 +1,238 @@
+package overload
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/emove/less/overload"
+	_go "github.com/emove/less/pkg/pool/go"
+)
+
+// ErrOverloaded is returned when an inbound message was rejected by Reject strategy
+var ErrOverloaded = errors.New("inbound message was rejected due to overload")
+
+const defaultBacklog = 128
+
+// NewLimiter returns a server level inflight limiter
+func NewLimiter(params *overload.Parameters) *Limiter {
+	if params.Strategy == overload.DropOldest && params.Backlog == 0 {
+		params.Backlog = defaultBacklog
+	}
+	return &Limiter{
+		params: params,
+		wake:   make(chan struct{}),
+	}
+}
+
+// Limiter limits the number of inflight inbound messages across all channels
+type Limiter struct {
+	params   *overload.Parameters
+	inflight int64
+	accepted uint64
+	rejected uint64
+	dropped  uint64
+	paused   uint64
+	waiters  int32
+	mu       sync.Mutex // guard the following
+	wake     chan struct{}
+}
+
+// Pauser pauses and resumes reading of a channel
+type Pauser interface {
+	PauseRead()
+	ResumeRead()
+	ReadPaused() bool
+}
+
+// Gate returns a channel level gate which shares the limiter, the pauser
+// will be paused while waiting with Pause strategy
+func (l *Limiter) Gate(p Pauser) *Gate {
+	return &Gate{l: l, p: p, backlog: list.New()}
+}
+
+// Params returns the parameters of limiter
+func (l *Limiter) Params() *overload.Parameters {
+	return l.params
+}
+
+// Stats returns a snapshot of the limiter counters
+func (l *Limiter) Stats() overload.Stats {
+	return overload.Stats{
+		Inflight: atomic.LoadInt64(&l.inflight),
+		Accepted: atomic.LoadUint64(&l.accepted),
+		Rejected: atomic.LoadUint64(&l.rejected),
+		Dropped:  atomic.LoadUint64(&l.dropped),
+		Paused:   atomic.LoadUint64(&l.paused),
+	}
+}
+
+func (l *Limiter) acquire() bool {
+	max := int64(l.params.MaxInflight)
+	if max <= 0 {
+		atomic.AddInt64(&l.inflight, 1)
+		return true
+	}
+	if atomic.AddInt64(&l.inflight, 1) > max {
+		atomic.AddInt64(&l.inflight, -1)
+		return false
+	}
+	return true
+}
+
+func (l *Limiter) release() {
+	atomic.AddInt64(&l.inflight, -1)
+	if atomic.LoadInt32(&l.waiters) > 0 {
+		l.mu.Lock()
+		close(l.wake)
+		l.wake = make(chan struct{})
+		l.mu.Unlock()
+	}
+}
+
+// waitc returns a channel which will be closed when any inflight message done
+func (l *Limiter) waitc() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.wake
+}
+
+// Gate limits the number of inflight inbound messages of a channel
+type Gate struct {
+	l        *Limiter
+	p        Pauser
+	inflight int64
+	mu       sync.Mutex // guard the following
+	backlog  *list.List
+	waiting  bool
+}
+
+// Dispatch submits the message to handle if the limits allow it, otherwise the
+// strategy of limiter applied. The done channel indicates the channel closed.
+func (g *Gate) Dispatch(done <-chan struct{}, message interface{}, handle func(message interface{})) error {
+	params := g.l.params
+	switch params.Strategy {
+	case overload.DropOldest:
+		g.mu.Lock()
+		if g.backlog.Len() == 0 && g.acquire() {
+			g.mu.Unlock()
+			g.run(message, handle)
+			return nil
+		}
+		g.backlog.PushBack(message)
+		if uint32(g.backlog.Len()) > params.Backlog {
+			g.backlog.Remove(g.backlog.Front())
+			atomic.AddUint64(&g.l.dropped, 1)
+		}
+		if !g.waiting {
+			g.waiting = true
+			_go.Submit(func() {
+				g.drain(done, handle)
+			})
+		}
+		g.mu.Unlock()
+		return nil
+	case overload.Pause:
+		if g.acquire() {
+			g.run(message, handle)
+			return nil
+		}
+		atomic.AddUint64(&g.l.paused, 1)
+		if g.p != nil && !g.p.ReadPaused() {
+			g.p.PauseRead()
+			defer g.p.ResumeRead()
+		}
+		if !g.await(done, g.acquire) {
+			return nil
+		}
+		g.run(message, handle)
+		return nil
+	default:
+		if g.acquire() {
+			g.run(message, handle)
+			return nil
+		}
+		atomic.AddUint64(&g.l.rejected, 1)
+		return ErrOverloaded
+	}
+}
+
+// Inflight returns the number of inflight messages of the channel
+func (g *Gate) Inflight() int64 {
+	return atomic.LoadInt64(&g.inflight)
+}
+
+func (g *Gate) acquire() bool {
+	max := int64(g.l.params.MaxChannelInflight)
+	if atomic.AddInt64(&g.inflight, 1) > max && max > 0 {
+		atomic.AddInt64(&g.inflight, -1)
+		return false
+	}
+	if !g.l.acquire() {
+		atomic.AddInt64(&g.inflight, -1)
+		return false
+	}
+	return true
+}
+
+func (g *Gate) release() {
+	atomic.AddInt64(&g.inflight, -1)
+	g.l.release()
+}
+
+func (g *Gate) run(message interface{}, handle func(message interface{})) {
+	atomic.AddUint64(&g.l.accepted, 1)
+	_go.Submit(func() {
+		defer g.release()
+		handle(message)
+	})
+}
+
+// await blocks until try returns true or the channel closed
+func (g *Gate) await(done <-chan struct{}, try func() bool) bool {
+	atomic.AddInt32(&g.l.waiters, 1)
+	defer atomic.AddInt32(&g.l.waiters, -1)
+	for {
+		wake := g.l.waitc()
+		if try() {
+			return true
+		}
+		select {
+		case <-done:
+			return false
+		case <-wake:
+		}
+	}
+}
+
+// drain dispatches the messages in backlog in order until the backlog is empty
+func (g *Gate) drain(done <-chan struct{}, handle func(message interface{})) {
+	for {
+		var message interface{}
+		ok := g.await(done, func() bool {
+			g.mu.Lock()
+			defer g.mu.Unlock()
+			if g.backlog.Len() == 0 {
+				g.waiting = false
+				return true
+			}
+			if !g.acquire() {
+				return false
+			}
+			message = g.backlog.Remove(g.backlog.Front())
+			return true
+		})
+		if !ok {
+			g.mu.Lock()
+			g.backlog.Init()
+			g.waiting = false
+			g.mu.Unlock()
+			return
+		}
+		if message == nil {
+			return
+		}
+		g.run(message, handle)
+	}
+}
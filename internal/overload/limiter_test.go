@@ -0,0 +1,107 @@
+package overload
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emove/less/overload"
+)
+
+func TestGate_Reject(t *testing.T) {
+	l := NewLimiter(&overload.Parameters{MaxChannelInflight: 1})
+	g := l.Gate(nil)
+	done := make(chan struct{})
+
+	block, fin := make(chan struct{}), make(chan struct{})
+	if err := g.Dispatch(done, 1, func(interface{}) {
+		<-block
+		close(fin)
+	}); err != nil {
+		t.Fatalf("want: nil, but: %v", err)
+	}
+
+	if err := g.Dispatch(done, 2, func(interface{}) {}); err != ErrOverloaded {
+		t.Fatalf("want: %v, but: %v", ErrOverloaded, err)
+	}
+
+	close(block)
+	<-fin
+
+	stats := l.Stats()
+	if stats.Accepted != 1 || stats.Rejected != 1 {
+		t.Fatalf("want accepted: 1, rejected: 1, but: %+v", stats)
+	}
+}
+
+func TestGate_DropOldest(t *testing.T) {
+	l := NewLimiter(&overload.Parameters{MaxInflight: 1, Strategy: overload.DropOldest, Backlog: 2})
+	g := l.Gate(nil)
+	done := make(chan struct{})
+
+	mu := sync.Mutex{}
+	var handled []interface{}
+	wg := sync.WaitGroup{}
+	block := make(chan struct{})
+	handle := func(message interface{}) {
+		if message == 1 {
+			<-block
+		}
+		mu.Lock()
+		handled = append(handled, message)
+		mu.Unlock()
+		wg.Done()
+	}
+
+	wg.Add(3)
+	for i := 1; i <= 4; i++ {
+		if err := g.Dispatch(done, i, handle); err != nil {
+			t.Fatalf("want: nil, but: %v", err)
+		}
+	}
+	close(block)
+	wg.Wait()
+
+	// message 2 was dropped from the backlog
+	want := []interface{}{1, 3, 4}
+	for i := range want {
+		if handled[i] != want[i] {
+			t.Fatalf("want: %v, but: %v", want, handled)
+		}
+	}
+	if dropped := l.Stats().Dropped; dropped != 1 {
+		t.Fatalf("want dropped: 1, but: %d", dropped)
+	}
+}
+
+func TestGate_Pause(t *testing.T) {
+	l := NewLimiter(&overload.Parameters{MaxInflight: 1, Strategy: overload.Pause})
+	g1, g2 := l.Gate(nil), l.Gate(nil)
+	done := make(chan struct{})
+
+	block := make(chan struct{})
+	_ = g1.Dispatch(done, 1, func(interface{}) { <-block })
+
+	time.AfterFunc(100*time.Millisecond, func() {
+		close(block)
+	})
+
+	start := time.Now()
+	fin := make(chan struct{})
+	_ = g2.Dispatch(done, 2, func(interface{}) { close(fin) })
+	if time.Since(start) < 100*time.Millisecond {
+		t.Fatalf("dispatch should be paused until the inflight message done")
+	}
+	<-fin
+
+	if paused := l.Stats().Paused; paused != 1 {
+		t.Fatalf("want paused: 1, but: %d", paused)
+	}
+
+	// closed channel stops waiting
+	_ = g1.Dispatch(done, 3, func(interface{}) { <-done })
+	close(done)
+	if err := g2.Dispatch(done, 4, func(interface{}) {}); err != nil {
+		t.Fatalf("want: nil, but: %v", err)
+	}
+}
@@ -0,0 +1,180 @@
+package ratelimit
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/emove/less/ratelimit"
+)
+
+const defaultMaxDelay = time.Second
+
+const (
+	globalMessagesKey = "global/messages"
+	globalBytesKey    = "global/bytes"
+	ipKeyPrefix       = "ip/"
+)
+
+// NewLimiter returns a server level rate limiter, the params is copied
+func NewLimiter(params *ratelimit.Parameters) *Limiter {
+	l := &Limiter{params: *params}
+	if l.params.MaxDelay <= 0 {
+		l.params.MaxDelay = defaultMaxDelay
+	}
+	if l.params.Store == nil {
+		l.params.Store = ratelimit.NewMemoryStore()
+	}
+	return l
+}
+
+// Limiter applies the rate limits of connections and inbound messages
+type Limiter struct {
+	params  ratelimit.Parameters
+	delayed uint64
+	limited uint64
+	refused uint64
+}
+
+// Params returns the parameters of limiter
+func (l *Limiter) Params() *ratelimit.Parameters {
+	return &l.params
+}
+
+// Stats returns a snapshot of the limiter counters
+func (l *Limiter) Stats() ratelimit.Stats {
+	return ratelimit.Stats{
+		Delayed:            atomic.LoadUint64(&l.delayed),
+		Limited:            atomic.LoadUint64(&l.limited),
+		RefusedConnections: atomic.LoadUint64(&l.refused),
+	}
+}
+
+// Accept applies the connection rate limit of remote ip, returns ErrConnectionLimited if exceeded
+func (l *Limiter) Accept(remote net.Addr) error {
+	limit := l.params.ConnectionsPerIP
+	if !limit.Enabled() || remote == nil {
+		return nil
+	}
+	if l.params.Store.Take(ipKeyPrefix+host(remote), limit, 1) > 0 {
+		atomic.AddUint64(&l.refused, 1)
+		return ratelimit.ErrConnectionLimited
+	}
+	return nil
+}
+
+// host returns the ip of address, or the address itself if it has no port
+func host(addr net.Addr) string {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP.String()
+	}
+	h, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return h
+}
+
+// Channel returns the channel level limiter which shares the global limits
+func (l *Limiter) Channel() *Channel {
+	c := &Channel{l: l}
+	if l.params.ChannelMessages.Enabled() {
+		c.messages = ratelimit.NewBucket(l.params.ChannelMessages)
+	}
+	if l.params.ChannelBytes.Enabled() {
+		c.bytes = ratelimit.NewBucket(l.params.ChannelBytes)
+	}
+	return c
+}
+
+// Channel applies the rate limits to the inbound messages of a channel
+type Channel struct {
+	l        *Limiter
+	messages *ratelimit.Bucket
+	bytes    *ratelimit.Bucket
+}
+
+// Wait takes the tokens of an inbound message of size. It waits for the tokens with Delay action
+// until the channel done, and returns ErrLimited if the message exceeds the limits, the tokens
+// taken from the other buckets are refunded then.
+func (c *Channel) Wait(done <-chan struct{}, size int) error {
+	params := &c.l.params
+	var waited time.Duration
+	for i := 0; i < numBuckets; i++ {
+		for {
+			wait := c.take(i, size)
+			if wait == 0 {
+				break
+			}
+			if params.Action != ratelimit.Delay || waited+wait > params.MaxDelay {
+				atomic.AddUint64(&c.l.limited, 1)
+				c.refund(i, size)
+				return ratelimit.ErrLimited
+			}
+			if waited == 0 {
+				atomic.AddUint64(&c.l.delayed, 1)
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+				waited += wait
+			case <-done:
+				timer.Stop()
+				c.refund(i, size)
+				return ratelimit.ErrLimited
+			}
+		}
+	}
+	return nil
+}
+
+const numBuckets = 4
+
+// take takes the tokens of the i-th bucket, returns zero if the bucket is disabled
+func (c *Channel) take(i, size int) time.Duration {
+	params := &c.l.params
+	switch i {
+	case 0:
+		if c.messages != nil {
+			return c.messages.Take(1)
+		}
+	case 1:
+		if c.bytes != nil {
+			return c.bytes.Take(size)
+		}
+	case 2:
+		if params.GlobalMessages.Enabled() {
+			return params.Store.Take(globalMessagesKey, params.GlobalMessages, 1)
+		}
+	case 3:
+		if params.GlobalBytes.Enabled() {
+			return params.Store.Take(globalBytesKey, params.GlobalBytes, size)
+		}
+	}
+	return 0
+}
+
+// refund refunds the tokens taken from the buckets before the i-th
+func (c *Channel) refund(i, size int) {
+	params := &c.l.params
+	for j := 0; j < i; j++ {
+		switch j {
+		case 0:
+			if c.messages != nil {
+				c.messages.Refund(1)
+			}
+		case 1:
+			if c.bytes != nil {
+				c.bytes.Refund(size)
+			}
+		case 2:
+			if params.GlobalMessages.Enabled() {
+				params.Store.Refund(globalMessagesKey, params.GlobalMessages, 1)
+			}
+		case 3:
+			if params.GlobalBytes.Enabled() {
+				params.Store.Refund(globalBytesKey, params.GlobalBytes, size)
+			}
+		}
+	}
+}
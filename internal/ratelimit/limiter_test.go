@@ -0,0 +1,109 @@
+package ratelimit
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/emove/less/ratelimit"
+)
+
+func TestLimiter_Accept(t *testing.T) {
+	store := ratelimit.NewMemoryStore()
+	params := ratelimit.Parameters{ConnectionsPerIP: ratelimit.Limit{Rate: 1, Burst: 2}, Store: store}
+	// limiters of two listeners share the store
+	l1, l2 := NewLimiter(&params), NewLimiter(&params)
+
+	a := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1}
+	b := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 1}
+	for i, l := range []*Limiter{l1, l2} {
+		if err := l.Accept(a); err != nil {
+			t.Fatalf("connection %d should be accepted, but: %v", i, err)
+		}
+	}
+	if err := l1.Accept(&net.TCPAddr{IP: a.IP, Port: 2}); err != ratelimit.ErrConnectionLimited {
+		t.Fatalf("want: %v, but: %v", ratelimit.ErrConnectionLimited, err)
+	}
+	if err := l2.Accept(b); err != nil {
+		t.Fatalf("the other ip should be accepted, but: %v", err)
+	}
+	if l1.Stats().RefusedConnections != 1 {
+		t.Fatalf("want refused: 1, but: %+v", l1.Stats())
+	}
+
+	// the parameters of caller are not modified
+	if params.MaxDelay != 0 || NewLimiter(&ratelimit.Parameters{}).Params().Store == nil {
+		t.Fatalf("want parameters copied with defaults, but: %+v", params)
+	}
+}
+
+func TestChannel_Wait(t *testing.T) {
+	done := make(chan struct{})
+
+	// drop
+	l := NewLimiter(&ratelimit.Parameters{ChannelBytes: ratelimit.Limit{Rate: 100, Burst: 100}})
+	c := l.Channel()
+	if err := c.Wait(done, 80); err != nil {
+		t.Fatalf("want: nil, but: %v", err)
+	}
+	if err := c.Wait(done, 80); err != ratelimit.ErrLimited {
+		t.Fatalf("want: %v, but: %v", ratelimit.ErrLimited, err)
+	}
+
+	// the tokens of channel are refunded when the global limit refused
+	l = NewLimiter(&ratelimit.Parameters{
+		ChannelMessages: ratelimit.Limit{Rate: 1, Burst: 2},
+		GlobalBytes:     ratelimit.Limit{Rate: 1, Burst: 10},
+	})
+	c = l.Channel()
+	if err := c.Wait(done, 20); err != nil {
+		t.Fatalf("want: nil, but: %v", err)
+	}
+	if err := c.Wait(done, 20); err != ratelimit.ErrLimited {
+		t.Fatalf("want: %v, but: %v", ratelimit.ErrLimited, err)
+	}
+	if wait := c.messages.Take(1); wait != 0 {
+		t.Fatalf("the message token of the second message should be refunded, but wait: %v", wait)
+	}
+
+	// delay
+	l = NewLimiter(&ratelimit.Parameters{
+		ChannelMessages: ratelimit.Limit{Rate: 1000},
+		GlobalMessages:  ratelimit.Limit{Rate: 50, Burst: 1},
+		Action:          ratelimit.Delay,
+	})
+	c = l.Channel()
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := c.Wait(done, 1); err != nil {
+			t.Fatalf("want: nil, but: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Fatalf("the messages should be delayed, but: %v", elapsed)
+	}
+	if stats := l.Stats(); stats.Delayed != 2 || stats.Limited != 0 {
+		t.Fatalf("want delayed: 2, but: %+v", stats)
+	}
+
+	// delay longer than MaxDelay
+	l = NewLimiter(&ratelimit.Parameters{
+		ChannelMessages: ratelimit.Limit{Rate: 1},
+		Action:          ratelimit.Delay,
+		MaxDelay:        10 * time.Millisecond,
+	})
+	c = l.Channel()
+	_ = c.Wait(done, 1)
+	if err := c.Wait(done, 1); err != ratelimit.ErrLimited {
+		t.Fatalf("want: %v, but: %v", ratelimit.ErrLimited, err)
+	}
+
+	// channel closed while waiting
+	l = NewLimiter(&ratelimit.Parameters{ChannelMessages: ratelimit.Limit{Rate: 1}, Action: ratelimit.Delay})
+	c = l.Channel()
+	_ = c.Wait(done, 1)
+	close(done)
+	if err := c.Wait(done, 1); err != ratelimit.ErrLimited {
+		t.Fatalf("want: %v, but: %v", ratelimit.ErrLimited, err)
+	}
+}
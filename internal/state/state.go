@@ -16,4 +16,6 @@ type Stater interface {
 	LastRead() int64
 	// LastWrite returns channel last write timestamp
 	LastWrite() int64
+	// ReadPaused returns channel reading paused or not
+	ReadPaused() bool
 }
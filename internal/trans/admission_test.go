@@ -0,0 +1,54 @@
+package trans
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/emove/less/admission"
+	"github.com/emove/less/codec/packet"
+	"github.com/emove/less/transport/tcp"
+)
+
+func TestTransHandler_Admission(t *testing.T) {
+	controller, _ := admission.NewController(admission.Config{MaxChannelsPerIP: 1, BanThreshold: 1})
+	th := NewTransHandler(
+		WithRouter(echoRouter),
+		WithPacketCodec(packet.NewDelimiterCodec("\n", 8)),
+		WithAdmission(controller),
+	)
+	defer th.Close(context.Background(), nil)
+
+	connect := func() error {
+		server, _ := net.Pipe()
+		_, err := th.OnConnect(context.Background(), tcp.WrapConnection(server))
+		return err
+	}
+
+	client := serve(t, th)
+	lineEcho(t, client, "hello")
+	if err := connect(); err != admission.ErrTooManyChannels {
+		t.Fatalf("want: %v, but: %v", admission.ErrTooManyChannels, err)
+	}
+
+	// the oversize line is a protocol error
+	_, _ = io.WriteString(client, "too long line\n")
+	deadline := time.Now().Add(time.Second)
+	for !controller.Banned("pipe") {
+		if time.Now().After(deadline) {
+			t.Fatalf("the remote should be banned")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	_ = client.Close()
+	if err := connect(); err != admission.ErrBanned {
+		t.Fatalf("want: %v, but: %v", admission.ErrBanned, err)
+	}
+
+	controller.Unban("pipe")
+	client = serve(t, th)
+	defer client.Close()
+	lineEcho(t, client, "hello")
+}
@@ -0,0 +1,106 @@
+package trans
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/emove/less"
+	"github.com/emove/less/codec/negotiate"
+	"github.com/emove/less/codec/packet"
+	"github.com/emove/less/codec/payload"
+	ior "github.com/emove/less/pkg/io/reader"
+	"github.com/emove/less/pkg/io/writer"
+)
+
+func echoRouter(ctx context.Context, ch less.Channel, msg interface{}) (less.Handler, error) {
+	return func(ctx context.Context, ch less.Channel, message interface{}) error {
+		return ch.Write(message)
+	}, nil
+}
+
+// lineEcho writes a line to conn and reads the echoed line
+func lineEcho(t *testing.T, conn net.Conn, line string) {
+	if _, err := io.WriteString(conn, line+"\n"); err != nil {
+		t.Fatalf("write error = %v", err)
+	}
+	got, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatalf("read error = %v", err)
+	}
+	if got != line+"\n" {
+		t.Fatalf("want: %q, but: %q", line+"\n", got)
+	}
+}
+
+func TestTransHandler_OnChannelCodec(t *testing.T) {
+	th := NewTransHandler(
+		WithRouter(echoRouter),
+		AddOnChannel(func(ctx context.Context, ch less.Channel) (context.Context, error) {
+			ch.SetCodec(packet.NewDelimiterCodec("\n", 1024), nil)
+			return ctx, nil
+		}),
+	)
+	defer th.Close(context.Background(), nil)
+
+	client := serve(t, th)
+	defer client.Close()
+	lineEcho(t, client, "hello")
+}
+
+func TestTransHandler_Negotiate(t *testing.T) {
+	th := NewTransHandler(
+		WithRouter(echoRouter),
+		WithNegotiator(negotiate.New(
+			negotiate.WithPacketCodecs(packet.NewVariableLengthCodec(), packet.NewDelimiterCodec("\n", 1024)),
+			negotiate.WithPayloadCodecs(payload.NewTextCodec()),
+		)),
+	)
+	defer th.Close(context.Background(), nil)
+
+	client := serve(t, th)
+	packetCodec, payloadCodec, err := negotiate.Handshake(client, []string{"unknown-packet-codec", "delimiter-packet-codec"}, nil)
+	if err != nil {
+		t.Fatalf("Handshake() error = %v", err)
+	}
+	if packetCodec != "delimiter-packet-codec" || payloadCodec != "text-payload-codec" {
+		t.Fatalf("unexpected codecs: %s, %s", packetCodec, payloadCodec)
+	}
+	lineEcho(t, client, "hello")
+	_ = client.Close()
+
+	client = serve(t, th)
+	defer client.Close()
+	if _, _, err = negotiate.Handshake(client, nil, []string{"unknown-payload-codec"}); err == nil || !strings.Contains(err.Error(), "no acceptable codec") {
+		t.Fatalf("want not acceptable error, but: %v", err)
+	}
+}
+
+func TestTransHandler_UpgradeCodec(t *testing.T) {
+	th := NewTransHandler(
+		WithRouter(func(ctx context.Context, ch less.Channel, msg interface{}) (less.Handler, error) {
+			if msg != "upgrade" {
+				return echoRouter(ctx, ch, msg)
+			}
+			return func(ctx context.Context, ch less.Channel, message interface{}) error {
+				return ch.UpgradeCodec("ok", packet.NewDelimiterCodec("\n", 1024), nil)
+			}, nil
+		}),
+	)
+	defer th.Close(context.Background(), nil)
+
+	client := serve(t, th)
+	defer client.Close()
+
+	vc, tc := packet.NewVariableLengthCodec(), payload.NewTextCodec()
+	if err := vc.Encode("upgrade", writer.NewBufferWriter(client), tc); err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	if reply, err := vc.Decode(ior.NewBufferReader(client), tc); err != nil || reply != "ok" {
+		t.Fatalf("want reply: ok, but: %v, err: %v", reply, err)
+	}
+	lineEcho(t, client, "upgraded")
+}
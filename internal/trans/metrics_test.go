@@ -0,0 +1,60 @@
+package trans
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emove/less/codec/packet"
+	"github.com/emove/less/metrics"
+)
+
+// waitMetric waits for the line exposed by registry
+func waitMetric(t *testing.T, registry *metrics.Registry, line string) {
+	deadline := time.Now().Add(time.Second)
+	for {
+		var buf bytes.Buffer
+		_ = registry.WritePrometheus(&buf)
+		if strings.Contains(buf.String(), line+"\n") {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("want line: %q, but:\n%s", line, buf.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTransHandler_Metrics(t *testing.T) {
+	registry := metrics.NewRegistry()
+	th := NewTransHandler(
+		WithRouter(echoRouter),
+		WithPacketCodec(packet.NewDelimiterCodec("\n", 8)),
+		WithMetrics(metrics.New(registry)),
+	)
+	defer th.Close(context.Background(), nil)
+
+	client := serve(t, th)
+	lineEcho(t, client, "hello")
+	lineEcho(t, client, "world")
+	waitMetric(t, registry, metrics.AcceptedConnections+" 1")
+	waitMetric(t, registry, metrics.ActiveChannels+" 1")
+	waitMetric(t, registry, metrics.ReceivedMessages+" 2")
+	waitMetric(t, registry, metrics.SentMessages+" 2")
+	waitMetric(t, registry, metrics.SentBytes+" 12")
+	waitMetric(t, registry, metrics.HandleDuration+`_count{route="string"} 2`)
+
+	// the oversize line fails to decode
+	_, _ = client.Write([]byte("too long line\n"))
+	waitMetric(t, registry, metrics.DecodeErrors+" 1")
+	waitMetric(t, registry, metrics.ClosedChannels+`{reason="error"} 1`)
+	waitMetric(t, registry, metrics.ActiveChannels+" 0")
+	_ = client.Close()
+
+	client = serve(t, th)
+	lineEcho(t, client, "hello")
+	_ = client.Close()
+	waitMetric(t, registry, metrics.ClosedChannels+`{reason="eof"} 1`)
+}
@@ -4,10 +4,16 @@ import (
 	"math"
 
 	"github.com/emove/less"
+	"github.com/emove/less/admission"
 	"github.com/emove/less/codec"
+	"github.com/emove/less/codec/negotiate"
 	"github.com/emove/less/codec/packet"
 	"github.com/emove/less/codec/payload"
 	"github.com/emove/less/keepalive"
+	"github.com/emove/less/metrics"
+	"github.com/emove/less/overload"
+	"github.com/emove/less/protocol"
+	"github.com/emove/less/ratelimit"
 	"github.com/emove/less/router"
 )
 
@@ -26,6 +32,12 @@ type options struct {
 	outbound              []less.Middleware
 	kp                    *keepalive.ServerParameters
 	useLessMsgCodec       bool
+	op                    *overload.Parameters
+	rl                    *ratelimit.Parameters
+	admission             *admission.Controller
+	metrics               *metrics.Metrics
+	protocols             []protocol.Protocol
+	negotiator            negotiate.Negotiator
 }
 
 var defaultTransOptions = &options{
@@ -116,3 +128,45 @@ func Keepalive(kp keepalive.ServerParameters) Option {
 		ops.kp = &kp
 	}
 }
+
+func Overload(op overload.Parameters) Option {
+	return func(ops *options) {
+		ops.op = &op
+	}
+}
+
+// RateLimit sets the token bucket rate limits of inbound messages and connections
+func RateLimit(rl ratelimit.Parameters) Option {
+	return func(ops *options) {
+		ops.rl = &rl
+	}
+}
+
+// WithAdmission sets the admission controller checking connections before channels created
+func WithAdmission(controller *admission.Controller) Option {
+	return func(ops *options) {
+		ops.admission = controller
+	}
+}
+
+// WithMetrics sets the metrics reporting the measurements of transport
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(ops *options) {
+		ops.metrics = m
+	}
+}
+
+// WithProtocols sets the protocols sniffed by the first bytes of channel in order,
+// the channel not matched any protocol uses the common codecs, router and middlewares
+func WithProtocols(protocols ...protocol.Protocol) Option {
+	return func(ops *options) {
+		ops.protocols = append(ops.protocols, protocols...)
+	}
+}
+
+// WithNegotiator sets the codec negotiator invoked before the first packet of channel decoded
+func WithNegotiator(negotiator negotiate.Negotiator) Option {
+	return func(ops *options) {
+		ops.negotiator = negotiator
+	}
+}
@@ -0,0 +1,75 @@
+package trans
+
+import (
+	"github.com/emove/less"
+	"github.com/emove/less/codec"
+	"github.com/emove/less/internal/channel"
+	"github.com/emove/less/log"
+	"github.com/emove/less/pkg/io"
+	"github.com/emove/less/protocol"
+)
+
+// channelProtocol is the protocol served by a channel, a nil codec keeps the channel's codec
+type channelProtocol struct {
+	name         string
+	match        protocol.Matcher
+	packetCodec  codec.PacketCodec
+	payloadCodec codec.PayloadCodec
+	router       less.Middleware
+	inbound      []less.Middleware
+	outbound     []less.Middleware
+}
+
+var defaultProtocol = &channelProtocol{name: "default"}
+
+func newChannelProtocols(opts *options) []*channelProtocol {
+	protocols := make([]*channelProtocol, 0, len(opts.protocols))
+	for _, p := range opts.protocols {
+		cp := &channelProtocol{
+			name:         p.Name,
+			match:        p.Match,
+			packetCodec:  p.PacketCodec,
+			payloadCodec: p.PayloadCodec,
+			inbound:      p.Inbound,
+			outbound:     p.Outbound,
+		}
+		if cp.match == nil {
+			cp.match = protocol.Any()
+		}
+		if p.Router != nil {
+			cp.router = newRouter(p.Router)
+		}
+		protocols = append(protocols, cp)
+		log.Infow("protocol", cp.name, "packet-codec", name(cp.packetCodec, opts.packetCodec), "payload-codec", name(cp.payloadCodec, opts.payloadCodec))
+	}
+	return protocols
+}
+
+// sniff peeks the first bytes of channel to match the protocol, and binds the codecs,
+// router and middlewares of the matched protocol to channel
+func (th *transHandler) sniff(ch *channel.Channel, reader io.Reader) {
+	proto := defaultProtocol
+	for _, p := range th.protocols {
+		if p.match(reader) {
+			proto = p
+			break
+		}
+	}
+
+	ch.SetCodec(proto.packetCodec, proto.payloadCodec)
+	ch.AddInboundMiddleware(proto.inbound...)
+	ch.AddOutboundMiddleware(proto.outbound...)
+	if proto.router != nil {
+		ch.SetRouter(proto.router)
+	}
+
+	log.Debugw("remote", ch.RemoteAddr(), "protocol", proto.name)
+}
+
+// name returns the name of codec, or the name of default codec if nil
+func name(c, defaultCodec interface{ Name() string }) string {
+	if c == nil {
+		return defaultCodec.Name()
+	}
+	return c.Name()
+}
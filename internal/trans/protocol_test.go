@@ -0,0 +1,234 @@
+package trans
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"math/big"
+	"net"
+	nethttp "net/http"
+	"testing"
+	"time"
+
+	"github.com/emove/less"
+	lesshttp "github.com/emove/less/codec/http"
+	"github.com/emove/less/codec/packet"
+	"github.com/emove/less/codec/payload"
+	"github.com/emove/less/overload"
+	ior "github.com/emove/less/pkg/io/reader"
+	"github.com/emove/less/pkg/io/writer"
+	"github.com/emove/less/protocol"
+	"github.com/emove/less/transport/tcp"
+)
+
+func TestTransHandler_Sniff(t *testing.T) {
+	echo := func(ctx context.Context, ch less.Channel, msg interface{}) (less.Handler, error) {
+		return func(ctx context.Context, ch less.Channel, message interface{}) error {
+			return ch.Write(message)
+		}, nil
+	}
+	health := func(ctx context.Context, ch less.Channel, msg interface{}) (less.Handler, error) {
+		return lesshttp.Serve(func(ctx context.Context, req *lesshttp.Request) *lesshttp.Response {
+			return &lesshttp.Response{Body: "healthy"}
+		}), nil
+	}
+
+	th := NewTransHandler(
+		WithPacketCodec(packet.NewVariableLengthCodec()),
+		WithPayloadCodec(payload.NewTextCodec()),
+		WithRouter(echo),
+		WithProtocols(protocol.Protocol{Name: "http", Match: protocol.HTTP1(), PacketCodec: lesshttp.NewCodec(), Router: health}),
+	)
+	defer th.Close(context.Background(), nil)
+
+	// http health check
+	client := serve(t, th)
+	if _, err := io.WriteString(client, "GET /health HTTP/1.1\r\nHost: localhost\r\n\r\n"); err != nil {
+		t.Fatalf("write request error = %v", err)
+	}
+	resp, err := nethttp.ReadResponse(bufio.NewReader(client), nil)
+	if err != nil {
+		t.Fatalf("read response error = %v", err)
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, resp.ContentLength))
+	if resp.StatusCode != nethttp.StatusOK || string(body) != "healthy" {
+		t.Fatalf("unexpected response: %d %q", resp.StatusCode, body)
+	}
+	_ = client.Close()
+
+	// falls back to the common codecs and router
+	client = serve(t, th)
+	vc, tc := packet.NewVariableLengthCodec(), payload.NewTextCodec()
+	for _, want := range []string{"hello", "GET is not a http request here"} {
+		if err = vc.Encode(want, writer.NewBufferWriter(client), tc); err != nil {
+			t.Fatalf("Encode() error = %v", err)
+		}
+		got, err := vc.Decode(ior.NewBufferReader(client), tc)
+		if err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		if got != want {
+			t.Fatalf("want: %q, but: %q", want, got)
+		}
+	}
+	_ = client.Close()
+}
+
+func TestTransHandler_HTTPPipelining(t *testing.T) {
+	router := func(ctx context.Context, ch less.Channel, msg interface{}) (less.Handler, error) {
+		return lesshttp.Serve(func(ctx context.Context, req *lesshttp.Request) *lesshttp.Response {
+			if req.RequestURI == "/slow" {
+				time.Sleep(50 * time.Millisecond)
+			}
+			return &lesshttp.Response{Body: req.RequestURI}
+		}), nil
+	}
+	for _, tt := range []struct {
+		name string
+		ops  []Option
+	}{
+		{name: "default"},
+		{name: "reject overload", ops: []Option{Overload(overload.Parameters{MaxChannelInflight: 1})}},
+		{name: "pause overload", ops: []Option{Overload(overload.Parameters{MaxChannelInflight: 1, Strategy: overload.Pause})}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			th := NewTransHandler(append([]Option{
+				WithPacketCodec(lesshttp.NewCodec()),
+				WithPayloadCodec(payload.NewTextCodec()),
+				WithRouter(router),
+			}, tt.ops...)...)
+			defer th.Close(context.Background(), nil)
+
+			client := serve(t, th)
+			defer client.Close()
+			_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+			go func() {
+				_, _ = io.WriteString(client, "GET /slow HTTP/1.1\r\n\r\nGET /fast HTTP/1.1\r\n\r\nGET /last HTTP/1.1\r\n\r\n")
+			}()
+
+			// the responses are in the order of requests
+			br := bufio.NewReader(client)
+			for _, want := range []string{"/slow", "/fast", "/last"} {
+				resp, err := nethttp.ReadResponse(br, nil)
+				if err != nil {
+					t.Fatalf("read response error = %v", err)
+				}
+				body, _ := io.ReadAll(resp.Body)
+				if string(body) != want {
+					t.Fatalf("want: %q, but: %q", want, body)
+				}
+			}
+		})
+	}
+}
+
+func TestTransHandler_SniffTLS(t *testing.T) {
+	health := func(ctx context.Context, ch less.Channel, msg interface{}) (less.Handler, error) {
+		return lesshttp.Serve(func(ctx context.Context, req *lesshttp.Request) *lesshttp.Response {
+			return &lesshttp.Response{Body: "healthy"}
+		}), nil
+	}
+	th := NewTransHandler(
+		WithPacketCodec(packet.NewDelimiterCodec("\n", 1024)),
+		WithPayloadCodec(payload.NewTextCodec()),
+		WithRouter(echoRouter),
+		WithProtocols(protocol.Protocol{Name: "http", Match: protocol.HTTP1(), PacketCodec: lesshttp.NewCodec(), Router: health}),
+	)
+	defer th.Close(context.Background(), nil)
+
+	// serves TLS and plaintext on one listener
+	tr := tcp.New(tcp.WithTLSConfig(&tls.Config{Certificates: []tls.Certificate{selfSigned(t)}}), tcp.WithTLSSniffing())
+	addr := freeAddr(t)
+	go func() {
+		_ = tr.Listen(addr, th)
+	}()
+	defer tr.Close()
+
+	dial := func(secure bool) net.Conn {
+		var conn net.Conn
+		var err error
+		for i := 0; i < 50; i++ {
+			if conn, err = net.Dial("tcp", addr); err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatalf("Dial() error = %v", err)
+		}
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if secure {
+			conn = tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+		}
+		return conn
+	}
+
+	for _, secure := range []bool{false, true} {
+		// the protocols are sniffed on the decrypted stream
+		client := dial(secure)
+		if _, err := io.WriteString(client, "GET /health HTTP/1.1\r\n\r\n"); err != nil {
+			t.Fatalf("write request error = %v", err)
+		}
+		resp, err := nethttp.ReadResponse(bufio.NewReader(client), nil)
+		if err != nil {
+			t.Fatalf("read response error = %v", err)
+		}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, resp.ContentLength))
+		if string(body) != "healthy" {
+			t.Fatalf("secure: %v, unexpected response body: %q", secure, body)
+		}
+		_ = client.Close()
+
+		client = dial(secure)
+		lineEcho(t, client, "hello")
+		_ = client.Close()
+	}
+}
+
+// selfSigned returns a self-signed certificate
+func selfSigned(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// freeAddr returns a local address with a free port
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// serve connects to th through a pipe and serves the connection, returns the client side
+func serve(t *testing.T, th TransHandler) net.Conn {
+	server, client := net.Pipe()
+	conn := tcp.WrapConnection(server)
+	ctx, err := th.OnConnect(context.Background(), conn)
+	if err != nil {
+		t.Fatalf("OnConnect() error = %v", err)
+	}
+	go func() {
+		for th.OnMessage(ctx, conn) == nil {
+		}
+	}()
+	return client
+}
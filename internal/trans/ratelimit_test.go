@@ -0,0 +1,69 @@
+package trans
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/emove/less/codec/packet"
+	"github.com/emove/less/ratelimit"
+	"github.com/emove/less/transport/tcp"
+)
+
+func TestTransHandler_RateLimit(t *testing.T) {
+	slow := ratelimit.Limit{Rate: 0.001, Burst: 1}
+	newHandler := func(params ratelimit.Parameters) TransHandler {
+		return NewTransHandler(
+			WithRouter(echoRouter),
+			WithPacketCodec(packet.NewDelimiterCodec("\n", 1024)),
+			RateLimit(params),
+		)
+	}
+
+	// reply
+	th := newHandler(ratelimit.Parameters{
+		ChannelMessages: slow,
+		Action:          ratelimit.Reply,
+		Reply:           func(message interface{}) interface{} { return "limited" },
+	})
+	client := serve(t, th)
+	lineEcho(t, client, "hello")
+	if _, err := io.WriteString(client, "world\n"); err != nil {
+		t.Fatalf("write error = %v", err)
+	}
+	if got, _ := bufio.NewReader(client).ReadString('\n'); got != "limited\n" {
+		t.Fatalf("want: %q, but: %q", "limited\n", got)
+	}
+	if stats := th.RateLimitStats(); stats.Limited != 1 {
+		t.Fatalf("want limited: 1, but: %+v", stats)
+	}
+	_ = client.Close()
+	_ = th.Close(context.Background(), nil)
+
+	// close
+	th = newHandler(ratelimit.Parameters{ChannelBytes: ratelimit.Limit{Rate: 0.001, Burst: 8}, Action: ratelimit.Close})
+	defer th.Close(context.Background(), nil)
+	client = serve(t, th)
+	defer client.Close()
+	lineEcho(t, client, "hello")
+	_, _ = io.WriteString(client, "world\n")
+	if _, err := bufio.NewReader(client).ReadString('\n'); err == nil {
+		t.Fatalf("the channel should be closed")
+	}
+
+	// connections per ip
+	th = newHandler(ratelimit.Parameters{ConnectionsPerIP: slow})
+	defer th.Close(context.Background(), nil)
+	client = serve(t, th)
+	defer client.Close()
+	server, refused := net.Pipe()
+	if _, err := th.OnConnect(context.Background(), tcp.WrapConnection(server)); err != ratelimit.ErrConnectionLimited {
+		t.Fatalf("want: %v, but: %v", ratelimit.ErrConnectionLimited, err)
+	}
+	// the refused connection is closed
+	if _, err := refused.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("want: %v, but: %v", io.EOF, err)
+	}
+}
@@ -4,18 +4,25 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	stdio "io"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/emove/less"
+	"github.com/emove/less/codec"
 	less_atomic "github.com/emove/less/internal/atomic"
 	"github.com/emove/less/internal/channel"
 	"github.com/emove/less/internal/keepalive"
 	"github.com/emove/less/internal/msg"
+	less_overload "github.com/emove/less/internal/overload"
+	less_ratelimit "github.com/emove/less/internal/ratelimit"
 	"github.com/emove/less/internal/recovery"
 	"github.com/emove/less/log"
+	"github.com/emove/less/overload"
 	"github.com/emove/less/pkg/io"
 	_go "github.com/emove/less/pkg/pool/go"
+	"github.com/emove/less/ratelimit"
 	"github.com/emove/less/router"
 	"github.com/emove/less/transport"
 )
@@ -31,10 +38,16 @@ type TransHandler interface {
 	transport.EventDriver
 	BoundHandler
 	transport.GracefulCloser
+	// OverloadStats returns the counters of inbound messages
+	OverloadStats() overload.Stats
+	// RateLimitStats returns the counters of rate limits
+	RateLimitStats() ratelimit.Stats
 }
 
 func NewTransHandler(ops ...Option) TransHandler {
-	opts := defaultTransOptions
+	// copy the default options to avoid being modified across handlers
+	opts := &options{}
+	*opts = *defaultTransOptions
 	for _, op := range ops {
 		op(opts)
 	}
@@ -50,8 +63,13 @@ func NewTransHandler(ops ...Option) TransHandler {
 
 	keepalive.ConsummateKeepaliveParams(opts.kp)
 
-	if opts.useLessMsgCodec {
-		opts.payloadCodec = msg.NewLessMsgPayloadCodec(opts.payloadCodec)
+	if opts.op != nil {
+		th.limiter = less_overload.NewLimiter(opts.op)
+	}
+	// handles the messages of ordered codecs one by one per channel
+	th.ordered = less_overload.NewLimiter(&overload.Parameters{MaxChannelInflight: 1, Strategy: overload.Pause})
+	if opts.rl != nil {
+		th.rateLimiter = less_ratelimit.NewLimiter(opts.rl)
 	}
 
 	inbound := opts.inbound
@@ -64,7 +82,7 @@ func NewTransHandler(ops ...Option) TransHandler {
 			if !ok {
 				return nil
 			}
-			return val.(*keepalive.Keeper)
+			return val.(*channelEntry).keeper
 		}
 		inbound = append([]less.Middleware{keepalive.KeepaliveMiddleware(kgetter), channel.Recorder(channel.ReadEvent)}, inbound...)
 	} else {
@@ -73,6 +91,8 @@ func NewTransHandler(ops ...Option) TransHandler {
 
 	outbound = append([]less.Middleware{channel.Recorder(channel.WriteEvent)}, outbound...)
 
+	th.protocols = newChannelProtocols(opts)
+
 	th.pipelineFactory = channel.NewPipelineFactory(opts.onChannel, onChannelClosed, inbound, outbound, newRouter(opts.router), th.outboundHandler)
 
 	log.Infow("max-channel-size", opts.maxChannelSize, "max-send-message-size", opts.maxSendMessageSize, "max-receive-message-size", opts.maxReceiveMessageSize)
@@ -96,6 +116,33 @@ type transHandler struct {
 	channelCount    less_atomic.AtomicInt64
 	pipelineFactory channel.PipelineFactory
 	closingCtx      context.Context
+	limiter         *less_overload.Limiter
+	ordered         *less_overload.Limiter
+	rateLimiter     *less_ratelimit.Limiter
+	protocols       []*channelProtocol
+}
+
+// channelEntry holds the resources of a channel managed by transHandler
+type channelEntry struct {
+	keeper *keepalive.Keeper
+	gate   *less_overload.Gate
+	rate   *less_ratelimit.Channel
+	// ordered is the gate for the messages of ordered codecs, created on the first one
+	ordered *less_overload.Gate
+	// release releases the admission of channel
+	release func()
+	// prepared indicates the protocol sniffing and codec negotiation of channel done
+	prepared bool
+}
+
+// Close releases the resources of channel
+func (ce *channelEntry) Close() {
+	if ce.keeper != nil {
+		ce.keeper.Close()
+	}
+	if ce.release != nil {
+		ce.release()
+	}
 }
 
 func (th *transHandler) OnConnect(ctx context.Context, con transport.Connection) (c context.Context, err error) {
@@ -114,8 +161,14 @@ func (th *transHandler) OnConnect(ctx context.Context, con transport.Connection)
 			err = errors.New("transport has been closed")
 			closingCtx = th.closingCtx
 		}
-		if err != nil && ch != nil {
-			_ = ch.Close(closingCtx, err)
+		if err != nil {
+			th.ops.metrics.Rejected(err)
+			if ch != nil {
+				_ = ch.Close(closingCtx, err)
+			} else {
+				// refused before the channel created
+				_ = con.Close()
+			}
 		}
 	}()
 
@@ -127,16 +180,46 @@ func (th *transHandler) OnConnect(ctx context.Context, con transport.Connection)
 		return ctx, errors.New("connection number out of limit")
 	}
 
+	var release func()
+	if th.ops.admission != nil {
+		if release, err = th.ops.admission.Admit(con.RemoteAddr()); err != nil {
+			log.Infof("new connect request from: %s was refused, err: %v", con.RemoteAddr().String(), err)
+			return ctx, err
+		}
+		defer func() {
+			// the admission is released by the entry of channel once stored
+			if _, ok := th.channels.Load(ch); !ok {
+				release()
+			}
+		}()
+	}
+
+	if th.rateLimiter != nil {
+		if err = th.rateLimiter.Accept(con.RemoteAddr()); err != nil {
+			log.Infof("new connect request from: %s was refused, err: %v", con.RemoteAddr().String(), err)
+			return ctx, err
+		}
+	}
+
 	ch = channel.NewChannel(con, th.side, th.pipelineFactory)
+	// the codecs could be replaced by OnChannel hooks
+	ch.SetCodec(th.ops.packetCodec, th.ops.payloadCodec)
 
 	if err = ch.Activate(ctx); err != nil {
 		log.Debugf("connect request from: %s failed, err: %v", con.RemoteAddr().String(), err)
 		return ctx, err
 	}
 
-	k := th.prepareKeepalive(ch)
+	entry := &channelEntry{keeper: th.prepareKeepalive(ch), release: release}
+	if th.limiter != nil {
+		entry.gate = th.limiter.Gate(ch)
+	}
+	if th.rateLimiter != nil {
+		entry.rate = th.rateLimiter.Channel()
+	}
 	th.channelCount.Inc()
-	th.channels.Store(ch, k)
+	th.channels.Store(ch, entry)
+	th.ops.metrics.Accepted()
 
 	return context.WithValue(ctx, ctxChannelKey{}, ch), nil
 }
@@ -151,7 +234,7 @@ func (th *transHandler) OnMessage(ctx context.Context, _ transport.Connection) e
 
 	reader, err := ch.Reader()
 	if err != nil {
-		return nil
+		return err
 	}
 
 	defer reader.Release()
@@ -174,25 +257,176 @@ func (th *transHandler) OnRead(ch *channel.Channel, reader io.Reader) error {
 		th.closeChannel(context.Background(), ch, err)
 	})
 
+	// waits for the first byte of packet, so that the codecs replaced
+	// during waiting take effect on this packet
+	if _, err := reader.Peek(1); err != nil {
+		th.closeChannel(context.Background(), ch, err)
+		return err
+	}
+
+	if err := th.prepare(ch, reader); err != nil {
+		th.reportProtocolError(ch, err)
+		th.closeChannel(context.Background(), ch, err)
+		return err
+	}
+
 	// do decode
-	msg, err := th.ops.packetCodec.Decode(reader, th.ops.payloadCodec)
+	packetCodec, payloadCodec := th.wrapCodec(ch.InboundCodec())
+	msg, err := packetCodec.Decode(reader, payloadCodec)
 	if err != nil {
+		if ch.IsActive() {
+			th.ops.metrics.DecodeError()
+		}
+		th.reportProtocolError(ch, err)
 		// close channel
 		th.closeChannel(context.Background(), ch, err)
 		return err
 	}
+	th.ops.metrics.Received(reader.Length())
 
 	if th.ops.maxReceiveMessageSize > 0 && uint32(reader.Length()) > th.ops.maxReceiveMessageSize {
 		log.Errorf("receive a message but message size greater than max-receive-message-size, message size: %d, max: %d", reader.Length(), th.ops.maxReceiveMessageSize)
 		return nil
 	}
 
-	_go.Submit(func() {
-		if err = ch.TriggerInbound(msg); err != nil {
+	if ok, err := th.limitRate(ch, reader.Length(), msg); !ok {
+		return err
+	}
+	return th.dispatch(ch, msg, codec.IsOrdered(packetCodec))
+}
+
+// limitRate applies the rate limits to an inbound message of size, it returns false if the
+// message should not be dispatched, and an error if the channel closed by Close action
+func (th *transHandler) limitRate(ch *channel.Channel, size int, msg interface{}) (bool, error) {
+	var rate *less_ratelimit.Channel
+	if v, ok := th.channels.Load(ch); ok {
+		rate = v.(*channelEntry).rate
+	}
+	if rate == nil {
+		return true, nil
+	}
+	if err := rate.Wait(ch.Done(), size); err == nil {
+		return true, nil
+	}
+
+	params := th.rateLimiter.Params()
+	log.Debugw("remote", ch.RemoteAddr(), log.DefaultMsgKey, "inbound message limited", "action", params.Action)
+	switch params.Action {
+	case ratelimit.Reply:
+		if params.Reply != nil {
+			if rm := params.Reply(msg); rm != nil {
+				if e := ch.Write(rm); e != nil {
+					log.Errorw("remote", ch.RemoteAddr(), log.DefaultMsgKey, "send limited reply failed", "err", e)
+				}
+			}
+		}
+	case ratelimit.Close:
+		th.closeChannel(context.Background(), ch, ratelimit.ErrLimited)
+		return false, ratelimit.ErrLimited
+	}
+	return false, nil
+}
+
+// reportProtocolError reports the error of decoding or negotiation to the admission controller,
+// the errors of the underlying connection are ignored
+func (th *transHandler) reportProtocolError(ch *channel.Channel, err error) {
+	if th.ops.admission == nil || transport.IsTimeout(err) || errors.Is(err, stdio.EOF) || !ch.IsActive() {
+		return
+	}
+	th.ops.admission.ReportError(ch.RemoteAddr())
+}
+
+// prepare sniffs the protocol and negotiates the codecs of channel once before the first packet decoded
+func (th *transHandler) prepare(ch *channel.Channel, reader io.Reader) error {
+	if len(th.protocols) == 0 && th.ops.negotiator == nil {
+		return nil
+	}
+	v, ok := th.channels.Load(ch)
+	if !ok {
+		return nil
+	}
+	entry := v.(*channelEntry)
+	if entry.prepared {
+		return nil
+	}
+	entry.prepared = true
+
+	if len(th.protocols) > 0 {
+		th.sniff(ch, reader)
+	}
+	if th.ops.negotiator == nil {
+		return nil
+	}
+
+	writer, err := ch.Writer()
+	if err != nil {
+		return err
+	}
+	defer writer.Release()
+	packetCodec, payloadCodec, err := th.ops.negotiator(ch, reader, writer)
+	if err != nil {
+		return err
+	}
+	ch.SetCodec(packetCodec, payloadCodec)
+
+	// waits for the first packet after negotiation
+	_, err = reader.Peek(1)
+	return err
+}
+
+// wrapCodec wraps the payload codec to recognize less messages if required
+func (th *transHandler) wrapCodec(packetCodec codec.PacketCodec, payloadCodec codec.PayloadCodec) (codec.PacketCodec, codec.PayloadCodec) {
+	if th.ops.useLessMsgCodec {
+		payloadCodec = msg.NewLessMsgPayloadCodec(payloadCodec)
+	}
+	return packetCodec, payloadCodec
+}
+
+// dispatch fires the inbound pipeline of channel in a goroutine, and applies the
+// overload strategy when the inflight limit reached. The ordered messages are handled
+// one by one, the overload strategy of server is not applied to them.
+func (th *transHandler) dispatch(ch *channel.Channel, msg interface{}, ordered bool) error {
+	handle := func(msg interface{}) {
+		start := time.Now()
+		err := ch.TriggerInbound(msg)
+		th.ops.metrics.Handled(msg, time.Since(start), err)
+		if err != nil {
 			log.Errorw("remote", ch.RemoteAddr(), log.DefaultMsgKey, msg, "err", err)
 		}
-	})
+	}
+
+	var gate *less_overload.Gate
+	if v, ok := th.channels.Load(ch); ok {
+		entry := v.(*channelEntry)
+		gate = entry.gate
+		if ordered {
+			// only the reading goroutine of channel dispatches
+			if entry.ordered == nil {
+				entry.ordered = th.ordered.Gate(ch)
+			}
+			gate = entry.ordered
+		}
+	}
+	if gate == nil {
+		_go.Submit(func() {
+			handle(msg)
+		})
+		return nil
+	}
+
+	err := gate.Dispatch(ch.Done(), msg, handle)
+	if err != less_overload.ErrOverloaded {
+		return err
+	}
 
+	log.Debugw("remote", ch.RemoteAddr(), log.DefaultMsgKey, "inbound message rejected", "inflight", gate.Inflight())
+	if reply := th.ops.op.RejectReply; reply != nil {
+		if rm := reply(msg); rm != nil {
+			if e := ch.Write(rm); e != nil {
+				log.Errorw("remote", ch.RemoteAddr(), log.DefaultMsgKey, "send reject reply failed", "err", e)
+			}
+		}
+	}
 	return nil
 }
 
@@ -209,8 +443,38 @@ func (th *transHandler) OnWrite(ch *channel.Channel, writer io.Writer, msg inter
 		// TODO limit writer buffer
 	}
 
+	var counter *countingWriter
+	if th.ops.metrics != nil {
+		counter = &countingWriter{Writer: writer}
+		writer = counter
+	}
+
 	// do encode
-	return th.ops.packetCodec.Encode(msg, writer, th.ops.payloadCodec)
+	packetCodec, payloadCodec := th.wrapCodec(ch.OutboundCodec())
+	err := packetCodec.Encode(msg, writer, payloadCodec)
+	if transport.IsTimeout(err) {
+		// the message may be partially written, close channel
+		th.closeChannel(context.Background(), ch, err)
+	}
+	if err == nil && counter != nil {
+		th.ops.metrics.Sent(counter.flushed)
+	}
+	return err
+}
+
+// countingWriter counts the bytes flushed to connection
+type countingWriter struct {
+	io.Writer
+	flushed int
+}
+
+func (cw *countingWriter) Flush() error {
+	n := cw.Writer.MallocLength()
+	if err := cw.Writer.Flush(); err != nil {
+		return err
+	}
+	cw.flushed += n
+	return nil
 }
 
 func (th *transHandler) Close(ctx context.Context, err error) error {
@@ -250,6 +514,21 @@ func (th *transHandler) Close(ctx context.Context, err error) error {
 	}
 }
 
+func (th *transHandler) OverloadStats() overload.Stats {
+	if th.limiter == nil {
+		return overload.Stats{}
+	}
+	return th.limiter.Stats()
+}
+
+// RateLimitStats returns the counters of rate limits
+func (th *transHandler) RateLimitStats() ratelimit.Stats {
+	if th.rateLimiter == nil {
+		return ratelimit.Stats{}
+	}
+	return th.rateLimiter.Stats()
+}
+
 func (th *transHandler) closeChannel(ctx context.Context, ch *channel.Channel, err error) {
 	var v interface{}
 	ok := false
@@ -262,6 +541,7 @@ func (th *transHandler) closeChannel(ctx context.Context, ch *channel.Channel, e
 		}
 	}
 	th.channelCount.Dec()
+	th.ops.metrics.Closed(err)
 	_ = ch.Close(ctx, err)
 }
 
@@ -281,7 +561,7 @@ func (th *transHandler) outboundHandler(_ context.Context, ch less.Channel, mess
 	return th.OnWrite(ch.(*channel.Channel), w, message)
 }
 
-func (th *transHandler) prepareKeepalive(ch *channel.Channel) interface{} {
+func (th *transHandler) prepareKeepalive(ch *channel.Channel) *keepalive.Keeper {
 
 	kp := th.ops.kp
 	if kp.MaxChannelIdleTime > 0 ||
@@ -293,7 +573,7 @@ func (th *transHandler) prepareKeepalive(ch *channel.Channel) interface{} {
 		return k
 	}
 
-	return struct{}{}
+	return nil
 }
 
 func newRouter(router router.Router) less.Middleware {
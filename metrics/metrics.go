@@ -0,0 +1,246 @@
+// Package metrics instruments the transport of server.
+//
+// The measurements are reported to a Sink, the Registry is a Sink exposing them in the Prometheus
+// text format through an HTTP handler:
+//
+//	registry := metrics.NewRegistry()
+//	srv := server.NewServer(addr, server.WithMetrics(metrics.New(registry)))
+//	go http.ListenAndServe("127.0.0.1:9090", registry.Handler())
+//
+// Implement Sink to report to other backends.
+package metrics
+
+import (
+	"errors"
+	stdio "io"
+	"net"
+	"reflect"
+	"time"
+
+	"github.com/emove/less/admission"
+	_go "github.com/emove/less/pkg/pool/go"
+	"github.com/emove/less/ratelimit"
+	"github.com/emove/less/transport"
+)
+
+// The names of metrics
+const (
+	ActiveChannels      = "less_channels_active"
+	AcceptedConnections = "less_connections_accepted_total"
+	RejectedConnections = "less_connections_rejected_total"
+	ClosedChannels      = "less_channels_closed_total"
+	ReceivedBytes       = "less_received_bytes_total"
+	SentBytes           = "less_sent_bytes_total"
+	ReceivedMessages    = "less_received_messages_total"
+	SentMessages        = "less_sent_messages_total"
+	DecodeErrors        = "less_decode_errors_total"
+	HandleDuration      = "less_handle_duration_seconds"
+	HandleErrors        = "less_handle_errors_total"
+	PoolRunning         = "less_goroutine_pool_running"
+	PoolCapacity        = "less_goroutine_pool_capacity"
+)
+
+var descriptions = map[string]string{
+	ActiveChannels:      "Number of active channels.",
+	AcceptedConnections: "Total number of connections accepted as channels.",
+	RejectedConnections: "Total number of connections rejected, by reason.",
+	ClosedChannels:      "Total number of channels closed, by reason.",
+	ReceivedBytes:       "Total number of bytes of the inbound messages.",
+	SentBytes:           "Total number of bytes of the outbound messages.",
+	ReceivedMessages:    "Total number of inbound messages decoded.",
+	SentMessages:        "Total number of outbound messages encoded.",
+	DecodeErrors:        "Total number of inbound packets failed to decode.",
+	HandleDuration:      "Duration of inbound messages passing through the pipeline, by route.",
+	HandleErrors:        "Total number of inbound messages failed to handle, by route.",
+	PoolRunning:         "Number of running workers of goroutine pool.",
+	PoolCapacity:        "Capacity of goroutine pool.",
+}
+
+// Label is a name and value pair of metric
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sink receives the measurements
+type Sink interface {
+	// IncCounter adds the non-negative delta to the counter
+	IncCounter(name string, delta float64, labels ...Label)
+	// AddGauge adds delta to the gauge
+	AddGauge(name string, delta float64, labels ...Label)
+	// SetGauge sets the value of gauge
+	SetGauge(name string, value float64, labels ...Label)
+	// Observe observes a value of the histogram
+	Observe(name string, value float64, labels ...Label)
+}
+
+// Describer is implemented by the Sink which accepts the descriptions of metrics
+type Describer interface {
+	Describe(name, help string)
+}
+
+// Collector is implemented by the Sink which collects the polled metrics, such as the usage of
+// goroutine pool, before exposing
+type Collector interface {
+	AddCollector(collect func())
+}
+
+type options struct {
+	routeKey func(message interface{}) string
+}
+
+// Option sets Metrics options
+type Option func(ops *options)
+
+// WithRouteKey sets the function returns the route label of inbound message, the type name of
+// message by default. The number of routes should be bounded.
+func WithRouteKey(key func(message interface{}) string) Option {
+	return func(ops *options) {
+		if key != nil {
+			ops.routeKey = key
+		}
+	}
+}
+
+// typeName returns the type name of message without pointer
+func typeName(message interface{}) string {
+	if message == nil {
+		return "nil"
+	}
+	typ := reflect.TypeOf(message)
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return typ.String()
+}
+
+// Metrics reports the measurements of transport to a Sink, the methods of nil Metrics do nothing
+type Metrics struct {
+	sink Sink
+	ops  options
+}
+
+// New returns a Metrics reporting to sink
+func New(sink Sink, op ...Option) *Metrics {
+	m := &Metrics{sink: sink, ops: options{routeKey: typeName}}
+	for _, o := range op {
+		o(&m.ops)
+	}
+	if d, ok := sink.(Describer); ok {
+		for name, help := range descriptions {
+			d.Describe(name, help)
+		}
+	}
+	if c, ok := sink.(Collector); ok {
+		c.AddCollector(m.Collect)
+	}
+	return m
+}
+
+// Collect reports the polled metrics, it's invoked by the Sink implements Collector, otherwise
+// should be invoked periodically
+func (m *Metrics) Collect() {
+	if m == nil {
+		return
+	}
+	m.sink.SetGauge(PoolRunning, float64(_go.Running()))
+	m.sink.SetGauge(PoolCapacity, float64(_go.Cap()))
+}
+
+// Accepted records a connection accepted as channel
+func (m *Metrics) Accepted() {
+	if m == nil {
+		return
+	}
+	m.sink.IncCounter(AcceptedConnections, 1)
+	m.sink.AddGauge(ActiveChannels, 1)
+}
+
+// Rejected records a connection rejected by err
+func (m *Metrics) Rejected(err error) {
+	if m == nil {
+		return
+	}
+	m.sink.IncCounter(RejectedConnections, 1, Label{Name: "reason", Value: RejectReason(err)})
+}
+
+// Closed records a channel closed by err
+func (m *Metrics) Closed(err error) {
+	if m == nil {
+		return
+	}
+	m.sink.AddGauge(ActiveChannels, -1)
+	m.sink.IncCounter(ClosedChannels, 1, Label{Name: "reason", Value: CloseReason(err)})
+}
+
+// Received records an inbound message of size
+func (m *Metrics) Received(size int) {
+	if m == nil {
+		return
+	}
+	m.sink.IncCounter(ReceivedMessages, 1)
+	m.sink.IncCounter(ReceivedBytes, float64(size))
+}
+
+// Sent records an outbound message of size
+func (m *Metrics) Sent(size int) {
+	if m == nil {
+		return
+	}
+	m.sink.IncCounter(SentMessages, 1)
+	m.sink.IncCounter(SentBytes, float64(size))
+}
+
+// DecodeError records an inbound packet failed to decode
+func (m *Metrics) DecodeError() {
+	if m == nil {
+		return
+	}
+	m.sink.IncCounter(DecodeErrors, 1)
+}
+
+// Handled records an inbound message passed through the pipeline
+func (m *Metrics) Handled(message interface{}, elapsed time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	route := Label{Name: "route", Value: m.ops.routeKey(message)}
+	m.sink.Observe(HandleDuration, elapsed.Seconds(), route)
+	if err != nil {
+		m.sink.IncCounter(HandleErrors, 1, route)
+	}
+}
+
+// RejectReason returns the reason label of the error rejecting connection
+func RejectReason(err error) string {
+	switch {
+	case errors.Is(err, admission.ErrDenied):
+		return "denied"
+	case errors.Is(err, admission.ErrNotAllowed):
+		return "not_allowed"
+	case errors.Is(err, admission.ErrTooManyChannels):
+		return "too_many_channels"
+	case errors.Is(err, admission.ErrBanned):
+		return "banned"
+	case errors.Is(err, ratelimit.ErrConnectionLimited):
+		return "rate_limited"
+	default:
+		return "refused"
+	}
+}
+
+// CloseReason returns the reason label of the error closing channel
+func CloseReason(err error) string {
+	switch {
+	case err == nil:
+		return "normal"
+	case errors.Is(err, stdio.EOF), errors.Is(err, stdio.ErrUnexpectedEOF), errors.Is(err, net.ErrClosed):
+		return "eof"
+	case transport.IsTimeout(err):
+		return "timeout"
+	case errors.Is(err, ratelimit.ErrLimited):
+		return "rate_limited"
+	default:
+		return "error"
+	}
+}
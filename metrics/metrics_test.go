@@ -0,0 +1,153 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emove/less/admission"
+	"github.com/emove/less/ratelimit"
+	"github.com/emove/less/transport"
+)
+
+func TestRegistry_WritePrometheus(t *testing.T) {
+	r := NewRegistry(WithBuckets(1, 0.1))
+	r.Describe("requests_total", "Total number of requests.")
+	r.IncCounter("requests_total", 1, Label{Name: "route", Value: "b"})
+	r.IncCounter("requests_total", 2, Label{Name: "route", Value: "a"})
+	r.IncCounter("requests_total", -1, Label{Name: "route", Value: "a"}) // ignored
+	r.AddGauge("active", 3)
+	r.AddGauge("active", -1)
+	r.SetGauge("pool", 8)
+	r.Observe("latency_seconds", 0.05, Label{Name: "path", Value: "a\"b\\c\n"})
+	r.Observe("latency_seconds", 0.5, Label{Name: "path", Value: "a\"b\\c\n"})
+	r.Observe("latency_seconds", 5, Label{Name: "path", Value: "a\"b\\c\n"})
+
+	var buf bytes.Buffer
+	if err := r.WritePrometheus(&buf); err != nil {
+		t.Fatalf("WritePrometheus() error = %v", err)
+	}
+	want := `# TYPE active gauge
+active 2
+# TYPE latency_seconds histogram
+latency_seconds_bucket{path="a\"b\\c\n",le="0.1"} 1
+latency_seconds_bucket{path="a\"b\\c\n",le="1"} 2
+latency_seconds_bucket{path="a\"b\\c\n",le="+Inf"} 3
+latency_seconds_sum{path="a\"b\\c\n"} 5.55
+latency_seconds_count{path="a\"b\\c\n"} 3
+# TYPE pool gauge
+pool 8
+# HELP requests_total Total number of requests.
+# TYPE requests_total counter
+requests_total{route="a"} 2
+requests_total{route="b"} 1
+`
+	if got := buf.String(); got != want {
+		t.Fatalf("want:\n%s\nbut:\n%s", want, got)
+	}
+}
+
+func TestRegistry_Handler(t *testing.T) {
+	r := NewRegistry()
+	m := New(r)
+	m.Accepted()
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Fatalf("unexpected content type: %s", ct)
+	}
+	body := rec.Body.String()
+	for _, line := range []string{
+		"# HELP " + ActiveChannels + " " + descriptions[ActiveChannels],
+		ActiveChannels + " 1",
+		AcceptedConnections + " 1",
+		// collected before exposing
+		PoolCapacity + " ",
+	} {
+		if !strings.Contains(body, line) {
+			t.Fatalf("want line: %q, but:\n%s", line, body)
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	var m *Metrics
+	// nil Metrics does nothing
+	m.Accepted()
+	m.Handled("hello", time.Millisecond, nil)
+
+	r := NewRegistry()
+	m = New(r, WithRouteKey(func(message interface{}) string { return fmt.Sprint(message) }))
+	m.Accepted()
+	m.Closed(io.EOF)
+	m.Rejected(admission.ErrBanned)
+	m.Received(5)
+	m.Sent(6)
+	m.DecodeError()
+	m.Handled("chat", time.Millisecond, errors.New("handle error"))
+
+	var buf bytes.Buffer
+	_ = r.WritePrometheus(&buf)
+	body := buf.String()
+	for _, line := range []string{
+		ActiveChannels + " 0",
+		ClosedChannels + `{reason="eof"} 1`,
+		RejectedConnections + `{reason="banned"} 1`,
+		ReceivedBytes + " 5",
+		SentBytes + " 6",
+		DecodeErrors + " 1",
+		HandleDuration + `_count{route="chat"} 1`,
+		HandleErrors + `{route="chat"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Fatalf("want line: %q, but:\n%s", line, body)
+		}
+	}
+}
+
+func TestReasons(t *testing.T) {
+	rejects := map[error]string{
+		admission.ErrDenied:            "denied",
+		admission.ErrNotAllowed:        "not_allowed",
+		admission.ErrTooManyChannels:   "too_many_channels",
+		ratelimit.ErrConnectionLimited: "rate_limited",
+		errors.New("refused"):          "refused",
+	}
+	for err, want := range rejects {
+		if got := RejectReason(err); got != want {
+			t.Fatalf("RejectReason(%v) want: %s, but: %s", err, want, got)
+		}
+	}
+
+	closes := map[error]string{
+		io.ErrUnexpectedEOF: "eof",
+		&transport.TimeoutError{Op: transport.OpRead, Err: os.ErrDeadlineExceeded}: "timeout",
+		ratelimit.ErrLimited:    "rate_limited",
+		errors.New("bad frame"): "error",
+	}
+	if got := CloseReason(nil); got != "normal" {
+		t.Fatalf("CloseReason(nil) want: normal, but: %s", got)
+	}
+	for err, want := range closes {
+		if got := CloseReason(err); got != want {
+			t.Fatalf("CloseReason(%v) want: %s, but: %s", err, want, got)
+		}
+	}
+}
+
+func TestTypeName(t *testing.T) {
+	type message struct{}
+	if got := typeName(&message{}); got != "metrics.message" {
+		t.Fatalf("want: metrics.message, but: %s", got)
+	}
+	if got := typeName(nil); got != "nil" {
+		t.Fatalf("want: nil, but: %s", got)
+	}
+}
@@ -0,0 +1,255 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the default upper bounds of histogram buckets in seconds
+var DefaultBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+const (
+	counterType   = "counter"
+	gaugeType     = "gauge"
+	histogramType = "histogram"
+)
+
+// RegistryOption sets Registry options
+type RegistryOption func(r *Registry)
+
+// WithBuckets sets the upper bounds of histogram buckets in increasing order, DefaultBuckets by default
+func WithBuckets(buckets ...float64) RegistryOption {
+	return func(r *Registry) {
+		if len(buckets) > 0 {
+			r.buckets = append([]float64{}, buckets...)
+			sort.Float64s(r.buckets)
+		}
+	}
+}
+
+// Registry is a Sink keeping the metrics in memory, and exposes them in the Prometheus text format
+type Registry struct {
+	buckets []float64
+
+	mu         sync.Mutex // guard the following
+	families   map[string]*family
+	help       map[string]string
+	collectors []func()
+}
+
+var (
+	_ Sink      = (*Registry)(nil)
+	_ Describer = (*Registry)(nil)
+	_ Collector = (*Registry)(nil)
+)
+
+// family is the series of a metric
+type family struct {
+	typ    string
+	series map[string]*series // keyed by the formatted labels
+}
+
+type series struct {
+	value  float64
+	counts []uint64 // the counts of histogram buckets, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// NewRegistry returns an empty Registry
+func NewRegistry(op ...RegistryOption) *Registry {
+	r := &Registry{
+		buckets:  DefaultBuckets,
+		families: make(map[string]*family),
+		help:     make(map[string]string),
+	}
+	for _, o := range op {
+		o(r)
+	}
+	return r
+}
+
+// Describe sets the help text of metric
+func (r *Registry) Describe(name, help string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.help[name] = help
+}
+
+// AddCollector adds a function invoked before exposing
+func (r *Registry) AddCollector(collect func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, collect)
+}
+
+// IncCounter implements Sink
+func (r *Registry) IncCounter(name string, delta float64, labels ...Label) {
+	if delta < 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.series(name, counterType, labels).value += delta
+}
+
+// AddGauge implements Sink
+func (r *Registry) AddGauge(name string, delta float64, labels ...Label) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.series(name, gaugeType, labels).value += delta
+}
+
+// SetGauge implements Sink
+func (r *Registry) SetGauge(name string, value float64, labels ...Label) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.series(name, gaugeType, labels).value = value
+}
+
+// Observe implements Sink
+func (r *Registry) Observe(name string, value float64, labels ...Label) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.series(name, histogramType, labels)
+	if s.counts == nil {
+		s.counts = make([]uint64, len(r.buckets))
+	}
+	if i := sort.SearchFloat64s(r.buckets, value); i < len(r.buckets) {
+		s.counts[i]++
+	}
+	s.sum += value
+	s.count++
+}
+
+// series returns the series of metric with labels, r.mu should be held.
+// The first type reported of a metric is kept.
+func (r *Registry) series(name, typ string, labels []Label) *series {
+	f, ok := r.families[name]
+	if !ok {
+		f = &family{typ: typ, series: make(map[string]*series)}
+		r.families[name] = f
+	}
+	key := formatLabels(labels)
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{}
+		f.series[key] = s
+	}
+	return s
+}
+
+// WritePrometheus writes the metrics in the Prometheus text format
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	r.mu.Lock()
+	collectors := r.collectors
+	r.mu.Unlock()
+	for _, collect := range collectors {
+		collect()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	bw := bufio.NewWriter(w)
+	for _, name := range names {
+		f := r.families[name]
+		if help, ok := r.help[name]; ok {
+			fmt.Fprintf(bw, "# HELP %s %s\n", name, escape(help, false))
+		}
+		fmt.Fprintf(bw, "# TYPE %s %s\n", name, f.typ)
+
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			s := f.series[key]
+			if f.typ != histogramType {
+				fmt.Fprintf(bw, "%s%s %s\n", name, braced(key), formatFloat(s.value))
+				continue
+			}
+			var cumulative uint64
+			for i, upper := range r.buckets {
+				cumulative += s.counts[i]
+				fmt.Fprintf(bw, "%s_bucket%s %d\n", name, braced(join(key, `le="`+formatFloat(upper)+`"`)), cumulative)
+			}
+			fmt.Fprintf(bw, "%s_bucket%s %d\n", name, braced(join(key, `le="+Inf"`)), s.count)
+			fmt.Fprintf(bw, "%s_sum%s %s\n", name, braced(key), formatFloat(s.sum))
+			fmt.Fprintf(bw, "%s_count%s %d\n", name, braced(key), s.count)
+		}
+	}
+	return bw.Flush()
+}
+
+// Handler returns an HTTP handler exposing the metrics in the Prometheus text format
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = r.WritePrometheus(w)
+	})
+}
+
+// formatLabels formats the labels sorted by name, such as `method="get",route="chat"`
+func formatLabels(labels []Label) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	sorted := append([]Label{}, labels...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	pairs := make([]string, 0, len(sorted))
+	for _, l := range sorted {
+		pairs = append(pairs, l.Name+`="`+escape(l.Value, true)+`"`)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func join(labels, label string) string {
+	if labels == "" {
+		return label
+	}
+	return labels + "," + label
+}
+
+func braced(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+// escape escapes the backslashes and line feeds, and the double quotes of label values
+func escape(s string, quote bool) string {
+	r := strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	if quote {
+		r = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	}
+	return r.Replace(s)
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+}
@@ -0,0 +1,60 @@
+package overload
+
+// Strategy decides what to do with an inbound message when the inflight limit has been reached.
+type Strategy int
+
+const (
+	// Reject discards the message and replies the peer with the message returned by RejectReply if set.
+	Reject Strategy = iota
+	// DropOldest keeps the message in a channel backlog and drops the oldest waiting message
+	// when the backlog is full.
+	DropOldest
+	// Pause stops reading from the channel until an inflight message of it is done, so that
+	// the peer will be slowed down by the flow control of the underlying transport.
+	Pause
+)
+
+// String returns the name of the strategy
+func (s Strategy) String() string {
+	switch s {
+	case Reject:
+		return "reject"
+	case DropOldest:
+		return "drop-oldest"
+	case Pause:
+		return "pause"
+	default:
+		return "unknown"
+	}
+}
+
+// Parameters is used to config the inbound concurrency limit
+type Parameters struct {
+	// MaxInflight is the maximum number of inbound messages being handled concurrently
+	// across all channels. Zero means no limit.
+	MaxInflight uint32 // the default value is infinity
+	// MaxChannelInflight is the maximum number of inbound messages being handled concurrently
+	// in a single channel. Zero means no limit.
+	MaxChannelInflight uint32 // the default value is infinity
+	// Strategy is applied to the inbound message which exceeds the limits.
+	Strategy Strategy // the default value is Reject
+	// Backlog is the maximum number of messages waiting in a channel when the Strategy is DropOldest.
+	Backlog uint32 // the default value is 128
+	// RejectReply returns the reply message which will be sent to the peer when a message
+	// was rejected, nil means do not reply. It only works with Reject strategy.
+	RejectReply func(message interface{}) interface{} // the default value is nil
+}
+
+// Stats records the counters of inbound messages
+type Stats struct {
+	// Inflight is the number of inbound messages being handled now.
+	Inflight int64
+	// Accepted is the total number of inbound messages dispatched to the pipeline.
+	Accepted uint64
+	// Rejected is the total number of inbound messages rejected by Reject strategy.
+	Rejected uint64
+	// Dropped is the total number of inbound messages dropped by DropOldest strategy.
+	Dropped uint64
+	// Paused is the total number of times channels stopped reading by Pause strategy.
+	Paused uint64
+}
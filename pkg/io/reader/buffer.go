@@ -1,6 +1,7 @@
 package reader
 
 import (
+	"bytes"
 	"github.com/emove/less/internal/errors"
 	less_io "github.com/emove/less/pkg/io"
 	"sync"
@@ -25,6 +26,16 @@ func NewBufferReaderWithBuf(decorator io.Reader, buf []byte) less_io.Reader {
 	return r
 }
 
+// NewBytesReader returns a Reader of data without copying, data should not be modified before released
+func NewBytesReader(data []byte) less_io.Reader {
+	r := bufferReaderPool.Get().(*bufferReader)
+	r.decorator = bytes.NewReader(nil)
+	r.buff = data
+	r.writeIndex = len(data)
+	r.growable = false
+	return r
+}
+
 var bufferReaderPool = sync.Pool{
 	New: func() interface{} { return &bufferReader{} },
 }
@@ -167,10 +178,12 @@ func (r *bufferReader) growth(want int) {
 	//buf := make([]byte, want)
 	//r.buff = append(r.buff, buf...)
 
-	if l := len(r.buff); want <= cap(r.buff)-l {
+	l := len(r.buff)
+	if want <= cap(r.buff)-l {
 		r.buff = r.buff[:l+want]
+		return
 	}
-	min := r.writeIndex + want
+	min := l + want
 	capacity := cap(r.buff)
 	for capacity < min {
 		capacity <<= 1
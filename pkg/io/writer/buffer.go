@@ -49,9 +49,11 @@ func NewBufferWriterWithBuff(buf []byte) less_io.Writer {
 type writer struct {
 	decorator     io.Writer
 	buff          []byte
-	writeDirectly bool // if true, write data to decorator directly
+	chunks        [][]byte // filled buffers waiting for flush when growable
+	writeDirectly bool     // if true, write data to decorator directly
 	preWriteIndex int
 	writeIndex    int
+	length        int  // total length of written data
 	growable      bool // if true, the buff grow when remain space not enough
 }
 
@@ -72,6 +74,7 @@ func (w *writer) Write(buf []byte) (n int, err error) {
 
 	copy(w.buff[w.writeIndex:w.writeIndex+need], buf)
 	w.writeIndex += need
+	w.length += need
 
 	return need, nil
 }
@@ -88,13 +91,17 @@ func (w *writer) Malloc(n int) (buf []byte, err error) {
 	}
 	buf = w.buff[w.writeIndex : w.writeIndex+n]
 	w.writeIndex += n
+	w.length += n
 	return buf, nil
 }
 
 // MallocLength returns the total length of the written data
 // that has not yet been submitted in the writer
 func (w *writer) MallocLength() (length int) {
-	return w.writeIndex
+	if w.writeDirectly {
+		return w.writeIndex - w.preWriteIndex
+	}
+	return w.length
 }
 
 // Flush writes all malloc data to net.Conn
@@ -113,15 +120,20 @@ func (w *writer) Flush() error {
 		return nil
 	}
 
-	if w.writeIndex <= 0 || w.preWriteIndex == w.writeIndex {
-		// ignore this operation
-		return nil
+	for _, chunk := range w.chunks {
+		if _, err := w.decorator.Write(chunk); err != nil {
+			return err
+		}
 	}
-	if _, err := w.decorator.Write(w.buff[w.preWriteIndex:w.writeIndex]); err != nil {
-		return err
+	w.chunks = w.chunks[:0]
+
+	if w.preWriteIndex < w.writeIndex {
+		if _, err := w.decorator.Write(w.buff[w.preWriteIndex:w.writeIndex]); err != nil {
+			return err
+		}
 	}
-	w.buff = w.buff[w.writeIndex:]
-	w.preWriteIndex = w.writeIndex
+	// all data had been submitted, reuses the buffer from beginning
+	w.preWriteIndex, w.writeIndex, w.length = 0, 0, 0
 	if d, ok := w.decorator.(less_io.Writer); ok {
 		return d.Flush()
 	}
@@ -132,9 +144,11 @@ func (w *writer) Flush() error {
 func (w *writer) Release() {
 	w.decorator = nil
 	w.buff = nil
+	w.chunks = nil
 	w.writeDirectly = false
 	w.preWriteIndex = 0
 	w.writeIndex = 0
+	w.length = 0
 }
 
 func (w *writer) checkWriteable(n int) bool {
@@ -147,18 +161,24 @@ func (w *writer) checkWriteable(n int) bool {
 	}
 
 	// try to grow by means of a reslice.
-	if l := len(w.buff); n <= cap(w.buff)-l {
-		w.buff = w.buff[:l+n]
+	if w.writeIndex+n <= cap(w.buff) {
+		w.buff = w.buff[:cap(w.buff)]
 		return true
 	}
-	min := w.writeIndex + n
-	capacity := cap(w.buff)
-	for capacity < min {
+
+	// the slices returned by Malloc must stay valid, so keeps the filled buffer
+	// as a chunk and allocates a new one rather than copying into a larger one
+	if w.preWriteIndex < w.writeIndex {
+		w.chunks = append(w.chunks, w.buff[w.preWriteIndex:w.writeIndex])
+	}
+	capacity := cap(w.buff) << 1
+	if capacity == 0 {
+		capacity = defaultBufferSize
+	}
+	for capacity < n {
 		capacity <<= 1
 	}
-
-	buf := make([]byte, capacity)
-	copy(buf, w.buff)
-	w.buff = buf
+	w.buff = make([]byte, capacity)
+	w.preWriteIndex, w.writeIndex = 0, 0
 	return true
 }
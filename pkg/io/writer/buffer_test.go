@@ -1,6 +1,7 @@
 package writer
 
 import (
+	"bytes"
 	"github.com/emove/less/pkg/io"
 	"reflect"
 	"testing"
@@ -69,8 +70,13 @@ func Test_writer_Flush(t *testing.T) {
 	if !reflect.DeepEqual(decorator.buf, content) {
 		t.Fatalf("writer flush error")
 	}
-	if bufferWriter.MallocLength() != 11+len(content) {
-		t.Fatalf("melloc length error, excepted: %d, got: %d", 11+len(content), bufferWriter.MallocLength())
+	// the submitted data is not counted
+	if bufferWriter.MallocLength() != 0 {
+		t.Fatalf("melloc length error, excepted: 0, got: %d", bufferWriter.MallocLength())
+	}
+	_, _ = bufferWriter.Write(content)
+	if bufferWriter.MallocLength() != len(content) {
+		t.Fatalf("melloc length error, excepted: %d, got: %d", len(content), bufferWriter.MallocLength())
 	}
 }
 
@@ -91,6 +97,23 @@ func Test_writer_Malloc(t *testing.T) {
 	doMalloc()
 }
 
+func Test_writer_MallocGrowth(t *testing.T) {
+	buff := &bytes.Buffer{}
+	bufferWriter := NewBufferWriter(buff)
+
+	header, _ := bufferWriter.Malloc(4)
+	body := bytes.Repeat([]byte("x"), defaultBufferSize*3)
+	_, _ = bufferWriter.Write(body)
+	// the malloc slice must stay valid after the buffer grows
+	copy(header, "less")
+	_ = bufferWriter.Flush()
+
+	want := append([]byte("less"), body...)
+	if !bytes.Equal(buff.Bytes(), want) {
+		t.Fatalf("want %d bytes starts with less, but: %q", len(want), buff.Bytes()[:4])
+	}
+}
+
 func Test_writer_MallocLength(t *testing.T) {
 	decorator := &testWriter{}
 	bufferWriter := NewBufferWriter(decorator).(*writer)
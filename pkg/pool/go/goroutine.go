@@ -71,3 +71,19 @@ func Release() {
 		global.Reboot()
 	}
 }
+
+// Running returns the number of running workers of the global pool, zero if the pool is not initialized
+func Running() int {
+	if global != nil {
+		return global.Running()
+	}
+	return 0
+}
+
+// Cap returns the capacity of the global pool, zero if the pool is not initialized
+func Cap() int {
+	if global != nil {
+		return global.Cap()
+	}
+	return 0
+}
@@ -0,0 +1,107 @@
+// Package protocol defines protocols served on a single port, the protocol of a channel is
+// sniffed by peeking the first bytes received from the channel, like cmux does.
+package protocol
+
+import (
+	"bytes"
+
+	"github.com/emove/less"
+	"github.com/emove/less/codec"
+	"github.com/emove/less/pkg/io"
+	"github.com/emove/less/router"
+)
+
+// Matcher reports whether the channel speaks a protocol, it should only peek the reader and
+// peek as few bytes as possible, due to peeking blocks until the bytes received.
+type Matcher func(reader io.Reader) bool
+
+// Protocol defines how to serve channels speaking a protocol
+type Protocol struct {
+	// Name is the name of protocol, used by logging
+	Name string
+	// Match recognizes the protocol by peeking the first bytes of channel
+	Match Matcher
+	// PacketCodec is used by the channels of protocol, the server's packet codec is used if nil
+	PacketCodec codec.PacketCodec
+	// PayloadCodec is used by the channels of protocol, the server's payload codec is used if nil
+	PayloadCodec codec.PayloadCodec
+	// Router routes the inbound messages of protocol, the server's router is used if nil
+	Router router.Router
+	// Inbound middlewares are fired after the server's inbound middlewares
+	Inbound []less.Middleware
+	// Outbound middlewares are fired before the server's outbound middlewares
+	Outbound []less.Middleware
+}
+
+// Any matches any channel, it's useful to be the last one as a fallback
+func Any() Matcher {
+	return func(io.Reader) bool {
+		return true
+	}
+}
+
+// Prefix matches the channel whose first bytes equal to one of the prefixes
+func Prefix(prefixes ...string) Matcher {
+	ps := make([][]byte, 0, len(prefixes))
+	for _, p := range prefixes {
+		if len(p) > 0 {
+			ps = append(ps, []byte(p))
+		}
+	}
+	return func(reader io.Reader) bool {
+		return matchPrefix(reader, ps)
+	}
+}
+
+// HTTP1 matches HTTP/1.x requests by the request method
+func HTTP1() Matcher {
+	return Prefix("GET ", "HEAD ", "POST ", "PUT ", "DELETE ", "CONNECT ", "OPTIONS ", "TRACE ", "PATCH ")
+}
+
+// HTTP2 matches HTTP/2 connections with prior knowledge by the client connection preface
+func HTTP2() Matcher {
+	return Prefix("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n")
+}
+
+// TLS matches the TLS handshake record which carries a ClientHello. To serve TLS and plaintext on one
+// listener, the tcp transport terminates TLS with tcp.WithTLSConfig and tcp.WithTLSSniffing, and the
+// protocols are sniffed on the decrypted stream. The matcher is useful when the TLS is not terminated by
+// the transport, to reject, or to pass the channel to a proxy codec.
+func TLS() Matcher {
+	return func(reader io.Reader) bool {
+		// content type handshake, checked first so that a short packet of other protocols
+		// is not blocked by peeking the whole header
+		if b, err := reader.Peek(1); err != nil || b[0] != 0x16 {
+			return false
+		}
+		// version major 3, and handshake type client hello
+		header, err := reader.Peek(6)
+		if err != nil {
+			return false
+		}
+		return header[1] == 0x03 && header[5] == 0x01
+	}
+}
+
+// matchPrefix peeks byte by byte, returns as soon as no prefix matches
+func matchPrefix(reader io.Reader, prefixes [][]byte) bool {
+	candidates := prefixes
+	for n := 1; len(candidates) > 0; n++ {
+		buf, err := reader.Peek(n)
+		if err != nil {
+			return false
+		}
+		remains := candidates[:0:0]
+		for _, p := range candidates {
+			if !bytes.HasPrefix(p, buf) {
+				continue
+			}
+			if len(p) == n {
+				return true
+			}
+			remains = append(remains, p)
+		}
+		candidates = remains
+	}
+	return false
+}
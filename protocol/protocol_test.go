@@ -0,0 +1,64 @@
+package protocol
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	ior "github.com/emove/less/pkg/io/reader"
+)
+
+func TestMatchers(t *testing.T) {
+	tests := []struct {
+		name    string
+		matcher Matcher
+		data    string
+		want    bool
+	}{
+		{name: "any", matcher: Any(), data: "", want: true},
+		{name: "http1 get", matcher: HTTP1(), data: "GET / HTTP/1.1\r\n\r\n", want: true},
+		{name: "http1 short mismatch", matcher: HTTP1(), data: "\x00", want: false},
+		{name: "http1 prefix of method", matcher: HTTP1(), data: "GE", want: false},
+		{name: "http2", matcher: HTTP2(), data: "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n", want: true},
+		{name: "http2 mismatch", matcher: HTTP2(), data: "POST / HTTP/1.1\r\n\r\n", want: false},
+		{name: "tls", matcher: TLS(), data: "\x16\x03\x01\x00\xa5\x01", want: true},
+		{name: "tls mismatch", matcher: TLS(), data: "\x16\x03\x01\x00\xa5\x02", want: false},
+		{name: "prefix mismatch", matcher: Prefix("LESS", "LEAF"), data: "LEX", want: false},
+		{name: "shorter prefix", matcher: Prefix("LESS", "LE"), data: "LESS", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := ior.NewBufferReader(bytes.NewReader([]byte(tt.data)))
+			if got := tt.matcher(r); got != tt.want {
+				t.Fatalf("want: %v, but: %v", tt.want, got)
+			}
+			// the matcher must not advance the reader
+			if buf, _ := r.Next(len(tt.data)); string(buf) != tt.data {
+				t.Fatalf("reader advanced, remains: %q", buf)
+			}
+		})
+	}
+}
+
+func TestTLS_ShortPacket(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	go func() {
+		_, _ = client.Write([]byte("+"))
+	}()
+
+	matched := make(chan bool, 1)
+	go func() {
+		matched <- TLS()(ior.NewBufferReader(server))
+	}()
+	select {
+	case got := <-matched:
+		if got {
+			t.Fatalf("want: false, but: true")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("matcher blocked by peeking more bytes than received")
+	}
+}
@@ -0,0 +1,235 @@
+// Package ratelimit defines the token bucket rate limits of inbound messages and connections.
+//
+// The limits of messages are applied by the transport after a message decoded and before it's
+// dispatched, so the size of message on the wire is counted, and the Delay action slows down the
+// peer by the flow control of the underlying transport, since the channel stops reading while
+// waiting. The limit of new connections per remote ip is applied before the channel created.
+//
+// The per ip and global buckets are kept by a Store, share a Store across servers to apply the
+// limits across listeners.
+package ratelimit
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrLimited is the reason of closing the channel by Close action
+	ErrLimited = errors.New("inbound rate limit exceeded")
+	// ErrConnectionLimited is returned when a connection is refused by the connection rate limit
+	ErrConnectionLimited = errors.New("connection rate limit exceeded")
+)
+
+// Action decides what to do with an inbound message exceeding the limits
+type Action int
+
+const (
+	// Drop discards the message.
+	Drop Action = iota
+	// Delay waits for the tokens without reading from the channel, the message is dropped if
+	// the tokens are not available in MaxDelay.
+	Delay
+	// Reply discards the message and replies the peer with the message returned by Reply.
+	Reply
+	// Close closes the channel with ErrLimited.
+	Close
+)
+
+// String returns the name of the action
+func (a Action) String() string {
+	switch a {
+	case Drop:
+		return "drop"
+	case Delay:
+		return "delay"
+	case Reply:
+		return "reply"
+	case Close:
+		return "close"
+	default:
+		return "unknown"
+	}
+}
+
+// Limit is the rate of token bucket, the zero value means no limit
+type Limit struct {
+	// Rate is the number of tokens refilled per second
+	Rate float64
+	// Burst is the capacity of bucket, it's at least the Rate rounded up by default
+	Burst int
+}
+
+// Enabled returns whether the limit applies
+func (l Limit) Enabled() bool {
+	return l.Rate > 0
+}
+
+func (l Limit) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return math.Max(1, math.Ceil(l.Rate))
+}
+
+// Parameters is used to config the rate limits
+type Parameters struct {
+	// ChannelMessages limits the inbound messages per second of each channel.
+	ChannelMessages Limit
+	// ChannelBytes limits the inbound bytes per second of each channel.
+	ChannelBytes Limit
+	// GlobalMessages limits the inbound messages per second across all channels.
+	GlobalMessages Limit
+	// GlobalBytes limits the inbound bytes per second across all channels.
+	GlobalBytes Limit
+	// ConnectionsPerIP limits the new connections per second of each remote ip.
+	ConnectionsPerIP Limit
+	// Action is applied to the inbound message which exceeds the limits.
+	Action Action // the default value is Drop
+	// MaxDelay is the maximum duration waiting for the tokens with Delay action.
+	MaxDelay time.Duration // the default value is 1s
+	// Reply returns the reply message which will be sent to the peer when a message was
+	// limited, nil means do not reply. It only works with Reply action.
+	Reply func(message interface{}) interface{} // the default value is nil
+	// Store keeps the buckets of ConnectionsPerIP, GlobalMessages and GlobalBytes.
+	Store Store // the default value is a memory store of the server
+}
+
+// Stats records the counters of rate limits
+type Stats struct {
+	// Delayed is the total number of inbound messages delayed by Delay action.
+	Delayed uint64
+	// Limited is the total number of inbound messages dropped, replied or closed the channel.
+	Limited uint64
+	// RefusedConnections is the total number of connections refused by ConnectionsPerIP.
+	RefusedConnections uint64
+}
+
+// Store keeps token buckets by key
+type Store interface {
+	// Take takes n tokens from the bucket of key which refills by limit, the bucket is created
+	// full if absent. It returns zero if taken, otherwise nothing is taken and the duration until
+	// the tokens available is returned. The n greater than the burst of limit is taken once the
+	// bucket is full, the bucket goes into debt and the following takes wait until it's repaid.
+	Take(key string, limit Limit, n int) time.Duration
+	// Refund gives back n tokens taken from the bucket of key, it's used when the other limits
+	// refused the message after the tokens taken.
+	Refund(key string, limit Limit, n int)
+}
+
+// Bucket is a token bucket, it's safe for concurrent use
+type Bucket struct {
+	mu     sync.Mutex
+	limit  Limit
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket returns a full bucket
+func NewBucket(limit Limit) *Bucket {
+	return &Bucket{limit: limit, tokens: limit.burst(), last: time.Now()}
+}
+
+// Take takes n tokens, see Store.Take
+func (b *Bucket) Take(n int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.take(b.limit, n, time.Now())
+}
+
+// Refund gives back n tokens, see Store.Refund
+func (b *Bucket) Refund(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refund(b.limit, n, time.Now())
+}
+
+func (b *Bucket) take(limit Limit, n int, now time.Time) time.Duration {
+	b.refill(limit, now)
+
+	// the n greater than burst waits for a full bucket, and takes all n tokens
+	need := math.Min(float64(n), limit.burst())
+	if b.tokens >= need {
+		b.tokens -= float64(n)
+		return 0
+	}
+	return time.Duration((need - b.tokens) / limit.Rate * float64(time.Second))
+}
+
+func (b *Bucket) refund(limit Limit, n int, now time.Time) {
+	b.refill(limit, now)
+	b.tokens = math.Min(limit.burst(), b.tokens+float64(n))
+}
+
+func (b *Bucket) refill(limit Limit, now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(limit.burst(), b.tokens+elapsed.Seconds()*limit.Rate)
+		b.last = now
+	}
+}
+
+// full returns whether the bucket has been refilled at now
+func (b *Bucket) full(now time.Time) bool {
+	b.refill(b.limit, now)
+	return b.tokens >= b.limit.burst()
+}
+
+const sweepInterval = time.Minute
+
+// MemoryStore is a Store keeping buckets in memory, the full buckets are removed periodically
+type MemoryStore struct {
+	mu        sync.Mutex // guard the following
+	buckets   map[string]*Bucket
+	lastSweep time.Time
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*Bucket), lastSweep: time.Now()}
+}
+
+// Take implements Store
+func (s *MemoryStore) Take(key string, limit Limit, n int) time.Duration {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) > sweepInterval {
+		s.lastSweep = now
+		for k, b := range s.buckets {
+			if b.full(now) {
+				delete(s.buckets, k)
+			}
+		}
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &Bucket{limit: limit, tokens: limit.burst(), last: now}
+		s.buckets[key] = b
+	}
+	b.limit = limit
+	return b.take(limit, n, now)
+}
+
+// Refund implements Store
+func (s *MemoryStore) Refund(key string, limit Limit, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the bucket swept is full already
+	if b, ok := s.buckets[key]; ok {
+		b.refund(limit, n, time.Now())
+	}
+}
+
+// Len returns the number of buckets
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.buckets)
+}
@@ -0,0 +1,66 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucket(t *testing.T) {
+	b := NewBucket(Limit{Rate: 10, Burst: 5})
+	now := b.last
+	for i := 0; i < 5; i++ {
+		if wait := b.take(b.limit, 1, now); wait != 0 {
+			t.Fatalf("token %d should be taken, but wait: %v", i, wait)
+		}
+	}
+	if wait := b.take(b.limit, 1, now); wait != 100*time.Millisecond {
+		t.Fatalf("want wait: 100ms, but: %v", wait)
+	}
+
+	// refilled
+	now = now.Add(200 * time.Millisecond)
+	if wait := b.take(b.limit, 2, now); wait != 0 {
+		t.Fatalf("tokens should be refilled, but wait: %v", wait)
+	}
+	// larger than burst is taken by a full bucket, and the debt is repaid before the next
+	now = now.Add(time.Second)
+	if wait := b.take(b.limit, 100, now); wait != 0 {
+		t.Fatalf("the message should be taken, but wait: %v", wait)
+	}
+	if wait := b.take(b.limit, 1, now); wait != 9600*time.Millisecond {
+		t.Fatalf("want wait: 9.6s, but: %v", wait)
+	}
+
+	// refunded
+	b.refund(b.limit, 100, now)
+	if wait := b.take(b.limit, 5, now); wait != 0 {
+		t.Fatalf("tokens should be refunded, but wait: %v", wait)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	s := NewMemoryStore()
+	limit := Limit{Rate: 1000}
+	if wait := s.Take("a", limit, 1000); wait != 0 {
+		t.Fatalf("want: 0, but: %v", wait)
+	}
+	if wait := s.Take("a", limit, 1); wait == 0 {
+		t.Fatalf("the bucket should be empty")
+	}
+	s.Refund("a", limit, 1)
+	if wait := s.Take("a", limit, 1); wait != 0 {
+		t.Fatalf("the token should be refunded, but wait: %v", wait)
+	}
+	s.Refund("a", limit, 1)
+	_ = s.Take("b", limit, 1)
+
+	// the full bucket b is swept, the bucket a is still refilling
+	time.Sleep(5 * time.Millisecond)
+	s.lastSweep = time.Now().Add(-2 * sweepInterval)
+	_ = s.Take("c", limit, 1)
+	_, a := s.buckets["a"]
+	_, b := s.buckets["b"]
+	if s.Len() != 2 || !a || b {
+		t.Fatalf("want buckets a and c after sweeping, but: %v", s.buckets)
+	}
+}
@@ -0,0 +1,142 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/emove/less"
+)
+
+// KeyFunc extracts the route key, such as a numeric command id, from the message
+type KeyFunc[K comparable] func(msg interface{}) (K, error)
+
+// RouteInfo describes a registered route
+type RouteInfo[K comparable] struct {
+	// Key is the route key
+	Key K
+	// Group is the name of group which the route registered in, empty means the root group
+	Group string
+	// Middlewares is the number of middlewares applied to the route, including its groups'
+	Middlewares int
+}
+
+// CommandRouter dispatches messages to the handlers registered by the route key extracted from message.
+type CommandRouter[K comparable] struct {
+	key      KeyFunc[K]
+	root     *Group[K]
+	mu       sync.RWMutex // guard the following
+	handlers map[K]less.Handler
+	routes   []RouteInfo[K]
+	notFound less.Handler
+}
+
+// Group is a set of routes sharing the same middlewares
+type Group[K comparable] struct {
+	r    *CommandRouter[K]
+	name string
+	mws  []less.Middleware
+}
+
+// NewCommandRouter returns a CommandRouter which uses key to extract the route key from message,
+// use CommandRouter.Route as the Router of server.
+func NewCommandRouter[K comparable](key KeyFunc[K]) *CommandRouter[K] {
+	if key == nil {
+		panic("router: key func can not be nil")
+	}
+	cr := &CommandRouter[K]{
+		key:      key,
+		handlers: make(map[K]less.Handler),
+	}
+	cr.root = &Group[K]{r: cr}
+	return cr
+}
+
+// NotFound sets the handler for messages whose route key has not been registered,
+// the ErrRouteNotFound will be returned by default.
+func (cr *CommandRouter[K]) NotFound(handler less.Handler) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	cr.notFound = handler
+}
+
+// Use appends middlewares to the root group, it only effects on routes registered after.
+func (cr *CommandRouter[K]) Use(mws ...less.Middleware) {
+	cr.root.Use(mws...)
+}
+
+// Handle registers the handler with route specific middlewares for the key in the root group.
+func (cr *CommandRouter[K]) Handle(key K, handler less.Handler, mws ...less.Middleware) {
+	cr.root.Handle(key, handler, mws...)
+}
+
+// Group returns a group with the name, the middlewares are applied to all routes of the group.
+func (cr *CommandRouter[K]) Group(name string, mws ...less.Middleware) *Group[K] {
+	return cr.root.Group(name, mws...)
+}
+
+// Route implements Router, it returns the handler registered by the route key of msg
+func (cr *CommandRouter[K]) Route(_ context.Context, _ less.Channel, msg interface{}) (less.Handler, error) {
+	key, err := cr.key(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+
+	if handler, ok := cr.handlers[key]; ok {
+		return handler, nil
+	}
+	if cr.notFound != nil {
+		return cr.notFound, nil
+	}
+	return nil, fmt.Errorf("%w, route key: %v", ErrRouteNotFound, key)
+}
+
+// Routes returns all registered routes in registration order
+func (cr *CommandRouter[K]) Routes() []RouteInfo[K] {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+
+	routes := make([]RouteInfo[K], len(cr.routes))
+	copy(routes, cr.routes)
+	return routes
+}
+
+// Use appends middlewares to the group, it only effects on routes registered after.
+func (g *Group[K]) Use(mws ...less.Middleware) {
+	g.mws = append(g.mws, mws...)
+}
+
+// Group returns a sub group named with the parent's name as prefix, the middlewares of
+// parent group are applied before the sub group's.
+func (g *Group[K]) Group(name string, mws ...less.Middleware) *Group[K] {
+	if len(g.name) > 0 {
+		name = g.name + "/" + name
+	}
+	return &Group[K]{
+		r:    g.r,
+		name: name,
+		mws:  append(append([]less.Middleware{}, g.mws...), mws...),
+	}
+}
+
+// Handle registers the handler with route specific middlewares for the key in the group.
+// Registering a key twice panics.
+func (g *Group[K]) Handle(key K, handler less.Handler, mws ...less.Middleware) {
+	if handler == nil {
+		panic("router: handler can not be nil")
+	}
+	chain := append(append([]less.Middleware{}, g.mws...), mws...)
+
+	cr := g.r
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	if _, ok := cr.handlers[key]; ok {
+		panic(fmt.Sprintf("router: multiple registrations for key %v", key))
+	}
+	cr.handlers[key] = less.Chain(chain...)(handler)
+	cr.routes = append(cr.routes, RouteInfo[K]{Key: key, Group: g.name, Middlewares: len(chain)})
+}
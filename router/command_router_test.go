@@ -0,0 +1,107 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/emove/less"
+)
+
+type command struct {
+	ID   uint16
+	Body string
+}
+
+func commandKey(msg interface{}) (uint16, error) {
+	cmd, ok := msg.(*command)
+	if !ok {
+		return 0, errors.New("not a command")
+	}
+	return cmd.ID, nil
+}
+
+func recordMiddleware(name string, trace *[]string) less.Middleware {
+	return func(handler less.Handler) less.Handler {
+		return func(ctx context.Context, ch less.Channel, message interface{}) error {
+			*trace = append(*trace, name)
+			return handler(ctx, ch, message)
+		}
+	}
+}
+
+func TestCommandRouter_Route(t *testing.T) {
+	var trace []string
+	handler := func(ctx context.Context, ch less.Channel, message interface{}) error {
+		trace = append(trace, message.(*command).Body)
+		return nil
+	}
+
+	cr := NewCommandRouter(commandKey)
+	cr.Use(recordMiddleware("log", &trace))
+	cr.Handle(1, handler)
+
+	admin := cr.Group("admin", recordMiddleware("auth", &trace))
+	admin.Handle(100, handler, recordMiddleware("audit", &trace))
+	admin.Group("users").Handle(101, handler)
+
+	tests := []struct {
+		name    string
+		msg     interface{}
+		want    []string
+		wantErr error
+	}{
+		{name: "root", msg: &command{ID: 1, Body: "echo"}, want: []string{"log", "echo"}},
+		{name: "group", msg: &command{ID: 100, Body: "kick"}, want: []string{"log", "auth", "audit", "kick"}},
+		{name: "sub group", msg: &command{ID: 101, Body: "list"}, want: []string{"log", "auth", "list"}},
+		{name: "not found", msg: &command{ID: 2}, wantErr: ErrRouteNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trace = nil
+			h, err := cr.Route(context.Background(), nil, tt.msg)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Route() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			_ = h(context.Background(), nil, tt.msg)
+			if !reflect.DeepEqual(trace, tt.want) {
+				t.Fatalf("want: %v, but: %v", tt.want, trace)
+			}
+		})
+	}
+
+	if _, err := cr.Route(context.Background(), nil, "unknown"); err == nil {
+		t.Fatalf("want key func error, but: nil")
+	}
+
+	want := []RouteInfo[uint16]{
+		{Key: 1, Group: "", Middlewares: 1},
+		{Key: 100, Group: "admin", Middlewares: 3},
+		{Key: 101, Group: "admin/users", Middlewares: 2},
+	}
+	if routes := cr.Routes(); !reflect.DeepEqual(routes, want) {
+		t.Fatalf("want routes: %v, but: %v", want, routes)
+	}
+}
+
+func TestCommandRouter_NotFound(t *testing.T) {
+	cr := NewCommandRouter(commandKey)
+	called := false
+	cr.NotFound(func(ctx context.Context, ch less.Channel, message interface{}) error {
+		called = true
+		return nil
+	})
+
+	h, err := cr.Route(context.Background(), nil, &command{ID: 1})
+	if err != nil {
+		t.Fatalf("Route() error = %v", err)
+	}
+	_ = h(context.Background(), nil, nil)
+	if !called {
+		t.Fatalf("want not found handler called")
+	}
+}
@@ -0,0 +1,111 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/emove/less"
+)
+
+// ErrRouteNotFound is returned when there is no handler matches the message
+var ErrRouteNotFound = errors.New("no handler matches the message")
+
+// TypeRouter dispatches messages to the handlers registered by the Go type of message.
+type TypeRouter struct {
+	mu       sync.RWMutex // guard the following
+	handlers map[reflect.Type]less.Handler
+	fallback less.Handler
+}
+
+// TypeRouterOption sets TypeRouter options
+type TypeRouterOption func(tr *TypeRouter)
+
+// WithFallback sets the handler for messages whose type has not been registered,
+// the ErrRouteNotFound will be returned by default.
+func WithFallback(handler less.Handler) TypeRouterOption {
+	return func(tr *TypeRouter) {
+		tr.fallback = handler
+	}
+}
+
+// NewTypeRouter returns a TypeRouter, use Handle to register handlers and
+// use TypeRouter.Route as the Router of server.
+func NewTypeRouter(ops ...TypeRouterOption) *TypeRouter {
+	tr := &TypeRouter{
+		handlers: make(map[reflect.Type]less.Handler),
+	}
+	for _, op := range ops {
+		op(tr)
+	}
+	return tr
+}
+
+// Handle registers the handler for messages of type T or *T, T must be a concrete non-pointer type,
+// register Foo rather than *Foo to handle *Foo messages. Registering a type twice panics.
+func Handle[T any](tr *TypeRouter, handler func(ctx context.Context, ch less.Channel, message *T) error) {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	tr.register(typ, func(ctx context.Context, ch less.Channel, message interface{}) error {
+		switch msg := message.(type) {
+		case *T:
+			return handler(ctx, ch, msg)
+		case T:
+			return handler(ctx, ch, &msg)
+		default:
+			return fmt.Errorf("message type %T mismatch handler type %v", message, typ)
+		}
+	})
+}
+
+// Route implements Router, it returns the handler registered by the dynamic type of msg
+func (tr *TypeRouter) Route(_ context.Context, _ less.Channel, msg interface{}) (less.Handler, error) {
+	tr.mu.RLock()
+	defer tr.mu.RUnlock()
+
+	if msg != nil {
+		typ := reflect.TypeOf(msg)
+		if typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+		if handler, ok := tr.handlers[typ]; ok {
+			return handler, nil
+		}
+	}
+
+	if tr.fallback != nil {
+		return tr.fallback, nil
+	}
+	return nil, fmt.Errorf("%w, message type: %T", ErrRouteNotFound, msg)
+}
+
+// Types returns all registered message types
+func (tr *TypeRouter) Types() []reflect.Type {
+	tr.mu.RLock()
+	defer tr.mu.RUnlock()
+
+	types := make([]reflect.Type, 0, len(tr.handlers))
+	for typ := range tr.handlers {
+		types = append(types, typ)
+	}
+	return types
+}
+
+func (tr *TypeRouter) register(typ reflect.Type, handler less.Handler) {
+	if typ.Kind() == reflect.Interface {
+		panic(fmt.Sprintf("router: can not register interface type %v", typ))
+	}
+	// the pointer of messages is stripped by Route, a pointer type would never be matched
+	if typ.Kind() == reflect.Ptr {
+		panic(fmt.Sprintf("router: can not register pointer type %v, register %v instead", typ, typ.Elem()))
+	}
+
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	if _, ok := tr.handlers[typ]; ok {
+		panic(fmt.Sprintf("router: multiple registrations for type %v", typ))
+	}
+	tr.handlers[typ] = handler
+}
@@ -0,0 +1,99 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/emove/less"
+)
+
+type login struct {
+	Name string
+}
+
+type logout struct {
+	Name string
+}
+
+func TestTypeRouter_Route(t *testing.T) {
+	tr := NewTypeRouter()
+
+	var got string
+	Handle(tr, func(ctx context.Context, ch less.Channel, message *login) error {
+		got = "login " + message.Name
+		return nil
+	})
+	Handle(tr, func(ctx context.Context, ch less.Channel, message *logout) error {
+		got = "logout " + message.Name
+		return nil
+	})
+
+	tests := []struct {
+		name    string
+		msg     interface{}
+		want    string
+		wantErr error
+	}{
+		{name: "ptr", msg: &login{Name: "less"}, want: "login less"},
+		{name: "value", msg: logout{Name: "less"}, want: "logout less"},
+		{name: "not found", msg: "less", wantErr: ErrRouteNotFound},
+		{name: "nil", msg: nil, wantErr: ErrRouteNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = ""
+			handler, err := tr.Route(context.Background(), nil, tt.msg)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Route() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if err = handler(context.Background(), nil, tt.msg); err != nil {
+				t.Fatalf("handle err: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("want: %s, but: %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestTypeRouter_Fallback(t *testing.T) {
+	fallback := errors.New("fallback")
+	tr := NewTypeRouter(WithFallback(func(ctx context.Context, ch less.Channel, message interface{}) error {
+		return fallback
+	}))
+
+	handler, err := tr.Route(context.Background(), nil, "unknown")
+	if err != nil {
+		t.Fatalf("Route() error = %v", err)
+	}
+	if err = handler(context.Background(), nil, "unknown"); err != fallback {
+		t.Fatalf("want: %v, but: %v", fallback, err)
+	}
+}
+
+func TestHandle_Duplicate(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("want panic on duplicate registration")
+		}
+	}()
+
+	tr := NewTypeRouter()
+	h := func(ctx context.Context, ch less.Channel, message *login) error { return nil }
+	Handle(tr, h)
+	Handle(tr, h)
+}
+
+func TestHandle_Pointer(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("want panic on pointer type registration")
+		}
+	}()
+
+	Handle(NewTypeRouter(), func(ctx context.Context, ch less.Channel, message **login) error { return nil })
+}
@@ -7,9 +7,15 @@ import (
 	"net"
 
 	"github.com/emove/less"
+	"github.com/emove/less/admission"
+	"github.com/emove/less/codec/negotiate"
 	"github.com/emove/less/internal/trans"
 	"github.com/emove/less/keepalive"
+	"github.com/emove/less/metrics"
+	"github.com/emove/less/overload"
 	_go "github.com/emove/less/pkg/pool/go"
+	"github.com/emove/less/protocol"
+	"github.com/emove/less/ratelimit"
 	"github.com/emove/less/router"
 	"github.com/emove/less/transport"
 	"github.com/emove/less/transport/tcp"
@@ -69,6 +75,22 @@ func (srv *Server) Run() {
 	}()
 }
 
+// OverloadStats returns the counters of inbound messages
+func (srv *Server) OverloadStats() overload.Stats {
+	if srv.handler == nil {
+		return overload.Stats{}
+	}
+	return srv.handler.OverloadStats()
+}
+
+// RateLimitStats returns the counters of rate limits
+func (srv *Server) RateLimitStats() ratelimit.Stats {
+	if srv.handler == nil {
+		return ratelimit.Stats{}
+	}
+	return srv.handler.RateLimitStats()
+}
+
 // Shutdown stops the Server, closes the transporter and all channels
 func (srv *Server) Shutdown() {
 	_ = srv.handler.Close(context.Background(), nil)
@@ -117,6 +139,36 @@ func KeepaliveParams(kp keepalive.ServerParameters) ServerOption {
 	}
 }
 
+// OverloadParams sets inbound concurrency limit parameters
+func OverloadParams(op overload.Parameters) ServerOption {
+	return func(ops *serverOptions) {
+		ops.transOptions = append(ops.transOptions, trans.Overload(op))
+	}
+}
+
+// RateLimitParams sets the token bucket rate limits of inbound messages and connections
+func RateLimitParams(rl ratelimit.Parameters) ServerOption {
+	return func(ops *serverOptions) {
+		ops.transOptions = append(ops.transOptions, trans.RateLimit(rl))
+	}
+}
+
+// WithAdmission sets the admission controller checking connections before channels created,
+// the config of controller could be updated at runtime
+func WithAdmission(controller *admission.Controller) ServerOption {
+	return func(ops *serverOptions) {
+		ops.transOptions = append(ops.transOptions, trans.WithAdmission(controller))
+	}
+}
+
+// WithMetrics sets the metrics reporting the measurements of transport, such as the active
+// channels, the traffic and the handle latency of messages
+func WithMetrics(m *metrics.Metrics) ServerOption {
+	return func(ops *serverOptions) {
+		ops.transOptions = append(ops.transOptions, trans.WithMetrics(m))
+	}
+}
+
 // WithInboundMiddleware adds inbound middlewares
 func WithInboundMiddleware(mws ...less.Middleware) ServerOption {
 	return func(ops *serverOptions) {
@@ -135,6 +187,24 @@ func WithOutboundMiddleware(mws ...less.Middleware) ServerOption {
 	}
 }
 
+// WithProtocols sets the protocols served on the same port, the protocol of a channel is sniffed
+// by the first bytes received in order, the channel not matched uses the server's codecs and router
+func WithProtocols(protocols ...protocol.Protocol) ServerOption {
+	return func(ops *serverOptions) {
+		if len(protocols) > 0 {
+			ops.transOptions = append(ops.transOptions, trans.WithProtocols(protocols...))
+		}
+	}
+}
+
+// WithNegotiator sets the codec negotiator, which negotiates the codecs of a channel with the peer
+// before the first packet decoded
+func WithNegotiator(negotiator negotiate.Negotiator) ServerOption {
+	return func(ops *serverOptions) {
+		ops.transOptions = append(ops.transOptions, trans.WithNegotiator(negotiator))
+	}
+}
+
 // MaxChannelSize sets the max size of channels
 func MaxChannelSize(size uint32) ServerOption {
 	return func(ops *serverOptions) {
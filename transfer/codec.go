@@ -0,0 +1,253 @@
+package transfer
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/emove/less/codec"
+	"github.com/emove/less/pkg/io"
+	"github.com/emove/less/pkg/io/reader"
+)
+
+var ErrMalformedFrame = errors.New("malformed transfer frame")
+
+// payload kinds, every payload starts with its kind
+const (
+	// kindMessage marks the payload is a message of inner codec
+	kindMessage byte = iota
+	kindOpen
+	kindChunk
+	kindAck
+	kindCancel
+	kindAbort
+)
+
+// Open starts a transfer, it's sent by the sender before the chunks.
+type Open struct {
+	ID     uint64
+	Offset int64 // the offset of the first chunk, non-zero when resuming
+	Size   int64 // the total size of the body including Offset, -1 means unknown
+	Window int64 // the maximum number of bytes sent but not acknowledged
+	Meta   string
+}
+
+// Chunk is a part of the body starting at Offset, EOF indicates it's the last chunk.
+type Chunk struct {
+	ID     uint64
+	Offset int64
+	Data   []byte
+	EOF    bool
+}
+
+// Ack is sent by the receiver, it acknowledges all bytes before Offset have been consumed.
+// The first Ack accepts the transfer with the Window of receiver.
+type Ack struct {
+	ID     uint64
+	Offset int64
+	Window int64
+}
+
+// Cancel is sent by the receiver to stop the sender.
+type Cancel struct {
+	ID     uint64
+	Reason string
+}
+
+// Abort is sent by the sender to tell the receiver that no more chunks will be sent.
+type Abort struct {
+	ID     uint64
+	Reason string
+}
+
+// NewPayloadCodec returns a payload codec which encodes the transfer frames, and delegates
+// the other messages to the inner codec. Each payload is prefixed with a kind byte marks whether
+// it's a transfer frame, so the payloads of inner codec are never mistaken for frames, and both
+// sides should use the codec.
+func NewPayloadCodec(c codec.PayloadCodec) codec.PayloadCodec {
+	return &payloadCodec{c: c}
+}
+
+var _ codec.PayloadCodec = (*payloadCodec)(nil)
+
+type payloadCodec struct {
+	c codec.PayloadCodec
+}
+
+func (pc *payloadCodec) Name() string {
+	return "transfer-" + pc.c.Name()
+}
+
+func (pc *payloadCodec) Marshal(message interface{}, writer io.Writer) (err error) {
+	var kind byte
+	var buf []byte
+	switch m := message.(type) {
+	case *Open:
+		kind = kindOpen
+		buf = appendUvarint(buf, m.ID)
+		buf = appendVarint(buf, m.Offset)
+		buf = appendVarint(buf, m.Size)
+		buf = appendVarint(buf, m.Window)
+		buf = appendString(buf, m.Meta)
+	case *Chunk:
+		kind = kindChunk
+		buf = appendUvarint(buf, m.ID)
+		buf = appendVarint(buf, m.Offset)
+		if m.EOF {
+			buf = append(buf, 1)
+		} else {
+			buf = append(buf, 0)
+		}
+	case *Ack:
+		kind = kindAck
+		buf = appendUvarint(buf, m.ID)
+		buf = appendVarint(buf, m.Offset)
+		buf = appendVarint(buf, m.Window)
+	case *Cancel:
+		kind = kindCancel
+		buf = appendUvarint(buf, m.ID)
+		buf = appendString(buf, m.Reason)
+	case *Abort:
+		kind = kindAbort
+		buf = appendUvarint(buf, m.ID)
+		buf = appendString(buf, m.Reason)
+	default:
+		if _, err = writer.Write([]byte{kindMessage}); err != nil {
+			return err
+		}
+		return pc.c.Marshal(message, writer)
+	}
+
+	if _, err = writer.Write([]byte{kind}); err != nil {
+		return err
+	}
+	if _, err = writer.Write(buf); err != nil {
+		return err
+	}
+	if chunk, ok := message.(*Chunk); ok {
+		// the data takes the rest of payload
+		_, err = writer.Write(chunk.Data)
+	}
+	return err
+}
+
+func (pc *payloadCodec) UnMarshal(r io.Reader) (message interface{}, err error) {
+	head, err := r.Next(1)
+	if err != nil {
+		return nil, err
+	}
+	kind, length := head[0], r.Length()-1
+	if kind == kindMessage {
+		return pc.c.UnMarshal(reader.NewLimitReader(r, uint32(length)))
+	}
+
+	body, err := r.Next(length)
+	if err != nil {
+		return nil, err
+	}
+	d := &decoder{buf: body}
+	switch kind {
+	case kindOpen:
+		message = &Open{ID: d.uvarint(), Offset: d.varint(), Size: d.varint(), Window: d.varint(), Meta: d.string()}
+	case kindChunk:
+		chunk := &Chunk{ID: d.uvarint(), Offset: d.varint(), EOF: d.byte() == 1}
+		// copies the data, the reader will be released after decoding
+		chunk.Data = append([]byte(nil), d.rest()...)
+		message = chunk
+	case kindAck:
+		message = &Ack{ID: d.uvarint(), Offset: d.varint(), Window: d.varint()}
+	case kindCancel:
+		message = &Cancel{ID: d.uvarint(), Reason: d.string()}
+	case kindAbort:
+		message = &Abort{ID: d.uvarint(), Reason: d.string()}
+	default:
+		return nil, fmt.Errorf("%w, unknown kind: %d", ErrMalformedFrame, kind)
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return message, nil
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	return append(buf, b[:binary.PutUvarint(b[:], v)]...)
+}
+
+func appendVarint(buf []byte, v int64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	return append(buf, b[:binary.PutVarint(b[:], v)]...)
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = appendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// decoder decodes the fields of frame, the first error is kept in err
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = ErrMalformedFrame
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.err = ErrMalformedFrame
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.buf) == 0 {
+		d.err = ErrMalformedFrame
+		return 0
+	}
+	b := d.buf[0]
+	d.buf = d.buf[1:]
+	return b
+}
+
+func (d *decoder) string() string {
+	n := d.uvarint()
+	if d.err != nil {
+		return ""
+	}
+	if uint64(len(d.buf)) < n {
+		d.err = ErrMalformedFrame
+		return ""
+	}
+	s := string(d.buf[:n])
+	d.buf = d.buf[n:]
+	return s
+}
+
+func (d *decoder) rest() []byte {
+	if d.err != nil {
+		return nil
+	}
+	rest := d.buf
+	d.buf = nil
+	return rest
+}
@@ -0,0 +1,537 @@
+// Package transfer streams large bodies over a channel as a sequence of chunks, so that neither
+// side materializes the whole body in memory.
+//
+// The sender sends an io.Reader by Streamer.Send, and the receiver's handler gets a *Transfer,
+// which is an io.Reader, as soon as the transfer opened. Both sides should install the
+// Streamer.Middleware as an inbound middleware, and wrap the payload codec by NewPayloadCodec.
+//
+// The sender keeps at most a window of bytes unacknowledged, so a slow receiver slows down the
+// sender. Cancelling the transfer on the receiver side stops the sender, and cancelling the
+// context of Send aborts the receiver.
+//
+// The chunks of a transfer are handled concurrently like other messages, so the handler of
+// *Transfer should not be limited to a single inflight message per channel by overload parameters.
+package transfer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	stdio "io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/emove/less"
+	"github.com/emove/less/log"
+)
+
+var (
+	// ErrCanceled is returned by Send when the receiver canceled the transfer
+	ErrCanceled = errors.New("transfer canceled by receiver")
+	// ErrAborted is returned by Transfer.Read when the sender aborted the transfer
+	ErrAborted = errors.New("transfer aborted by sender")
+	// ErrChannelClosed is returned when the channel closed during the transfer
+	ErrChannelClosed = errors.New("channel closed during transfer")
+	// ErrWindowExceeded is the cancel reason when the sender sent more than the window
+	ErrWindowExceeded = errors.New("transfer window exceeded")
+)
+
+type options struct {
+	chunkSize int
+	window    int64
+}
+
+var defaultOptions = options{
+	chunkSize: 32 * 1024,
+	window:    1024 * 1024,
+}
+
+// Option sets Streamer options
+type Option func(ops *options)
+
+// WithChunkSize sets the maximum data size of each chunk, 32KB by default
+func WithChunkSize(size int) Option {
+	return func(ops *options) {
+		if size > 0 {
+			ops.chunkSize = size
+		}
+	}
+}
+
+// WithWindow sets the maximum number of bytes sent but not acknowledged, 1MB by default.
+// It's at least the chunk size.
+func WithWindow(window int64) Option {
+	return func(ops *options) {
+		if window > 0 {
+			ops.window = window
+		}
+	}
+}
+
+// SendOption sets options of a single transfer
+type SendOption func(o *sendOptions)
+
+type sendOptions struct {
+	offset int64
+	size   int64
+	meta   string
+}
+
+// WithOffset resumes the transfer from offset. The reader is seeked to offset if it's an
+// io.Seeker, otherwise it should have been positioned at offset by caller.
+func WithOffset(offset int64) SendOption {
+	return func(o *sendOptions) {
+		o.offset = offset
+	}
+}
+
+// WithSize tells the receiver the total size of body including the offset
+func WithSize(size int64) SendOption {
+	return func(o *sendOptions) {
+		o.size = size
+	}
+}
+
+// WithMeta sets the meta of transfer, such as a file name
+func WithMeta(meta string) SendOption {
+	return func(o *sendOptions) {
+		o.meta = meta
+	}
+}
+
+// Streamer sends and receives transfers
+type Streamer struct {
+	ops      options
+	nextID   uint64
+	channels sync.Map // less.Channel -> *channelTransfers
+}
+
+// channelTransfers holds the transfers of a channel
+type channelTransfers struct {
+	mu       sync.Mutex // guard the following
+	closed   bool
+	incoming map[uint64]*Transfer
+	outgoing map[uint64]*outgoing
+}
+
+// New returns a Streamer
+func New(op ...Option) *Streamer {
+	ops := defaultOptions
+	for _, o := range op {
+		o(&ops)
+	}
+	if ops.window < int64(ops.chunkSize) {
+		ops.window = int64(ops.chunkSize)
+	}
+	return &Streamer{ops: ops}
+}
+
+// transfers returns the transfers of ch, they are failed with ErrChannelClosed after ch closed
+func (s *Streamer) transfers(ch less.Channel) *channelTransfers {
+	if v, ok := s.channels.Load(ch); ok {
+		return v.(*channelTransfers)
+	}
+	ct := &channelTransfers{incoming: make(map[uint64]*Transfer), outgoing: make(map[uint64]*outgoing)}
+	v, loaded := s.channels.LoadOrStore(ch, ct)
+	if loaded {
+		return v.(*channelTransfers)
+	}
+
+	onClosed := func(ctx context.Context, ch less.Channel, err error) {
+		s.channels.Delete(ch)
+		ct.close()
+	}
+	ch.AddOnChannelClosed(onClosed)
+	if !ch.IsActive() {
+		onClosed(context.Background(), ch, nil)
+	}
+	return ct
+}
+
+func (ct *channelTransfers) close() {
+	ct.mu.Lock()
+	ct.closed = true
+	in, out := ct.incoming, ct.outgoing
+	ct.incoming, ct.outgoing = map[uint64]*Transfer{}, map[uint64]*outgoing{}
+	ct.mu.Unlock()
+
+	for _, t := range in {
+		t.fail(ErrChannelClosed)
+	}
+	for _, o := range out {
+		o.fail(ErrChannelClosed)
+	}
+}
+
+// Middleware returns an inbound middleware which handles the transfer frames, the handler
+// after it receives a *Transfer when a transfer opened
+func (s *Streamer) Middleware() less.Middleware {
+	return func(next less.Handler) less.Handler {
+		return func(ctx context.Context, ch less.Channel, message interface{}) error {
+			switch m := message.(type) {
+			case *Open:
+				return s.onOpen(ctx, ch, m, next)
+			case *Chunk:
+				if t := s.incoming(ch, m.ID); t != nil {
+					t.recv(m)
+				}
+			case *Abort:
+				if t := s.incoming(ch, m.ID); t != nil {
+					t.fail(fmt.Errorf("%w: %s", ErrAborted, m.Reason))
+				}
+			case *Ack:
+				if o := s.outgoing(ch, m.ID); o != nil {
+					o.ack(m.Offset, m.Window)
+				}
+			case *Cancel:
+				if o := s.outgoing(ch, m.ID); o != nil {
+					o.fail(fmt.Errorf("%w: %s", ErrCanceled, m.Reason))
+				}
+			default:
+				return next(ctx, ch, message)
+			}
+			return nil
+		}
+	}
+}
+
+func (s *Streamer) incoming(ch less.Channel, id uint64) *Transfer {
+	ct := s.transfers(ch)
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	return ct.incoming[id]
+}
+
+func (s *Streamer) outgoing(ch less.Channel, id uint64) *outgoing {
+	ct := s.transfers(ch)
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	return ct.outgoing[id]
+}
+
+func (s *Streamer) onOpen(ctx context.Context, ch less.Channel, m *Open, next less.Handler) error {
+	window := m.Window
+	if window <= 0 || window > s.ops.window {
+		// the receiver buffers a window of bytes at most, the sender follows the window of acceptance
+		window = s.ops.window
+	}
+	t := &Transfer{
+		ID:      m.ID,
+		Offset:  m.Offset,
+		Size:    m.Size,
+		Meta:    m.Meta,
+		ch:      ch,
+		window:  window,
+		next:    m.Offset,
+		acked:   m.Offset,
+		end:     -1,
+		pending: make(map[int64][]byte),
+		notify:  make(chan struct{}, 1),
+	}
+
+	ct := s.transfers(ch)
+	ct.mu.Lock()
+	if ct.closed {
+		ct.mu.Unlock()
+		return ErrChannelClosed
+	}
+	if _, ok := ct.incoming[m.ID]; ok {
+		ct.mu.Unlock()
+		return fmt.Errorf("transfer %d has been opened", m.ID)
+	}
+	ct.incoming[m.ID] = t
+	ct.mu.Unlock()
+
+	defer func() {
+		ct.mu.Lock()
+		delete(ct.incoming, m.ID)
+		ct.mu.Unlock()
+	}()
+
+	// accepts the transfer with the window
+	if err := ch.Write(&Ack{ID: m.ID, Offset: m.Offset, Window: window}); err != nil {
+		return err
+	}
+
+	err := next(ctx, ch, t)
+	if !t.done() {
+		// tells the sender to stop if the handler returned before the transfer completed
+		reason := "transfer not consumed"
+		if err != nil {
+			reason = err.Error()
+		}
+		_ = t.Cancel(reason)
+	}
+	return err
+}
+
+// Send sends the data of r to ch until io.EOF, returns the number of bytes sent. A failed transfer
+// can be resumed from the offset received by the peer, see Transfer.Received.
+func (s *Streamer) Send(ctx context.Context, ch less.Channel, r stdio.Reader, op ...SendOption) (n int64, err error) {
+	so := sendOptions{size: -1}
+	for _, o := range op {
+		o(&so)
+	}
+	if seeker, ok := r.(stdio.Seeker); ok && so.offset > 0 {
+		if _, err = seeker.Seek(so.offset, stdio.SeekStart); err != nil {
+			return 0, err
+		}
+	}
+
+	o := &outgoing{acked: -1, notify: make(chan struct{}, 1)}
+	id := atomic.AddUint64(&s.nextID, 1)
+	ct := s.transfers(ch)
+	ct.mu.Lock()
+	if ct.closed {
+		ct.mu.Unlock()
+		return 0, ErrChannelClosed
+	}
+	ct.outgoing[id] = o
+	ct.mu.Unlock()
+	defer func() {
+		ct.mu.Lock()
+		delete(ct.outgoing, id)
+		ct.mu.Unlock()
+	}()
+
+	defer func() {
+		if err != nil && !errors.Is(err, ErrCanceled) && !errors.Is(err, ErrChannelClosed) {
+			// propagates the failure to the receiver
+			if e := ch.Write(&Abort{ID: id, Reason: err.Error()}); e != nil {
+				log.Debugw("remote", ch.RemoteAddr(), log.DefaultMsgKey, "send transfer abort failed", "err", e)
+			}
+		}
+	}()
+
+	if err = ch.Write(&Open{ID: id, Offset: so.offset, Size: so.size, Window: s.ops.window, Meta: so.meta}); err != nil {
+		return 0, err
+	}
+	// waits for the receiver accepting the transfer with its window
+	window, err := o.accepted(ctx)
+	if err != nil {
+		return 0, err
+	}
+	chunkSize := s.ops.chunkSize
+	if int64(chunkSize) > window {
+		chunkSize = int(window)
+	}
+
+	offset := so.offset
+	for eof := false; !eof; {
+		buf := make([]byte, chunkSize)
+		var size int
+		size, err = stdio.ReadFull(r, buf)
+		switch err {
+		case nil:
+		case stdio.EOF, stdio.ErrUnexpectedEOF:
+			eof = true
+		default:
+			return n, err
+		}
+
+		// waits until the window has room for the chunk
+		if err = o.await(ctx, offset+int64(size)-window); err != nil {
+			return n, err
+		}
+		if err = ch.Write(&Chunk{ID: id, Offset: offset, Data: buf[:size], EOF: eof}); err != nil {
+			return n, err
+		}
+		offset += int64(size)
+		n += int64(size)
+	}
+	return n, nil
+}
+
+// outgoing is the state of a transfer being sent
+type outgoing struct {
+	mu     sync.Mutex // guard the following
+	acked  int64      // -1 until the transfer accepted
+	window int64
+	err    error
+	notify chan struct{}
+}
+
+func (o *outgoing) ack(offset int64, window int64) {
+	o.mu.Lock()
+	if o.acked < 0 {
+		o.window = window
+	}
+	if offset > o.acked {
+		o.acked = offset
+	}
+	o.mu.Unlock()
+	o.wakeup()
+}
+
+func (o *outgoing) fail(err error) {
+	o.mu.Lock()
+	if o.err == nil {
+		o.err = err
+	}
+	o.mu.Unlock()
+	o.wakeup()
+}
+
+func (o *outgoing) wakeup() {
+	select {
+	case o.notify <- struct{}{}:
+	default:
+	}
+}
+
+// accepted waits until the transfer accepted, returns the window of receiver
+func (o *outgoing) accepted(ctx context.Context) (int64, error) {
+	if err := o.await(ctx, 0); err != nil {
+		return 0, err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.window, nil
+}
+
+// await waits until the transfer accepted and the acknowledged offset reaches offset
+func (o *outgoing) await(ctx context.Context, offset int64) error {
+	for {
+		o.mu.Lock()
+		acked, err := o.acked, o.err
+		o.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		if acked >= 0 && acked >= offset {
+			return nil
+		}
+
+		select {
+		case <-o.notify:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Transfer is the receiving side of a transfer, it reads the body in order.
+type Transfer struct {
+	ID     uint64
+	Offset int64  // the offset of the first byte, non-zero when resuming
+	Size   int64  // the total size of body including Offset, -1 means unknown
+	Meta   string // the meta set by the sender
+
+	ch     less.Channel
+	window int64
+
+	mu      sync.Mutex // guard the following
+	pending map[int64][]byte
+	buf     []byte
+	next    int64 // the offset of the next byte to read
+	acked   int64
+	end     int64 // the offset after the last byte, -1 until the last chunk received
+	err     error
+	notify  chan struct{}
+}
+
+// Read reads the body in order, returns io.EOF after the whole body read
+func (t *Transfer) Read(p []byte) (n int, err error) {
+	for {
+		t.mu.Lock()
+		if len(t.buf) == 0 {
+			if data, ok := t.pending[t.next]; ok {
+				delete(t.pending, t.next)
+				t.buf = data
+			}
+		}
+		if len(t.buf) > 0 {
+			n = copy(p, t.buf)
+			t.buf = t.buf[n:]
+			t.next += int64(n)
+			var ack int64
+			if t.next-t.acked >= t.window/2 || t.next == t.end {
+				t.acked, ack = t.next, t.next
+			}
+			t.mu.Unlock()
+
+			if ack > 0 {
+				if err = t.ch.Write(&Ack{ID: t.ID, Offset: ack}); err != nil {
+					return n, err
+				}
+			}
+			return n, nil
+		}
+		if t.end >= 0 && t.next >= t.end {
+			t.mu.Unlock()
+			return 0, stdio.EOF
+		}
+		err = t.err
+		t.mu.Unlock()
+		if err != nil {
+			return 0, err
+		}
+
+		<-t.notify
+	}
+}
+
+// Received returns the offset after the last byte read, the sender can resume from it
+func (t *Transfer) Received() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.next
+}
+
+// Cancel stops the transfer and tells the sender the reason
+func (t *Transfer) Cancel(reason string) error {
+	if !t.fail(fmt.Errorf("%w: %s", ErrCanceled, reason)) {
+		return nil
+	}
+	return t.ch.Write(&Cancel{ID: t.ID, Reason: reason})
+}
+
+// done returns whether the whole body read or the transfer failed
+func (t *Transfer) done() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err != nil || (t.end >= 0 && t.next >= t.end)
+}
+
+func (t *Transfer) recv(chunk *Chunk) {
+	t.mu.Lock()
+	if t.err != nil || chunk.Offset < t.next {
+		// duplicate or canceled
+		t.mu.Unlock()
+		return
+	}
+	if chunk.Offset+int64(len(chunk.Data)) > t.acked+t.window {
+		t.mu.Unlock()
+		_ = t.Cancel(ErrWindowExceeded.Error())
+		return
+	}
+	if len(chunk.Data) > 0 {
+		t.pending[chunk.Offset] = chunk.Data
+	}
+	if chunk.EOF {
+		t.end = chunk.Offset + int64(len(chunk.Data))
+	}
+	t.mu.Unlock()
+	t.wakeup()
+}
+
+// fail sets the error of transfer, returns false if the transfer has been failed
+func (t *Transfer) fail(err error) bool {
+	t.mu.Lock()
+	if t.err != nil {
+		t.mu.Unlock()
+		return false
+	}
+	t.err = err
+	t.pending, t.buf = map[int64][]byte{}, nil
+	t.mu.Unlock()
+	t.wakeup()
+	return true
+}
+
+func (t *Transfer) wakeup() {
+	select {
+	case t.notify <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,202 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	stdio "io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/emove/less"
+	"github.com/emove/less/codec"
+	"github.com/emove/less/codec/packet"
+	"github.com/emove/less/codec/payload"
+	"github.com/emove/less/internal/trans"
+	ior "github.com/emove/less/pkg/io/reader"
+	"github.com/emove/less/pkg/io/writer"
+	"github.com/emove/less/transport/tcp"
+)
+
+func TestPayloadCodec(t *testing.T) {
+	pc := NewPayloadCodec(payload.NewTextCodec())
+	for _, msg := range []interface{}{
+		&Open{ID: 1, Offset: 10, Size: -1, Window: 1024, Meta: "file.txt"},
+		&Chunk{ID: 1, Offset: 10, Data: []byte("data"), EOF: true},
+		&Ack{ID: 1, Offset: 14, Window: 1024},
+		&Cancel{ID: 1, Reason: "enough"},
+		&Abort{ID: 1, Reason: "eof"},
+		"plain text",
+		// the payloads of inner codec are not sniffed
+		"LSTF\x01\x01\x14\x01\x00",
+		"",
+	} {
+		buf := mustMarshal(t, pc, msg)
+		r := ior.NewLimitReader(ior.NewBufferReader(bytes.NewReader(buf)), uint32(len(buf)))
+		got, err := pc.UnMarshal(r)
+		if err != nil {
+			t.Fatalf("UnMarshal(%T) error = %v", msg, err)
+		}
+		if s, ok := msg.(string); ok {
+			if got != s {
+				t.Fatalf("want: %q, but: %v", s, got)
+			}
+			continue
+		}
+		if !bytes.Equal(mustMarshal(t, pc, got), mustMarshal(t, pc, msg)) {
+			t.Fatalf("want: %+v, but: %+v", msg, got)
+		}
+	}
+
+	buf := []byte{0x7f}
+	if _, err := pc.UnMarshal(ior.NewLimitReader(ior.NewBufferReader(bytes.NewReader(buf)), 1)); !errors.Is(err, ErrMalformedFrame) {
+		t.Fatalf("want: %v, but: %v", ErrMalformedFrame, err)
+	}
+}
+
+func mustMarshal(t *testing.T, pc codec.PayloadCodec, msg interface{}) []byte {
+	buff := &bytes.Buffer{}
+	w := writer.NewBufferWriter(buff)
+	if err := pc.Marshal(msg, w); err != nil {
+		t.Fatalf("Marshal(%T) error = %v", msg, err)
+	}
+	_ = w.Flush()
+	return buff.Bytes()
+}
+
+// connect connects a client to a server through a pipe, returns the client channel
+func connect(t *testing.T, server, client trans.TransHandler, chs chan less.Channel) less.Channel {
+	s, c := net.Pipe()
+	for _, p := range []struct {
+		th   trans.TransHandler
+		conn net.Conn
+	}{{server, s}, {client, c}} {
+		conn := tcp.WrapConnection(p.conn)
+		ctx, err := p.th.OnConnect(context.Background(), conn)
+		if err != nil {
+			t.Fatalf("OnConnect() error = %v", err)
+		}
+		th := p.th
+		go func() {
+			for th.OnMessage(ctx, conn) == nil {
+			}
+		}()
+	}
+	return <-chs
+}
+
+func newHandler(s *Streamer, handler less.Handler, ops ...trans.Option) trans.TransHandler {
+	ops = append([]trans.Option{
+		trans.WithPacketCodec(packet.NewVariableLengthCodec()),
+		trans.WithPayloadCodec(NewPayloadCodec(payload.NewTextCodec())),
+		trans.AddInboundMiddleware(s.Middleware()),
+		trans.WithRouter(func(ctx context.Context, ch less.Channel, msg interface{}) (less.Handler, error) {
+			return handler, nil
+		}),
+	}, ops...)
+	return trans.NewTransHandler(ops...)
+}
+
+// setup returns the client channel and the client streamer, the transfers are handled by handler
+func setup(t *testing.T, handler func(t *Transfer) error) (less.Channel, *Streamer) {
+	server := newHandler(New(WithWindow(64*1024), WithChunkSize(4096)), func(ctx context.Context, ch less.Channel, message interface{}) error {
+		return handler(message.(*Transfer))
+	})
+	t.Cleanup(func() { server.Close(context.Background(), nil) })
+
+	chs := make(chan less.Channel, 1)
+	cs := New(WithChunkSize(8192))
+	client := newHandler(cs, func(ctx context.Context, ch less.Channel, message interface{}) error { return nil },
+		trans.AddOnChannel(func(ctx context.Context, ch less.Channel) (context.Context, error) {
+			chs <- ch
+			return ctx, nil
+		}))
+	t.Cleanup(func() { client.Close(context.Background(), nil) })
+
+	return connect(t, server, client, chs), cs
+}
+
+func TestStreamer_Send(t *testing.T) {
+	body := make([]byte, 1024*1024+100)
+	_, _ = rand.Read(body)
+
+	type result struct {
+		transfer *Transfer
+		data     []byte
+		err      error
+	}
+	results := make(chan result, 1)
+	ch, s := setup(t, func(t *Transfer) error {
+		data, err := stdio.ReadAll(t)
+		results <- result{transfer: t, data: data, err: err}
+		return err
+	})
+
+	n, err := s.Send(context.Background(), ch, bytes.NewReader(body), WithMeta("body.bin"), WithSize(int64(len(body))))
+	if err != nil || n != int64(len(body)) {
+		t.Fatalf("Send() = %d, %v", n, err)
+	}
+	r := <-results
+	if r.err != nil {
+		t.Fatalf("ReadAll() error = %v", r.err)
+	}
+	if !bytes.Equal(r.data, body) {
+		t.Fatalf("received body mismatch, length: %d", len(r.data))
+	}
+	if r.transfer.Meta != "body.bin" || r.transfer.Size != int64(len(body)) || r.transfer.Received() != int64(len(body)) {
+		t.Fatalf("unexpected transfer: %+v", r.transfer)
+	}
+
+	// resumes from offset
+	offset := int64(1000)
+	if _, err = s.Send(context.Background(), ch, bytes.NewReader(body), WithOffset(offset)); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	r = <-results
+	if r.transfer.Offset != offset || !bytes.Equal(r.data, body[offset:]) {
+		t.Fatalf("unexpected resumed transfer, offset: %d, length: %d", r.transfer.Offset, len(r.data))
+	}
+}
+
+func TestStreamer_Cancel(t *testing.T) {
+	ch, s := setup(t, func(t *Transfer) error {
+		if _, err := stdio.ReadFull(t, make([]byte, 10)); err != nil {
+			return err
+		}
+		return t.Cancel("enough")
+	})
+
+	_, err := s.Send(context.Background(), ch, bytes.NewReader(make([]byte, 1024*1024)))
+	if !errors.Is(err, ErrCanceled) {
+		t.Fatalf("want: %v, but: %v", ErrCanceled, err)
+	}
+}
+
+func TestStreamer_Abort(t *testing.T) {
+	errs := make(chan error, 1)
+	ch, s := setup(t, func(t *Transfer) error {
+		_, err := stdio.ReadAll(t)
+		errs <- err
+		return nil
+	})
+
+	// the reader blocks after the first chunk until the context canceled
+	pr, pw := stdio.Pipe()
+	go func() {
+		_, _ = pw.Write(make([]byte, 100))
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		_ = pw.CloseWithError(ctx.Err())
+	}()
+	if _, err := s.Send(ctx, ch, pr); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want: %v, but: %v", context.DeadlineExceeded, err)
+	}
+	if err := <-errs; !errors.Is(err, ErrAborted) {
+		t.Fatalf("want: %v, but: %v", ErrAborted, err)
+	}
+}
@@ -2,6 +2,7 @@ package transport
 
 import (
 	"net"
+	"time"
 
 	"github.com/emove/less/pkg/io"
 )
@@ -33,7 +34,18 @@ type Connection interface {
 	// RemoteAddr returns the remote network address, same as net.Conn#RemoteAddr.
 	RemoteAddr() net.Addr
 
-	// SetReadTimeout sets the timeout for future Read calls wait.
-	// A zero value for timeout means Reader will not be timeout.
-	//SetReadTimeout(t time.Duration) error
+	// PauseRead stops the transport reading from the connection and firing
+	// EventDriver#OnMessage until ResumeRead called.
+	PauseRead()
+
+	// ResumeRead resumes reading from the connection.
+	ResumeRead()
+
+	// SetReadDeadline sets the deadline for future Read calls, it overrides the read
+	// timeout of transport. A zero value for t means Read will not time out.
+	SetReadDeadline(t time.Time) error
+
+	// SetWriteDeadline sets the deadline for future Write calls, it overrides the write
+	// timeout of transport. A zero value for t means Write will not time out.
+	SetWriteDeadline(t time.Time) error
 }
@@ -0,0 +1,40 @@
+package transport
+
+import "errors"
+
+// Operations of the TimeoutError
+const (
+	OpRead  = "read"
+	OpWrite = "write"
+)
+
+// TimeoutError is returned when a read or write of the connection exceeded the deadline.
+type TimeoutError struct {
+	Op  string
+	Err error
+}
+
+func (e *TimeoutError) Error() string {
+	return "transport " + e.Op + " timeout: " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// Timeout implements net.Error
+func (e *TimeoutError) Timeout() bool {
+	return true
+}
+
+// Temporary implements net.Error
+func (e *TimeoutError) Temporary() bool {
+	return false
+}
+
+// IsTimeout returns whether the err is caused by a read or write timeout
+func IsTimeout(err error) bool {
+	var te *TimeoutError
+	return errors.As(err, &te)
+}
@@ -0,0 +1,74 @@
+package mux
+
+import (
+	"encoding/binary"
+)
+
+// The frame header is compatible with yamux:
+//
+//	| version(1) | type(1) | flags(2) | stream id(4) | length(4) |
+//
+// the length is the size of data of a data frame, the window delta of a window update frame,
+// the opaque value of a ping frame, or the error code of a go away frame.
+const (
+	protoVersion byte = 0
+	headerSize        = 12
+)
+
+// frame types
+const (
+	typeData byte = iota
+	typeWindowUpdate
+	typePing
+	typeGoAway
+)
+
+// frame flags
+const (
+	flagSYN uint16 = 1 << iota
+	flagACK
+	flagFIN
+	flagRST
+)
+
+// go away codes
+const (
+	goAwayNormal uint32 = iota
+	goAwayProtoErr
+	goAwayInternalErr
+)
+
+// initialStreamWindow is the initial window size of stream defined by yamux
+const initialStreamWindow uint32 = 256 * 1024
+
+type header []byte
+
+func encodeHeader(typ byte, flags uint16, streamID uint32, length uint32) header {
+	hdr := make(header, headerSize)
+	hdr[0] = protoVersion
+	hdr[1] = typ
+	binary.BigEndian.PutUint16(hdr[2:4], flags)
+	binary.BigEndian.PutUint32(hdr[4:8], streamID)
+	binary.BigEndian.PutUint32(hdr[8:12], length)
+	return hdr
+}
+
+func (h header) version() byte {
+	return h[0]
+}
+
+func (h header) typ() byte {
+	return h[1]
+}
+
+func (h header) flags() uint16 {
+	return binary.BigEndian.Uint16(h[2:4])
+}
+
+func (h header) streamID() uint32 {
+	return binary.BigEndian.Uint32(h[4:8])
+}
+
+func (h header) length() uint32 {
+	return binary.BigEndian.Uint32(h[8:12])
+}
@@ -0,0 +1,188 @@
+// Package mux multiplexes logical bidirectional streams over a single connection of the underlying
+// transport, the frames are compatible with yamux. Each stream is served as a connection of its own,
+// so that it becomes a less.Channel with its own middlewares and hooks.
+//
+// The transport wraps an underlying transport:
+//
+//	srv := server.NewServer(addr, server.WithTransport(mux.New(tcp.New())))
+//
+// and the client opens streams by Client:
+//
+//	session := mux.Client(conn)
+//	stream, err := session.Open()
+package mux
+
+import (
+	"context"
+
+	"github.com/emove/less/internal/recovery"
+	"github.com/emove/less/log"
+	trans "github.com/emove/less/transport"
+)
+
+type Options struct {
+	// StreamWindow is the receive window size of each stream, at least 256KB
+	StreamWindow uint32
+	// MaxStreams is the maximum number of concurrent streams per session, 0 means no limit
+	MaxStreams int
+	// AcceptBacklog is the maximum number of streams waiting for Session.Accept
+	AcceptBacklog int
+}
+
+var DefaultOptions = &Options{
+	StreamWindow:  initialStreamWindow,
+	MaxStreams:    1024,
+	AcceptBacklog: 256,
+}
+
+// WithStreamWindow sets the receive window size of each stream, it's not allowed less than 256KB
+func WithStreamWindow(size uint32) trans.Option {
+	return func(ops trans.Options) {
+		if muxOps, ok := ops.(*Options); ok {
+			if size < initialStreamWindow {
+				log.Warnf("stream window %d is too small, apply %d", size, initialStreamWindow)
+				size = initialStreamWindow
+			}
+			muxOps.StreamWindow = size
+		}
+	}
+}
+
+// WithMaxStreams sets the maximum number of concurrent streams per session
+func WithMaxStreams(max int) trans.Option {
+	return func(ops trans.Options) {
+		if muxOps, ok := ops.(*Options); ok {
+			muxOps.MaxStreams = max
+		}
+	}
+}
+
+// WithAcceptBacklog sets the maximum number of streams waiting for Session.Accept
+func WithAcceptBacklog(backlog int) trans.Option {
+	return func(ops trans.Options) {
+		if muxOps, ok := ops.(*Options); ok {
+			muxOps.AcceptBacklog = backlog
+		}
+	}
+}
+
+func newOptions(op ...trans.Option) *Options {
+	ops := *DefaultOptions
+	for _, o := range op {
+		o(&ops)
+	}
+	return &ops
+}
+
+type transport struct {
+	inner trans.Transport
+	ops   *Options
+}
+
+var _ trans.Transport = (*transport)(nil)
+
+// New returns a transport which multiplexes streams over the connections of inner transport
+func New(inner trans.Transport, op ...trans.Option) trans.Transport {
+	return &transport{
+		inner: inner,
+		ops:   newOptions(op...),
+	}
+}
+
+// Listen listens the addr by the inner transport, each stream opened by peer fires events of driver
+func (t *transport) Listen(addr string, driver trans.EventDriver) error {
+	return t.inner.Listen(addr, &sessionDriver{driver: driver, ops: t.ops})
+}
+
+// Dial dials the remote endpoint by the inner transport, and opens a stream which fires events of driver
+func (t *transport) Dial(network, addr string, driver trans.EventDriver) error {
+	return t.inner.Dial(network, addr, &sessionDriver{driver: driver, ops: t.ops, client: true})
+}
+
+// Close closes the inner transport
+func (t *transport) Close() {
+	t.inner.Close()
+}
+
+type ctxSessionKey struct{}
+
+// sessionDriver serves the connection of inner transport as a session
+type sessionDriver struct {
+	driver trans.EventDriver
+	ops    *Options
+	client bool
+}
+
+var _ trans.EventDriver = (*sessionDriver)(nil)
+
+func (d *sessionDriver) OnConnect(ctx context.Context, con trans.Connection) (context.Context, error) {
+	// streams are served with the context of connection, which carries the TLS state
+	serve := func(st *Stream) {
+		d.serveStream(ctx, st)
+	}
+	s := newSession(connection{con}, d.client, d.ops, serve)
+	if d.client {
+		st, err := s.Open()
+		if err != nil {
+			return ctx, err
+		}
+		go serve(st)
+	}
+	return context.WithValue(ctx, ctxSessionKey{}, s), nil
+}
+
+// OnMessage receives a frame
+func (d *sessionDriver) OnMessage(ctx context.Context, con trans.Connection) error {
+	s := ctx.Value(ctxSessionKey{}).(*Session)
+
+	reader := con.Reader()
+	defer reader.Release()
+	if err := s.recvFrame(reader); err != nil {
+		s.closeWithError(err)
+		return err
+	}
+	return nil
+}
+
+func (d *sessionDriver) OnConnClosed(ctx context.Context, _ trans.Connection, _ error) {
+	if s, ok := ctx.Value(ctxSessionKey{}).(*Session); ok {
+		_ = s.Close()
+	}
+}
+
+// serveStream fires the events of driver with the stream as a connection
+func (d *sessionDriver) serveStream(ctx context.Context, st *Stream) {
+	ctx, err := d.driver.OnConnect(ctx, st)
+	if err != nil {
+		_ = st.Reset()
+		return
+	}
+
+	defer recovery.Recover(func(err error) {
+		// trigger onConnClosed event
+		d.driver.OnConnClosed(ctx, st, err)
+	})
+
+	for st.awaitReadable() {
+		if err = d.driver.OnMessage(ctx, st); err != nil {
+			return
+		}
+	}
+}
+
+// connection adapts transport.Connection to conn
+type connection struct {
+	trans.Connection
+}
+
+func (c connection) writeFrame(hdr header, data []byte) error {
+	writer := c.Writer()
+	defer writer.Release()
+	if _, err := writer.Write(hdr); err != nil {
+		return err
+	}
+	if _, err := writer.Write(data); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
@@ -0,0 +1,198 @@
+package mux
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/emove/less"
+	"github.com/emove/less/codec/packet"
+	"github.com/emove/less/codec/payload"
+	lesstrans "github.com/emove/less/internal/trans"
+	ior "github.com/emove/less/pkg/io/reader"
+	"github.com/emove/less/pkg/io/writer"
+	trans "github.com/emove/less/transport"
+	"github.com/emove/less/transport/tcp"
+)
+
+func pipe() (client, server *Session) {
+	c, s := net.Pipe()
+	return Client(c), Server(s)
+}
+
+func TestSession_Echo(t *testing.T) {
+	client, server := pipe()
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		for {
+			st, err := server.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(st, st)
+				_ = st.Close()
+			}()
+		}
+	}()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			st, err := client.Open()
+			if err != nil {
+				t.Errorf("Open() error = %v", err)
+				return
+			}
+			want := bytes.Repeat([]byte(strconv.Itoa(i)), 1024)
+			if _, err = st.Write(want); err != nil {
+				t.Errorf("Write() error = %v", err)
+				return
+			}
+			got := make([]byte, len(want))
+			if _, err = io.ReadFull(st, got); err != nil {
+				t.Errorf("Read() error = %v", err)
+				return
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("stream %d echoed unexpected data", st.StreamID())
+			}
+			_ = st.Close()
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestStream_FlowControl(t *testing.T) {
+	client, server := pipe()
+	defer client.Close()
+	defer server.Close()
+
+	st, err := client.Open()
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	_ = st.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+	n, err := st.Write(make([]byte, initialStreamWindow+1))
+	if !trans.IsTimeout(err) {
+		t.Fatalf("want timeout error, but: %v", err)
+	}
+	if n != int(initialStreamWindow) {
+		t.Fatalf("want %d bytes written before the window exhausted, but: %d", initialStreamWindow, n)
+	}
+
+	// the window updated after the peer consumed the data
+	peer, err := server.Accept()
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+	if _, err = io.ReadFull(peer, make([]byte, n)); err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	_ = st.SetWriteDeadline(time.Now().Add(time.Second))
+	if _, err = st.Write([]byte{1}); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+}
+
+func TestStream_CloseAndReset(t *testing.T) {
+	client, server := pipe()
+	defer client.Close()
+	defer server.Close()
+
+	st, _ := client.Open()
+	_, _ = st.Write([]byte("bye"))
+	_ = st.Close()
+	peer, _ := server.Accept()
+	if got, err := io.ReadAll(peer); err != nil || string(got) != "bye" {
+		t.Fatalf("want: bye with EOF, but: %q, err: %v", got, err)
+	}
+
+	st, _ = client.Open()
+	_, _ = st.Write([]byte("hi"))
+	peer, _ = server.Accept()
+	_ = peer.Reset()
+	_ = st.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := st.Read(make([]byte, 1)); !errors.Is(err, ErrStreamReset) {
+		t.Fatalf("want: %v, but: %v", ErrStreamReset, err)
+	}
+
+	_ = server.Close()
+	if _, err := client.Accept(); err != ErrSessionShutdown {
+		// the client session closed after the underlying connection closed
+		t.Fatalf("want: %v, but: %v", ErrSessionShutdown, err)
+	}
+}
+
+func TestTransport_Channels(t *testing.T) {
+	var channels, tlsChannels int32
+	th := lesstrans.NewTransHandler(
+		lesstrans.WithPacketCodec(packet.NewVariableLengthCodec()),
+		lesstrans.WithPayloadCodec(payload.NewTextCodec()),
+		lesstrans.AddOnChannel(func(ctx context.Context, ch less.Channel) (context.Context, error) {
+			atomic.AddInt32(&channels, 1)
+			if _, ok := trans.TLSFromContext(ctx); ok {
+				atomic.AddInt32(&tlsChannels, 1)
+			}
+			return ctx, nil
+		}),
+		lesstrans.WithRouter(func(ctx context.Context, ch less.Channel, msg interface{}) (less.Handler, error) {
+			return func(ctx context.Context, ch less.Channel, message interface{}) error {
+				return ch.Write(ch.RemoteAddr().String() + ":" + message.(string))
+			}, nil
+		}),
+	)
+	defer th.Close(context.Background(), nil)
+
+	// serves the server side by the session driver as the inner transport does
+	s, c := net.Pipe()
+	d := &sessionDriver{driver: th, ops: DefaultOptions}
+	conn := tcp.WrapConnection(s)
+	// the TLS state of the session is passed to the streams
+	ctx, err := d.OnConnect(trans.ContextWithTLS(context.Background(), &tls.ConnectionState{}), conn)
+	if err != nil {
+		t.Fatalf("OnConnect() error = %v", err)
+	}
+	go func() {
+		for d.OnMessage(ctx, conn) == nil {
+		}
+	}()
+
+	client := Client(c)
+	defer client.Close()
+	vc, tc := packet.NewVariableLengthCodec(), payload.NewTextCodec()
+	for i := 0; i < 3; i++ {
+		st, err := client.Open()
+		if err != nil {
+			t.Fatalf("Open() error = %v", err)
+		}
+		if err = vc.Encode("hello", writer.NewBufferWriter(st), tc); err != nil {
+			t.Fatalf("Encode() error = %v", err)
+		}
+		got, err := vc.Decode(ior.NewBufferReader(st), tc)
+		if err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		if want := "pipe#" + strconv.Itoa(int(st.StreamID())) + ":hello"; got != want {
+			t.Fatalf("want: %q, but: %q", want, got)
+		}
+	}
+	if n := atomic.LoadInt32(&channels); n != 3 {
+		t.Fatalf("want a channel per stream, but: %d channels", n)
+	}
+	if n := atomic.LoadInt32(&tlsChannels); n != 3 {
+		t.Fatalf("want the TLS state in each channel, but: %d channels", n)
+	}
+}
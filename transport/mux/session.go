@@ -0,0 +1,332 @@
+package mux
+
+import (
+	"errors"
+	"fmt"
+	stdio "io"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/emove/less/log"
+	"github.com/emove/less/pkg/io"
+	ior "github.com/emove/less/pkg/io/reader"
+	trans "github.com/emove/less/transport"
+)
+
+var (
+	ErrSessionShutdown = errors.New("mux session shutdown")
+	ErrStreamReset     = errors.New("mux stream reset")
+	ErrStreamClosed    = errors.New("mux stream closed")
+	ErrRemoteGoAway    = errors.New("mux remote end is not accepting streams")
+	ErrTooManyStreams  = errors.New("mux streams out of limit")
+	ErrProtocol        = errors.New("mux protocol error")
+	ErrTimeout         = errors.New("mux i/o timeout")
+)
+
+// conn is the underlying connection of session
+type conn interface {
+	stdio.Reader
+	Close() error
+	LocalAddr() net.Addr
+	RemoteAddr() net.Addr
+	// writeFrame writes the frame header and data at once
+	writeFrame(hdr header, data []byte) error
+}
+
+// netConn adapts net.Conn to conn
+type netConn struct {
+	net.Conn
+}
+
+func (c netConn) writeFrame(hdr header, data []byte) error {
+	bufs := net.Buffers{hdr, data}
+	_, err := bufs.WriteTo(c.Conn)
+	return err
+}
+
+// Session multiplexes streams over a connection
+type Session struct {
+	conn   conn
+	client bool
+	ops    *Options
+	nextID uint32
+
+	// onStream serves the stream opened by peer, or the stream is queued to accept if nil
+	onStream func(st *Stream)
+	accept   chan *Stream
+
+	mu      sync.Mutex // guard the following
+	streams map[uint32]*Stream
+
+	writeMu      sync.Mutex // serializes frames writing
+	remoteGoAway int32
+	closed       chan struct{}
+	closeOnce    sync.Once
+}
+
+// Client returns a client session over conn, and starts receiving frames from conn
+func Client(conn net.Conn, op ...trans.Option) *Session {
+	s := newSession(netConn{conn}, true, newOptions(op...), nil)
+	go s.recvLoop()
+	return s
+}
+
+// Server returns a server session over conn, and starts receiving frames from conn
+func Server(conn net.Conn, op ...trans.Option) *Session {
+	s := newSession(netConn{conn}, false, newOptions(op...), nil)
+	go s.recvLoop()
+	return s
+}
+
+func newSession(c conn, client bool, ops *Options, onStream func(st *Stream)) *Session {
+	s := &Session{
+		conn:     c,
+		client:   client,
+		ops:      ops,
+		onStream: onStream,
+		streams:  make(map[uint32]*Stream),
+		closed:   make(chan struct{}),
+	}
+	// the client opens odd stream ids, and the server opens even stream ids
+	if client {
+		s.nextID = 1
+	} else {
+		s.nextID = 2
+	}
+	if onStream == nil {
+		s.accept = make(chan *Stream, ops.AcceptBacklog)
+	}
+	return s
+}
+
+// Open opens a new stream
+func (s *Session) Open() (*Stream, error) {
+	if s.IsClosed() {
+		return nil, ErrSessionShutdown
+	}
+	if atomic.LoadInt32(&s.remoteGoAway) == 1 {
+		return nil, ErrRemoteGoAway
+	}
+
+	id := atomic.AddUint32(&s.nextID, 2) - 2
+	st := newStream(s, id)
+
+	s.mu.Lock()
+	if s.ops.MaxStreams > 0 && len(s.streams) >= s.ops.MaxStreams {
+		s.mu.Unlock()
+		return nil, ErrTooManyStreams
+	}
+	s.streams[id] = st
+	s.mu.Unlock()
+
+	if err := s.writeFrame(typeWindowUpdate, flagSYN, id, st.recvWindow-initialStreamWindow, nil); err != nil {
+		s.remove(id)
+		return nil, err
+	}
+	return st, nil
+}
+
+// Accept returns the next stream opened by peer, only works when the session is created by Client or Server
+func (s *Session) Accept() (*Stream, error) {
+	select {
+	case st := <-s.accept:
+		return st, nil
+	case <-s.closed:
+		return nil, ErrSessionShutdown
+	}
+}
+
+// NumStreams returns the number of opened streams
+func (s *Session) NumStreams() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.streams)
+}
+
+// IsClosed returns whether the session has been closed
+func (s *Session) IsClosed() bool {
+	select {
+	case <-s.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// LocalAddr returns the local address of the underlying connection
+func (s *Session) LocalAddr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote address of the underlying connection
+func (s *Session) RemoteAddr() net.Addr {
+	return s.conn.RemoteAddr()
+}
+
+// Close tells the peer going away, closes all streams and the underlying connection
+func (s *Session) Close() error {
+	return s.close(goAwayNormal)
+}
+
+func (s *Session) close(code uint32) (err error) {
+	s.closeOnce.Do(func() {
+		_ = s.writeFrame(typeGoAway, 0, 0, code, nil)
+		close(s.closed)
+		err = s.conn.Close()
+
+		s.mu.Lock()
+		streams := s.streams
+		s.streams = make(map[uint32]*Stream)
+		s.mu.Unlock()
+		for _, st := range streams {
+			st.terminate(ErrSessionShutdown)
+		}
+	})
+	return err
+}
+
+// closeWithError closes the session due to the err
+func (s *Session) closeWithError(err error) {
+	code := goAwayNormal
+	if errors.Is(err, ErrProtocol) {
+		code = goAwayProtoErr
+		log.Errorw("remote", s.conn.RemoteAddr(), log.DefaultMsgKey, "mux session closed", "err", err)
+	}
+	_ = s.close(code)
+}
+
+func (s *Session) recvLoop() {
+	for {
+		reader := ior.NewBufferReader(s.conn)
+		err := s.recvFrame(reader)
+		reader.Release()
+		if err != nil {
+			s.closeWithError(err)
+			return
+		}
+	}
+}
+
+// recvFrame receives a frame from reader
+func (s *Session) recvFrame(reader io.Reader) error {
+	buf, err := reader.Next(headerSize)
+	if err != nil {
+		return err
+	}
+	hdr := header(buf)
+	if hdr.version() != protoVersion {
+		return fmt.Errorf("%w, unsupported version: %d", ErrProtocol, hdr.version())
+	}
+
+	switch hdr.typ() {
+	case typeData, typeWindowUpdate:
+		return s.recvStreamFrame(reader, hdr.typ(), hdr.flags(), hdr.streamID(), hdr.length())
+	case typePing:
+		if hdr.flags()&flagSYN == flagSYN {
+			// echoes the opaque value
+			return s.writeFrame(typePing, flagACK, 0, hdr.length(), nil)
+		}
+		return nil
+	case typeGoAway:
+		atomic.StoreInt32(&s.remoteGoAway, 1)
+		if code := hdr.length(); code != goAwayNormal {
+			log.Warnw("remote", s.conn.RemoteAddr(), log.DefaultMsgKey, "mux remote going away", "code", code)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w, unknown frame type: %d", ErrProtocol, hdr.typ())
+	}
+}
+
+func (s *Session) recvStreamFrame(reader io.Reader, typ byte, flags uint16, id uint32, length uint32) error {
+	if flags&flagSYN == flagSYN {
+		if err := s.incomingStream(id); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	st := s.streams[id]
+	s.mu.Unlock()
+
+	if typ == typeWindowUpdate {
+		if st != nil {
+			st.updateSendWindow(flags, length)
+		}
+		return nil
+	}
+
+	if length > s.ops.StreamWindow {
+		return fmt.Errorf("%w, data length %d exceeds window %d", ErrProtocol, length, s.ops.StreamWindow)
+	}
+	var data []byte
+	if length > 0 {
+		var err error
+		if data, err = reader.Next(int(length)); err != nil {
+			return err
+		}
+	}
+	if st == nil {
+		// the stream has been closed locally, discards data
+		return nil
+	}
+	return st.recvData(flags, data)
+}
+
+// incomingStream creates the stream opened by peer
+func (s *Session) incomingStream(id uint32) error {
+	// the peer opens stream ids in the other parity
+	if (id%2 == 1) == s.client {
+		return fmt.Errorf("%w, invalid stream id: %d", ErrProtocol, id)
+	}
+
+	st := newStream(s, id)
+	s.mu.Lock()
+	if _, ok := s.streams[id]; ok {
+		s.mu.Unlock()
+		return fmt.Errorf("%w, duplicate stream id: %d", ErrProtocol, id)
+	}
+	if s.ops.MaxStreams > 0 && len(s.streams) >= s.ops.MaxStreams {
+		s.mu.Unlock()
+		log.Warnw("remote", s.conn.RemoteAddr(), log.DefaultMsgKey, "mux stream refused", "err", ErrTooManyStreams)
+		return s.writeFrame(typeWindowUpdate, flagRST, id, 0, nil)
+	}
+	s.streams[id] = st
+	s.mu.Unlock()
+
+	if err := s.writeFrame(typeWindowUpdate, flagACK, id, st.recvWindow-initialStreamWindow, nil); err != nil {
+		return err
+	}
+
+	if s.onStream != nil {
+		go s.onStream(st)
+		return nil
+	}
+	select {
+	case s.accept <- st:
+	default:
+		log.Warnw("remote", s.conn.RemoteAddr(), log.DefaultMsgKey, "mux accept backlog exceeded")
+		return st.Reset()
+	}
+	return nil
+}
+
+// writeFrame writes a frame to the underlying connection
+func (s *Session) writeFrame(typ byte, flags uint16, id uint32, length uint32, data []byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if typ != typeGoAway && s.IsClosed() {
+		return ErrSessionShutdown
+	}
+	if typ == typeData {
+		length = uint32(len(data))
+	}
+	return s.conn.writeFrame(encodeHeader(typ, flags, id, length), data)
+}
+
+func (s *Session) remove(id uint32) {
+	s.mu.Lock()
+	delete(s.streams, id)
+	s.mu.Unlock()
+}
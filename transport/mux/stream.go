@@ -0,0 +1,362 @@
+package mux
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	stdio "io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/emove/less/pkg/io"
+	"github.com/emove/less/pkg/io/reader"
+	"github.com/emove/less/pkg/io/writer"
+	trans "github.com/emove/less/transport"
+)
+
+// Addr is the address of stream, it's the address of underlying connection with stream id
+type Addr struct {
+	net.Addr
+	StreamID uint32
+}
+
+func (a Addr) String() string {
+	return a.Addr.String() + "#" + strconv.FormatUint(uint64(a.StreamID), 10)
+}
+
+var (
+	_ trans.Connection = (*Stream)(nil)
+	_ net.Conn         = (*Stream)(nil)
+)
+
+// Stream is a logical bidirectional stream of session, it implements both
+// transport.Connection and net.Conn
+type Stream struct {
+	session *Session
+	id      uint32
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	writeMu sync.Mutex // prevents messages of concurrent writes from interleaving
+
+	mu            sync.Mutex // guard the following
+	recvBuf       bytes.Buffer
+	recvWindow    uint32 // the remaining receive window advertised to peer
+	sendWindow    uint32
+	remoteClosed  bool  // FIN received
+	localClosed   bool  // FIN sent
+	err           error // terminal error, the stream is unusable once set
+	readDeadline  time.Time
+	writeDeadline time.Time
+	resume        chan struct{} // not nil when reading paused
+
+	recvNotify chan struct{}
+	sendNotify chan struct{}
+}
+
+func newStream(s *Session, id uint32) *Stream {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Stream{
+		session:    s,
+		id:         id,
+		ctx:        ctx,
+		cancel:     cancel,
+		recvWindow: s.ops.StreamWindow,
+		sendWindow: initialStreamWindow,
+		recvNotify: make(chan struct{}, 1),
+		sendNotify: make(chan struct{}, 1),
+	}
+}
+
+// StreamID returns the id of stream
+func (st *Stream) StreamID() uint32 {
+	return st.id
+}
+
+// Read reads data received from peer, returns io.EOF after the peer closed the stream
+func (st *Stream) Read(buf []byte) (n int, err error) {
+	for {
+		st.mu.Lock()
+		if st.recvBuf.Len() > 0 {
+			n, _ = st.recvBuf.Read(buf)
+			st.mu.Unlock()
+			return n, st.updateRecvWindow()
+		}
+		switch {
+		case st.err != nil:
+			err = st.err
+		case st.localClosed:
+			err = ErrStreamClosed
+		case st.remoteClosed:
+			err = stdio.EOF
+		}
+		deadline := st.readDeadline
+		st.mu.Unlock()
+		if err != nil {
+			return 0, err
+		}
+
+		if err = st.wait(st.recvNotify, deadline, trans.OpRead); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Write writes buf to peer, it blocks when the send window exhausted
+func (st *Stream) Write(buf []byte) (n int, err error) {
+	st.writeMu.Lock()
+	defer st.writeMu.Unlock()
+
+	for n < len(buf) {
+		st.mu.Lock()
+		switch {
+		case st.err != nil:
+			err = st.err
+		case st.localClosed:
+			err = ErrStreamClosed
+		}
+		window, deadline := st.sendWindow, st.writeDeadline
+		if err == nil && window > 0 {
+			if size := uint32(len(buf) - n); size < window {
+				window = size
+			}
+			st.sendWindow -= window
+		}
+		st.mu.Unlock()
+		if err != nil {
+			return n, err
+		}
+
+		if window == 0 {
+			if err = st.wait(st.sendNotify, deadline, trans.OpWrite); err != nil {
+				return n, err
+			}
+			continue
+		}
+
+		if err = st.session.writeFrame(typeData, 0, st.id, 0, buf[n:n+int(window)]); err != nil {
+			return n, err
+		}
+		n += int(window)
+	}
+	return n, nil
+}
+
+// wait waits for the notify until the deadline exceeded or the stream terminated
+func (st *Stream) wait(notify chan struct{}, deadline time.Time, op string) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return &trans.TimeoutError{Op: op, Err: ErrTimeout}
+		}
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-notify:
+		return nil
+	case <-st.ctx.Done():
+		// the terminal error will be returned by the caller
+		return nil
+	case <-timeout:
+		return &trans.TimeoutError{Op: op, Err: ErrTimeout}
+	}
+}
+
+// Reader returns a reader of stream
+func (st *Stream) Reader() io.Reader {
+	return reader.NewBufferReader(st)
+}
+
+// Writer returns a writer of stream, the buffered data will be written as a whole when flushing
+func (st *Stream) Writer() io.Writer {
+	return writer.NewBufferWriter(st)
+}
+
+// IsActive returns false once the stream closed or reset
+func (st *Stream) IsActive() bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.err == nil && !st.localClosed
+}
+
+// Close closes the stream, the peer reads io.EOF after all data sent
+func (st *Stream) Close() error {
+	st.mu.Lock()
+	if st.err != nil || st.localClosed {
+		st.mu.Unlock()
+		return nil
+	}
+	st.localClosed = true
+	st.mu.Unlock()
+
+	st.notify()
+	st.cancel()
+	st.session.remove(st.id)
+	return st.session.writeFrame(typeWindowUpdate, flagFIN, st.id, 0, nil)
+}
+
+// Reset closes the stream immediately, and tells the peer to discard the stream
+func (st *Stream) Reset() error {
+	if !st.terminate(ErrStreamReset) {
+		return nil
+	}
+	st.session.remove(st.id)
+	return st.session.writeFrame(typeWindowUpdate, flagRST, st.id, 0, nil)
+}
+
+// terminate sets the terminal error of stream, returns false if the stream has been terminated
+func (st *Stream) terminate(err error) bool {
+	st.mu.Lock()
+	if st.err != nil {
+		st.mu.Unlock()
+		return false
+	}
+	st.err = err
+	st.mu.Unlock()
+
+	st.notify()
+	st.cancel()
+	return true
+}
+
+// LocalAddr returns the local address
+func (st *Stream) LocalAddr() net.Addr {
+	return Addr{Addr: st.session.LocalAddr(), StreamID: st.id}
+}
+
+// RemoteAddr returns the remote address
+func (st *Stream) RemoteAddr() net.Addr {
+	return Addr{Addr: st.session.RemoteAddr(), StreamID: st.id}
+}
+
+// PauseRead stops serving the stream, the peer will be blocked after the receive window exhausted
+func (st *Stream) PauseRead() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.resume == nil {
+		st.resume = make(chan struct{})
+	}
+}
+
+// ResumeRead resumes serving the stream
+func (st *Stream) ResumeRead() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.resume != nil {
+		close(st.resume)
+		st.resume = nil
+	}
+}
+
+// awaitReadable blocks until reading resumed, returns false if the stream closed
+func (st *Stream) awaitReadable() bool {
+	st.mu.Lock()
+	resume := st.resume
+	st.mu.Unlock()
+	if resume == nil {
+		return true
+	}
+
+	select {
+	case <-resume:
+		return true
+	case <-st.ctx.Done():
+		return false
+	}
+}
+
+// SetDeadline sets both read and write deadline
+func (st *Stream) SetDeadline(t time.Time) error {
+	_ = st.SetReadDeadline(t)
+	return st.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the deadline for future Read calls
+func (st *Stream) SetReadDeadline(t time.Time) error {
+	st.mu.Lock()
+	st.readDeadline = t
+	st.mu.Unlock()
+	st.notify()
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for future Write calls
+func (st *Stream) SetWriteDeadline(t time.Time) error {
+	st.mu.Lock()
+	st.writeDeadline = t
+	st.mu.Unlock()
+	st.notify()
+	return nil
+}
+
+// recvData receives data frame from peer
+func (st *Stream) recvData(flags uint16, data []byte) error {
+	st.mu.Lock()
+	if uint32(len(data)) > st.recvWindow {
+		st.mu.Unlock()
+		return fmt.Errorf("%w, stream %d exceeds the receive window", ErrProtocol, st.id)
+	}
+	st.recvWindow -= uint32(len(data))
+	if st.err == nil && !st.localClosed {
+		st.recvBuf.Write(data)
+	}
+	st.mu.Unlock()
+
+	st.processFlags(flags)
+	st.notify()
+	return nil
+}
+
+// updateSendWindow receives window update frame from peer
+func (st *Stream) updateSendWindow(flags uint16, delta uint32) {
+	st.mu.Lock()
+	st.sendWindow += delta
+	st.mu.Unlock()
+
+	st.processFlags(flags)
+	st.notify()
+}
+
+func (st *Stream) processFlags(flags uint16) {
+	if flags&flagRST == flagRST {
+		st.terminate(ErrStreamReset)
+		st.session.remove(st.id)
+		return
+	}
+	if flags&flagFIN == flagFIN {
+		st.mu.Lock()
+		st.remoteClosed = true
+		st.mu.Unlock()
+	}
+}
+
+// updateRecvWindow tells the peer to send more data after half of the window consumed
+func (st *Stream) updateRecvWindow() error {
+	max := st.session.ops.StreamWindow
+	st.mu.Lock()
+	delta := max - uint32(st.recvBuf.Len()) - st.recvWindow
+	if delta < max/2 {
+		st.mu.Unlock()
+		return nil
+	}
+	st.recvWindow += delta
+	st.mu.Unlock()
+	return st.session.writeFrame(typeWindowUpdate, 0, st.id, delta, nil)
+}
+
+// notify wakes up the blocked reading and writing
+func (st *Stream) notify() {
+	for _, ch := range []chan struct{}{st.recvNotify, st.sendNotify} {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package tcp
 
 import (
+	"crypto/tls"
 	"time"
 
 	"github.com/emove/less/log"
@@ -14,6 +15,10 @@ type TCPOptions struct {
 	KeepAlivePeriod time.Duration
 	Linger          int
 	NoDelay         bool
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	TLSConfig       *tls.Config
+	SniffTLS        bool
 }
 
 var DefaultOptions = &TCPOptions{
@@ -23,6 +28,8 @@ var DefaultOptions = &TCPOptions{
 	KeepAlivePeriod: time.Minute,
 	Linger:          -1,
 	NoDelay:         true,
+	ReadTimeout:     0, // never timeout
+	WriteTimeout:    0, // never timeout
 }
 
 type Network string
@@ -36,7 +43,7 @@ const (
 // WithNetwork sets tcp network, TCP, TCP4, TCP6 is allowed
 func WithNetwork(network Network) trans.Option {
 	return func(ops trans.Options) {
-		if tcpOps, ok := ops.(TCPOptions); ok {
+		if tcpOps, ok := ops.(*TCPOptions); ok {
 			switch network {
 			case TCP, TCP4, TCP6:
 				tcpOps.Network = string(network)
@@ -51,7 +58,7 @@ func WithNetwork(network Network) trans.Option {
 // WithTimeout sets dial timeout, only works in client
 func WithTimeout(d time.Duration) trans.Option {
 	return func(ops trans.Options) {
-		if tcpOps, ok := ops.(TCPOptions); ok {
+		if tcpOps, ok := ops.(*TCPOptions); ok {
 			tcpOps.Timeout = d
 		}
 	}
@@ -60,7 +67,7 @@ func WithTimeout(d time.Duration) trans.Option {
 // WithKeepalive sets tcp keepalive
 func WithKeepalive(keepalive bool) trans.Option {
 	return func(ops trans.Options) {
-		if tcpOps, ok := ops.(TCPOptions); ok {
+		if tcpOps, ok := ops.(*TCPOptions); ok {
 			tcpOps.Keepalive = keepalive
 		}
 	}
@@ -69,7 +76,7 @@ func WithKeepalive(keepalive bool) trans.Option {
 // WithKeepalivePeriod sets tcp keepalive period
 func WithKeepalivePeriod(period time.Duration) trans.Option {
 	return func(ops trans.Options) {
-		if tcpOps, ok := ops.(TCPOptions); ok {
+		if tcpOps, ok := ops.(*TCPOptions); ok {
 			tcpOps.KeepAlivePeriod = period
 		}
 	}
@@ -78,7 +85,7 @@ func WithKeepalivePeriod(period time.Duration) trans.Option {
 // WithLinger sets tcp linger
 func WithLinger(linger int) trans.Option {
 	return func(ops trans.Options) {
-		if tcpOps, ok := ops.(TCPOptions); ok {
+		if tcpOps, ok := ops.(*TCPOptions); ok {
 			tcpOps.Linger = linger
 		}
 	}
@@ -87,8 +94,51 @@ func WithLinger(linger int) trans.Option {
 // WithNoDelay sets tcp no delay
 func WithNoDelay(delay bool) trans.Option {
 	return func(ops trans.Options) {
-		if tcpOps, ok := ops.(TCPOptions); ok {
+		if tcpOps, ok := ops.(*TCPOptions); ok {
 			tcpOps.NoDelay = delay
 		}
 	}
 }
+
+// WithReadTimeout sets the maximum duration waiting for the next message of
+// a connection, the connection will be closed if timeout
+func WithReadTimeout(d time.Duration) trans.Option {
+	return func(ops trans.Options) {
+		if tcpOps, ok := ops.(*TCPOptions); ok {
+			tcpOps.ReadTimeout = d
+		}
+	}
+}
+
+// WithWriteTimeout sets the maximum duration of writing a message to
+// a connection, the connection will be closed if timeout
+func WithWriteTimeout(d time.Duration) trans.Option {
+	return func(ops trans.Options) {
+		if tcpOps, ok := ops.(*TCPOptions); ok {
+			tcpOps.WriteTimeout = d
+		}
+	}
+}
+
+// WithTLSConfig terminates TLS on the connections by config, the handshake is limited by the
+// dial timeout. The state of TLS connection is passed to the OnChannel hooks by context,
+// see transport.TLSFromContext. Every connection accepted is served over TLS, unless the
+// WithTLSSniffing is set.
+func WithTLSConfig(config *tls.Config) trans.Option {
+	return func(ops trans.Options) {
+		if tcpOps, ok := ops.(*TCPOptions); ok {
+			tcpOps.TLSConfig = config
+		}
+	}
+}
+
+// WithTLSSniffing serves both TLS and plaintext connections on the listener. The connections whose
+// first byte is a TLS handshake record are terminated by the config of WithTLSConfig, and the others
+// are served as plaintext, the protocol sniffing of server runs on the decrypted stream.
+func WithTLSSniffing() trans.Option {
+	return func(ops trans.Options) {
+		if tcpOps, ok := ops.(*TCPOptions); ok {
+			tcpOps.SniffTLS = true
+		}
+	}
+}
@@ -3,7 +3,9 @@ package tcp
 import (
 	"context"
 	"net"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/emove/less/pkg/io"
 	"github.com/emove/less/pkg/io/reader"
@@ -13,11 +15,17 @@ import (
 
 // WrapConnection wraps net.Conn to conn.Connection
 func WrapConnection(conn net.Conn) trans.Connection {
+	return wrapConnection(conn, DefaultOptions)
+}
+
+func wrapConnection(conn net.Conn, ops *TCPOptions) *connection {
 	ctx, cancelFunc := context.WithCancel(context.Background())
 	return &connection{
-		ctx:        ctx,
-		cancelFunc: cancelFunc,
-		delegate:   conn,
+		ctx:          ctx,
+		cancelFunc:   cancelFunc,
+		delegate:     conn,
+		readTimeout:  ops.ReadTimeout,
+		writeTimeout: ops.WriteTimeout,
 	}
 }
 
@@ -29,25 +37,49 @@ type connection struct {
 	cancelFunc context.CancelFunc
 	delegate   net.Conn
 
+	readTimeout   time.Duration
+	writeTimeout  time.Duration
+	readDeadline  int32 // 1 indicates the read deadline has been set by SetReadDeadline
+	writeDeadline int32 // 1 indicates the write deadline has been set by SetWriteDeadline
+
 	closed int32
+	mu     sync.Mutex    // guard the following
+	resume chan struct{} // not nil when reading paused
 }
 
 func (c *connection) Read(buf []byte) (n int, err error) {
-	return c.delegate.Read(buf)
+	n, err = c.delegate.Read(buf)
+	if err != nil {
+		err = wrapTimeout(trans.OpRead, err)
+	}
+	return
+}
+
+// Write writes buf to net.Conn with write timeout
+func (c *connection) Write(buf []byte) (n int, err error) {
+	if c.writeTimeout > 0 && atomic.LoadInt32(&c.writeDeadline) == 0 {
+		if err = c.delegate.SetWriteDeadline(time.Now().Add(c.writeTimeout)); err != nil {
+			return 0, err
+		}
+	}
+	n, err = c.delegate.Write(buf)
+	if err != nil {
+		err = wrapTimeout(trans.OpWrite, err)
+	}
+	return
 }
 
-// Reader returns a reader
+// Reader returns a reader, the read timeout is the maximum duration of waiting for the reader
 func (c *connection) Reader() io.Reader {
-	r := reader.NewBufferReader(c)
-	//if c.readTimeout > 0 {
-	//	r = reader.NewTimeoutReader(r, c.readTimeout)
-	//}
-	return r
+	if c.readTimeout > 0 && atomic.LoadInt32(&c.readDeadline) == 0 {
+		_ = c.delegate.SetReadDeadline(time.Now().Add(c.readTimeout))
+	}
+	return reader.NewBufferReader(c)
 }
 
 // Writer returns a writer
 func (c *connection) Writer() io.Writer {
-	return writer.NewBufferWriter(c.delegate)
+	return writer.NewBufferWriter(c)
 }
 
 // IsActive returns false when connection closed
@@ -58,6 +90,7 @@ func (c *connection) IsActive() bool {
 // Close closes the net.Conn
 func (c *connection) Close() error {
 	if atomic.CompareAndSwapInt32(&c.closed, trans.Active, trans.Inactive) {
+		c.cancelFunc()
 		return c.delegate.Close()
 	}
 	return nil
@@ -72,3 +105,70 @@ func (c *connection) LocalAddr() net.Addr {
 func (c *connection) RemoteAddr() net.Addr {
 	return c.delegate.RemoteAddr()
 }
+
+// PauseRead stops the read loop reading from the connection
+func (c *connection) PauseRead() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.resume == nil {
+		c.resume = make(chan struct{})
+	}
+}
+
+// ResumeRead resumes the read loop
+func (c *connection) ResumeRead() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.resume != nil {
+		close(c.resume)
+		c.resume = nil
+	}
+}
+
+// awaitReadable blocks until reading resumed, returns false if the connection or ctx closed
+func (c *connection) awaitReadable(ctx context.Context) bool {
+	c.mu.Lock()
+	resume := c.resume
+	c.mu.Unlock()
+	if resume == nil {
+		return true
+	}
+
+	select {
+	case <-resume:
+		return true
+	case <-c.ctx.Done():
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// SetReadDeadline sets the read deadline of net.Conn, the read timeout
+// will be disabled until t is zero
+func (c *connection) SetReadDeadline(t time.Time) error {
+	if t.IsZero() {
+		atomic.StoreInt32(&c.readDeadline, 0)
+	} else {
+		atomic.StoreInt32(&c.readDeadline, 1)
+	}
+	return c.delegate.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline of net.Conn, the write timeout
+// will be disabled until t is zero
+func (c *connection) SetWriteDeadline(t time.Time) error {
+	if t.IsZero() {
+		atomic.StoreInt32(&c.writeDeadline, 0)
+	} else {
+		atomic.StoreInt32(&c.writeDeadline, 1)
+	}
+	return c.delegate.SetWriteDeadline(t)
+}
+
+func wrapTimeout(op string, err error) error {
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return &trans.TimeoutError{Op: op, Err: err}
+	}
+	return err
+}
@@ -1,11 +1,23 @@
 package tcp
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math/big"
 	"net"
 	"testing"
 	"time"
+
+	trans "github.com/emove/less/transport"
 )
 
 type connPair struct {
@@ -19,6 +31,9 @@ func prepare() (pair *connPair, err error) {
 	if err != nil {
 		return nil, err
 	}
+	defer func() {
+		_ = listen.Close()
+	}()
 
 	type clientCon struct {
 		client net.Conn
@@ -203,8 +218,43 @@ func Test_connection_RemoteAddr(t *testing.T) {
 	})
 }
 
-func Test_connection_SetReadTimeout(t *testing.T) {
-	// ignore
+func Test_connection_SetReadDeadline(t *testing.T) {
+	do(func(pair *connPair) {
+		server := WrapConnection(pair.server)
+
+		if err := server.SetReadDeadline(time.Now().Add(100 * time.Millisecond)); err != nil {
+			t.Fatalf("set read deadline err: %v", err)
+		}
+
+		_, err := server.Reader().Next(1)
+		if !trans.IsTimeout(err) {
+			t.Fatalf("want: timeout error, but: %v", err)
+		}
+		t.Logf("read err: %v", err)
+	})
+}
+
+func Test_connection_ReadTimeout(t *testing.T) {
+	do(func(pair *connPair) {
+		server := wrapConnection(pair.server, &TCPOptions{ReadTimeout: 100 * time.Millisecond})
+
+		go func() {
+			_, _ = pair.client.Write([]byte("hi"))
+		}()
+
+		// read timeout is reset by each reader
+		for i := 0; i < 2; i++ {
+			time.Sleep(60 * time.Millisecond)
+			if _, err := server.Reader().Next(1); err != nil {
+				t.Fatalf("read err: %v", err)
+			}
+		}
+
+		_, err := server.Reader().Next(1)
+		if !trans.IsTimeout(err) {
+			t.Fatalf("want: timeout error, but: %v", err)
+		}
+	})
 }
 
 func Test_connection_Writer(t *testing.T) {
@@ -243,3 +293,111 @@ func Test_connection_Writer(t *testing.T) {
 		t.Logf("server read msg: %s", string(buf))
 	})
 }
+
+type tlsDriver struct {
+	states chan *tls.ConnectionState
+}
+
+func (d *tlsDriver) OnConnect(ctx context.Context, _ trans.Connection) (context.Context, error) {
+	state, _ := trans.TLSFromContext(ctx)
+	d.states <- state
+	return ctx, errors.New("done")
+}
+
+func (d *tlsDriver) OnMessage(context.Context, trans.Connection) error { return nil }
+
+func (d *tlsDriver) OnConnClosed(context.Context, trans.Connection, error) {}
+
+func selfSigned(t *testing.T, name string) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func Test_transport_TLS(t *testing.T) {
+	serverCert, serverPool := selfSigned(t, "server")
+	clientCert, clientPool := selfSigned(t, "client")
+
+	tr := New(WithTLSConfig(&tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    clientPool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})).(*transport)
+	driver := &tlsDriver{states: make(chan *tls.ConnectionState, 1)}
+
+	s, c := net.Pipe()
+	go tr.serveTLS(tls.Server(s, tr.ops.TLSConfig), driver)
+
+	client := tls.Client(c, &tls.Config{
+		Certificates: []tls.Certificate{clientCert},
+		RootCAs:      serverPool,
+		ServerName:   "server",
+	})
+	if err := client.Handshake(); err != nil {
+		t.Fatalf("Handshake() error = %v", err)
+	}
+
+	state := <-driver.states
+	if state == nil || len(state.VerifiedChains) == 0 || state.VerifiedChains[0][0].Subject.CommonName != "client" {
+		t.Fatalf("want the verified client certificate, but: %v", state)
+	}
+
+	// the connection rejected by driver is closed
+	_ = client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("want: %v, but: %v", io.EOF, err)
+	}
+}
+
+func Test_transport_Rejected(t *testing.T) {
+	// finds a free port
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	_ = l.Close()
+
+	driver := &tlsDriver{states: make(chan *tls.ConnectionState, 1)}
+	go func() {
+		_ = New().Listen(addr, driver)
+	}()
+
+	var client net.Conn
+	for i := 0; i < 50; i++ {
+		if client, err = net.Dial("tcp", addr); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer client.Close()
+
+	<-driver.states
+	_ = client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = client.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("want: %v, but: %v", io.EOF, err)
+	}
+}
@@ -2,7 +2,9 @@ package tcp
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"io"
 	"net"
 	"time"
 
@@ -21,13 +23,13 @@ var _ trans.Transport = (*transport)(nil)
 
 func New(op ...trans.Option) trans.Transport {
 
-	ops := DefaultOptions
+	ops := *DefaultOptions
 	for _, o := range op {
-		o(ops)
+		o(&ops)
 	}
 
 	return &transport{
-		ops: ops,
+		ops: &ops,
 	}
 }
 
@@ -65,15 +67,103 @@ func (t *transport) Listen(addr string, driver trans.EventDriver) error {
 			continue
 		}
 
-		cc := context.Background()
-		wrapped := WrapConnection(con)
-		cc, err = driver.OnConnect(cc, wrapped)
-		if err != nil {
+		if t.ops.TLSConfig != nil {
+			// the handshake should not block accepting
+			if t.ops.SniffTLS {
+				go t.sniffTLS(con, driver)
+			} else {
+				go t.serveTLS(tls.Server(con, t.ops.TLSConfig), driver)
+			}
 			continue
 		}
 
-		go t.readLoop(cc, wrapped, driver)
+		if cc, wrapped, ok := t.connect(context.Background(), con, driver); ok {
+			go t.readLoop(cc, wrapped, driver)
+		}
+	}
+}
+
+// connect fires the OnConnect event of connection, the connection rejected by driver is closed
+func (t *transport) connect(cc context.Context, con net.Conn, driver trans.EventDriver) (context.Context, trans.Connection, bool) {
+	wrapped := wrapConnection(con, t.ops)
+	cc, err := driver.OnConnect(cc, wrapped)
+	if err != nil {
+		// rejected by driver
+		_ = con.Close()
+		return cc, wrapped, false
+	}
+	return cc, wrapped, true
+}
+
+// sniffTLS peeks the first byte of connection, serves it over TLS if it's a handshake record,
+// otherwise serves it as plaintext
+func (t *transport) sniffTLS(con net.Conn, driver trans.EventDriver) {
+	if t.ops.Timeout > 0 {
+		_ = con.SetReadDeadline(time.Now().Add(t.ops.Timeout))
+	}
+	first := make([]byte, 1)
+	if _, err := io.ReadFull(con, first); err != nil {
+		log.Debugf("sniff tls with %s failed, err: %v", con.RemoteAddr().String(), err)
+		_ = con.Close()
+		return
+	}
+	_ = con.SetReadDeadline(time.Time{})
+
+	// replays the peeked byte
+	pc := &peekedConn{Conn: con, peeked: first}
+	if first[0] == recordTypeHandshake {
+		t.serveTLS(tls.Server(pc, t.ops.TLSConfig), driver)
+		return
+	}
+	if cc, wrapped, ok := t.connect(context.Background(), pc, driver); ok {
+		t.readLoop(cc, wrapped, driver)
+	}
+}
+
+// recordTypeHandshake is the content type of TLS handshake record, which starts a TLS connection
+const recordTypeHandshake = 0x16
+
+// peekedConn reads the peeked bytes before reading from Conn
+type peekedConn struct {
+	net.Conn
+	peeked []byte
+}
+
+func (c *peekedConn) Read(buf []byte) (int, error) {
+	if len(c.peeked) > 0 {
+		n := copy(buf, c.peeked)
+		c.peeked = c.peeked[n:]
+		return n, nil
+	}
+	return c.Conn.Read(buf)
+}
+
+// serveTLS serves the server side TLS connection after the handshake completed
+func (t *transport) serveTLS(con *tls.Conn, driver trans.EventDriver) {
+	cc, err := t.handshake(context.Background(), con)
+	if err != nil {
+		log.Debugf("tls handshake with %s failed, err: %v", con.RemoteAddr().String(), err)
+		_ = con.Close()
+		return
+	}
+
+	if cc, wrapped, ok := t.connect(cc, con, driver); ok {
+		t.readLoop(cc, wrapped, driver)
+	}
+}
+
+// handshake runs the TLS handshake in the dial timeout, returns a context carrying the state
+func (t *transport) handshake(ctx context.Context, con *tls.Conn) (context.Context, error) {
+	if t.ops.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.ops.Timeout)
+		defer cancel()
+	}
+	if err := con.HandshakeContext(ctx); err != nil {
+		return ctx, err
 	}
+	state := con.ConnectionState()
+	return trans.ContextWithTLS(context.Background(), &state), nil
 }
 
 func (t *transport) Dial(network, addr string, driver trans.EventDriver) error {
@@ -99,9 +189,25 @@ func (t *transport) Dial(network, addr string, driver trans.EventDriver) error {
 	}
 
 	cc := context.Background()
-	wrapped := WrapConnection(con)
+	if t.ops.TLSConfig != nil {
+		config := t.ops.TLSConfig
+		if config.ServerName == "" {
+			// verify the host dialed like tls.Dial
+			config = config.Clone()
+			config.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+		tc := tls.Client(con, config)
+		if cc, err = t.handshake(cc, tc); err != nil {
+			_ = con.Close()
+			return err
+		}
+		con = tc
+	}
+
+	wrapped := wrapConnection(con, t.ops)
 	if cc, err = driver.OnConnect(cc, wrapped); err != nil {
 		_ = con.Close()
+		return err
 	}
 
 	go t.readLoop(cc, wrapped, driver)
@@ -125,7 +231,14 @@ func (t *transport) readLoop(ctx context.Context, conn trans.Connection, driver
 		case <-t.ctx.Done():
 			return
 		default:
-			_ = driver.OnMessage(ctx, conn)
+			// reading paused, waiting for resume without reading from the connection
+			// so that the flow control of tcp will slow down the peer
+			if c, ok := conn.(*connection); ok && !c.awaitReadable(t.ctx) {
+				return
+			}
+			if err := driver.OnMessage(ctx, conn); err != nil {
+				return
+			}
 		}
 	}
 }
@@ -0,0 +1,20 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+)
+
+type tlsStateKey struct{}
+
+// ContextWithTLS returns a copy of ctx carrying the state of TLS connection, the transports
+// terminating TLS pass it to EventDriver#OnConnect
+func ContextWithTLS(ctx context.Context, state *tls.ConnectionState) context.Context {
+	return context.WithValue(ctx, tlsStateKey{}, state)
+}
+
+// TLSFromContext returns the state of TLS connection carried by ctx
+func TLSFromContext(ctx context.Context) (*tls.ConnectionState, bool) {
+	state, ok := ctx.Value(tlsStateKey{}).(*tls.ConnectionState)
+	return state, ok && state != nil
+}
@@ -12,6 +12,7 @@ type EventDriver interface {
 	// if the error returned, the connection will be rejected.
 	OnConnect(ctx context.Context, con Connection) (context.Context, error)
 	// OnMessage fires when receive a request.
+	// if the error returned, the transport stops reading from the connection.
 	OnMessage(ctx context.Context, con Connection) error
 	// OnConnClosed should be called when the connection be closed.
 	OnConnClosed(ctx context.Context, con Connection, err error)
@@ -0,0 +1,143 @@
+package validate
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/emove/less"
+	"github.com/emove/less/log"
+)
+
+// Recorder records the invalid messages
+type Recorder func(ctx context.Context, ch less.Channel, message interface{}, err *Error)
+
+type options struct {
+	reply    func(message interface{}, err *Error) interface{}
+	close    bool
+	recorder Recorder
+	skip     less.Interceptor
+}
+
+// Option sets the validation middleware options
+type Option func(ops *options)
+
+// WithReply sets the function returns the reply message which will be sent to the peer
+// when a message is invalid, nil means do not reply
+func WithReply(reply func(message interface{}, err *Error) interface{}) Option {
+	return func(ops *options) {
+		ops.reply = reply
+	}
+}
+
+// WithClose closes the channel with the *Error when a message is invalid, after the reply is sent
+func WithClose() Option {
+	return func(ops *options) {
+		ops.close = true
+	}
+}
+
+// WithRecorder adds a Recorder of the invalid messages
+func WithRecorder(recorder Recorder) Option {
+	return func(ops *options) {
+		if recorder == nil {
+			return
+		}
+		if prev := ops.recorder; prev != nil {
+			ops.recorder = func(ctx context.Context, ch less.Channel, message interface{}, err *Error) {
+				prev(ctx, ch, message, err)
+				recorder(ctx, ch, message, err)
+			}
+			return
+		}
+		ops.recorder = recorder
+	}
+}
+
+// WithSkip sets the interceptor of messages which should not be validated
+func WithSkip(skip less.Interceptor) Option {
+	return func(ops *options) {
+		ops.skip = skip
+	}
+}
+
+// Middleware returns an inbound middleware which validates messages by Struct. The invalid message
+// is dropped without invoking the next handler, and replied or closes the channel as configured.
+func Middleware(op ...Option) less.Middleware {
+	ops := &options{}
+	for _, o := range op {
+		o(ops)
+	}
+
+	return func(next less.Handler) less.Handler {
+		return func(ctx context.Context, ch less.Channel, message interface{}) error {
+			if ops.skip != nil && ops.skip(message) {
+				return next(ctx, ch, message)
+			}
+			err := Struct(message)
+			if err == nil {
+				return next(ctx, ch, message)
+			}
+
+			var ve *Error
+			if !errors.As(err, &ve) {
+				return err
+			}
+			log.Debugw("remote", ch.RemoteAddr().String(), log.DefaultMsgKey, "invalid message", "err", err)
+			if ops.recorder != nil {
+				ops.recorder(ctx, ch, message, ve)
+			}
+			if ops.reply != nil {
+				if reply := ops.reply(message, ve); reply != nil {
+					if err := ch.Write(reply); err != nil {
+						log.Warnw("remote", ch.RemoteAddr().String(), log.DefaultMsgKey, "reply invalid message failed", "err", err)
+					}
+				}
+			}
+			if ops.close {
+				return ch.Close(ctx, ve)
+			}
+			return nil
+		}
+	}
+}
+
+// Stats counts the violations, it's a Recorder by the Record method
+type Stats struct {
+	mu       sync.Mutex
+	messages uint64
+	counts   map[string]uint64
+}
+
+// NewStats returns an empty Stats
+func NewStats() *Stats {
+	return &Stats{counts: make(map[string]uint64)}
+}
+
+// Record counts the violations of err by the key "Type.Field:Rule"
+func (s *Stats) Record(_ context.Context, _ less.Channel, _ interface{}, err *Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages++
+	for _, v := range err.Violations {
+		s.counts[join(err.Type, v.Field)+":"+v.Rule]++
+	}
+}
+
+// Invalid returns the total number of invalid messages
+func (s *Stats) Invalid() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.messages
+}
+
+// Violations returns a copy of the violation counts
+func (s *Stats) Violations() map[string]uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := make(map[string]uint64, len(s.counts))
+	for k, v := range s.counts {
+		counts[k] = v
+	}
+	return counts
+}
@@ -0,0 +1,393 @@
+// Package validate validates the decoded messages before they reach handlers.
+//
+// A message is validated by the rules in the `validate` tags of its struct fields, and then by its
+// Validate method if it implements Validator. The rules are separated by commas:
+//
+//	type Login struct {
+//		Name     string `validate:"required,min=3,max=32,regex=^[a-z0-9_]+$"`
+//		Password string `validate:"required,len=64"`
+//		Age      int    `validate:"min=18"`
+//	}
+//
+//   - required: the field is not the zero value, or not empty for slices and maps
+//   - min, max: the bounds of numbers, or the bounds of length for strings, slices and maps
+//   - len: the exact length of strings, slices and maps
+//   - regex: the pattern strings should match, it must be the last rule since it may contain commas
+//
+// The nested structs, pointers to structs and slices of them are validated recursively.
+package validate
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Validator is implemented by messages which validate themselves
+type Validator interface {
+	Validate() error
+}
+
+// Violation describes a field violates a rule
+type Violation struct {
+	// Field is the path of field, such as "Items[0].Name", empty for the violations returned by Validate
+	Field string
+	// Rule is the violated rule, such as "required" and "min=18", or "validate" for Validate method
+	Rule string
+	// Message is readable description
+	Message string
+}
+
+func (v Violation) String() string {
+	if v.Field == "" {
+		return v.Message
+	}
+	return v.Field + ": " + v.Message
+}
+
+// Error is returned when a message is invalid
+type Error struct {
+	// Type is the type name of message
+	Type       string
+	Violations []Violation
+}
+
+func (e *Error) Error() string {
+	vs := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		vs = append(vs, v.String())
+	}
+	return "invalid " + e.Type + ": " + strings.Join(vs, "; ")
+}
+
+const tagName = "validate"
+
+// Struct validates message by the tags and the Validate method, returns an *Error if invalid
+func Struct(message interface{}) error {
+	if message == nil {
+		return nil
+	}
+	v := reflect.ValueOf(message)
+	e := &Error{Type: reflect.TypeOf(message).String()}
+	validateValue(v, "", e)
+	if len(e.Violations) > 0 {
+		return e
+	}
+	return nil
+}
+
+// validateValue validates the struct value which may be pointed, the violations are appended to e
+func validateValue(v reflect.Value, path string, e *Error) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+
+	if v.Kind() == reflect.Struct {
+		rules, err := structRules(v.Type())
+		if err != nil {
+			e.Violations = append(e.Violations, Violation{Field: path, Rule: tagName, Message: err.Error()})
+			return
+		}
+		for _, fr := range rules {
+			field := v.Field(fr.index)
+			name := join(path, fr.name)
+			for _, r := range fr.rules {
+				if msg := r.check(field); msg != "" {
+					e.Violations = append(e.Violations, Violation{Field: name, Rule: r.name, Message: msg})
+				}
+			}
+			if fr.nested {
+				validateNested(field, name, e)
+			}
+		}
+	}
+
+	validateSelf(v, path, e)
+}
+
+// validateNested validates structs in the field
+func validateNested(field reflect.Value, path string, e *Error) {
+	switch field.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < field.Len(); i++ {
+			validateValue(field.Index(i), path+"["+strconv.Itoa(i)+"]", e)
+		}
+	default:
+		validateValue(field, path, e)
+	}
+}
+
+// validateSelf calls the Validate method of v or its pointer
+func validateSelf(v reflect.Value, path string, e *Error) {
+	var validator Validator
+	if v.CanInterface() {
+		validator, _ = v.Interface().(Validator)
+	}
+	if validator == nil && v.CanAddr() && v.Addr().CanInterface() {
+		validator, _ = v.Addr().Interface().(Validator)
+	}
+	if validator == nil {
+		return
+	}
+
+	err := validator.Validate()
+	if err == nil {
+		return
+	}
+	var ve *Error
+	if errors.As(err, &ve) {
+		for _, violation := range ve.Violations {
+			violation.Field = join(path, violation.Field)
+			e.Violations = append(e.Violations, violation)
+		}
+		return
+	}
+	e.Violations = append(e.Violations, Violation{Field: path, Rule: "validate", Message: err.Error()})
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	if name == "" {
+		return path
+	}
+	return path + "." + name
+}
+
+type fieldRules struct {
+	index  int
+	name   string
+	rules  []rule
+	nested bool // whether the field may contain structs
+}
+
+type rule struct {
+	name  string
+	check func(v reflect.Value) string // returns the violation message, empty if valid
+}
+
+var cache sync.Map // reflect.Type -> []fieldRules or error
+
+// structRules returns the parsed rules of struct type, the result is cached
+func structRules(typ reflect.Type) ([]fieldRules, error) {
+	if v, ok := cache.Load(typ); ok {
+		if err, ok := v.(error); ok {
+			return nil, err
+		}
+		return v.([]fieldRules), nil
+	}
+
+	var rules []fieldRules
+	var err error
+	for i := 0; i < typ.NumField() && err == nil; i++ {
+		f := typ.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		fr := fieldRules{index: i, name: f.Name, nested: mayContainStruct(f.Type)}
+		if tag, ok := f.Tag.Lookup(tagName); ok && tag != "-" {
+			fr.rules, err = parseRules(f.Type, tag)
+			if err != nil {
+				err = fmt.Errorf("field %s: %w", f.Name, err)
+			}
+		}
+		if len(fr.rules) > 0 || fr.nested {
+			rules = append(rules, fr)
+		}
+	}
+
+	if err != nil {
+		cache.Store(typ, err)
+		return nil, err
+	}
+	cache.Store(typ, rules)
+	return rules, nil
+}
+
+func mayContainStruct(typ reflect.Type) bool {
+	for {
+		switch typ.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Array:
+			typ = typ.Elem()
+		case reflect.Struct, reflect.Interface:
+			return true
+		default:
+			return false
+		}
+	}
+}
+
+// parseRules parses the rules in tag for the field type
+func parseRules(typ reflect.Type, tag string) ([]rule, error) {
+	var rules []rule
+	for tag != "" {
+		var item string
+		if strings.HasPrefix(tag, "regex=") {
+			// the pattern takes the rest of tag
+			item, tag = tag, ""
+		} else if i := strings.IndexByte(tag, ','); i >= 0 {
+			item, tag = tag[:i], tag[i+1:]
+		} else {
+			item, tag = tag, ""
+		}
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		name, arg, _ := strings.Cut(item, "=")
+		r, err := newRule(typ, name, arg)
+		if err != nil {
+			return nil, err
+		}
+		r.name = item
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+func newRule(typ reflect.Type, name, arg string) (rule, error) {
+	switch name {
+	case "required":
+		return rule{check: func(v reflect.Value) string {
+			if isEmpty(v) {
+				return "is required"
+			}
+			return ""
+		}}, nil
+	case "min", "max":
+		return boundRule(typ, name, arg)
+	case "len":
+		n, err := strconv.Atoi(arg)
+		if err != nil || !hasLength(typ) {
+			return rule{}, fmt.Errorf("invalid rule len=%s for %v", arg, typ)
+		}
+		return rule{check: func(v reflect.Value) string {
+			if l, ok := length(v); ok && l != n {
+				return fmt.Sprintf("length must be %d, but %d", n, l)
+			}
+			return ""
+		}}, nil
+	case "regex":
+		re, err := regexp.Compile(arg)
+		if err != nil || indirect(typ).Kind() != reflect.String {
+			return rule{}, fmt.Errorf("invalid rule regex=%s for %v", arg, typ)
+		}
+		return rule{check: func(v reflect.Value) string {
+			if v = deref(v); v.IsValid() && !re.MatchString(v.String()) {
+				return "must match " + arg
+			}
+			return ""
+		}}, nil
+	default:
+		return rule{}, fmt.Errorf("unknown rule %q", name)
+	}
+}
+
+// boundRule returns a min or max rule, it bounds numbers or the length of strings, slices and maps
+func boundRule(typ reflect.Type, name, arg string) (rule, error) {
+	bound, err := strconv.ParseFloat(arg, 64)
+	if err != nil {
+		return rule{}, fmt.Errorf("invalid rule %s=%s", name, arg)
+	}
+	violated := func(n float64) bool {
+		if name == "min" {
+			return n < bound
+		}
+		return n > bound
+	}
+	desc := "at least"
+	if name == "max" {
+		desc = "at most"
+	}
+
+	if hasLength(typ) {
+		return rule{check: func(v reflect.Value) string {
+			if l, ok := length(v); ok && violated(float64(l)) {
+				return fmt.Sprintf("length must be %s %s, but %d", desc, arg, l)
+			}
+			return ""
+		}}, nil
+	}
+	switch indirect(typ).Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+	default:
+		return rule{}, fmt.Errorf("invalid rule %s=%s for %v", name, arg, typ)
+	}
+	return rule{check: func(v reflect.Value) string {
+		n, ok := number(v)
+		if ok && violated(n) {
+			return fmt.Sprintf("must be %s %s, but %v", desc, arg, deref(v).Interface())
+		}
+		return ""
+	}}, nil
+}
+
+func indirect(typ reflect.Type) reflect.Type {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return typ
+}
+
+// deref returns the value pointed, or an invalid value if nil
+func deref(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+func hasLength(typ reflect.Type) bool {
+	switch indirect(typ).Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return true
+	default:
+		return false
+	}
+}
+
+// length returns the length of v, false if v is a nil pointer
+func length(v reflect.Value) (int, bool) {
+	if v = deref(v); !v.IsValid() {
+		return 0, false
+	}
+	return v.Len(), true
+}
+
+// number returns the value of number v, false if v is a nil pointer
+func number(v reflect.Value) (float64, bool) {
+	if v = deref(v); !v.IsValid() {
+		return 0, false
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(v.Uint()), true
+	default:
+		return v.Float(), true
+	}
+}
+
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	default:
+		return v.IsZero()
+	}
+}
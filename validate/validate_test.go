@@ -0,0 +1,165 @@
+package validate
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/emove/less"
+)
+
+type item struct {
+	Name  string `validate:"required,regex=^[a-z]{1,3}$"`
+	Count *int   `validate:"min=1,max=10"`
+}
+
+type order struct {
+	ID    string   `validate:"required,len=4"`
+	Tags  []string `validate:"max=2"`
+	Price float64  `validate:"min=0.5"`
+	Items []item   `validate:"required"`
+	Owner *item
+	note  string
+}
+
+func (o *order) Validate() error {
+	if o.note == "bad" {
+		return errors.New("bad note")
+	}
+	return nil
+}
+
+func TestStruct(t *testing.T) {
+	one, eleven := 1, 11
+	tests := []struct {
+		name    string
+		message interface{}
+		want    []string // field:rule
+	}{
+		{name: "not struct", message: "text"},
+		{name: "nil", message: (*order)(nil)},
+		{
+			name:    "valid",
+			message: &order{ID: "abcd", Price: 1, Items: []item{{Name: "a", Count: &one}, {Name: "b"}}},
+		},
+		{
+			name:    "required",
+			message: &order{Price: 1},
+			want:    []string{"ID:required", "ID:len=4", "Items:required"},
+		},
+		{
+			name: "bounds",
+			message: order{ID: "abcd", Tags: []string{"a", "b", "c"}, Items: []item{{Name: "a", Count: &eleven}},
+				Owner: &item{Name: "toolong"}},
+			want: []string{"Tags:max=2", "Price:min=0.5", "Items[0].Count:max=10", "Owner.Name:regex=^[a-z]{1,3}$"},
+		},
+		{
+			name:    "Validate",
+			message: &order{ID: "abcd", Price: 1, Items: []item{{Name: "a"}}, note: "bad"},
+			want:    []string{":validate"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Struct(tt.message)
+			var got []string
+			if err != nil {
+				var ve *Error
+				if !errors.As(err, &ve) {
+					t.Fatalf("want *Error, but: %v", err)
+				}
+				for _, v := range ve.Violations {
+					got = append(got, v.Field+":"+v.Rule)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("want: %v, but: %v, err: %v", tt.want, got, err)
+			}
+		})
+	}
+}
+
+func TestStruct_InvalidTag(t *testing.T) {
+	type bad struct {
+		Flag bool `validate:"min=1"`
+	}
+	err := Struct(bad{})
+	var ve *Error
+	if !errors.As(err, &ve) || ve.Violations[0].Rule != "validate" {
+		t.Fatalf("want the tag error, but: %v", err)
+	}
+}
+
+type fakeChannel struct {
+	less.Channel
+	written []interface{}
+	closed  error
+}
+
+func (ch *fakeChannel) RemoteAddr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+func (ch *fakeChannel) Write(message interface{}) error {
+	ch.written = append(ch.written, message)
+	return nil
+}
+
+func (ch *fakeChannel) Close(_ context.Context, err error) error {
+	ch.closed = err
+	return nil
+}
+
+func TestMiddleware(t *testing.T) {
+	var handled []interface{}
+	handler := func(ctx context.Context, ch less.Channel, message interface{}) error {
+		handled = append(handled, message)
+		return nil
+	}
+	stats := NewStats()
+	invalid := &order{ID: "abc", Price: 1, Items: []item{{Name: "a"}}}
+	valid := &order{ID: "abcd", Price: 1, Items: []item{{Name: "a"}}}
+
+	// reply
+	ch := &fakeChannel{}
+	h := Middleware(
+		WithRecorder(stats.Record),
+		WithReply(func(message interface{}, err *Error) interface{} { return err.Error() }),
+		WithSkip(func(message interface{}) bool { return message == "skip" }),
+	)(handler)
+	for _, msg := range []interface{}{valid, invalid, "skip"} {
+		if err := h(context.Background(), ch, msg); err != nil {
+			t.Fatalf("handler error = %v", err)
+		}
+	}
+	if len(handled) != 2 || handled[0] != valid || handled[1] != "skip" {
+		t.Fatalf("the invalid message should not be handled, but: %v", handled)
+	}
+	if len(ch.written) != 1 || ch.written[0] != "invalid *validate.order: ID: length must be 4, but 3" {
+		t.Fatalf("unexpected reply: %v", ch.written)
+	}
+	if ch.closed != nil {
+		t.Fatalf("the channel should not be closed")
+	}
+
+	// close
+	ch = &fakeChannel{}
+	h = Middleware(WithClose(), WithRecorder(stats.Record))(handler)
+	if err := h(context.Background(), ch, invalid); err != nil {
+		t.Fatalf("handler error = %v", err)
+	}
+	var ve *Error
+	if !errors.As(ch.closed, &ve) || len(ch.written) != 0 {
+		t.Fatalf("the channel should be closed with *Error, but: %v", ch.closed)
+	}
+
+	if stats.Invalid() != 2 {
+		t.Fatalf("want 2 invalid messages, but: %d", stats.Invalid())
+	}
+	want := map[string]uint64{"*validate.order.ID:len=4": 2}
+	if got := stats.Violations(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("want: %v, but: %v", want, got)
+	}
+}